@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/hex"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ifaFlagTemporary is IFA_F_TEMPORARY: a privacy-extension address.
+const ifaFlagTemporary = 0x01
+
+// ipv6AddressKind reports whether ip is one of this host's temporary
+// (privacy extension) or stable IPv6 addresses, or "" if unknown.
+func ipv6AddressKind(ip net.IP) string {
+	if ip.To4() != nil || ip.To16() == nil {
+		return ""
+	}
+	data, err := os.ReadFile("/proc/net/if_inet6")
+	if err != nil {
+		return ""
+	}
+	want := hex.EncodeToString(ip.To16())
+	for _, line := range strings.Split(string(data), "\n") {
+		// address, interface index, prefix length, scope, flags, name
+		fields := strings.Fields(line)
+		if len(fields) < 6 || fields[0] != want {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			return ""
+		}
+		if flags&ifaFlagTemporary != 0 {
+			return "temporary"
+		}
+		return "stable"
+	}
+	return ""
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+func ipv6AddressKind(ip net.IP) string {
+	return ""
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// anomalyMinRuns is the fewest past runs a result is judged against; with
+// fewer, nothing is unusual yet.
+const anomalyMinRuns = 5
+
+// DetectAnomalies describes the measurements in s that are unusual for the
+// past runs, by their modified z-score: the distance from the runs' median
+// in units of their median absolute deviation, scaled so that 3.5 is the
+// customary threshold for outliers. Unlike the mean and standard deviation,
+// the median and MAD aren't pulled along by the outliers they are meant to
+// find. Phases skipped in cfg or with insufficient data aren't checked, and
+// past runs without enough samples of a phase don't count for it.
+func DetectAnomalies(cfg Config, s Summary, runs []Summary, threshold float64) []string {
+	var anomalies []string
+	check := func(name, phase, unit string, skip bool, value func(Summary) float64) {
+		if skip || s.lacks(phase) {
+			return
+		}
+		var values []float64
+		for _, run := range runs {
+			if !run.lacks(phase) {
+				values = append(values, value(run))
+			}
+		}
+		if len(values) < anomalyMinRuns {
+			return
+		}
+		median, _ := fastcom.CalcPercentile(values, 50)
+		for i, v := range values {
+			values[i] = math.Abs(v - median)
+		}
+		mad, _ := fastcom.CalcPercentile(values, 50)
+		if mad == 0 {
+			// most runs measured exactly the same; there is no spread
+			// to judge by
+			return
+		}
+		v := value(s)
+		z := 0.6745 * (v - median) / mad
+		if math.Abs(z) <= threshold {
+			return
+		}
+		direction := "high"
+		if z < 0 {
+			direction = "low"
+		}
+		anomalies = append(anomalies, fmt.Sprintf("%s %0.1f %s is unusually %s for the last %d runs (median %0.1f %s, z %+0.1f)",
+			name, v, unit, direction, len(values), median, unit, z))
+	}
+	check("download", "download", "Mbit/s", cfg.SkipDownload, func(s Summary) float64 { return s.DownloadMbps })
+	check("upload", "upload", "Mbit/s", cfg.SkipUpload, func(s Summary) float64 { return s.UploadMbps })
+	check("ping", "latency", "ms", cfg.SkipLatency, func(s Summary) float64 { return s.PingMs })
+	check("jitter", "latency", "ms", cfg.SkipLatency, func(s Summary) float64 { return s.JitterMs })
+	return anomalies
+}
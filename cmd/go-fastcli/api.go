@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// maxStoredResults bounds the results the daemon keeps in memory for the
+// REST API. Use -db or -journal to keep more.
+const maxStoredResults = 1000
+
+const streamKeepalive = 15 * time.Second
+
+// Results returns the stored results of successful runs in [since, until),
+// oldest first. Zero times leave the range open.
+func (d *Daemon) Results(since, until time.Time) []Result {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	results := []Result{}
+	for _, result := range d.results {
+		if !since.IsZero() && result.Time.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !result.Time.Before(until) {
+			continue
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// APIHandler serves the REST API:
+//
+//	POST /v1/test            start a run
+//	GET  /v1/results/latest  the last successful result
+//	GET  /v1/results         stored results, filtered by ?since= and ?until=
+//	GET  /v1/stream          live events of running tests as server-sent events
+//
+// If token is not empty, requests must send it as a bearer token or, since
+// browsers' EventSource can't set headers, in the access_token parameter.
+func (d *Daemon) APIHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/test", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+		if !d.Trigger() {
+			writeAPIError(w, http.StatusConflict, "a run is already in progress")
+			return
+		}
+		writeJSON(w, http.StatusAccepted, ControlResponse{Triggered: true})
+	})
+	mux.HandleFunc("/v1/results/latest", func(w http.ResponseWriter, r *http.Request) {
+		status := d.Status()
+		if status.LastResult == nil {
+			writeAPIError(w, http.StatusNotFound, "no successful run yet")
+			return
+		}
+		writeJSON(w, http.StatusOK, status.LastResult)
+	})
+	mux.HandleFunc("/v1/results", func(w http.ResponseWriter, r *http.Request) {
+		since, until, err := parseTimeRange(r.URL.Query().Get("since"), r.URL.Query().Get("until"))
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, d.Results(since, until))
+	})
+	mux.HandleFunc("/v1/stream", d.serveStream)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && !validToken(r, token) {
+			writeAPIError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func validToken(r *http.Request, token string) bool {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1 {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("access_token")), []byte(token)) == 1
+}
+
+// serveStream sends each event as a server-sent event named after its type,
+// e.g. "sample" for the per-second throughput and the latency samples.
+func (d *Daemon) serveStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	events, cancel := d.Subscribe()
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// comments keep proxies from closing the stream between runs
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case evt := <-events:
+			data, err := json.Marshal(evt)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Type, data)
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, ControlResponse{Error: msg})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// A failed assertion exits with ExitAssertion plus a bit for each failed
+// metric: 9 for download, 10 for upload, 12 for ping, and their sums, e.g.
+// 11 when both speeds are too low.
+const (
+	ExitAssertion      = 8
+	AssertDownloadFail = 1
+	AssertUploadFail   = 2
+	AssertPingFail     = 4
+)
+
+// Assertions are the limits of -assert-download-min, -assert-upload-min and
+// -assert-ping-max. Zero leaves a limit unset.
+type Assertions struct {
+	DownloadMinMbps float64
+	UploadMinMbps   float64
+	PingMaxMs       float64
+}
+
+func (a Assertions) Enabled() bool {
+	return a != Assertions{}
+}
+
+// Validate rejects assertions on phases skipped in cfg, which could never
+// hold.
+func (a Assertions) Validate(cfg Config) error {
+	switch {
+	case a.DownloadMinMbps > 0 && cfg.SkipDownload:
+		return fmt.Errorf("-assert-download-min needs the download phase")
+	case a.UploadMinMbps > 0 && cfg.SkipUpload:
+		return fmt.Errorf("-assert-upload-min needs the upload phase")
+	case a.PingMaxMs > 0 && cfg.SkipLatency:
+		return fmt.Errorf("-assert-ping-max needs the latency phase")
+	}
+	return nil
+}
+
+// Failures prints each assertion the result fails to w and returns their
+// bits, 0 if they all hold. An assertion on a phase without enough samples
+// can't be checked either way; it is reported as such and doesn't fail.
+func (a Assertions) Failures(w io.Writer, result Result) int {
+	s := result.Summary()
+	checked := func(limit float64, phase string) bool {
+		if limit <= 0 {
+			return false
+		}
+		if s.lacks(phase) {
+			fmt.Fprintf(w, "Assertion not checked: %s has insufficient data\n", phase)
+			return false
+		}
+		return true
+	}
+	failed := 0
+	if checked(a.DownloadMinMbps, "download") && s.DownloadMbps < a.DownloadMinMbps {
+		fmt.Fprintf(w, "Assertion failed: download %0.1f Mbit/s is below %g Mbit/s\n", s.DownloadMbps, a.DownloadMinMbps)
+		failed |= AssertDownloadFail
+	}
+	if checked(a.UploadMinMbps, "upload") && s.UploadMbps < a.UploadMinMbps {
+		fmt.Fprintf(w, "Assertion failed: upload %0.1f Mbit/s is below %g Mbit/s\n", s.UploadMbps, a.UploadMinMbps)
+		failed |= AssertUploadFail
+	}
+	if checked(a.PingMaxMs, "latency") && s.PingMs > a.PingMaxMs {
+		fmt.Fprintf(w, "Assertion failed: ping %0.1f ms is above %g ms\n", s.PingMs, a.PingMaxMs)
+		failed |= AssertPingFail
+	}
+	return failed
+}
+
+// AssertionExitCode is the exit code of the failed assertion bits.
+func AssertionExitCode(failed int) int {
+	if failed == 0 {
+		return 0
+	}
+	return ExitAssertion + failed
+}
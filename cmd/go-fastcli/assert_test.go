@@ -0,0 +1,22 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAssertionsInsufficient(t *testing.T) {
+	a := Assertions{DownloadMinMbps: 100, UploadMinMbps: 10, PingMaxMs: 50}
+	result := Result{Servers: []ServerResult{
+		{Host: "a", LatencyMs: 80, UploadMbps: 5, Insufficient: []string{"download"}},
+	}}
+	var b bytes.Buffer
+	failed := a.Failures(&b, result)
+	if failed != AssertUploadFail|AssertPingFail {
+		t.Errorf("got failures %b, want upload and ping only", failed)
+	}
+	if !strings.Contains(b.String(), "download has insufficient data") {
+		t.Errorf("got %q, want the download reported as not checked", b.String())
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// Baseline is the speed a run is expected to reach, taken from the history.
+type Baseline struct {
+	// Runs is the number of past runs it is the median of.
+	Runs         int
+	DownloadMbps float64
+	UploadMbps   float64
+}
+
+// LoadBaseline takes the baseline from store. spec is "auto" for the median
+// of the last runs runs, or the ID of a record as listed by history.
+func LoadBaseline(store HistoryStore, spec string, runs int) (Baseline, error) {
+	if spec != "auto" {
+		id, err := strconv.ParseInt(spec, 10, 64)
+		if err != nil {
+			return Baseline{}, fmt.Errorf("-baseline must be auto or a history ID, not %q", spec)
+		}
+		record, err := store.Record(id)
+		if err != nil {
+			return Baseline{}, err
+		}
+		// a phase the record has no measurement of has a zero baseline,
+		// which isn't checked
+		server := record.serverResult()
+		return Baseline{Runs: 1, DownloadMbps: server.DownloadMbps, UploadMbps: server.UploadMbps}, nil
+	}
+	records, err := store.Records(time.Time{}, time.Time{})
+	if err != nil {
+		return Baseline{}, err
+	}
+	var down, up []float64
+	recent := recentRuns(records, runs)
+	for _, run := range recent {
+		if !run.lacks("download") {
+			down = append(down, run.DownloadMbps)
+		}
+		if !run.lacks("upload") {
+			up = append(up, run.UploadMbps)
+		}
+	}
+	if len(recent) == 0 {
+		return Baseline{}, errors.New("no runs in the history to compare with; store some with -db first")
+	}
+	// a phase none of the runs measured well enough has a zero baseline,
+	// which isn't checked
+	b := Baseline{Runs: len(recent)}
+	b.DownloadMbps, _ = fastcom.CalcPercentile(down, 50)
+	b.UploadMbps, _ = fastcom.CalcPercentile(up, 50)
+	return b, nil
+}
+
+// Regressions describes the speeds in s that are more than dropPercent below
+// the baseline, and returns the assertion bits of those that are. Phases
+// skipped in cfg or with insufficient data, or without a speed in the
+// baseline, aren't checked.
+func (b Baseline) Regressions(cfg Config, s Summary, dropPercent float64) (regressions []string, failed int) {
+	check := func(name string, v, base float64, skip bool, bit int) {
+		if skip || base <= 0 || v >= base*(1-dropPercent/100) {
+			return
+		}
+		regressions = append(regressions, fmt.Sprintf("%s %0.1f Mbit/s is %0.1f%% below the baseline of %0.1f Mbit/s",
+			name, v, (base-v)/base*100, base))
+		failed |= bit
+	}
+	check("download", s.DownloadMbps, b.DownloadMbps, cfg.SkipDownload || s.lacks("download"), AssertDownloadFail)
+	check("upload", s.UploadMbps, b.UploadMbps, cfg.SkipUpload || s.lacks("upload"), AssertUploadFail)
+	return regressions, failed
+}
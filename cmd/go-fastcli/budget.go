@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Relative share of the -max-total-time budget each phase gets per server.
+// Transfers need longer than latency samples to stabilize.
+const (
+	latencyWeight  = 1
+	transferWeight = 3
+)
+
+// timeBudget splits what is left of a wall-clock budget between the phases
+// still to run, in proportion to their weights. Time a phase doesn't use
+// carries over to the later ones.
+type timeBudget struct {
+	deadline time.Time
+	weight   int
+}
+
+// phase returns a context that expires when the phase has used up its share.
+// Without a budget, ctx is returned unchanged.
+func (b *timeBudget) phase(ctx context.Context, weight int) (context.Context, context.CancelFunc) {
+	if b.deadline.IsZero() || b.weight <= 0 {
+		return ctx, func() {}
+	}
+	share := time.Until(b.deadline) * time.Duration(weight) / time.Duration(b.weight)
+	b.weight -= weight
+	return context.WithTimeout(ctx, share)
+}
+
+// explain points at the budget when a phase couldn't finish a single
+// measurement within its share.
+func (b *timeBudget) explain(err error) error {
+	if !b.deadline.IsZero() && errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("-max-total-time is too short to finish a measurement: %w", err)
+	}
+	return err
+}
+
+// overrunGrace is how long a test may go on after -max-total-time for its
+// requests to return once their context has ended.
+const overrunGrace = 10 * time.Second
+
+// runWithinBudget runs RunTest, but stops it if it is still running
+// overrunGrace after -max-total-time, which the budget's context alone can't
+// promise should a request be stuck where cancelling doesn't reach: the
+// test's context is cancelled and the client's connections are closed under
+// it. It returns once the test has, so that the next run of -watch or serve
+// doesn't share the client, the progress output or the samples file with
+// it; the result of a stopped test is dropped.
+func runWithinBudget(ctx context.Context, cfg Config) (Result, error) {
+	if cfg.MaxTotalTime <= 0 {
+		return RunTest(ctx, cfg)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type outcome struct {
+		result Result
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := RunTest(ctx, cfg)
+		done <- outcome{result, err}
+	}()
+	timer := time.NewTimer(cfg.MaxTotalTime + overrunGrace)
+	defer timer.Stop()
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-timer.C:
+	}
+	cancel()
+	client.AbortConnections()
+	<-done
+	return Result{}, fmt.Errorf("the test was still running %v after -max-total-time ran out, stopped it", overrunGrace)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// burstSampleInterval is how finely the throughput of a burst and the
+// latency around it are sampled.
+const burstSampleInterval = 100 * time.Millisecond
+
+// burstRampRatio is the share of a burst's peak rate that counts as having
+// ramped up.
+const burstRampRatio = 0.9
+
+// BurstConfig shapes a burst test.
+type BurstConfig struct {
+	Burst    time.Duration
+	Idle     time.Duration
+	Duration time.Duration
+	// Directions are the directions of the bursts in turn, "download" and
+	// "upload".
+	Directions []string
+	// RecoveredWithin is how close to the idle latency counts as recovered.
+	RecoveredWithin time.Duration
+}
+
+// BurstCycle is one burst of load and the idle gap after it.
+type BurstCycle struct {
+	Direction string
+	Mbps      float64
+	PeakMbps  float64
+	// RampMs is the time from the start of the burst until its rate first
+	// reached 90% of the peak.
+	RampMs float64
+	// PeakLatencyMs is the highest latency sampled during the burst.
+	PeakLatencyMs float64
+	// RecoveryMs is the time from the end of the burst until the latency
+	// was back within RecoveredWithin of the idle latency. Recovered is
+	// false if it wasn't before the next burst.
+	RecoveryMs float64
+	Recovered  bool
+
+	start, end time.Duration
+}
+
+// BurstResult is the outcome of a burst test against one server.
+type BurstResult struct {
+	Server        string
+	IdleLatencyMs float64
+	Cycles        []BurstCycle
+}
+
+// RunBurstTest alternates bursts of load at full speed with idle gaps for
+// bcfg.Duration, while sampling latency on new connections throughout, and
+// reports how quickly each burst ramped up and how quickly latency
+// recovered after it. Short bursts with pauses are closer to browsing and
+// interactive use than the sustained load of the regular test. The server
+// and idle latency come from a latency test first.
+func RunBurstTest(ctx context.Context, cfg Config, bcfg BurstConfig, w io.Writer) (BurstResult, error) {
+	saved := out.Progress
+	out.Progress = io.Discard
+	defer func() { out.Progress = saved }()
+
+	fmt.Fprintln(w, "Measuring idle latency...")
+	cfg.SkipDownload, cfg.SkipUpload = true, true
+	result, err := RunTest(ctx, cfg)
+	if err != nil {
+		return BurstResult{}, err
+	}
+	server := result.Servers[0]
+	idle, err := fastcom.CalcPercentile(server.LatencySamplesMs, 50)
+	if err != nil {
+		idle = server.LatencyMs
+	}
+	r := BurstResult{Server: server.Host, IdleLatencyMs: idle}
+
+	cycles := int(bcfg.Duration / (bcfg.Burst + bcfg.Idle))
+	if cycles < 1 {
+		cycles = 1
+	}
+	fmt.Fprintf(w, "Running %d bursts of %v with %v idle against %s (%0.1f ms idle latency)...\n", cycles, bcfg.Burst, bcfg.Idle, server.Host, idle)
+	// the probes are kept as they arrive, so each cycle can be reported
+	// once its idle gap is over
+	var mu sync.Mutex
+	var probes []fastcom.LatencyProbe
+	prober := &fastcom.LatencyProber{Client: client, URL: server.URL, Interval: burstSampleInterval, OnProbe: func(probe fastcom.LatencyProbe) {
+		mu.Lock()
+		probes = append(probes, probe)
+		mu.Unlock()
+	}}
+	prober.Start(ctx)
+	defer prober.Stop()
+	recovered := idle + float64(bcfg.RecoveredWithin)/float64(time.Millisecond)
+	for i := 0; i < cycles; i++ {
+		c := BurstCycle{Direction: bcfg.Directions[i%len(bcfg.Directions)]}
+		c.start = time.Since(prober.Origin)
+		burst, err := client.MeasureBurst(ctx, server.URL, c.Direction == "upload", bcfg.Burst, burstSampleInterval)
+		c.end = time.Since(prober.Origin)
+		if err != nil {
+			return r, err
+		}
+		c.Mbps = burst.Mbps
+		for _, mbps := range burst.SamplesMbps {
+			if mbps > c.PeakMbps {
+				c.PeakMbps = mbps
+			}
+		}
+		for j, mbps := range burst.SamplesMbps {
+			if mbps >= c.PeakMbps*burstRampRatio {
+				c.RampMs = float64(j+1) * float64(burstSampleInterval) / float64(time.Millisecond)
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return r, ctx.Err()
+		case <-time.After(bcfg.Idle):
+		}
+		mu.Lock()
+		c.analyzeLatency(probes, time.Since(prober.Origin), recovered)
+		mu.Unlock()
+		r.Cycles = append(r.Cycles, c)
+		fmt.Fprintf(w, "  %2d %-8s  %0.1f Mbit/s, %0.0f%% of peak after %0.0f ms, latency up to %0.1f ms, %s\n",
+			i+1, c.Direction, c.Mbps, burstRampRatio*100, c.RampMs, c.PeakLatencyMs, c.recovery())
+	}
+	return r, nil
+}
+
+// analyzeLatency finds the highest latency during the burst, and the
+// first probe after it, sent before next, that was back below recovered.
+func (c *BurstCycle) analyzeLatency(probes []fastcom.LatencyProbe, next time.Duration, recovered float64) {
+	first := next
+	for _, probe := range probes {
+		ms := float64(probe.Latency) / float64(time.Millisecond)
+		switch {
+		case probe.At >= c.start && probe.At < c.end:
+			if ms > c.PeakLatencyMs {
+				c.PeakLatencyMs = ms
+			}
+		case probe.At >= c.end && probe.At < first && ms <= recovered:
+			// probes finish out of order, so the earliest one is kept
+			first = probe.At
+			c.Recovered = true
+			c.RecoveryMs = float64(probe.At-c.end) / float64(time.Millisecond)
+		}
+	}
+}
+
+func (c BurstCycle) recovery() string {
+	if !c.Recovered {
+		return "not recovered before the next burst"
+	}
+	return fmt.Sprintf("recovered after %0.0f ms", c.RecoveryMs)
+}
+
+// WriteBurstReport summarizes the cycles of each direction with medians,
+// which a burst cut short by a hiccup doesn't skew.
+func WriteBurstReport(w io.Writer, r BurstResult, bcfg BurstConfig) {
+	median := func(values []float64) float64 {
+		m, _ := fastcom.CalcPercentile(values, 50)
+		return m
+	}
+	for _, direction := range []string{"download", "upload"} {
+		var mbps, ramp, peak, recovery []float64
+		n := 0
+		for _, c := range r.Cycles {
+			if c.Direction != direction {
+				continue
+			}
+			n++
+			mbps, ramp, peak = append(mbps, c.Mbps), append(ramp, c.RampMs), append(peak, c.PeakLatencyMs)
+			if c.Recovered {
+				recovery = append(recovery, c.RecoveryMs)
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%-9s %0.1f Mbit/s per burst, %0.0f%% of peak after %0.0f ms\n",
+			direction+":", median(mbps), burstRampRatio*100, median(ramp))
+		fmt.Fprintf(w, "%-9s latency peaked at %0.1f ms, %0.1f ms idle", "", median(peak), r.IdleLatencyMs)
+		if len(recovery) > 0 {
+			fmt.Fprintf(w, ", back within %v after %0.0f ms", bcfg.RecoveredWithin, median(recovery))
+		}
+		if len(recovery) < n {
+			fmt.Fprintf(w, "; %d of %d bursts didn't recover within %v", n-len(recovery), n, bcfg.Idle)
+		}
+		fmt.Fprintln(w)
+	}
+}
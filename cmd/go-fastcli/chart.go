@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Chart layout in pixels, shared by the SVG and PNG renderings.
+const (
+	chartWidth  = 800
+	chartHeight = 400
+	chartLeft   = 70
+	chartRight  = 20
+	chartTop    = 30
+	chartBottom = 40
+)
+
+var (
+	downloadColors = []color.RGBA{{31, 119, 180, 255}, {44, 160, 44, 255}, {148, 103, 189, 255}}
+	uploadColors   = []color.RGBA{{255, 127, 14, 255}, {214, 39, 40, 255}, {140, 86, 75, 255}}
+	chartGrid      = color.RGBA{220, 220, 220, 255}
+	chartInk       = color.RGBA{0, 0, 0, 255}
+)
+
+// chartSeries is the speed of every second of one phase on one server.
+type chartSeries struct {
+	Label string
+	Color color.RGBA
+	// Mbps[i] is the speed in second i+1 of the phase.
+	Mbps []float64
+}
+
+// ValidChartFile reports whether path names a chart format WriteChart can
+// render.
+func ValidChartFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".svg", ".png":
+		return true
+	}
+	return false
+}
+
+// WriteChart draws the per-second download and upload speeds of samples, one
+// line per phase and server over the seconds of the phase, as SVG or PNG
+// depending on the extension of path.
+func WriteChart(path string, samples []Sample) error {
+	series := chartData(samples)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if strings.ToLower(filepath.Ext(path)) == ".png" {
+		err = renderPNG(w, series)
+	} else {
+		err = renderSVG(w, series)
+	}
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func chartData(samples []Sample) []chartSeries {
+	var series []chartSeries
+	var seriesHost []int
+	index := map[string]int{}
+	var hosts []string
+	for _, sample := range samples {
+		key := sample.Phase + " " + sample.Host
+		i, ok := index[key]
+		if !ok {
+			n := 0
+			for n < len(hosts) && hosts[n] != sample.Host {
+				n++
+			}
+			if n == len(hosts) {
+				hosts = append(hosts, sample.Host)
+			}
+			colors, label := downloadColors, "Download"
+			if sample.Phase == "upload" {
+				colors, label = uploadColors, "Upload"
+			}
+			i = len(series)
+			index[key] = i
+			series = append(series, chartSeries{Label: label, Color: colors[n%len(colors)]})
+			seriesHost = append(seriesHost, n)
+		}
+		for len(series[i].Mbps) < sample.Second {
+			series[i].Mbps = append(series[i].Mbps, 0)
+		}
+		series[i].Mbps[sample.Second-1] = float64(sample.Bytes) / 125000
+	}
+	if len(hosts) > 1 {
+		// tell the servers apart by their position in the report
+		for i, n := range seriesHost {
+			series[i].Label += fmt.Sprintf(" %d (%s)", n+1, hosts[n])
+		}
+	}
+	return series
+}
+
+// chartScale picks round axis limits and tick steps for the series.
+func chartScale(series []chartSeries) (seconds, secondStep int, maxMbps, mbpsStep float64) {
+	for _, s := range series {
+		if len(s.Mbps) > seconds {
+			seconds = len(s.Mbps)
+		}
+		for _, v := range s.Mbps {
+			maxMbps = math.Max(maxMbps, v)
+		}
+	}
+	if seconds < 1 {
+		seconds = 1
+	}
+	secondStep = int(niceStep(float64(seconds) / 10))
+	if secondStep < 1 {
+		secondStep = 1
+	}
+	seconds = (seconds + secondStep - 1) / secondStep * secondStep
+	if maxMbps <= 0 {
+		maxMbps = 1
+	}
+	mbpsStep = niceStep(maxMbps / 5)
+	maxMbps = math.Ceil(maxMbps/mbpsStep) * mbpsStep
+	return seconds, secondStep, maxMbps, mbpsStep
+}
+
+// niceStep rounds v up to 1, 2 or 5 times a power of ten.
+func niceStep(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*magnitude {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// mbpsLabel formats a tick at v with as many decimals as the step needs.
+func mbpsLabel(v, step float64) string {
+	decimals := int(math.Max(0, -math.Floor(math.Log10(step))))
+	return strconv.FormatFloat(v, 'f', decimals, 64)
+}
+
+// chartPoint maps a second and speed to pixel coordinates.
+func chartPoint(second, mbps float64, seconds int, maxMbps float64) (x, y float64) {
+	plotWidth := float64(chartWidth - chartLeft - chartRight)
+	plotHeight := float64(chartHeight - chartTop - chartBottom)
+	return chartLeft + second/float64(seconds)*plotWidth, chartTop + (1-mbps/maxMbps)*plotHeight
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func renderSVG(w io.Writer, series []chartSeries) error {
+	seconds, secondStep, maxMbps, mbpsStep := chartScale(series)
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`+"\n",
+		chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(w, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+	for i := 0; float64(i)*mbpsStep <= maxMbps+mbpsStep/2; i++ {
+		v := float64(i) * mbpsStep
+		x0, y := chartPoint(0, v, seconds, maxMbps)
+		x1, _ := chartPoint(float64(seconds), v, seconds, maxMbps)
+		fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s"/>`+"\n", x0, y, x1, y, svgColor(chartGrid))
+		fmt.Fprintf(w, `<text x="%.1f" y="%.1f" text-anchor="end">%s</text>`+"\n", x0-6, y+4, mbpsLabel(v, mbpsStep))
+	}
+	for s := 0; s <= seconds; s += secondStep {
+		x, y := chartPoint(float64(s), 0, seconds, maxMbps)
+		fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s"/>`+"\n", x, y, x, y+5, svgColor(chartInk))
+		fmt.Fprintf(w, `<text x="%.1f" y="%.1f" text-anchor="middle">%d</text>`+"\n", x, y+18, s)
+	}
+	fmt.Fprintf(w, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n", chartLeft, chartHeight-chartBottom, chartWidth-chartRight, chartHeight-chartBottom)
+	fmt.Fprintf(w, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="black"/>`+"\n", chartLeft, chartTop, chartLeft, chartHeight-chartBottom)
+	fmt.Fprintf(w, `<text x="%d" y="%d" text-anchor="end">seconds</text>`+"\n", chartWidth-chartRight, chartHeight-4)
+	fmt.Fprintf(w, `<text x="14" y="%d" text-anchor="middle" transform="rotate(-90 14 %d)">Mbit/s</text>`+"\n", chartHeight/2, chartHeight/2)
+
+	x := chartLeft + 60
+	for _, s := range series {
+		points := make([]string, len(s.Mbps))
+		for n, v := range s.Mbps {
+			x, y := chartPoint(float64(n+1), v, seconds, maxMbps)
+			points[n] = fmt.Sprintf("%.1f,%.1f", x, y)
+		}
+		fmt.Fprintf(w, `<polyline fill="none" stroke="%s" stroke-width="2" points="%s"/>`+"\n", svgColor(s.Color), strings.Join(points, " "))
+		fmt.Fprintf(w, `<rect x="%d" y="10" width="12" height="4" fill="%s"/>`+"\n", x, svgColor(s.Color))
+		fmt.Fprintf(w, `<text x="%d" y="16">%s</text>`+"\n", x+18, svgEscape(s.Label))
+		x += legendWidth(s.Label)
+	}
+	_, err := fmt.Fprintln(w, "</svg>")
+	return err
+}
+
+// legendWidth is the room a legend entry takes in the top margin, where the
+// entries are laid out in a row above the plot.
+func legendWidth(label string) int {
+	return 18 + textWidth(label) + 16
+}
+
+func svgEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func renderPNG(w io.Writer, series []chartSeries) error {
+	seconds, secondStep, maxMbps, mbpsStep := chartScale(series)
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	line := func(x0, y0, x1, y1 float64, c color.RGBA, width int) {
+		steps := int(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))) + 1
+		for i := 0; i <= steps; i++ {
+			t := float64(i) / float64(steps)
+			x := int(math.Round(x0 + (x1-x0)*t))
+			y := int(math.Round(y0 + (y1-y0)*t))
+			for dx := 0; dx < width; dx++ {
+				for dy := 0; dy < width; dy++ {
+					img.SetRGBA(x+dx, y+dy, c)
+				}
+			}
+		}
+	}
+
+	for i := 0; float64(i)*mbpsStep <= maxMbps+mbpsStep/2; i++ {
+		v := float64(i) * mbpsStep
+		x0, y := chartPoint(0, v, seconds, maxMbps)
+		x1, _ := chartPoint(float64(seconds), v, seconds, maxMbps)
+		line(x0, y, x1, y, chartGrid, 1)
+		label := mbpsLabel(v, mbpsStep)
+		drawText(img, int(x0)-6-textWidth(label), int(y)-glyphHeight, label, chartInk)
+	}
+	for s := 0; s <= seconds; s += secondStep {
+		x, y := chartPoint(float64(s), 0, seconds, maxMbps)
+		line(x, y, x, y+5, chartInk, 1)
+		label := fmt.Sprint(s)
+		drawText(img, int(x)-textWidth(label)/2, int(y)+8, label, chartInk)
+	}
+	line(chartLeft, chartHeight-chartBottom, chartWidth-chartRight, chartHeight-chartBottom, chartInk, 1)
+	line(chartLeft, chartTop, chartLeft, chartHeight-chartBottom, chartInk, 1)
+	drawText(img, chartWidth-chartRight-textWidth("SECONDS"), chartHeight-glyphHeight-4, "SECONDS", chartInk)
+	drawText(img, 4, 8, "MBIT/S", chartInk)
+
+	x := chartLeft + 60
+	for _, s := range series {
+		for n := 1; n < len(s.Mbps); n++ {
+			x0, y0 := chartPoint(float64(n), s.Mbps[n-1], seconds, maxMbps)
+			x1, y1 := chartPoint(float64(n+1), s.Mbps[n], seconds, maxMbps)
+			line(x0, y0, x1, y1, s.Color, 2)
+		}
+		line(float64(x), 11, float64(x+12), 11, s.Color, 3)
+		drawText(img, x+18, 7, strings.ToUpper(s.Label), chartInk)
+		x += legendWidth(s.Label)
+	}
+	return png.Encode(w, img)
+}
+
+// The PNG chart's labels use a 3x5 pixel font, scaled up, for the few
+// characters they need; the standard library has no fonts.
+const (
+	glyphScale   = 2
+	glyphHeight  = 5 * glyphScale
+	glyphAdvance = 4 * glyphScale
+)
+
+var glyphs = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", "..#", "..#", "..#"},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	'.': {"...", "...", "...", "...", ".#."},
+	'/': {"..#", "..#", ".#.", "#..", "#.."},
+	'(': {".#.", "#..", "#..", "#..", ".#."},
+	')': {".#.", "..#", "..#", "..#", ".#."},
+	':': {"...", ".#.", "...", ".#.", "..."},
+	'-': {"...", "...", "###", "...", "..."},
+	'A': {".#.", "#.#", "###", "#.#", "#.#"},
+	'B': {"##.", "#.#", "##.", "#.#", "##."},
+	'C': {"###", "#..", "#..", "#..", "###"},
+	'D': {"##.", "#.#", "#.#", "#.#", "##."},
+	'E': {"###", "#..", "###", "#..", "###"},
+	'F': {"###", "#..", "###", "#..", "#.."},
+	'G': {"###", "#..", "#.#", "#.#", "###"},
+	'H': {"#.#", "#.#", "###", "#.#", "#.#"},
+	'I': {"###", ".#.", ".#.", ".#.", "###"},
+	'J': {"..#", "..#", "..#", "#.#", "###"},
+	'K': {"#.#", "#.#", "##.", "#.#", "#.#"},
+	'L': {"#..", "#..", "#..", "#..", "###"},
+	'M': {"#.#", "###", "###", "#.#", "#.#"},
+	'N': {"##.", "#.#", "#.#", "#.#", "#.#"},
+	'O': {"###", "#.#", "#.#", "#.#", "###"},
+	'P': {"##.", "#.#", "##.", "#..", "#.."},
+	'Q': {"###", "#.#", "#.#", "###", "..#"},
+	'R': {"##.", "#.#", "##.", "#.#", "#.#"},
+	'S': {"###", "#..", "###", "..#", "###"},
+	'T': {"###", ".#.", ".#.", ".#.", ".#."},
+	'U': {"#.#", "#.#", "#.#", "#.#", "###"},
+	'V': {"#.#", "#.#", "#.#", "#.#", ".#."},
+	'W': {"#.#", "#.#", "#.#", "###", "#.#"},
+	'X': {"#.#", "#.#", ".#.", "#.#", "#.#"},
+	'Y': {"#.#", "#.#", ".#.", ".#.", ".#."},
+	'Z': {"###", "..#", ".#.", "#..", "###"},
+}
+
+func textWidth(s string) int {
+	return len([]rune(s)) * glyphAdvance
+}
+
+// drawText draws s with its top left corner at x, y. Characters without a
+// glyph are left blank.
+func drawText(img *image.RGBA, x, y int, s string, c color.RGBA) {
+	for _, r := range s {
+		g := glyphs[r]
+		for row, bits := range g {
+			for col, bit := range bits {
+				if bit != '#' {
+					continue
+				}
+				for dx := 0; dx < glyphScale; dx++ {
+					for dy := 0; dy < glyphScale; dy++ {
+						img.SetRGBA(x+col*glyphScale+dx, y+row*glyphScale+dy, c)
+					}
+				}
+			}
+		}
+		x += glyphAdvance
+	}
+}
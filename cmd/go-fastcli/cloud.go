@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cloudMetadataTimeout bounds the lookup of the instance metadata. Off a
+// cloud instance, the link-local address doesn't answer at all, so the
+// lookup takes this long; it runs alongside the test.
+const cloudMetadataTimeout = 2 * time.Second
+
+// The metadata services, which are only reachable from the instance.
+const (
+	ec2MetadataURL   = "http://169.254.169.254/latest"
+	gceMetadataURL   = "http://metadata.google.internal/computeMetadata/v1/instance"
+	azureMetadataURL = "http://169.254.169.254/metadata/instance/compute?api-version=2021-02-01"
+)
+
+// CloudInfo describes the cloud instance a test ran on.
+type CloudInfo struct {
+	// Provider is aws, gcp or azure.
+	Provider     string
+	InstanceType string
+	Region       string
+	Zone         string `json:",omitempty"`
+}
+
+func (c CloudInfo) String() string {
+	s := fmt.Sprintf("%s %s in %s", c.Provider, c.InstanceType, c.Region)
+	if c.Zone != "" && c.Zone != c.Region {
+		s += fmt.Sprintf(" (%s)", c.Zone)
+	}
+	return s
+}
+
+// metadataClient reaches the metadata services directly, never through a
+// proxy.
+var metadataClient = &http.Client{Transport: &http.Transport{Proxy: nil}}
+
+// LookupCloudInfo asks the EC2, GCE and Azure metadata services at once
+// for the instance the program runs on, and returns the answer of the one
+// that has it.
+func LookupCloudInfo(ctx context.Context) (*CloudInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, cloudMetadataTimeout)
+	defer cancel()
+	lookups := []func(context.Context) (*CloudInfo, error){lookupEC2, lookupGCE, lookupAzure}
+	type answer struct {
+		info *CloudInfo
+		err  error
+	}
+	answers := make(chan answer, len(lookups))
+	for _, lookup := range lookups {
+		go func(lookup func(context.Context) (*CloudInfo, error)) {
+			info, err := lookup(ctx)
+			answers <- answer{info, err}
+		}(lookup)
+	}
+	for range lookups {
+		if a := <-answers; a.err == nil {
+			return a.info, nil
+		}
+	}
+	return nil, errors.New("no cloud metadata service answered")
+}
+
+// getMetadata sends a method request to url with header set, and returns
+// the body of a 200 response.
+func getMetadata(ctx context.Context, method, url string, header map[string]string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// lookupEC2 uses IMDSv2, which needs a session token first.
+func lookupEC2(ctx context.Context) (*CloudInfo, error) {
+	token, err := getMetadata(ctx, "PUT", ec2MetadataURL+"/api/token", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+	if err != nil {
+		return nil, err
+	}
+	header := map[string]string{"X-aws-ec2-metadata-token": token}
+	info := &CloudInfo{Provider: "aws"}
+	for path, dst := range map[string]*string{
+		"/meta-data/instance-type":               &info.InstanceType,
+		"/meta-data/placement/region":            &info.Region,
+		"/meta-data/placement/availability-zone": &info.Zone,
+	} {
+		if *dst, err = getMetadata(ctx, "GET", ec2MetadataURL+path, header); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// lookupGCE reads the machine type and zone, which the service gives as
+// resource paths, e.g. projects/123/zones/us-central1-a.
+func lookupGCE(ctx context.Context) (*CloudInfo, error) {
+	header := map[string]string{"Metadata-Flavor": "Google"}
+	machineType, err := getMetadata(ctx, "GET", gceMetadataURL+"/machine-type", header)
+	if err != nil {
+		return nil, err
+	}
+	zone, err := getMetadata(ctx, "GET", gceMetadataURL+"/zone", header)
+	if err != nil {
+		return nil, err
+	}
+	info := &CloudInfo{Provider: "gcp", InstanceType: lastPathElement(machineType), Zone: lastPathElement(zone)}
+	// a zone is its region with a letter appended
+	info.Region = info.Zone
+	if i := strings.LastIndex(info.Zone, "-"); i > 0 {
+		info.Region = info.Zone[:i]
+	}
+	return info, nil
+}
+
+func lastPathElement(s string) string {
+	return s[strings.LastIndex(s, "/")+1:]
+}
+
+func lookupAzure(ctx context.Context) (*CloudInfo, error) {
+	body, err := getMetadata(ctx, "GET", azureMetadataURL, map[string]string{"Metadata": "true"})
+	if err != nil {
+		return nil, err
+	}
+	var compute struct {
+		VMSize   string `json:"vmSize"`
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+	}
+	if err := json.Unmarshal([]byte(body), &compute); err != nil {
+		return nil, fmt.Errorf("azure metadata: %w", err)
+	}
+	info := &CloudInfo{Provider: "azure", InstanceType: compute.VMSize, Region: compute.Location}
+	// zones are numbered within the region
+	if compute.Zone != "" {
+		info.Zone = compute.Location + "-" + compute.Zone
+	}
+	return info, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCollectedResult bounds the size of a result a probe may submit.
+const maxCollectedResult = 1 << 20
+
+var validPathLabel = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ProbeKey is what a collector API key grants: the tenant a probe belongs to
+// and the labels attached to everything it submits. Probes cannot choose
+// these themselves, so one tenant can never write into another's storage.
+type ProbeKey struct {
+	Tenant string
+	Probe  string
+	Labels map[string]string
+}
+
+type CollectorConfig struct {
+	// Keys maps API keys to the probes they identify.
+	Keys map[string]ProbeKey
+	// PartitionBy lists labels whose values become directories below the
+	// tenant's directory, e.g. ["site"] stores results in <tenant>/<site>/.
+	PartitionBy []string `json:",omitempty"`
+	// RequireVersion, if set, is the range of probe versions whose results
+	// are accepted, e.g. ">=1.4, <2", so the whole fleet measures the
+	// same way. Results from other versions are rejected.
+	RequireVersion string `json:",omitempty"`
+	// Update tells rejected probes where to get a version in the range.
+	Update *UpdateInfo `json:",omitempty"`
+
+	requireVersion VersionConstraint
+}
+
+type CollectedResult struct {
+	Received time.Time
+	Tenant   string
+	Probe    string
+	Labels   map[string]string `json:",omitempty"`
+	Version  string            `json:",omitempty"`
+	Result   json.RawMessage
+}
+
+type Collector struct {
+	Config  CollectorConfig
+	DataDir string
+
+	mu sync.Mutex
+}
+
+func LoadCollectorConfig(path string) (CollectorConfig, error) {
+	var cfg CollectorConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, key := range cfg.Keys {
+		if !validPathLabel.MatchString(key.Tenant) {
+			return cfg, fmt.Errorf("%s: invalid tenant %q", path, key.Tenant)
+		}
+		for _, label := range cfg.PartitionBy {
+			if value, ok := key.Labels[label]; ok && !validPathLabel.MatchString(value) {
+				return cfg, fmt.Errorf("%s: label %s=%q cannot be used as a partition", path, label, value)
+			}
+		}
+	}
+	if cfg.RequireVersion != "" {
+		if cfg.requireVersion, err = ParseVersionConstraint(cfg.RequireVersion); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if cfg.Update != nil && (cfg.Update.URL == "" || !cfg.requireVersion.Allows(cfg.Update.Version)) {
+		return cfg, fmt.Errorf("%s: Update needs a URL and a Version within RequireVersion", path)
+	}
+	return cfg, nil
+}
+
+// VersionMismatchError is what a collector answers, with 409 Conflict, to a
+// probe outside its RequireVersion range.
+type VersionMismatchError struct {
+	Version  string
+	Required string
+	Update   *UpdateInfo `json:",omitempty"`
+}
+
+func (e *VersionMismatchError) Error() string {
+	return fmt.Sprintf("collector requires version %s, this is %s", e.Required, e.Version)
+}
+
+func (c *Collector) lookupKey(r *http.Request) (ProbeKey, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return ProbeKey{}, false
+	}
+	for key, probe := range c.Config.Keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1 {
+			return probe, true
+		}
+	}
+	return ProbeKey{}, false
+}
+
+func (c *Collector) partitionDir(probe ProbeKey) string {
+	dir := filepath.Join(c.DataDir, probe.Tenant)
+	for _, label := range c.Config.PartitionBy {
+		value := probe.Labels[label]
+		if value == "" {
+			value = "_"
+		}
+		dir = filepath.Join(dir, value)
+	}
+	return dir
+}
+
+func (c *Collector) store(record CollectedResult, dir string) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, record.Received.UTC().Format("2006-01-02")+".ndjson")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	probe, ok := c.lookupKey(r)
+	if !ok {
+		http.Error(w, "invalid API key", http.StatusUnauthorized)
+		return
+	}
+	version := r.Header.Get("X-Fastcli-Version")
+	if c.Config.RequireVersion != "" && !c.Config.requireVersion.Allows(version) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(VersionMismatchError{Version: version, Required: c.Config.RequireVersion, Update: c.Config.Update})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCollectedResult+1))
+	if err != nil {
+		http.Error(w, "error reading result", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxCollectedResult {
+		http.Error(w, "result too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var result Result
+	if err := json.Unmarshal(body, &result); err != nil {
+		http.Error(w, "invalid result: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	record := CollectedResult{
+		Received: time.Now(),
+		Tenant:   probe.Tenant,
+		Probe:    probe.Probe,
+		Labels:   probe.Labels,
+		Version:  version,
+		Result:   body,
+	}
+	if err := c.store(record, c.partitionDir(probe)); err != nil {
+		fmt.Fprintln(out.Log, "Error storing result:", err)
+		http.Error(w, "error storing result", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SubmitResult sends a result to a collector started with "go-fastcli collect".
+func SubmitResult(ctx context.Context, collectorURL, key string, result Result) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(collectorURL, "/")+"/v1/results", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("User-Agent", "go-fastcli/"+appVersion())
+	req.Header.Set("X-Fastcli-Version", appVersion())
+	resp, err := sinkClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		var mismatch VersionMismatchError
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&mismatch); err == nil && mismatch.Required != "" {
+			return &mismatch
+		}
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.New("collector returned " + resp.Status + ": " + strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ANSI escapes of the colored text report.
+const (
+	ansiReset  = "\033[0m"
+	ansiBold   = "\033[1m"
+	ansiRed    = "\033[31m"
+	ansiGreen  = "\033[32m"
+	ansiYellow = "\033[33m"
+	ansiCyan   = "\033[36m"
+)
+
+// Colors is how the text report is colored. Speeds and latency are green,
+// yellow or red by the -w and -c thresholds, or, for speeds without them, by
+// the share of the -plan-down and -plan-up speeds they reach.
+type Colors struct {
+	Enabled bool
+	Warn    NagiosThresholds
+	Crit    NagiosThresholds
+}
+
+// UseColor decides on colors for mode, which is "auto", "always" or "never".
+// auto colors a terminal report, unless the NO_COLOR environment variable is
+// set (https://no-color.org) or TERM is dumb.
+func UseColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		// switches on escapes on a Windows console
+		ansiTerminal(out.Progress)
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && ansiTerminal(out.Progress), nil
+	}
+	return false, fmt.Errorf("unknown -color %q, expected auto, always or never", mode)
+}
+
+func (c Colors) paint(color, s string) string {
+	if !c.Enabled || color == "" {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// Header colors the title of a report section.
+func (c Colors) Header(title string) string {
+	return c.paint(ansiBold+ansiCyan, title)
+}
+
+// Speed formats mbps of the download or upload phase, colored by how it
+// compares with the thresholds, or else the plan speed, if there is one.
+func (c Colors) Speed(phase string, mbps, planMbps float64) string {
+	warn, crit := c.Warn.DownloadMbps, c.Crit.DownloadMbps
+	if phase == "upload" {
+		warn, crit = c.Warn.UploadMbps, c.Crit.UploadMbps
+	}
+	var color string
+	switch {
+	case warn > 0 || crit > 0:
+		color = ansiGreen
+		if warn > 0 && mbps < warn {
+			color = ansiYellow
+		}
+		if crit > 0 && mbps < crit {
+			color = ansiRed
+		}
+	case planMbps > 0:
+		switch percent := mbps / planMbps * 100; {
+		case percent < 50:
+			color = ansiRed
+		case percent < 80:
+			color = ansiYellow
+		default:
+			color = ansiGreen
+		}
+	}
+	return c.paint(color, fmt.Sprintf("%0.3f Mbit/s", mbps))
+}
+
+// Latency formats ms, colored by how it compares with the thresholds.
+func (c Colors) Latency(ms float64) string {
+	var color string
+	if c.Warn.LatencyMs > 0 || c.Crit.LatencyMs > 0 {
+		color = ansiGreen
+		if c.Warn.LatencyMs > 0 && ms > c.Warn.LatencyMs {
+			color = ansiYellow
+		}
+		if c.Crit.LatencyMs > 0 && ms > c.Crit.LatencyMs {
+			color = ansiRed
+		}
+	}
+	return c.paint(color, fmt.Sprintf("%0.3f ms", ms))
+}
@@ -0,0 +1,741 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// stringList is a flag that may be repeated or given comma-separated values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, strings.Split(value, ",")...)
+	return nil
+}
+
+func (l *stringList) Reset() {
+	*l = nil
+}
+
+func RegisterTestFlags(fs *flag.FlagSet, cfg *Config) {
+	// file with targets to test against instead of querying the API
+	fs.StringVar(&cfg.TargetsFile, "targets-file", "", "test against targets from `file` (fast.com JSON or one URL per line) instead of the fast.com API")
+	// per-second sample export
+	fs.StringVar(&cfg.SamplesFile, "samples-file", "", "write per-second throughput samples as CSV to `file`")
+	fs.StringVar(&cfg.ChartFile, "chart", "", "draw the per-second download and upload speeds to `file`, as .svg or .png")
+	fs.DurationVar(&cfg.LoadedLatencyInterval, "loaded-latency", 0, "probe latency every `interval`, e.g. 250ms, during download and upload, each probe on a new connection")
+	fs.StringVar(&cfg.LatencySamplesFile, "latency-samples-file", "", "write the -loaded-latency probes as CSV to `file`, timed like -samples-file")
+	fs.StringVar(&cfg.NICCounters, "nic-counters", "", "add a per-second series from the OS byte counters of `interface` to the sample export")
+	fs.StringVar(&cfg.DSCP, "dscp", "", "mark test traffic with the DSCP `class`, e.g. EF, AF41, CS1 or a number from 0 to 63")
+	fs.StringVar(&cfg.PAC, "pac", "", "pick proxies with the proxy auto-config script at `url` or path")
+	fs.StringVar(&cfg.NetNS, "netns", "", "test from the network namespace `name` (or path), like ip netns exec (Linux only)")
+	fs.StringVar(&cfg.SourceAddress, "source-address", "", "connect from local `ip`, e.g. a stable instead of a temporary IPv6 address")
+	fs.BoolVar(&cfg.CloudMetadata, "cloud-metadata", false, "look up the instance type, region and zone from the EC2, GCE or Azure metadata service and add them to the result")
+	fs.Var((*stringList)(&cfg.ExpectCountries), "expect-country", "warn that a VPN or proxy likely carries the test when fast.com locates the connection outside the country `code`, e.g. DE (repeatable)")
+	fs.Var((*stringList)(&cfg.IncludeHosts), "include-host", "only test targets whose host name matches the glob `pattern`, e.g. '*.isp.nflxvideo.net' (repeatable)")
+	fs.Var((*stringList)(&cfg.ExcludeHosts), "exclude-host", "never test targets whose host name matches the glob `pattern`, e.g. a cache node known to be broken (repeatable)")
+	fs.StringVar(&cfg.DedupeTargets, "dedupe-targets", DedupeHost, "leave out targets on the same server as an earlier one, by `method`: host name, ip (any shared address) or off")
+	fs.Var((*stringList)(&cfg.Resolve), "resolve", "connect to `host:ip` instead of resolving host, like curl's --resolve (repeatable)")
+	registerTimeoutFlags(fs, cfg)
+	fs.DurationVar(&cfg.MaxTotalTime, "max-total-time", 0, "finish the whole test within `duration`, cutting the phases short if needed")
+	fs.IntVar(&cfg.MinSamples, "min-samples", 3, "report a phase as insufficient data instead of a speed or latency when it took fewer than `n` transfers or latency samples (0 to always report)")
+	fs.BoolVar(&cfg.Sparkline, "sparkline", false, "draw a sparkline of the last 30 seconds of speed during download and upload, when the report goes to a terminal")
+	fs.BoolVar(&cfg.Warm, "warm", false, "open and handshake the connection of each download and upload before its timer starts, so short tests measure the transfer instead of the setup")
+	fs.StringVar(&cfg.Converge, "converge", "", "`criterion` for when to stop measuring: stddev, once the last transfers agree, or ewma, once their moving average levels off (faster on noisy links, so the default for -watch and serve to save data; stddev otherwise)")
+	fs.Float64Var(&cfg.PlanDownMbps, "plan-down", 0, "report download as a percentage of your plan's `Mbit/s`")
+	fs.Float64Var(&cfg.PlanUpMbps, "plan-up", 0, "report upload as a percentage of your plan's `Mbit/s`")
+	fs.StringVar(&cfg.Headline, "headline", HeadlineStable, "how to compute the download and upload `metric`: stable, mean, p90, or trimmed-mean")
+	// cheaper transports for CPU-limited devices
+	fs.BoolVar(&cfg.NoHTTPS, "no-https", false, "ask fast.com for plain HTTP targets instead of HTTPS")
+	fs.BoolVar(&cfg.HTTP1, "http1", false, "use HTTP/1.1 instead of HTTP/2 for HTTPS targets")
+	fs.StringVar(&cfg.TLSCipher, "tls-cipher", "auto", "TLS cipher family: auto, aes-gcm, or chacha20 (faster on CPUs without AES instructions, caps TLS at 1.2)")
+	// result delivery
+	fs.StringVar(&cfg.CollectorURL, "collector-url", "", "submit results to the collector at `url`")
+	SecretVar(fs, &cfg.CollectorKey, "collector-key", "API `key` for -collector-url (a secret)")
+	fs.BoolVar(&cfg.SelfUpdate, "self-update", false, "when -collector-url requires another version, install the one it offers for the next start, if signed by -update-key")
+	fs.Var(updateKey{&cfg.UpdateKey}, "update-key", "base64 Ed25519 public `key` that binaries installed by -self-update must be signed with")
+	// local log, kept even when no sink is configured
+	fs.StringVar(&cfg.Journal, "journal", "", "append a JSON line per run, including failed runs, to `file`")
+	fs.StringVar(&cfg.JournalMaxSize, "journal-max-size", defaultJournalMaxSize, "rotate the journal when it would grow past `size`")
+	fs.IntVar(&cfg.JournalKeep, "journal-keep", defaultJournalKeep, "`number` of rotated journal files to keep")
+	fs.StringVar(&cfg.HistoryDB, "db", "", "store results in the history at `location`, e.g. "+DefaultHistoryDB())
+	fs.StringVar(&cfg.HistoryBackend, "db-backend", "sqlite", "history `backend` for -db: sqlite, postgres (-db is a connection URL) or file")
+	fs.StringVar(&cfg.StatsDAddr, "statsd", "", "send gauges to the StatsD or DogStatsD server at `host:port` over UDP")
+	fs.BoolVar(&cfg.StatsDTags, "statsd-tags", false, "send -statsd gauges per server with DogStatsD tags for the server and IP family")
+	fs.StringVar(&cfg.GraphiteAddr, "graphite", "", "send metrics to the Graphite plaintext listener at `host:port`, e.g. graphite:2003")
+	fs.StringVar(&cfg.GraphitePrefix, "graphite-prefix", defaultGraphitePrefix, "metric path `prefix` for -graphite")
+	SecretVar(fs, &cfg.PushgatewayURL, "pushgateway-url", "push the metrics of the run to the Prometheus Pushgateway at `url` before exiting (a secret)")
+	fs.StringVar(&cfg.PushgatewayJob, "pushgateway-job", defaultPushgatewayJob, "`job` label for -pushgateway-url")
+	fs.StringVar(&cfg.PushgatewayInstance, "pushgateway-instance", "", "`instance` label for -pushgateway-url (default the host name)")
+	SecretVar(fs, &cfg.RemoteWriteURL, "remote-write-url", "push results to a Prometheus remote-write endpoint at `url` (a secret)")
+	SecretVar(fs, &cfg.WebhookURL, "webhook-url", "POST each result as JSON to `url`, retrying on failure (a secret)")
+	fs.StringVar(&cfg.WebhookTemplate, "webhook-template", "", "render the -webhook-url body with the Go template in `file`, or the built-in slack, discord or ntfy one, instead of sending the result JSON")
+	fs.Var(headerValue{&cfg.WebhookHeaders}, "webhook-header", "add the `Name: value` header to -webhook-url requests; the value may be a secret reference (repeatable)")
+	SecretVar(fs, &cfg.SlackWebhook, "slack-webhook", "post a summary of each run, compared with the previous one, to the Slack incoming webhook `url` (a secret)")
+	SecretVar(fs, &cfg.DiscordWebhook, "discord-webhook", "post a summary of each run, compared with the previous one, to the Discord webhook `url` (a secret)")
+	SecretVar(fs, &cfg.NtfyURL, "ntfy-url", "push a summary of each run to the ntfy topic at `url`, e.g. https://ntfy.sh/mytopic (a secret)")
+	SecretVar(fs, &cfg.NtfyToken, "ntfy-token", "access `token` for -ntfy-url (a secret)")
+	fs.StringVar(&cfg.GotifyURL, "gotify-url", "", "push a summary of each run to the Gotify server at `url`, needs -gotify-token")
+	SecretVar(fs, &cfg.GotifyToken, "gotify-token", "Gotify application `token` for -gotify-url (a secret)")
+	fs.Var(thresholdsValue{&cfg.NotifyThresholds}, "notify-thresholds", "only notify when `download/upload/latency` limits are breached, e.g. 100/10/50, and once they recover")
+	fs.Var((*stringList)(&cfg.EmailTo), "email-to", "email a summary of each run, with the results attached as JSON and CSV, to `address` (repeatable)")
+	fs.StringVar(&cfg.EmailFrom, "email-from", "", "sender `address` for -email-to (default go-fastcli@ the host name)")
+	fs.StringVar(&cfg.SMTPServer, "smtp-server", "", "send -email-to through the SMTP server at `host:port`, with implicit TLS on port 465 and STARTTLS elsewhere")
+	fs.StringVar(&cfg.SMTPUser, "smtp-user", "", "`user` to authenticate to -smtp-server as")
+	SecretVar(fs, &cfg.SMTPPassword, "smtp-password", "`password` for -smtp-user (a secret)")
+	fs.StringVar(&cfg.NotifyState, "notify-state", DefaultNotifyState(), "`file` keeping the previous run to compare notifications with")
+	fs.Var(logLevelFlag(LevelInfo), "verbose", "log every HTTP request with its status and duration, and every retry, to stderr")
+	fs.Var(logLevelFlag(LevelDebug), "debug", "like -verbose, adding each request's connection and the times of its lookup, connect, TLS handshake and first byte")
+}
+
+// registerTimeoutFlags adds -request-timeout and -stall-timeout, which the
+// commands with flags of their own share with RegisterTestFlags.
+func registerTimeoutFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.DurationVar(&cfg.RequestTimeout, "request-timeout", fastcom.DefaultRequestTimeout, "give up on a request without a payload, such as the fast.com API request or a latency sample, after `duration` (0 to wait indefinitely)")
+	fs.DurationVar(&cfg.StallTimeout, "stall-timeout", fastcom.DefaultStallTimeout, "abort a download or upload that moved no data for `duration` (0 to wait indefinitely)")
+}
+
+func runServe(args []string) int {
+	var cfg Config
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	RegisterTestFlags(fs, &cfg)
+	interval := fs.Duration("interval", time.Hour, "time between scheduled runs")
+	controlSocket := fs.String("control-socket", DefaultControlSocket(), "unix `socket` for the status and trigger commands")
+	listen := fs.String("listen", "", "serve Prometheus metrics at /metrics and the REST API at /v1/ on `address`")
+	var apiToken string
+	SecretVar(fs, &apiToken, "api-token", "require this bearer `token` for the REST API and gRPC (a secret)")
+	grpcListen := fs.String("grpc-listen", "", "serve the gRPC service from proto/fastcli.proto on `address`, needs -grpc-cert and -grpc-key")
+	grpcCert := fs.String("grpc-cert", "", "TLS certificate `file` for -grpc-listen")
+	grpcKey := fs.String("grpc-key", "", "TLS key `file` for -grpc-listen")
+	traceRoutes := fs.Bool("trace-routes", false, "trace the route to each server after every run and flag runs where it changed (Linux only)")
+	emailEvery := fs.Duration("email-every", 0, "with -email-to, email one report of the runs every `duration`, e.g. 168h, instead of one per run")
+	anomalyRuns := fs.Int("anomaly-runs", 0, "flag runs that are unusual for the previous `number` of runs in the -db history, e.g. 30")
+	anomalyThreshold := fs.Float64("anomaly-threshold", 3.5, "modified z-score `limit` beyond which -anomaly-runs flags a measurement")
+	memoryLimit := fs.String("gomemlimit", "", "soft memory `limit` for the Go runtime, e.g. 128MiB (overrides GOMEMLIMIT)")
+	shutdownGrace := fs.Duration("shutdown-grace", defaultShutdownGrace, "on SIGTERM or interrupt, give the run in progress, the HTTP servers and the pending -email-every report up to `duration` to finish")
+	logFile := registerLogFileFlags(fs)
+	ParseFlags(fs, args)
+
+	systemLog, closeLog, err := logFile.open()
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
+	}
+	defer closeLog()
+	if err := ApplyMemoryTuning(*memoryLimit); err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
+	}
+	if *grpcListen != "" && (*grpcCert == "" || *grpcKey == "") {
+		// gRPC needs HTTP/2, which net/http only speaks over TLS
+		fmt.Fprintln(out.Log, "serve: -grpc-listen needs -grpc-cert and -grpc-key")
+		return 2
+	}
+	if cfg.PushgatewayURL != "" {
+		fmt.Fprintln(out.Log, "serve: -pushgateway-url is for single runs; scrape the daemon with -listen instead")
+		return 2
+	}
+	if *anomalyRuns > 0 && (cfg.HistoryDB == "" || *anomalyThreshold <= 0) {
+		fmt.Fprintln(out.Log, "serve: -anomaly-runs needs -db and a positive -anomaly-threshold")
+		return 2
+	}
+
+	// monitoring adds up to a lot of data over a month; ewma settles on
+	// fewer transfers than stddev
+	if cfg.Converge == "" {
+		cfg.Converge = fastcom.ConvergeEWMA
+	}
+	d := NewDaemon(cfg, *interval)
+	d.TraceRoutes = *traceRoutes
+	d.EmailEvery = *emailEvery
+	d.AnomalyRuns, d.AnomalyThreshold = *anomalyRuns, *anomalyThreshold
+	d.ShutdownGrace = *shutdownGrace
+	d.SystemLog = systemLog
+	if err := ServeControl(*controlSocket, d); err != nil {
+		fmt.Fprintln(out.Log, "Error listening on control socket:", err)
+		return 1
+	}
+	defer os.Remove(*controlSocket)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var servers []*http.Server
+	if *listen != "" {
+		http.Handle("/metrics", d)
+		http.Handle("/v1/", d.APIHandler(apiToken))
+		server := &http.Server{Addr: *listen}
+		servers = append(servers, server)
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Fprintln(out.Log, "Error serving HTTP:", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	if *grpcListen != "" {
+		server := &http.Server{Addr: *grpcListen, Handler: d.GRPCHandler(apiToken)}
+		servers = append(servers, server)
+		go func() {
+			if err := server.ListenAndServeTLS(*grpcCert, *grpcKey); err != nil && err != http.ErrServerClosed {
+				fmt.Fprintln(out.Log, "Error serving gRPC:", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	d.Run(ctx)
+
+	// the run in progress ended with ctx; the rest shares the grace period
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownGrace)
+	defer cancel()
+	for _, server := range servers {
+		server.Shutdown(shutdownCtx)
+	}
+	d.Shutdown(shutdownCtx)
+	// the sinks share http.DefaultTransport
+	sinkClient.CloseIdleConnections()
+	closeConnections()
+	return 0
+}
+
+func runNetdata(args []string) int {
+	var cfg Config
+	fs := flag.NewFlagSet("netdata", flag.ExitOnError)
+	RegisterTestFlags(fs, &cfg)
+	interval := fs.Duration("interval", time.Hour, "time between runs")
+	logFile := registerLogFileFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go-fastcli netdata [flags] [update_every]")
+		fmt.Fprintln(fs.Output(), "Netdata starts the plugin with update_every, the seconds between chart updates (default 1).")
+		fs.PrintDefaults()
+	}
+	ParseFlags(fs, args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	updateEvery := time.Second
+	if fs.NArg() == 1 {
+		seconds, err := strconv.Atoi(fs.Arg(0))
+		if err != nil || seconds < 1 {
+			fmt.Fprintf(out.Log, "netdata: update_every must be a positive number of seconds, not %q\n", fs.Arg(0))
+			return 2
+		}
+		updateEvery = time.Duration(seconds) * time.Second
+	}
+	if cfg.PushgatewayURL != "" {
+		fmt.Fprintln(out.Log, "netdata: -pushgateway-url is for single runs")
+		return 2
+	}
+
+	systemLog, closeLog, err := logFile.open()
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
+	}
+	defer closeLog()
+	// stdout is Netdata's; the log goes to its error log
+	out.MachineReadable(true)
+	if cfg.Converge == "" {
+		cfg.Converge = fastcom.ConvergeEWMA
+	}
+	plugin := &NetdataPlugin{W: out.Data, UpdateEvery: updateEvery}
+	cfg.OnEvent = plugin.Event
+	d := NewDaemon(cfg, *interval)
+	d.SystemLog = systemLog
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		if err := plugin.Run(ctx, d); err != nil {
+			fmt.Fprintln(out.Log, "Error writing to Netdata:", err)
+			stop()
+		}
+	}()
+	d.Run(ctx)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), d.ShutdownGrace)
+	defer cancel()
+	d.Shutdown(shutdownCtx)
+	sinkClient.CloseIdleConnections()
+	closeConnections()
+	return 0
+}
+
+func runCollect(args []string) int {
+	fs := flag.NewFlagSet("collect", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "`address` to accept results on")
+	keysFile := fs.String("keys", "", "JSON `file` with per-probe API keys, tenants, labels and partitioning")
+	dataDir := fs.String("data-dir", "results", "`directory` to store results in, partitioned by tenant")
+	ParseFlags(fs, args)
+
+	if *keysFile == "" {
+		fmt.Fprintln(out.Log, "collect: -keys is required")
+		return 2
+	}
+	cfg, err := LoadCollectorConfig(*keysFile)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/v1/results", &Collector{Config: cfg, DataDir: *dataDir})
+	if err := http.ListenAndServe(*listen, mux); err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func runMatrix(args []string) int {
+	fs := flag.NewFlagSet("matrix", flag.ExitOnError)
+	var runner MatrixRunner
+	SecretVar(fs, &runner.APIToken, "api-token", "bearer `token` for the REST API of daemon agents (a secret)")
+	fs.StringVar(&runner.SSH, "ssh", "ssh", "ssh `command` and options for ssh: agents, e.g. \"ssh -i key.pem\"")
+	fs.StringVar(&runner.RemoteCommand, "remote-command", defaultRemoteCommand, "`command` to run on ssh: agents, with -stdio appended")
+	fs.DurationVar(&runner.Timeout, "timeout", 5*time.Minute, "give up on an agent after `duration`")
+	parallel := fs.Bool("parallel", false, "test on all agents at once instead of one after the other")
+	format := fs.String("format", "text", "output `format`: text or json")
+	quiet := fs.Bool("quiet", false, "don't print progress to stderr with -format json")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go-fastcli matrix [flags] [label=]agent...")
+		fmt.Fprintln(fs.Output(), "An agent is a daemon's -listen URL, e.g. http://10.0.0.5:9876, or ssh:[user@]host.")
+		fs.PrintDefaults()
+	}
+	ParseFlags(fs, args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(out.Log, "matrix: unknown format %q\n", *format)
+		return 2
+	}
+	var agents []MatrixAgent
+	for _, arg := range fs.Args() {
+		agent, err := ParseMatrixAgent(arg)
+		if err != nil {
+			fmt.Fprintln(out.Log, "Error:", err)
+			return 2
+		}
+		agents = append(agents, agent)
+	}
+	if *format == "json" {
+		out.MachineReadable(*quiet)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	rows := runner.Run(ctx, agents, *parallel)
+	if *format == "json" {
+		enc := json.NewEncoder(out.Data)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rows); err != nil {
+			fmt.Fprintln(out.Log, "Error:", err)
+			return 1
+		}
+	} else {
+		fmt.Fprintln(out.Progress)
+		if err := WriteMatrix(out.Data, rows); err != nil {
+			fmt.Fprintln(out.Log, "Error:", err)
+			return 1
+		}
+	}
+	for _, row := range rows {
+		if row.Error != "" {
+			return 1
+		}
+	}
+	return 0
+}
+
+func runSLA(args []string) int {
+	fs := flag.NewFlagSet("sla", flag.ExitOnError)
+	planSpec := fs.String("plan", "", "subscribed `download/upload` speeds in Mbit/s, e.g. 500/50")
+	minPercent := fs.Float64("min-percent", 80, "a measurement complies if it reaches this `percent` of the plan")
+	requiredPercent := fs.Float64("required-percent", 95, "`percent` of measurements that must comply")
+	since := fs.String("since", "", "only include measurements from `time` on (RFC 3339, YYYY-MM-DD, or e.g. 30d ago)")
+	until := fs.String("until", "", "only include measurements before `time`")
+	db := fs.String("db", DefaultHistoryDB(), "history `location` written with -db, checked when no CSV files are given")
+	backend := fs.String("db-backend", "sqlite", "history `backend`: sqlite, postgres or file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go-fastcli sla -plan 500/50 [flags] [results.csv...]")
+		fs.PrintDefaults()
+	}
+	ParseFlags(fs, args)
+
+	if *planSpec == "" {
+		fs.Usage()
+		return 2
+	}
+	plan := Plan{MinPercent: *minPercent, RequiredPercent: *requiredPercent}
+	var err error
+	if plan.DownloadMbps, plan.UploadMbps, err = ParsePlanSpeeds(*planSpec); err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 2
+	}
+	sinceTime, untilTime, err := parseTimeRange(*since, *until)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 2
+	}
+
+	var records []HistoryRecord
+	if fs.NArg() == 0 {
+		store, err := OpenHistory(*backend, *db)
+		if err != nil {
+			fmt.Fprintln(out.Log, "Error:", err)
+			return 2
+		}
+		if records, err = store.Records(sinceTime, untilTime); err != nil {
+			fmt.Fprintln(out.Log, "Error:", err)
+			return 1
+		}
+	}
+	for _, path := range fs.Args() {
+		fileRecords, err := ReadCSVHistory(path)
+		if err != nil {
+			fmt.Fprintln(out.Log, "Error:", err)
+			return 1
+		}
+		records = append(records, FilterHistory(fileRecords, sinceTime, untilTime)...)
+	}
+	report := ComputeCompliance(plan, records)
+	report.Since, report.Until = sinceTime, untilTime
+	report.Write(out.Data)
+	return 0
+}
+
+func runHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	db := fs.String("db", DefaultHistoryDB(), "history `location` written with -db")
+	backend := fs.String("db-backend", "sqlite", "history `backend`: sqlite, postgres or file")
+	since := fs.String("since", "", "only include measurements from `time` on (RFC 3339, YYYY-MM-DD, or e.g. 30d ago)")
+	until := fs.String("until", "", "only include measurements before `time`")
+	summaryOnly := fs.Bool("summary", false, "only print the summary")
+	planDown := fs.Float64("plan-down", 0, "grade download against your plan's `Mbit/s`")
+	planUp := fs.Float64("plan-up", 0, "grade upload against your plan's `Mbit/s`")
+	gradePercent := fs.Float64("grade-percent", 90, "count tests reaching this `percent` of the plan")
+	ParseFlags(fs, args)
+
+	sinceTime, untilTime, err := parseTimeRange(*since, *until)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 2
+	}
+	store, err := OpenHistory(*backend, *db)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 2
+	}
+	records, err := store.Records(sinceTime, untilTime)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
+	}
+	if !*summaryOnly {
+		WriteHistory(out.Data, records)
+		fmt.Fprintln(out.Data)
+	}
+	WriteHistorySummary(out.Data, records)
+	if *planDown > 0 || *planUp > 0 {
+		fmt.Fprintln(out.Data)
+		WritePlanGrades(out.Data, records, *planDown, *planUp, *gradePercent)
+	}
+	return 0
+}
+
+func runTrend(args []string) int {
+	fs := flag.NewFlagSet("trend", flag.ExitOnError)
+	db := fs.String("db", DefaultHistoryDB(), "history `location` written with -db")
+	backend := fs.String("db-backend", "sqlite", "history `backend`: sqlite, postgres or file")
+	since := fs.String("since", "", "only include measurements from `time` on (RFC 3339, YYYY-MM-DD, or e.g. 30d ago)")
+	until := fs.String("until", "", "only include measurements before `time`")
+	by := fs.String("by", "hour", "`bucket` runs by hour of the day (local time) or by day")
+	ParseFlags(fs, args)
+
+	sinceTime, untilTime, err := parseTimeRange(*since, *until)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 2
+	}
+	store, err := OpenHistory(*backend, *db)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 2
+	}
+	records, err := store.Records(sinceTime, untilTime)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
+	}
+	trend, err := Trend(records, *by)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 2
+	}
+	WriteTrend(out.Data, trend)
+	return 0
+}
+
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	db := fs.String("db", DefaultHistoryDB(), "history `location` for history IDs")
+	backend := fs.String("db-backend", "sqlite", "history `backend`: sqlite, postgres or file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go-fastcli compare [flags] a.json b.json")
+		fmt.Fprintln(fs.Output(), "       go-fastcli compare [flags] ID ID")
+		fs.PrintDefaults()
+	}
+	ParseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	store, err := OpenHistory(*backend, *db)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 2
+	}
+
+	var results [2]Result
+	for i, arg := range fs.Args() {
+		var err error
+		if results[i], err = loadCompareArg(arg, store); err != nil {
+			fmt.Fprintln(out.Log, "Error:", err)
+			return 1
+		}
+	}
+	fmt.Fprintf(out.Data, "A: %s (%s)\n", fs.Arg(0), results[0].Time.Local().Format("2006-01-02 15:04"))
+	fmt.Fprintf(out.Data, "B: %s (%s)\n\n", fs.Arg(1), results[1].Time.Local().Format("2006-01-02 15:04"))
+	WriteComparison(out.Data, CompareResults(results[0], results[1]))
+	return 0
+}
+
+func runSNI(args []string) int {
+	var cfg Config
+	fs := flag.NewFlagSet("sni", flag.ExitOnError)
+	fs.StringVar(&cfg.TargetsFile, "targets-file", "", "test against targets from `file` instead of the fast.com API")
+	registerTimeoutFlags(fs, &cfg)
+	fs.StringVar(&cfg.TLSCipher, "tls-cipher", "auto", "TLS cipher family: auto, aes-gcm, or chacha20")
+	fs.Var((*stringList)(&cfg.Resolve), "resolve", "connect to `host:ip` instead of resolving host (repeatable)")
+	serverName := fs.String("server-name", "example.com", "innocuous TLS server `name` to compare against")
+	ParseFlags(fs, args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if _, err := RunSNICheck(ctx, cfg, *serverName, out.Data); err != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintln(out.Log, "Interrupted")
+			return ExitInterrupted
+		}
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func runDSCP(args []string) int {
+	var cfg Config
+	fs := flag.NewFlagSet("dscp", flag.ExitOnError)
+	fs.StringVar(&cfg.TargetsFile, "targets-file", "", "test against targets from `file` instead of the fast.com API")
+	registerTimeoutFlags(fs, &cfg)
+	fs.StringVar(&cfg.TLSCipher, "tls-cipher", "auto", "TLS cipher family: auto, aes-gcm, or chacha20")
+	fs.Var((*stringList)(&cfg.Resolve), "resolve", "connect to `host:ip` instead of resolving host (repeatable)")
+	fs.StringVar(&cfg.SourceAddress, "source-address", "", "connect from local `ip`")
+	classes := fs.String("classes", defaultDSCPClasses, "comma-separated DSCP `classes` to test; the first is the baseline")
+	perClass := fs.Duration("time-per-class", 20*time.Second, "`duration` of the test for each class")
+	ParseFlags(fs, args)
+	cfg.Headline = HeadlineStable
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	results, err := RunDSCPSweep(ctx, cfg, strings.Split(*classes, ","), *perClass, out.Data)
+	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintln(out.Log, "Interrupted")
+			return ExitInterrupted
+		}
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
+	}
+	fmt.Fprintln(out.Data)
+	WriteDSCPReport(out.Data, results)
+	return 0
+}
+
+func runRate(args []string) int {
+	var cfg Config
+	fs := flag.NewFlagSet("rate", flag.ExitOnError)
+	fs.StringVar(&cfg.TargetsFile, "targets-file", "", "test against targets from `file` instead of the fast.com API")
+	registerTimeoutFlags(fs, &cfg)
+	fs.StringVar(&cfg.TLSCipher, "tls-cipher", "auto", "TLS cipher family: auto, aes-gcm, or chacha20")
+	fs.Var((*stringList)(&cfg.Resolve), "resolve", "connect to `host:ip` instead of resolving host (repeatable)")
+	fs.StringVar(&cfg.SourceAddress, "source-address", "", "connect from local `ip`")
+	maxIncrease := fs.Duration("max-latency-increase", 30*time.Millisecond, "highest acceptable rise of the median latency under load")
+	step := fs.Duration("step", 5*time.Second, "`duration` to hold each rate")
+	ingress := fs.Float64("sqm-ingress", 90, "suggest SQM download shaping at this `percent` of the sustainable rate")
+	egress := fs.Float64("sqm-egress", 95, "suggest SQM upload shaping at this `percent` of the sustainable rate")
+	ParseFlags(fs, args)
+	if *ingress <= 0 || *ingress > 100 || *egress <= 0 || *egress > 100 {
+		fmt.Fprintln(out.Log, "Error: -sqm-ingress and -sqm-egress must be between 0 and 100")
+		return 2
+	}
+	cfg.Headline = HeadlineStable
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	results, err := RunRateSearch(ctx, cfg, *maxIncrease, *step, out.Data)
+	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintln(out.Log, "Interrupted")
+			return ExitInterrupted
+		}
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
+	}
+	fmt.Fprintln(out.Data)
+	WriteRateReport(out.Data, results, *maxIncrease)
+	fmt.Fprintln(out.Data)
+	WriteSQMSuggestion(out.Data, results, *ingress, *egress)
+	return 0
+}
+
+func runBurst(args []string) int {
+	var cfg Config
+	fs := flag.NewFlagSet("burst", flag.ExitOnError)
+	fs.StringVar(&cfg.TargetsFile, "targets-file", "", "test against targets from `file` instead of the fast.com API")
+	registerTimeoutFlags(fs, &cfg)
+	fs.StringVar(&cfg.TLSCipher, "tls-cipher", "auto", "TLS cipher family: auto, aes-gcm, or chacha20")
+	fs.Var((*stringList)(&cfg.Resolve), "resolve", "connect to `host:ip` instead of resolving host (repeatable)")
+	fs.StringVar(&cfg.SourceAddress, "source-address", "", "connect from local `ip`")
+	var bcfg BurstConfig
+	fs.DurationVar(&bcfg.Burst, "burst", 2*time.Second, "`duration` of each burst of load")
+	fs.DurationVar(&bcfg.Idle, "idle", 2*time.Second, "`duration` of the idle gap after each burst")
+	fs.DurationVar(&bcfg.Duration, "duration", 3*time.Minute, "total `duration` of the bursts and gaps")
+	direction := fs.String("direction", "download", "`direction` of the bursts: download, upload or both, which alternates them")
+	fs.DurationVar(&bcfg.RecoveredWithin, "recovered-within", 5*time.Millisecond, "latency counts as recovered once within this `duration` of the idle latency")
+	ParseFlags(fs, args)
+	switch *direction {
+	case "download", "upload":
+		bcfg.Directions = []string{*direction}
+	case "both":
+		bcfg.Directions = []string{"download", "upload"}
+	default:
+		fmt.Fprintf(out.Log, "burst: unknown -direction %q\n", *direction)
+		return 2
+	}
+	if bcfg.Burst < burstSampleInterval || bcfg.Idle <= 0 {
+		fmt.Fprintf(out.Log, "burst: -burst must be at least %v and -idle positive\n", burstSampleInterval)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	result, err := RunBurstTest(ctx, cfg, bcfg, out.Data)
+	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintln(out.Log, "Interrupted")
+			return ExitInterrupted
+		}
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
+	}
+	fmt.Fprintln(out.Data)
+	WriteBurstReport(out.Data, result, bcfg)
+	return 0
+}
+
+func runWizard(args []string) int {
+	var cfg Config
+	fs := flag.NewFlagSet("wizard", flag.ExitOnError)
+	RegisterTestFlags(fs, &cfg)
+	ParseFlags(fs, args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := RunWizard(ctx, cfg, os.Stdin, out.Data); err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func queryDaemon(name string, args []string) (ControlResponse, bool) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	controlSocket := fs.String("control-socket", DefaultControlSocket(), "unix `socket` of the running daemon")
+	ParseFlags(fs, args)
+
+	resp, err := QueryDaemon(*controlSocket, name)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error talking to daemon:", err)
+		return resp, false
+	}
+	return resp, true
+}
+
+func runStatus(args []string) int {
+	resp, ok := queryDaemon("status", args)
+	if !ok {
+		return 1
+	}
+	status := resp.Status
+	if status == nil {
+		fmt.Fprintln(out.Log, "Daemon returned no status")
+		return 1
+	}
+	fmt.Fprintf(out.Data, "State: %s\n", status.State)
+	fmt.Fprintf(out.Data, "Runs: %d (%d failed)\n", status.Runs, status.Failures)
+	if status.Runs > 0 {
+		fmt.Fprintf(out.Data, "Data used: %s, %s per run\n", formatBytes(status.BytesUsed), formatBytes(status.BytesUsed/int64(status.Runs)))
+	}
+	if status.LastRun != nil {
+		fmt.Fprintf(out.Data, "Last run: %s\n", status.LastRun.Format(time.RFC1123))
+	}
+	if status.LastError != "" {
+		fmt.Fprintf(out.Data, "Last error: %s\n", status.LastError)
+	}
+	if status.LastResult != nil {
+		fmt.Fprintln(out.Data, "Last result:")
+		for _, server := range status.LastResult.Servers {
+			fmt.Fprintf(out.Data, "  - %s: %0.3f ms, %0.3f Mbit/s down, %0.3f Mbit/s up\n", server.Host, server.LatencyMs, server.DownloadMbps, server.UploadMbps)
+			if server.Route != nil {
+				changed := ""
+				if server.Route.Changed {
+					changed = ", changed since the previous run"
+				}
+				fmt.Fprintf(out.Data, "    Route %s (%d hops%s)\n", server.Route.Hash, len(server.Route.Hops), changed)
+			}
+		}
+		for _, anomaly := range status.LastResult.Anomalies {
+			fmt.Fprintf(out.Data, "  Unusual: %s\n", anomaly)
+		}
+	}
+	if status.State != "running" {
+		fmt.Fprintf(out.Data, "Next run: %s (in %s)\n", status.NextRun.Format(time.RFC1123), time.Until(status.NextRun).Round(time.Second))
+	}
+	return 0
+}
+
+func runTrigger(args []string) int {
+	if _, ok := queryDaemon("trigger", args); !ok {
+		return 1
+	}
+	fmt.Fprintln(out.Data, "Run triggered")
+	return 0
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// tCritical95 holds the two-sided 95% critical values of Student's t for 1
+// to 30 degrees of freedom; beyond that the normal 1.96 is close enough.
+var tCritical95 = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+func significantT(t, df float64) bool {
+	critical := 1.96
+	if df < float64(len(tCritical95)+1) {
+		// rounding df down errs on the side of "not significant"
+		critical = tCritical95[int(math.Max(1, math.Floor(df)))-1]
+	}
+	return math.Abs(t) > critical
+}
+
+// LoadResultFile reads a result written with -format json. NDJSON files such
+// as the journal are accepted too; their last successful run is used.
+func LoadResultFile(path string) (Result, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Result{}, err
+	}
+	var last *Result
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			return Result{}, fmt.Errorf("%s: %w", path, err)
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(value, &entry); err == nil && entry.Result != nil {
+			if entry.Error == "" {
+				last = entry.Result
+			}
+			continue
+		}
+		var result Result
+		if err := json.Unmarshal(value, &result); err == nil && len(result.Servers) > 0 {
+			last = &result
+		}
+	}
+	if last == nil {
+		return Result{}, fmt.Errorf("%s: no successful result", path)
+	}
+	return *last, nil
+}
+
+// LoadHistoryResult turns a history record into a result.
+func LoadHistoryResult(store HistoryStore, id int64) (Result, error) {
+	record, err := store.Record(id)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Time:       record.Time,
+		Connection: fastcom.ConnectionInfo{IP: record.IP, ASN: record.ASN},
+		Servers:    []ServerResult{record.serverResult()},
+	}, nil
+}
+
+// loadCompareArg loads a result file, or a history record when arg is a
+// number that isn't also a file name.
+func loadCompareArg(arg string, store HistoryStore) (Result, error) {
+	if _, err := os.Stat(arg); errors.Is(err, os.ErrNotExist) {
+		if id, err := strconv.ParseInt(arg, 10, 64); err == nil {
+			return LoadHistoryResult(store, id)
+		}
+	}
+	return LoadResultFile(arg)
+}
+
+type MetricComparison struct {
+	Name           string
+	A, B           float64
+	HigherIsBetter bool
+	// Insufficient is set when either result had too few samples of the
+	// metric's phase to compare it.
+	Insufficient bool
+	// Tested is set when both results had enough samples to test whether
+	// the change is significant.
+	Tested      bool
+	Significant bool
+}
+
+func (m MetricComparison) Regression() bool {
+	return m.Significant && (m.B > m.A) != m.HigherIsBetter
+}
+
+func phaseSamplesMbps(result Result, phase string) []float64 {
+	var mbps []float64
+	for _, sample := range result.Samples {
+		if sample.Phase == phase {
+			mbps = append(mbps, float64(sample.Bytes)*8/1e6)
+		}
+	}
+	return mbps
+}
+
+func latencySamples(result Result) []float64 {
+	var samples []float64
+	for _, server := range result.Servers {
+		samples = append(samples, server.LatencySamplesMs...)
+	}
+	return samples
+}
+
+// CompareResults compares the averages of two results. Changes are tested
+// for significance with Welch's t-test on the latency samples and
+// per-second speeds when both results include them. Those are pooled across
+// servers and aren't independent; CalcWelchT allows for consecutive values
+// moving together, but the test remains approximate, and metrics whose
+// samples don't vary aren't tested.
+func CompareResults(a, b Result) []MetricComparison {
+	sa, sb := a.Summary(), b.Summary()
+	lacks := func(phase string) bool { return sa.lacks(phase) || sb.lacks(phase) }
+	metrics := []MetricComparison{
+		{Name: "Ping (ms)", A: sa.PingMs, B: sb.PingMs, Insufficient: lacks("latency")},
+		{Name: "Jitter (ms)", A: sa.JitterMs, B: sb.JitterMs, Insufficient: lacks("latency")},
+		{Name: "Download (Mbit/s)", A: sa.DownloadMbps, B: sb.DownloadMbps, HigherIsBetter: true, Insufficient: lacks("download")},
+		{Name: "Upload (Mbit/s)", A: sa.UploadMbps, B: sb.UploadMbps, HigherIsBetter: true, Insufficient: lacks("upload")},
+	}
+	samples := [][2][]float64{
+		{latencySamples(a), latencySamples(b)},
+		{nil, nil},
+		{phaseSamplesMbps(a, "download"), phaseSamplesMbps(b, "download")},
+		{phaseSamplesMbps(a, "upload"), phaseSamplesMbps(b, "upload")},
+	}
+	for i := range metrics {
+		if metrics[i].Insufficient {
+			continue
+		}
+		if t, df, err := fastcom.CalcWelchT(samples[i][0], samples[i][1]); err == nil {
+			metrics[i].Tested = true
+			metrics[i].Significant = significantT(t, df)
+		}
+	}
+	return metrics
+}
+
+func WriteComparison(w io.Writer, metrics []MetricComparison) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tA\tB\tChange\t\t")
+	for _, m := range metrics {
+		if m.Insufficient {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t\t\tinsufficient data\n", m.Name, compareValue(m.A), compareValue(m.B))
+			continue
+		}
+		percent := "n/a"
+		if m.A != 0 {
+			percent = fmt.Sprintf("%+0.1f%%", (m.B-m.A)/m.A*100)
+		}
+		note := ""
+		switch {
+		case m.Regression():
+			note = "significant regression"
+		case m.Significant:
+			note = "significant improvement"
+		case m.Tested:
+			note = "not significant"
+		}
+		fmt.Fprintf(tw, "%s\t%0.2f\t%0.2f\t%+0.2f\t%s\t%s\n", m.Name, m.A, m.B, m.B-m.A, percent, note)
+	}
+	tw.Flush()
+}
+
+// compareValue formats a value of WriteComparison, "-" for the zero of a
+// phase with insufficient data.
+func compareValue(v float64) string {
+	if v == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
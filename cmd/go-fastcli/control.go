@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type ControlRequest struct {
+	Command string
+}
+
+type ControlResponse struct {
+	Status    *DaemonStatus `json:",omitempty"`
+	Triggered bool          `json:",omitempty"`
+	Error     string        `json:",omitempty"`
+}
+
+func DefaultControlSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "go-fastcli.sock")
+}
+
+func handleControlConn(conn net.Conn, d *Daemon) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	var req ControlRequest
+	var resp ControlResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		resp.Error = "invalid request"
+	} else {
+		switch req.Command {
+		case "status":
+			status := d.Status()
+			resp.Status = &status
+		case "trigger":
+			resp.Triggered = d.Trigger()
+			if !resp.Triggered {
+				resp.Error = "a run is already in progress"
+			}
+		default:
+			resp.Error = "unknown command " + req.Command
+		}
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// ServeControl listens on a unix socket for status and trigger requests from
+// the CLI. A socket left behind by a daemon that is no longer running is
+// replaced.
+func ServeControl(path string, d *Daemon) error {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return errors.New("another daemon is listening on " + path)
+	}
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleControlConn(conn, d)
+		}
+	}()
+	return nil
+}
+
+func QueryDaemon(path, command string) (ControlResponse, error) {
+	var resp ControlResponse
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return resp, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := json.NewEncoder(conn).Encode(ControlRequest{Command: command}); err != nil {
+		return resp, err
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// A single pegged core is enough to cap throughput, since the TLS and copy
+// work for a transfer runs on one goroutine at a time.
+const cpuLimitedProcessPercent = 90
+const cpuLimitedSystemPercent = 95
+
+type CPUUsage struct {
+	// ProcessPercent is the CPU time used by this process as a percentage of
+	// one core, so it can exceed 100 on multi-core machines.
+	ProcessPercent float64
+	// SystemPercent is the busy time of all cores, or -1 if unavailable.
+	SystemPercent float64
+	CPULimited    bool
+}
+
+func (u CPUUsage) String() string {
+	s := fmt.Sprintf("process used %0.0f%% of a core", u.ProcessPercent)
+	if u.SystemPercent >= 0 {
+		s += fmt.Sprintf(", system %0.0f%% busy", u.SystemPercent)
+	}
+	return s
+}
+
+type cpuSample struct {
+	wall        time.Time
+	process     time.Duration
+	systemBusy  uint64
+	systemTotal uint64
+}
+
+type CPUMonitor struct {
+	start cpuSample
+	err   error
+}
+
+func (m *CPUMonitor) Start() {
+	m.start, m.err = readCPUSample()
+}
+
+// Stop returns the CPU usage since Start, or false if it could not be measured
+// on this platform.
+func (m *CPUMonitor) Stop() (CPUUsage, bool) {
+	if m.err != nil {
+		return CPUUsage{}, false
+	}
+	end, err := readCPUSample()
+	if err != nil {
+		return CPUUsage{}, false
+	}
+	wall := end.wall.Sub(m.start.wall)
+	if wall <= 0 {
+		return CPUUsage{}, false
+	}
+	usage := CPUUsage{
+		ProcessPercent: float64(end.process-m.start.process) / float64(wall) * 100,
+		SystemPercent:  -1,
+	}
+	if total := end.systemTotal - m.start.systemTotal; total > 0 {
+		usage.SystemPercent = float64(end.systemBusy-m.start.systemBusy) / float64(total) * 100
+	}
+	usage.CPULimited = usage.ProcessPercent >= cpuLimitedProcessPercent || usage.SystemPercent >= cpuLimitedSystemPercent
+	return usage, true
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func readCPUSample() (cpuSample, error) {
+	sample := cpuSample{wall: time.Now()}
+	var rusage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &rusage); err != nil {
+		return sample, err
+	}
+	sample.process = time.Duration(rusage.Utime.Nano() + rusage.Stime.Nano())
+
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return sample, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return sample, errors.New("/proc/stat is empty")
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return sample, errors.New("unexpected /proc/stat format")
+	}
+	for i, field := range fields[1:] {
+		// guest time is already counted in user time
+		if i >= 8 {
+			break
+		}
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return sample, err
+		}
+		sample.systemTotal += v
+		// idle and iowait
+		if i != 3 && i != 4 {
+			sample.systemBusy += v
+		}
+	}
+	return sample, nil
+}
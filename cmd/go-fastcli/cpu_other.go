@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func readCPUSample() (cpuSample, error) {
+	return cpuSample{}, errors.New("CPU sampling is only supported on Linux")
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DaemonStatus struct {
+	State      string
+	Runs       int
+	Failures   int
+	LastRun    *time.Time `json:",omitempty"`
+	LastError  string     `json:",omitempty"`
+	LastResult *Result    `json:",omitempty"`
+	NextRun    time.Time
+	// BytesUsed is the payload the runs since the daemon started have
+	// transferred, failed ones included.
+	BytesUsed int64
+}
+
+// defaultShutdownGrace is the default of Daemon.ShutdownGrace and of serve's
+// -shutdown-grace.
+const defaultShutdownGrace = 10 * time.Second
+
+type Daemon struct {
+	Config   Config
+	Interval time.Duration
+	Metrics  *ProbeMetrics
+	// TraceRoutes records the route to each server after every run and
+	// flags runs where it changed.
+	TraceRoutes bool
+	// EmailEvery, if set, replaces the email of each run to Config.EmailTo
+	// with a report of all runs once per period.
+	EmailEvery time.Duration
+	// AnomalyRuns, if set, compares each run with that many previous runs
+	// in the Config.HistoryDB history, and flags measurements whose
+	// modified z-score exceeds AnomalyThreshold.
+	AnomalyRuns      int
+	AnomalyThreshold float64
+	// ShutdownGrace is how long a run that finished as the daemon was
+	// stopped has to deliver its result.
+	ShutdownGrace time.Duration
+	// SystemLog, if set, gets every result with its measurements as
+	// fields.
+	SystemLog SystemLog
+
+	mu      sync.Mutex
+	status  DaemonStatus
+	trigger chan struct{}
+	routes  map[string]string
+	results []Result
+	report  EmailReport
+
+	subscribers map[chan Event]struct{}
+	finished    chan struct{}
+}
+
+func NewDaemon(cfg Config, interval time.Duration) *Daemon {
+	return &Daemon{
+		Config:   cfg,
+		Interval: interval,
+		Metrics:  NewProbeMetrics(),
+
+		ShutdownGrace: defaultShutdownGrace,
+
+		status:  DaemonStatus{State: "idle"},
+		trigger: make(chan struct{}, 1),
+		routes:  map[string]string{},
+
+		subscribers: map[chan Event]struct{}{},
+		finished:    make(chan struct{}),
+	}
+}
+
+// Finished returns a channel that is closed when the next run ends.
+func (d *Daemon) Finished() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.finished
+}
+
+// Subscribe returns a channel receiving the events of every run until cancel
+// is called. Events are dropped rather than holding up the test when the
+// subscriber falls behind.
+func (d *Daemon) Subscribe() (events <-chan Event, cancel func()) {
+	ch := make(chan Event, 64)
+	d.mu.Lock()
+	d.subscribers[ch] = struct{}{}
+	d.mu.Unlock()
+	return ch, func() {
+		d.mu.Lock()
+		delete(d.subscribers, ch)
+		d.mu.Unlock()
+	}
+}
+
+func (d *Daemon) publish(evt Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for ch := range d.subscribers {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+}
+
+func (d *Daemon) Status() DaemonStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status
+}
+
+// Trigger schedules an immediate run. It returns false if a run is already in
+// progress or pending.
+func (d *Daemon) Trigger() bool {
+	d.mu.Lock()
+	running := d.status.State == "running"
+	d.mu.Unlock()
+	if running {
+		return false
+	}
+	select {
+	case d.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *Daemon) runOnce(ctx context.Context) {
+	d.mu.Lock()
+	d.status.State = "running"
+	d.mu.Unlock()
+
+	started := time.Now()
+	bytesBefore := client.BytesTransferred()
+	var phase, failedPhase string
+	cfg := d.Config
+	cfg.OnEvent = func(evt Event) {
+		if evt.Type == EventPhaseStart {
+			phase = evt.Phase
+		}
+		if d.Config.OnEvent != nil {
+			d.Config.OnEvent(evt)
+		}
+		d.publish(evt)
+	}
+	result, err := runWithinBudget(ctx, cfg)
+	if err != nil {
+		failedPhase = phase
+		fmt.Fprintln(out.Log, "Error:", err)
+		d.publish(Event{Type: EventError, Time: time.Now(), Error: err.Error()})
+	}
+	bytesUsed := client.BytesTransferred() - bytesBefore
+	d.Metrics.ObserveRun(started, time.Since(started), failedPhase, bytesUsed)
+	// the next run gets new targets, so connections to these would only
+	// pile up over the runs
+	client.CloseIdleConnections()
+
+	// a run that finished as the daemon was stopped is still delivered,
+	// within the grace period; one it interrupted is dropped
+	interrupted := err != nil && ctx.Err() != nil
+	sinkCtx, cancel := graceContext(ctx, d.ShutdownGrace)
+	defer cancel()
+	if d.Config.Journal != "" && !interrupted {
+		if err := AppendJournal(d.Config, result, err); err != nil {
+			fmt.Fprintln(out.Log, "Error writing journal:", err)
+		}
+	}
+
+	if err == nil && d.TraceRoutes {
+		d.traceRoutes(ctx, &result)
+	}
+	if err == nil && d.AnomalyRuns > 0 {
+		d.detectAnomalies(&result)
+	}
+	sinkCfg := d.Config
+	if d.EmailEvery > 0 {
+		sinkCfg.EmailTo = nil
+	}
+	if err == nil {
+		DeliverResult(sinkCtx, sinkCfg, result, d.sinkError)
+	}
+	if err == nil && d.SystemLog != nil {
+		if err := logResult(d.SystemLog, result); err != nil {
+			d.sinkError("system log", err)
+		}
+	}
+	if d.EmailEvery > 0 && len(d.Config.EmailTo) > 0 && !interrupted {
+		d.addToReport(sinkCtx, started, result, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer func() {
+		close(d.finished)
+		d.finished = make(chan struct{})
+	}()
+	d.status.State = "idle"
+	d.status.Runs++
+	d.status.BytesUsed += bytesUsed
+	d.status.LastRun = &started
+	d.status.LastError = ""
+	if err != nil {
+		d.status.LastError = err.Error()
+		d.status.Failures++
+		return
+	}
+	result.Samples, result.LoadedLatency = nil, nil
+	d.status.LastResult = &result
+	d.results = append(d.results, result)
+	if len(d.results) > maxStoredResults {
+		d.results = d.results[len(d.results)-maxStoredResults:]
+	}
+}
+
+func (d *Daemon) sinkError(sink string, err error) {
+	d.Metrics.ObserveSinkError(sink)
+	printSinkError(sink, err)
+}
+
+// addToReport collects a run for the email report and sends the report once
+// EmailEvery has passed since the period started. A report that fails to
+// send is kept and tried again after the next run.
+func (d *Daemon) addToReport(ctx context.Context, started time.Time, result Result, err error) {
+	if d.report.Start.IsZero() {
+		d.report.Start = started
+	}
+	if err != nil {
+		d.report.Failures++
+	} else {
+		result.Samples, result.LoadedLatency = nil, nil
+		d.report.Results = append(d.report.Results, result)
+	}
+	if time.Since(d.report.Start) < d.EmailEvery {
+		return
+	}
+	d.report.End = time.Now()
+	if err := SendEmailReport(ctx, d.Config, d.report); err != nil {
+		d.sinkError("email", err)
+		return
+	}
+	d.report = EmailReport{Start: d.report.End}
+}
+
+func (d *Daemon) traceRoutes(ctx context.Context, result *Result) {
+	pinned, _ := ParseResolve(d.Config.Resolve)
+	for n := range result.Servers {
+		server := &result.Servers[n]
+		route, err := TraceRoute(ctx, server.Host, pinned)
+		if err != nil {
+			fmt.Fprintf(out.Log, "Error tracing route to %s: %v\n", server.Host, err)
+			continue
+		}
+		if prev, ok := d.routes[server.Host]; ok && prev != route.Hash {
+			route.Changed = true
+			fmt.Fprintf(out.Progress, "Route to %s changed: %s\n", server.Host, strings.Join(route.Hops, " "))
+		}
+		d.routes[server.Host] = route.Hash
+		server.Route = &route
+	}
+}
+
+// detectAnomalies flags what is unusual about the result before it is added
+// to the history, so it is judged against the runs before it only.
+func (d *Daemon) detectAnomalies(result *Result) {
+	store, err := OpenHistory(d.Config.HistoryBackend, d.Config.HistoryDB)
+	if err != nil {
+		d.sinkError("history", err)
+		return
+	}
+	// a new history has no runs to compare with yet
+	records, err := store.Records(time.Time{}, time.Time{})
+	if err != nil {
+		return
+	}
+	result.Anomalies = DetectAnomalies(d.Config, result.Summary(), recentRuns(records, d.AnomalyRuns), d.AnomalyThreshold)
+	for _, anomaly := range result.Anomalies {
+		fmt.Fprintf(out.Progress, "Unusual result: %s\n", anomaly)
+	}
+}
+
+// graceContext returns a context that ends grace after ctx does, so work
+// under way when ctx ends, such as delivering a finished result, can finish.
+func graceContext(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	graced, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-graced.Done():
+			return
+		}
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-graced.Done():
+		}
+	}()
+	return graced, cancel
+}
+
+// Shutdown delivers what the daemon holds back, the runs of the unfinished
+// -email-every period, within ctx. It is called once Run has returned.
+func (d *Daemon) Shutdown(ctx context.Context) {
+	if d.EmailEvery > 0 && len(d.Config.EmailTo) > 0 && (len(d.report.Results) > 0 || d.report.Failures > 0) {
+		d.report.End = time.Now()
+		if err := SendEmailReport(ctx, d.Config, d.report); err != nil {
+			d.sinkError("email", err)
+		}
+	}
+}
+
+// Run runs tests on schedule until ctx is cancelled. A run in progress is
+// cancelled with ctx, and Run returns once it has delivered or dropped its
+// result, within ShutdownGrace.
+func (d *Daemon) Run(ctx context.Context) {
+	for {
+		next := time.Now().Add(d.Interval)
+		d.mu.Lock()
+		if d.status.Runs == 0 {
+			next = time.Now()
+		}
+		d.status.NextRun = next
+		d.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-d.trigger:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		d.runOnce(ctx)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// How -dedupe-targets tells that two targets are the same server.
+const (
+	DedupeHost = "host"
+	DedupeIP   = "ip"
+	DedupeOff  = "off"
+)
+
+// DedupeServers drops the servers that duplicate an earlier one, by host name
+// or, with DedupeIP, by sharing an address, as the fast.com API sometimes
+// hands out several URLs for one server. It returns the servers kept and the
+// URLs of those dropped. A host that doesn't resolve is kept, for the test
+// to report.
+func DedupeServers(ctx context.Context, servers []fastcom.Server, by string) (kept []fastcom.Server, dropped []string) {
+	if by == DedupeOff {
+		return servers, nil
+	}
+	seen := map[string]bool{}
+	for _, server := range servers {
+		host := server.URL
+		if u, err := url.Parse(server.URL); err == nil && u.Hostname() != "" {
+			host = u.Hostname()
+		}
+		keys := []string{host}
+		if by == DedupeIP && net.ParseIP(host) == nil {
+			if addrs, err := client.LookupHost(ctx, host); err == nil {
+				keys = addrs
+			}
+		}
+		duplicate := false
+		for _, key := range keys {
+			duplicate = duplicate || seen[key]
+			seen[key] = true
+		}
+		if duplicate {
+			dropped = append(dropped, server.URL)
+			continue
+		}
+		kept = append(kept, server)
+	}
+	return kept, dropped
+}
+
+func validDedupe(by string) error {
+	switch by {
+	case "", DedupeHost, DedupeIP, DedupeOff:
+		return nil
+	}
+	return fmt.Errorf("unknown -dedupe-targets %q, expected host, ip or off", by)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// defaultDSCPClasses covers best effort, the usual AF classes, expedited
+// forwarding and the scavenger class.
+const defaultDSCPClasses = "CS0,CS1,AF11,AF21,AF31,AF41,EF"
+
+// A class whose speed differs from the first class by more than this factor,
+// either way, is reported as treated differently.
+const dscpSpeedRatio = 0.7
+
+// ...and so is one whose ping is this much higher, by at least dscpMinPingMs.
+const (
+	dscpPingRatio = 1.5
+	dscpMinPingMs = 5
+)
+
+var dscpNames = map[string]int{"EF": 46, "VA": 44, "LE": 1, "DF": 0, "BE": 0}
+
+// ParseDSCP parses a DSCP class name such as EF, AF41 or CS1, or a code
+// point from 0 to 63.
+func ParseDSCP(class string) (int, error) {
+	name := strings.ToUpper(class)
+	if v, ok := dscpNames[name]; ok {
+		return v, nil
+	}
+	switch {
+	case len(name) == 3 && strings.HasPrefix(name, "CS") && name[2] >= '0' && name[2] <= '7':
+		return int(name[2]-'0') << 3, nil
+	case len(name) == 4 && strings.HasPrefix(name, "AF") && name[2] >= '1' && name[2] <= '4' && name[3] >= '1' && name[3] <= '3':
+		return int(name[2]-'0')<<3 | int(name[3]-'0')<<1, nil
+	}
+	if v, err := strconv.Atoi(class); err == nil && v >= 0 && v <= 63 {
+		return v, nil
+	}
+	return 0, fmt.Errorf("invalid DSCP class %q, expected e.g. EF, AF41, CS1 or 0 to 63", class)
+}
+
+type DSCPResult struct {
+	Class string
+	DSCP  int
+	Summary
+}
+
+// RunDSCPSweep runs a short test per DSCP class against the same servers and
+// reports each class next to the first one, to expose networks that treat
+// marked traffic differently. perClass bounds the time of each test.
+func RunDSCPSweep(ctx context.Context, cfg Config, classes []string, perClass time.Duration, w io.Writer) ([]DSCPResult, error) {
+	for _, class := range classes {
+		if _, err := ParseDSCP(class); err != nil {
+			return nil, err
+		}
+	}
+	saved := out.Progress
+	out.Progress = io.Discard
+	defer func() { out.Progress = saved }()
+
+	var results []DSCPResult
+	for _, class := range classes {
+		fmt.Fprintf(w, "Testing %s...\n", class)
+		cfg.DSCP = class
+		cfg.MaxTotalTime = perClass
+		result, err := RunTest(ctx, cfg)
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", class, err)
+		}
+		if cfg.targets == nil {
+			cfg.targets = &targetList{info: result.Connection}
+			for _, server := range result.Servers {
+				cfg.targets.servers = append(cfg.targets.servers, fastcom.Server{URL: server.URL, City: server.City, Country: server.Country})
+			}
+		}
+		dscp, _ := ParseDSCP(class)
+		results = append(results, DSCPResult{Class: class, DSCP: dscp, Summary: result.Summary()})
+	}
+	return results, nil
+}
+
+// WriteDSCPReport prints a table of the classes and notes those treated
+// differently from the first. Phases with insufficient data are shown as "-"
+// and not compared.
+func WriteDSCPReport(w io.Writer, results []DSCPResult) {
+	if len(results) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%-6s %4s %10s %12s %18s %16s\n", "Class", "DSCP", "Ping (ms)", "Jitter (ms)", "Download (Mbit/s)", "Upload (Mbit/s)")
+	for _, r := range results {
+		value := func(v float64, phase string) string {
+			if r.lacks(phase) {
+				return "-"
+			}
+			return strconv.FormatFloat(v, 'f', 1, 64)
+		}
+		fmt.Fprintf(w, "%-6s %4d %10s %12s %18s %16s\n", r.Class, r.DSCP, value(r.PingMs, "latency"), value(r.JitterMs, "latency"),
+			value(r.DownloadMbps, "download"), value(r.UploadMbps, "upload"))
+	}
+	fmt.Fprintln(w)
+
+	base := results[0]
+	differs := false
+	speed := func(r DSCPResult, name string, v, baseV float64) {
+		if r.lacks(name) || base.lacks(name) {
+			return
+		}
+		class := r.Class
+		if baseV > 0 && (v < baseV*dscpSpeedRatio || v*dscpSpeedRatio > baseV) {
+			fmt.Fprintf(w, "%s got %0.0f%% of the %s speed of %s.\n", class, v/baseV*100, name, base.Class)
+			differs = true
+		}
+	}
+	for _, r := range results[1:] {
+		speed(r, "download", r.DownloadMbps, base.DownloadMbps)
+		speed(r, "upload", r.UploadMbps, base.UploadMbps)
+		if !r.lacks("latency") && !base.lacks("latency") && r.PingMs > base.PingMs*dscpPingRatio && r.PingMs-base.PingMs >= dscpMinPingMs {
+			fmt.Fprintf(w, "%s had %0.1f ms more ping than %s.\n", r.Class, r.PingMs-base.PingMs, base.Class)
+			differs = true
+		}
+	}
+	if !differs {
+		fmt.Fprintf(w, "All classes performed like %s: no sign of treatment by DSCP marking.\n", base.Class)
+	}
+}
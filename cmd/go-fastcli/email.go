@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// smtpTimeout bounds the whole conversation with the SMTP server.
+const smtpTimeout = time.Minute
+
+// EmailReport is the runs of a reporting period, e.g. a week of the daemon's
+// runs or a single run.
+type EmailReport struct {
+	Start    time.Time
+	End      time.Time
+	Results  []Result
+	Failures int
+}
+
+// SendEmailReport emails a plain text summary of the report to cfg.EmailTo,
+// with the results attached as JSON and CSV.
+func SendEmailReport(ctx context.Context, cfg Config, report EmailReport) error {
+	if cfg.SMTPServer == "" {
+		return errors.New("-email-to needs -smtp-server")
+	}
+	from := cfg.EmailFrom
+	if from == "" {
+		host, _ := os.Hostname()
+		from = "go-fastcli@" + host
+	}
+	msg, err := buildReportEmail(cfg, from, report)
+	if err != nil {
+		return err
+	}
+	return sendMail(ctx, cfg.SMTPServer, cfg.SMTPUser, cfg.SMTPPassword, from, cfg.EmailTo, msg)
+}
+
+func buildReportEmail(cfg Config, from string, report EmailReport) ([]byte, error) {
+	var jsonData, csvData bytes.Buffer
+	enc := json.NewEncoder(&jsonData)
+	enc.SetIndent("", "  ")
+	results := report.Results
+	if results == nil {
+		results = []Result{}
+	}
+	if err := enc.Encode(results); err != nil {
+		return nil, err
+	}
+	cw := csv.NewWriter(&csvData)
+	cw.Write(csvHeader)
+	for _, result := range report.Results {
+		writeCSVRows(cw, result)
+	}
+	cw.Flush()
+
+	var msg bytes.Buffer
+	body := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(cfg.EmailTo, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", reportSubject(cfg, report)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", body.Boundary())
+
+	part, err := body.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	part.Write([]byte(strings.ReplaceAll(FormatEmailReport(cfg, report), "\n", "\r\n")))
+	for _, attachment := range []struct {
+		name, contentType string
+		data              []byte
+	}{
+		{"results.json", "application/json", jsonData.Bytes()},
+		{"results.csv", "text/csv", csvData.Bytes()},
+	} {
+		part, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {`attachment; filename="` + attachment.name + `"`},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(attachment.data)
+		// RFC 2045 limits lines to 76 characters
+		for len(encoded) > 76 {
+			fmt.Fprintf(part, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", encoded)
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+func reportSubject(cfg Config, report EmailReport) string {
+	host, _ := os.Hostname()
+	subject := "Speed test report"
+	if host != "" {
+		subject += " for " + host
+	}
+	if len(report.Results) == 0 {
+		return fmt.Sprintf("%s: all %d runs failed", subject, report.Failures)
+	}
+	// runs without enough samples of a phase are left out of its mean
+	var down, up, downRuns, upRuns float64
+	for _, result := range report.Results {
+		s := result.Summary()
+		if !s.lacks("download") {
+			down += s.DownloadMbps
+			downRuns++
+		}
+		if !s.lacks("upload") {
+			up += s.UploadMbps
+			upRuns++
+		}
+	}
+	var parts []string
+	if !cfg.SkipDownload && downRuns > 0 {
+		parts = append(parts, fmt.Sprintf("%0.0f Mbit/s down", down/downRuns))
+	}
+	if !cfg.SkipUpload && upRuns > 0 {
+		parts = append(parts, fmt.Sprintf("%0.0f Mbit/s up", up/upRuns))
+	}
+	if len(report.Results) > 1 {
+		parts = append(parts, fmt.Sprintf("%d runs", len(report.Results)+report.Failures))
+	}
+	if len(parts) == 0 {
+		return subject
+	}
+	return subject + ": " + strings.Join(parts, ", ")
+}
+
+// FormatEmailReport writes the body of a report email: the period, the
+// number of runs and the mean, minimum and maximum of each measurement.
+// Phases skipped in cfg are left out, as are runs without enough samples of
+// a phase from its row.
+func FormatEmailReport(cfg Config, report EmailReport) string {
+	var b strings.Builder
+	host, _ := os.Hostname()
+	fmt.Fprintf(&b, "Speed tests on %s from %s to %s\n", host,
+		report.Start.Local().Format("2006-01-02 15:04"), report.End.Local().Format("2006-01-02 15:04"))
+	runs := len(report.Results) + report.Failures
+	if runs == 1 {
+		fmt.Fprintf(&b, "1 run, %d failed.\n", report.Failures)
+	} else {
+		fmt.Fprintf(&b, "%d runs, %d failed.\n", runs, report.Failures)
+	}
+	if len(report.Results) == 0 {
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "\n%-10s %10s %10s %10s\n", "", "mean", "min", "max")
+	row := func(name, phase, unit string, value func(Summary) float64) {
+		sum, n, lo, hi := 0.0, 0, math.Inf(1), math.Inf(-1)
+		for _, result := range report.Results {
+			s := result.Summary()
+			if s.lacks(phase) {
+				continue
+			}
+			v := value(s)
+			sum += v
+			n++
+			lo = math.Min(lo, v)
+			hi = math.Max(hi, v)
+		}
+		if n == 0 {
+			fmt.Fprintf(&b, "%-10s %10s\n", name, "insufficient data")
+			return
+		}
+		fmt.Fprintf(&b, "%-10s %10.1f %10.1f %10.1f  %s\n", name, sum/float64(n), lo, hi, unit)
+	}
+	if !cfg.SkipDownload {
+		row("Download", "download", "Mbit/s", func(s Summary) float64 { return s.DownloadMbps })
+	}
+	if !cfg.SkipUpload {
+		row("Upload", "upload", "Mbit/s", func(s Summary) float64 { return s.UploadMbps })
+	}
+	if !cfg.SkipLatency {
+		row("Ping", "latency", "ms", func(s Summary) float64 { return s.PingMs })
+		row("Jitter", "latency", "ms", func(s Summary) float64 { return s.JitterMs })
+	}
+	fmt.Fprintln(&b, "\nThe results are attached as JSON and CSV.")
+	return b.String()
+}
+
+// sendMail is smtp.SendMail with a timeout and implicit TLS on port 465.
+// Other ports use STARTTLS when the server offers it; net/smtp refuses to
+// send the password over a connection without TLS except to localhost.
+func sendMail(ctx context.Context, server, user, password, from string, to []string, msg []byte) error {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		return fmt.Errorf("-smtp-server: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	tlsConfig := &tls.Config{ServerName: host}
+	if port == "465" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && port != "465" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if user != "" {
+		if err := c.Auth(smtp.PlainAuth("", user, password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvName returns the environment variable that sets the flag name, e.g.
+// FASTCLI_TARGETS_FILE for -targets-file.
+func EnvName(name string) string {
+	return "FASTCLI_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// ParseFlags sets flags from their FASTCLI_* environment variables, or the
+// encrypted $FASTCLI_SECRETS file, then parses args, so flags on the command
+// line take precedence. A repeatable flag given on the command line replaces
+// the values from the environment rather than adding to them.
+func ParseFlags(fs *flag.FlagSet, args []string) {
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok, err := lookupEnv(EnvName(f.Name))
+		if err != nil {
+			fmt.Fprintln(fs.Output(), err)
+			os.Exit(2)
+		}
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			fmt.Fprintf(fs.Output(), "invalid value %q for %s: %v\n", value, EnvName(f.Name), err)
+			os.Exit(2)
+		}
+		if list, ok := f.Value.(listValue); ok {
+			f.Value = &listFromEnv{listValue: list}
+		}
+	})
+	fs.Parse(args)
+}
+
+// listValue is a repeatable flag, whose values add up.
+type listValue interface {
+	flag.Value
+	// Reset removes the values set so far.
+	Reset()
+}
+
+// listFromEnv is a repeatable flag set from the environment, which the first
+// value on the command line clears.
+type listFromEnv struct {
+	listValue
+	replaced bool
+}
+
+func (l *listFromEnv) Set(value string) error {
+	if !l.replaced {
+		l.Reset()
+		l.replaced = true
+	}
+	return l.listValue.Set(value)
+}
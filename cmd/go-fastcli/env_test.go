@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestParseFlagsListsFromEnv(t *testing.T) {
+	t.Setenv("FASTCLI_INCLUDE_HOST", "a.example,b.example")
+	t.Setenv("FASTCLI_EXCLUDE_HOST", "c.example")
+	parse := func(args ...string) (include, exclude []string) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Var((*stringList)(&include), "include-host", "")
+		fs.Var((*stringList)(&exclude), "exclude-host", "")
+		ParseFlags(fs, args)
+		return include, exclude
+	}
+
+	include, exclude := parse()
+	if want := []string{"a.example", "b.example"}; !reflect.DeepEqual(include, want) {
+		t.Errorf("from the environment: got %v, want %v", include, want)
+	}
+	if want := []string{"c.example"}; !reflect.DeepEqual(exclude, want) {
+		t.Errorf("from the environment: got %v, want %v", exclude, want)
+	}
+
+	include, exclude = parse("-include-host", "d.example", "-include-host", "e.example")
+	if want := []string{"d.example", "e.example"}; !reflect.DeepEqual(include, want) {
+		t.Errorf("from the command line: got %v, want %v", include, want)
+	}
+	if want := []string{"c.example"}; !reflect.DeepEqual(exclude, want) {
+		t.Errorf("not on the command line: got %v, want %v", exclude, want)
+	}
+}
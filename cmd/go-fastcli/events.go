@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	EventPhaseStart = "phase_start"
+	EventSample     = "sample"
+	EventPhaseEnd   = "phase_end"
+	EventDone       = "done"
+	EventError      = "error"
+)
+
+// Event describes test progress for wrappers and GUIs. Sample events are sent
+// from a separate goroutine, so handlers must be safe for concurrent use.
+type Event struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Phase  string    `json:"phase,omitempty"`
+	Server string    `json:"server,omitempty"`
+	Second int       `json:"second,omitempty"`
+	// Mbps is set by the sample events of a download or upload second, even
+	// one in which nothing moved, and by the phase_end events of those
+	// phases; it tells them apart from the latency samples taken meanwhile.
+	Mbps      *float64 `json:"mbps,omitempty"`
+	LatencyMs float64  `json:"latency_ms,omitempty"`
+	JitterMs  float64  `json:"jitter_ms,omitempty"`
+	UsedMB    int      `json:"used_mb,omitempty"`
+	Servers   int      `json:"servers,omitempty"`
+	Shortened bool     `json:"shortened,omitempty"`
+	// Insufficient is set when the phase took too few samples to report.
+	Insufficient bool   `json:"insufficient,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// eventMbps returns mbps for Event.Mbps.
+func eventMbps(mbps float64) *float64 {
+	return &mbps
+}
+
+// NDJSONEvents returns an event handler that writes one JSON object per line.
+func NDJSONEvents(w io.Writer) func(Event) {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(evt Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(evt)
+	}
+}
+
+// teeEvents returns a handler that passes each event to both handlers,
+// either of which may be nil.
+func teeEvents(a, b func(Event)) func(Event) {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	return func(evt Event) {
+		a(evt)
+		b(evt)
+	}
+}
+
+// OpenProgressOutput opens the side channel of -progress-fd or
+// -progress-file for the NDJSON events, or returns nil if neither is set.
+// Opening a named pipe waits for its reader.
+func OpenProgressOutput(fd int, path string) (*os.File, error) {
+	switch {
+	case fd >= 0 && path != "":
+		return nil, errors.New("-progress-fd and -progress-file can't be used together")
+	case fd >= 0:
+		if fd <= 2 {
+			return nil, fmt.Errorf("-progress-fd %d is stdin, stdout or stderr; use -progress ndjson for stdout", fd)
+		}
+		f := os.NewFile(uintptr(fd), "progress-fd")
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("-progress-fd %d is not open: %w", fd, err)
+		}
+		return f, nil
+	case path != "":
+		return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	}
+	return nil, nil
+}
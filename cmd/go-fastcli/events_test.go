@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestNDJSONEventsMbps(t *testing.T) {
+	var buf bytes.Buffer
+	emit := NDJSONEvents(&buf)
+	emit(Event{Type: EventSample, Phase: "download", Second: 3, Mbps: eventMbps(0)})
+	emit(Event{Type: EventSample, Phase: "download", Second: 3, LatencyMs: 25})
+
+	dec := json.NewDecoder(&buf)
+	for _, wantMbps := range []bool{true, false} {
+		var fields map[string]interface{}
+		if err := dec.Decode(&fields); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := fields["mbps"]; ok != wantMbps {
+			t.Errorf("%v: got mbps %v, want %v", fields, ok, wantMbps)
+		}
+	}
+}
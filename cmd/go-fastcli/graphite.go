@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const defaultGraphitePrefix = "fastcli"
+
+// SendGraphite writes the averages across servers to the Graphite server at
+// addr with the plaintext protocol, one "path value timestamp" line per
+// metric, timestamped with the start of the run. Phases that were skipped
+// or had insufficient data are left out.
+func SendGraphite(ctx context.Context, addr, prefix string, result Result) error {
+	summary := result.Summary()
+	prefix = strings.TrimSuffix(prefix, ".")
+	ts := result.Time.Unix()
+	var lines strings.Builder
+	metric := func(name, phase string, value float64) {
+		if value != 0 && !summary.lacks(phase) {
+			fmt.Fprintf(&lines, "%s.%s %s %d\n", prefix, name, strconv.FormatFloat(value, 'f', -1, 64), ts)
+		}
+	}
+	metric("download_mbps", "download", summary.DownloadMbps)
+	metric("upload_mbps", "upload", summary.UploadMbps)
+	metric("ping_ms", "latency", summary.PingMs)
+	metric("jitter_ms", "latency", summary.JitterMs)
+	metric("bytes_used", "", float64(summary.BytesUsed))
+	if lines.Len() == 0 {
+		return nil
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write([]byte(lines.String())); err != nil {
+		conn.Close()
+		return err
+	}
+	return conn.Close()
+}
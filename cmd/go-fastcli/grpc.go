@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The daemon serves the FastCLI service from proto/fastcli.proto. gRPC is
+// plain HTTP/2 with length-prefixed protobuf messages and the status in
+// trailers, so net/http can serve it once TLS enables HTTP/2; the messages
+// are encoded by hand like the remote-write payload.
+
+const grpcService = "/fastcli.v1.FastCLI/"
+
+const grpcMaxRequestLength = 1 << 20
+
+// gRPC status codes
+const (
+	grpcOK              = 0
+	grpcUnknown         = 2
+	grpcInvalidArgument = 3
+	grpcAborted         = 10
+	grpcUnimplemented   = 12
+	grpcInternal        = 13
+	grpcUnauthenticated = 16
+)
+
+type grpcError struct {
+	Code    int
+	Message string
+}
+
+func (e *grpcError) Error() string {
+	return e.Message
+}
+
+// GRPCHandler serves RunTest, StreamProgress and GetHistory. If token is not
+// empty, calls must send it as a bearer token in the authorization metadata.
+func (d *Daemon) GRPCHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "gRPC requires POST over HTTP/2", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.WriteHeader(http.StatusOK)
+		err := d.serveGRPC(w, r, token)
+		status := &grpcError{Code: grpcOK}
+		if err != nil && !errors.As(err, &status) {
+			status = &grpcError{Code: grpcInternal, Message: err.Error()}
+		}
+		w.Header().Set("Grpc-Status", strconv.Itoa(status.Code))
+		if status.Message != "" {
+			w.Header().Set("Grpc-Message", grpcPercentEncode(status.Message))
+		}
+	})
+}
+
+func (d *Daemon) serveGRPC(w http.ResponseWriter, r *http.Request, token string) error {
+	if token != "" && !validToken(r, token) {
+		return &grpcError{Code: grpcUnauthenticated, Message: "invalid or missing token"}
+	}
+	req, err := readGRPCMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	switch strings.TrimPrefix(r.URL.Path, grpcService) {
+	case "RunTest":
+		finished := d.Finished()
+		if !d.Trigger() {
+			return &grpcError{Code: grpcAborted, Message: "a run is already in progress"}
+		}
+		select {
+		case <-finished:
+		case <-r.Context().Done():
+			return r.Context().Err()
+		}
+		status := d.Status()
+		if status.LastError != "" {
+			return &grpcError{Code: grpcUnknown, Message: status.LastError}
+		}
+		return writeGRPCMessage(w, encodeResult(*status.LastResult))
+	case "StreamProgress":
+		events, cancel := d.Subscribe()
+		defer cancel()
+		for {
+			select {
+			case evt := <-events:
+				if err := writeGRPCMessage(w, encodeEvent(evt)); err != nil {
+					return err
+				}
+			case <-r.Context().Done():
+				return nil
+			}
+		}
+	case "GetHistory":
+		var sinceArg, untilArg string
+		err := decodeProto(req, func(field int, _ uint64, data []byte) {
+			switch field {
+			case 1:
+				sinceArg = string(data)
+			case 2:
+				untilArg = string(data)
+			}
+		})
+		if err != nil {
+			return &grpcError{Code: grpcInvalidArgument, Message: err.Error()}
+		}
+		since, until, err := parseTimeRange(sinceArg, untilArg)
+		if err != nil {
+			return &grpcError{Code: grpcInvalidArgument, Message: err.Error()}
+		}
+		var resp protoBuf
+		for _, result := range d.Results(since, until) {
+			resp.bytesField(1, encodeResult(result))
+		}
+		return writeGRPCMessage(w, resp)
+	default:
+		return &grpcError{Code: grpcUnimplemented, Message: "unknown method " + r.URL.Path}
+	}
+}
+
+// readGRPCMessage reads the single request message of a unary or
+// server-streaming call.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, &grpcError{Code: grpcInvalidArgument, Message: "reading request: " + err.Error()}
+	}
+	if prefix[0] != 0 {
+		return nil, &grpcError{Code: grpcUnimplemented, Message: "compressed requests are not supported"}
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > grpcMaxRequestLength {
+		return nil, &grpcError{Code: grpcInvalidArgument, Message: "request too large"}
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, &grpcError{Code: grpcInvalidArgument, Message: "reading request: " + err.Error()}
+	}
+	return msg, nil
+}
+
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) error {
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+	if _, err := w.Write(append(prefix[:], msg...)); err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
+
+// decodeProto calls fn for each field of msg with the value of varint fields
+// or the data of length-delimited ones. Other wire types are skipped.
+func decodeProto(msg []byte, fn func(field int, v uint64, data []byte)) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errors.New("malformed protobuf message")
+		}
+		msg = msg[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return errors.New("malformed protobuf message")
+			}
+			msg = msg[n:]
+			fn(field, v, nil)
+		case 1:
+			if len(msg) < 8 {
+				return errors.New("malformed protobuf message")
+			}
+			msg = msg[8:]
+		case 2:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < size {
+				return errors.New("malformed protobuf message")
+			}
+			fn(field, 0, msg[n:n+int(size)])
+			msg = msg[n+int(size):]
+		case 5:
+			if len(msg) < 4 {
+				return errors.New("malformed protobuf message")
+			}
+			msg = msg[4:]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", key&7)
+		}
+	}
+	return nil
+}
+
+func encodeResult(result Result) []byte {
+	var b protoBuf
+	b.uintField(1, uint64(result.Time.UnixNano()))
+	b.stringField(2, result.Connection.IP)
+	b.stringField(3, result.Connection.ASN)
+	b.stringField(4, result.Connection.Location.City)
+	b.stringField(5, result.Connection.Location.Country)
+	b.stringField(6, result.SourceAddress)
+	for _, server := range result.Servers {
+		var s protoBuf
+		s.stringField(1, server.Host)
+		s.stringField(2, server.URL)
+		s.stringField(3, server.City)
+		s.stringField(4, server.Country)
+		s.doubleField(5, server.LatencyMs)
+		s.doubleField(6, server.JitterMs)
+		s.doubleField(7, server.DownloadMbps)
+		s.uintField(8, uint64(server.DownloadMB))
+		s.doubleField(9, server.UploadMbps)
+		s.uintField(10, uint64(server.UploadMB))
+		for _, phase := range server.Shortened {
+			s.stringField(11, phase)
+		}
+		for _, phase := range server.Insufficient {
+			s.stringField(12, phase)
+		}
+		b.bytesField(7, s)
+	}
+	return b
+}
+
+func encodeEvent(evt Event) []byte {
+	var b protoBuf
+	b.stringField(1, evt.Type)
+	b.uintField(2, uint64(evt.Time.UnixNano()))
+	b.stringField(3, evt.Phase)
+	b.stringField(4, evt.Server)
+	b.uintField(5, uint64(evt.Second))
+	if evt.Mbps != nil {
+		b.doubleField(6, *evt.Mbps)
+	}
+	b.doubleField(7, evt.LatencyMs)
+	b.doubleField(8, evt.JitterMs)
+	b.uintField(9, uint64(evt.UsedMB))
+	b.uintField(10, uint64(evt.Servers))
+	b.stringField(11, evt.Error)
+	if evt.Shortened {
+		b.uintField(12, 1)
+	}
+	if evt.Insufficient {
+		b.uintField(13, 1)
+	}
+	return b
+}
+
+// grpcPercentEncode escapes a status message as the gRPC spec requires.
+func grpcPercentEncode(msg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// Regulators and ISPs define "speed" differently, so the headline download
+// and upload figures can be computed in several ways.
+const (
+	HeadlineStable      = "stable"       // best of the last transfers once speed stabilized
+	HeadlineMean        = "mean"         // mean of per-second samples
+	HeadlineP90         = "p90"          // 90th percentile of per-second samples
+	HeadlineTrimmedMean = "trimmed-mean" // mean without the best and worst 10%
+)
+
+// Short tests on fast links can finish before enough whole seconds have
+// passed; the per-transfer speeds are used instead in that case.
+const minPerSecondSamples = 3
+
+func ValidHeadline(metric string) bool {
+	switch metric {
+	case "", HeadlineStable, HeadlineMean, HeadlineP90, HeadlineTrimmedMean:
+		return true
+	}
+	return false
+}
+
+func HeadlineMbps(metric string, t fastcom.Throughput, samples []Sample) (float64, error) {
+	if metric == HeadlineStable || metric == "" {
+		return t.Mbps, nil
+	}
+	values := t.SamplesMbps
+	if len(samples) >= minPerSecondSamples {
+		values = make([]float64, len(samples))
+		for i, sample := range samples {
+			values[i] = float64(sample.Bytes) / 125000
+		}
+	}
+	switch metric {
+	case HeadlineMean:
+		return fastcom.CalcMean(values)
+	case HeadlineP90:
+		return fastcom.CalcPercentile(values, 90)
+	case HeadlineTrimmedMean:
+		return fastcom.CalcTrimmedMean(values, 0.1)
+	}
+	return 0, fmt.Errorf("unknown headline metric %q", metric)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// HistoryRecord is one server's measurements from a past run.
+type HistoryRecord struct {
+	// ID identifies the record in a history store; it is 0 for CSV logs.
+	ID     int64
+	Time   time.Time
+	IP     string
+	ASN    string
+	Server string
+	// The measurements of a phase the server took too few samples of are
+	// NaN.
+	PingMs       float64
+	JitterMs     float64
+	DownloadMbps float64
+	UploadMbps   float64
+	BytesUsed    int64
+	// Anomaly describes what was unusual about the run, if the daemon
+	// found anything.
+	Anomaly string
+}
+
+// lacks reports whether the record has no measurement of phase.
+func (r HistoryRecord) lacks(phase string) bool {
+	switch phase {
+	case "latency":
+		return math.IsNaN(r.PingMs)
+	case "download":
+		return math.IsNaN(r.DownloadMbps)
+	case "upload":
+		return math.IsNaN(r.UploadMbps)
+	}
+	return false
+}
+
+// serverResult turns the record back into the result of its server.
+func (r HistoryRecord) serverResult() ServerResult {
+	server := ServerResult{Host: r.Server}
+	for _, phase := range []string{"latency", "download", "upload"} {
+		if r.lacks(phase) {
+			server.Insufficient = append(server.Insufficient, phase)
+		}
+	}
+	if !r.lacks("latency") {
+		server.LatencyMs, server.JitterMs = r.PingMs, r.JitterMs
+	}
+	if !r.lacks("download") {
+		server.DownloadMbps = r.DownloadMbps
+	}
+	if !r.lacks("upload") {
+		server.UploadMbps = r.UploadMbps
+	}
+	return server
+}
+
+// recentRuns returns the summaries of the last n runs in records, newest
+// first. The records of a run share its time; like Result.Summary, a run's
+// measurements are the mean over the servers that measured them.
+func recentRuns(records []HistoryRecord, n int) []Summary {
+	var runs []Summary
+	for i := len(records) - 1; i >= 0 && len(runs) < n; {
+		t := records[i].Time
+		var run Result
+		var used int64
+		for ; i >= 0 && records[i].Time.Equal(t); i-- {
+			run.Servers = append(run.Servers, records[i].serverResult())
+			used += records[i].BytesUsed
+		}
+		s := run.Summary()
+		s.BytesUsed = used
+		runs = append(runs, s)
+	}
+	return runs
+}
+
+// ReadCSVHistory reads a log written with -format csv.
+func ReadCSVHistory(path string) ([]HistoryRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = len(csvHeader)
+	var records []HistoryRecord
+	for line := 1; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if row[0] == csvHeader[0] {
+			continue
+		}
+		record, err := parseCSVRecord(row)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, record)
+	}
+}
+
+func parseCSVRecord(row []string) (HistoryRecord, error) {
+	record := HistoryRecord{IP: row[1], ASN: row[2], Server: row[3]}
+	var err error
+	if record.Time, err = time.Parse(time.RFC3339, row[0]); err != nil {
+		return record, err
+	}
+	for i, dst := range []*float64{&record.PingMs, &record.JitterMs, &record.DownloadMbps, &record.UploadMbps} {
+		// an empty field is a phase with insufficient data
+		if row[4+i] == "" {
+			*dst = math.NaN()
+		} else if *dst, err = strconv.ParseFloat(row[4+i], 64); err != nil {
+			return record, err
+		}
+	}
+	record.BytesUsed, err = strconv.ParseInt(row[8], 10, 64)
+	return record, err
+}
+
+// ParseTimeBound accepts an RFC 3339 time, a date (2006-01-02), or a duration
+// ago such as 72h or 30d.
+func ParseTimeBound(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339, YYYY-MM-DD, or a duration like 30d", s)
+}
+
+// parseTimeRange parses the -since and -until flags; empty bounds are zero.
+func parseTimeRange(since, until string) (sinceTime, untilTime time.Time, err error) {
+	now := time.Now()
+	if since != "" {
+		if sinceTime, err = ParseTimeBound(since, now); err != nil {
+			return
+		}
+	}
+	if until != "" {
+		untilTime, err = ParseTimeBound(until, now)
+	}
+	return
+}
+
+func FilterHistory(records []HistoryRecord, since, until time.Time) []HistoryRecord {
+	var filtered []HistoryRecord
+	for _, record := range records {
+		if !since.IsZero() && record.Time.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !record.Time.Before(until) {
+			continue
+		}
+		filtered = append(filtered, record)
+	}
+	return filtered
+}
+
+// WriteHistory prints records as a table. Measurements with insufficient
+// data are shown as "-".
+func WriteHistory(w io.Writer, records []HistoryRecord) {
+	value := func(v float64) string {
+		if math.IsNaN(v) {
+			return "-"
+		}
+		return strconv.FormatFloat(v, 'f', 1, 64)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "ID\tTime\tServer\tPing (ms)\tJitter (ms)\tDownload (Mbit/s)\tUpload (Mbit/s)\t")
+	for _, r := range records {
+		// the anomaly trails the columns rather than widening them
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t %s\n", r.ID, r.Time.Local().Format("2006-01-02 15:04"), r.Server,
+			value(r.PingMs), value(r.JitterMs), value(r.DownloadMbps), value(r.UploadMbps), r.Anomaly)
+	}
+	tw.Flush()
+}
+
+// formatBytes formats n in decimal units, like the data caps of ISPs.
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1e12:
+		return fmt.Sprintf("%0.2f TB", float64(n)/1e12)
+	case n >= 1e9:
+		return fmt.Sprintf("%0.2f GB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%0.1f MB", float64(n)/1e6)
+	}
+	return fmt.Sprintf("%d kB", n/1000)
+}
+
+// WriteHistorySummary prints the mean, minimum and maximum of the records'
+// ping, download and upload, leaving out the records with insufficient data
+// of each.
+func WriteHistorySummary(w io.Writer, records []HistoryRecord) {
+	if len(records) == 0 {
+		fmt.Fprintln(w, "No measurements")
+		return
+	}
+	fmt.Fprintf(w, "%d measurements from %s to %s\n", len(records),
+		records[0].Time.Local().Format("2006-01-02 15:04"), records[len(records)-1].Time.Local().Format("2006-01-02 15:04"))
+	var used int64
+	for _, r := range records {
+		used += r.BytesUsed
+	}
+	runs := len(recentRuns(records, len(records)))
+	fmt.Fprintf(w, "Data used: %s by %d runs, %s per run\n", formatBytes(used), runs, formatBytes(used/int64(runs)))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\tMean\tMin\tMax\t")
+	for _, metric := range []struct {
+		name  string
+		value func(HistoryRecord) float64
+	}{
+		{"Ping (ms)", func(r HistoryRecord) float64 { return r.PingMs }},
+		{"Download (Mbit/s)", func(r HistoryRecord) float64 { return r.DownloadMbps }},
+		{"Upload (Mbit/s)", func(r HistoryRecord) float64 { return r.UploadMbps }},
+	} {
+		sum, n, min, max := 0.0, 0, math.Inf(1), math.Inf(-1)
+		for _, r := range records {
+			v := metric.value(r)
+			if math.IsNaN(v) {
+				continue
+			}
+			sum += v
+			n++
+			min = math.Min(min, v)
+			max = math.Max(max, v)
+		}
+		if n == 0 {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t\n", metric.name)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%0.1f\t%0.1f\t%0.1f\t\n", metric.name, sum/float64(n), min, max)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HistoryStore keeps results for the history and compare commands.
+type HistoryStore interface {
+	// Add stores the result, one record per server.
+	Add(result Result) error
+	// Records returns the stored measurements in [since, until), oldest
+	// first. Zero times leave the range open.
+	Records(since, until time.Time) ([]HistoryRecord, error)
+	// Record returns the record with the given ID, as listed by history.
+	Record(id int64) (HistoryRecord, error)
+}
+
+// OpenHistory returns the store of the given backend. location is a
+// database file for sqlite, a connection URL for postgres and an NDJSON file
+// for file.
+func OpenHistory(backend, location string) (HistoryStore, error) {
+	switch backend {
+	case "", "sqlite":
+		return SQLiteHistory{Path: location}, nil
+	case "postgres":
+		return PostgresHistory{URL: location}, nil
+	case "file":
+		return FileHistory{Path: location}, nil
+	}
+	return nil, fmt.Errorf("unknown history backend %q, expected sqlite, postgres or file", backend)
+}
+
+// historyRow is a HistoryRecord as the backends store it, with the column
+// names of the results table. The measurements of a phase with insufficient
+// data are null.
+type historyRow struct {
+	ID           int64     `json:"id"`
+	Time         time.Time `json:"time"`
+	IP           string    `json:"ip"`
+	ASN          string    `json:"asn"`
+	Server       string    `json:"server"`
+	PingMs       *float64  `json:"ping_ms"`
+	JitterMs     *float64  `json:"jitter_ms"`
+	DownloadMbps *float64  `json:"download_mbps"`
+	UploadMbps   *float64  `json:"upload_mbps"`
+	BytesUsed    int64     `json:"bytes_used"`
+	Anomaly      string    `json:"anomaly,omitempty"`
+}
+
+// record turns a null measurement into NaN.
+func (row historyRow) record() HistoryRecord {
+	value := func(v *float64) float64 {
+		if v == nil {
+			return math.NaN()
+		}
+		return *v
+	}
+	return HistoryRecord{
+		ID:           row.ID,
+		Time:         row.Time,
+		IP:           row.IP,
+		ASN:          row.ASN,
+		Server:       row.Server,
+		PingMs:       value(row.PingMs),
+		JitterMs:     value(row.JitterMs),
+		DownloadMbps: value(row.DownloadMbps),
+		UploadMbps:   value(row.UploadMbps),
+		BytesUsed:    row.BytesUsed,
+		Anomaly:      row.Anomaly,
+	}
+}
+
+func historyRecords(rows []historyRow) []HistoryRecord {
+	records := make([]HistoryRecord, len(rows))
+	for i, row := range rows {
+		records[i] = row.record()
+	}
+	return records
+}
+
+// storedValue is the value a history stores for a measurement of server:
+// v, or null if the server took too few samples of phase or v isn't a
+// number SQL or JSON can hold, such as the NaN of a jitter without samples.
+func storedValue(server ServerResult, phase string, v float64) *float64 {
+	if server.lacks(phase) || math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+// FileHistory stores results as JSON lines in a plain file, one per server,
+// for systems without sqlite3 such as routers. It stands in for an embedded
+// database such as bbolt, which would be the module's first dependency
+// outside the standard library, and needs no cgo or command either. IDs
+// count up from 1 in the order the records were added. Adding reads only
+// the last record, for its ID; queries scan the whole file, which is fine
+// for the few thousand records of a scheduled test.
+type FileHistory struct {
+	Path string
+}
+
+// Add appends the result, creating the file if needed.
+func (h FileHistory) Add(result Result) error {
+	if err := os.MkdirAll(filepath.Dir(h.Path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.Path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	id, err := h.lastID(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, server := range result.Servers {
+		id++
+		err := enc.Encode(historyRow{
+			ID:           id,
+			Time:         result.Time.UTC().Truncate(time.Second),
+			IP:           result.Connection.IP,
+			ASN:          result.Connection.ASN,
+			Server:       server.Host,
+			PingMs:       storedValue(server, "latency", server.LatencyMs),
+			JitterMs:     storedValue(server, "latency", server.JitterMs),
+			DownloadMbps: storedValue(server, "download", server.DownloadMbps),
+			UploadMbps:   storedValue(server, "upload", server.UploadMbps),
+			BytesUsed:    int64(server.DownloadMB+server.UploadMB) * 1024 * 1024,
+			Anomaly:      strings.Join(result.Anomalies, "; "),
+		})
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func (h FileHistory) Records(since, until time.Time) ([]HistoryRecord, error) {
+	rows, err := h.rows()
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s does not exist; store results in it with -db first", h.Path)
+	} else if err != nil {
+		return nil, err
+	}
+	var records []HistoryRecord
+	for _, row := range rows {
+		if (since.IsZero() || !row.Time.Before(since)) && (until.IsZero() || row.Time.Before(until)) {
+			records = append(records, row.record())
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+func (h FileHistory) Record(id int64) (HistoryRecord, error) {
+	rows, err := h.rows()
+	if err != nil {
+		return HistoryRecord{}, err
+	}
+	for _, row := range rows {
+		if row.ID == id {
+			return row.record(), nil
+		}
+	}
+	return HistoryRecord{}, fmt.Errorf("no record %d in %s", id, h.Path)
+}
+
+// lastID returns the ID of the last record in f, or 0 if it has none. It
+// reads backwards from the end in blocks until it has the last whole line.
+func (h FileHistory) lastID(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	const block = 4096
+	var tail []byte
+	for end := info.Size(); end > 0; {
+		start := end - block
+		if start < 0 {
+			start = 0
+		}
+		buf := make([]byte, end-start)
+		if _, err := f.ReadAt(buf, start); err != nil {
+			return 0, err
+		}
+		tail = append(buf, tail...)
+		end = start
+		// the last line is whole once a newline precedes it, or the start
+		// of the file was reached
+		line := bytes.TrimRight(tail, "\n")
+		i := bytes.LastIndexByte(line, '\n')
+		if i < 0 && end > 0 {
+			continue
+		}
+		line = line[i+1:]
+		if len(line) == 0 {
+			return 0, nil
+		}
+		var row historyRow
+		if err := json.Unmarshal(line, &row); err != nil {
+			return 0, fmt.Errorf("%s: last record: %w", h.Path, err)
+		}
+		return row.ID, nil
+	}
+	return 0, nil
+}
+
+func (h FileHistory) rows() ([]historyRow, error) {
+	f, err := os.Open(h.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rows []historyRow
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var row historyRow
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", h.Path, line, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileHistory(t *testing.T) {
+	h := FileHistory{Path: filepath.Join(t.TempDir(), "sub", "history.ndjson")}
+	start := time.Date(2024, 6, 12, 14, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		result := Result{
+			Time: start.Add(time.Duration(i) * time.Hour),
+			Servers: []ServerResult{
+				{Host: "a.example", DownloadMbps: float64(100 + i)},
+				// long enough that the last record spans more than one
+				// block read by lastID
+				{Host: "b.example" + strings.Repeat("x", 5000), DownloadMbps: float64(200 + i)},
+			},
+		}
+		if err := h.Add(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err := h.Records(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 6 {
+		t.Fatalf("got %d records, want 6", len(records))
+	}
+	for i, record := range records {
+		if record.ID != int64(i+1) {
+			t.Errorf("record %d has ID %d", i, record.ID)
+		}
+	}
+	record, err := h.Record(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.DownloadMbps != 201 || !record.Time.Equal(start.Add(time.Hour)) {
+		t.Errorf("record 4 is %+v", record)
+	}
+	records, err = h.Records(start.Add(time.Hour), start.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].ID != 3 {
+		t.Errorf("got %+v for the second run", records)
+	}
+}
+
+func TestFileHistoryInsufficient(t *testing.T) {
+	h := FileHistory{Path: filepath.Join(t.TempDir(), "history.ndjson")}
+	result := Result{
+		Time:    time.Date(2024, 6, 12, 14, 0, 0, 0, time.UTC),
+		Servers: []ServerResult{{Host: "a.example", LatencyMs: 12, DownloadMbps: 100, Insufficient: []string{"upload"}}},
+	}
+	if err := h.Add(result); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(h.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"upload_mbps":null`) {
+		t.Errorf("got %s, want a null upload", data)
+	}
+	record, err := h.Record(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsNaN(record.UploadMbps) || record.DownloadMbps != 100 {
+		t.Errorf("got %+v, want a NaN upload", record)
+	}
+	runs := recentRuns([]HistoryRecord{record}, 1)
+	if len(runs) != 1 || !runs[0].lacks("upload") || runs[0].DownloadMbps != 100 {
+		t.Errorf("got runs %+v", runs)
+	}
+}
+
+func TestSQLiteHistoryInsufficient(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+	h := SQLiteHistory{Path: filepath.Join(t.TempDir(), "results.db")}
+	// a table from before the measurements could be NULL
+	old := strings.ReplaceAll(sqliteSchema, "_ms REAL,", "_ms REAL NOT NULL,")
+	old = strings.ReplaceAll(old, "_mbps REAL,", "_mbps REAL NOT NULL,")
+	if _, err := h.run(old + "INSERT INTO results VALUES ('2024-06-12T13:00:00Z', '', '', 'old.example', 10, 1, 50, 5, 0, '');\n"); err != nil {
+		t.Fatal(err)
+	}
+	result := Result{
+		Time:    time.Date(2024, 6, 12, 14, 0, 0, 0, time.UTC),
+		Servers: []ServerResult{{Host: "a.example", UploadMbps: 20, Insufficient: []string{"latency", "download"}}},
+	}
+	if err := h.Add(result); err != nil {
+		t.Fatal(err)
+	}
+	records, err := h.Records(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].ID != 1 || records[0].DownloadMbps != 50 {
+		t.Fatalf("got %+v, want the old record kept as 1", records)
+	}
+	r := records[1]
+	if !math.IsNaN(r.PingMs) || !math.IsNaN(r.JitterMs) || !math.IsNaN(r.DownloadMbps) || r.UploadMbps != 20 {
+		t.Errorf("got %+v, want NaN latency and download", r)
+	}
+}
+
+func TestWriteInsertsNonFinite(t *testing.T) {
+	result := Result{
+		Time:    time.Date(2024, 6, 12, 14, 0, 0, 0, time.UTC),
+		Servers: []ServerResult{{Host: "a.example", LatencyMs: 12, JitterMs: math.NaN(), DownloadMbps: math.Inf(1), UploadMbps: math.Inf(-1)}},
+	}
+	var sql strings.Builder
+	writeInserts(&sql, result)
+	for _, bad := range []string{"NaN", "Inf"} {
+		if strings.Contains(sql.String(), bad) {
+			t.Errorf("got %s", sql.String())
+		}
+	}
+	if !strings.Contains(sql.String(), "12, NULL, NULL, NULL") {
+		t.Errorf("got %s, want NULL for the values that aren't finite", sql.String())
+	}
+
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+	h := SQLiteHistory{Path: filepath.Join(t.TempDir(), "results.db")}
+	if err := h.Add(result); err != nil {
+		t.Fatal(err)
+	}
+	record, err := h.Record(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.PingMs != 12 || !math.IsNaN(record.JitterMs) || !math.IsNaN(record.DownloadMbps) {
+		t.Errorf("got %+v", record)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// filterServerNum is how many targets to ask the fast.com API for when
+// -include-host or -exclude-host may leave some out.
+const filterServerNum = 5
+
+// FilterServers keeps the servers whose host name matches one of the
+// include globs, if any are given, and none of the exclude globs, in the
+// syntax of path.Match, e.g. "*.isp.nflxvideo.net". It returns the servers
+// kept and the URLs of those left out.
+func FilterServers(servers []fastcom.Server, include, exclude []string) (kept []fastcom.Server, excluded []string) {
+	for _, server := range servers {
+		host := server.URL
+		if u, err := url.Parse(server.URL); err == nil && u.Hostname() != "" {
+			host = u.Hostname()
+		}
+		if (len(include) > 0 && !matchHost(include, host)) || matchHost(exclude, host) {
+			excluded = append(excluded, server.URL)
+			continue
+		}
+		kept = append(kept, server)
+	}
+	return kept, excluded
+}
+
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		// the patterns are checked by validHostPatterns
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func validHostPatterns(flag string, patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid -%s pattern %q: %w", flag, pattern, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// interrupted reports whether ctx was cancelled, by SIGINT or SIGTERM,
+// rather than stopped by a deadline.
+func interrupted(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled)
+}
+
+// markIncomplete records that an interrupt stopped the phase of server n
+// before it finished.
+func markIncomplete(result *Result, n int, phase string) {
+	result.Interrupted = true
+	result.Servers[n].Incomplete = append(result.Servers[n].Incomplete, phase)
+}
+
+// partialLatency keeps the latency samples server n took before the
+// interrupt, and prints them marked as incomplete.
+func partialLatency(cfg Config, result *Result, n int, latency fastcom.Latency) {
+	markIncomplete(result, n, "latency")
+	server := &result.Servers[n]
+	var millis []float64
+	for _, sample := range latency.Samples {
+		millis = append(millis, float64(sample)/float64(time.Millisecond))
+	}
+	server.LatencySamplesMs = millis
+	if len(millis) == 0 {
+		fmt.Fprintf(out.Progress, "  - %s: incomplete, interrupted before the first sample\n", server.Host)
+		return
+	}
+	server.LatencyMs, _ = fastcom.CalcMean(millis)
+	if len(millis) > 1 {
+		server.JitterMs, _ = fastcom.CalcJitter(millis)
+	}
+	fmt.Fprintf(out.Progress, "  - %s: %s (%0.3f ms jitter, incomplete: interrupted after %d samples)\n", server.Host, cfg.Colors.Latency(server.LatencyMs), server.JitterMs, len(millis))
+}
+
+// partialTransfer keeps the mean speed of the whole seconds a transfer phase
+// of server n ran before the interrupt, and prints it marked as incomplete.
+func partialTransfer(cfg Config, result *Result, n int, phase string, samples []Sample) {
+	markIncomplete(result, n, phase)
+	server := &result.Servers[n]
+	if len(samples) == 0 {
+		fmt.Fprintf(out.Progress, "  - %s: incomplete, interrupted within the first second\n", server.Host)
+		return
+	}
+	var bytes int64
+	values := make([]float64, len(samples))
+	for i, sample := range samples {
+		bytes += sample.Bytes
+		values[i] = float64(sample.Bytes) / 125000
+	}
+	mbps, _ := fastcom.CalcMean(values)
+	usedMB := int(bytes / (1024 * 1024))
+	plan := cfg.PlanDownMbps
+	if phase == "download" {
+		server.DownloadMbps, server.DownloadMB = mbps, usedMB
+	} else {
+		server.UploadMbps, server.UploadMB = mbps, usedMB
+		plan = cfg.PlanUpMbps
+	}
+	fmt.Fprintf(out.Progress, "  - %s: %s (used %d MB, incomplete: interrupted after %d s)\n", server.Host, cfg.Colors.Speed(phase, mbps, plan), usedMB, len(samples))
+}
+
+// incompletePhases lists the phases an interrupt cut short, as
+// "download on host", for the message that the result is partial.
+func incompletePhases(result Result) string {
+	var phases []string
+	for _, server := range result.Servers {
+		for _, phase := range server.Incomplete {
+			phases = append(phases, phase+" on "+server.Host)
+		}
+	}
+	return strings.Join(phases, ", ")
+}
+
+// completedOnly returns result with every phase an interrupt kept from
+// completing, whether it cut the phase short or the phase never started,
+// marked as lacking, so that the history stores null for it rather than a
+// truncated value or a 0 that would pass for a measurement. Servers left
+// without any measurement are dropped.
+func completedOnly(result Result) Result {
+	var servers []ServerResult
+	for _, server := range result.Servers {
+		insufficient := append([]string(nil), server.Insufficient...)
+		for _, phase := range []string{"latency", "download", "upload"} {
+			value := server.LatencyMs
+			switch phase {
+			case "download":
+				value = server.DownloadMbps
+			case "upload":
+				value = server.UploadMbps
+			}
+			if !server.lacks(phase) && (containsString(server.Incomplete, phase) || value == 0) {
+				insufficient = append(insufficient, phase)
+			}
+		}
+		if len(insufficient) == 3 {
+			continue
+		}
+		server.Insufficient = insufficient
+		servers = append(servers, server)
+	}
+	result.Servers = servers
+	return result
+}
+
+// reportInterrupted writes the partial result of a run stopped by SIGINT or
+// SIGTERM in format, and keeps it in the journal and the history, where
+// it is marked as interrupted and holds only the phases that completed. The
+// other sinks are left out: a partial result would only set off their
+// alerts.
+func reportInterrupted(cfg Config, format string, result Result, runErr error) {
+	fmt.Fprintf(out.Log, "Interrupted, the result is partial: %s incomplete\n", incompletePhases(result))
+	if err := WriteResult(out.Data, format, result); err != nil {
+		fmt.Fprintln(out.Log, "Error writing result:", err)
+	}
+	if cfg.Journal != "" {
+		if err := AppendJournal(cfg, result, runErr); err != nil {
+			fmt.Fprintln(out.Log, "Error writing journal:", err)
+		}
+	}
+	if stored := completedOnly(result); cfg.HistoryDB != "" && len(stored.Servers) > 0 {
+		stored.Anomalies = append(stored.Anomalies, "interrupted, "+incompletePhases(result)+" incomplete")
+		store, err := OpenHistory(cfg.HistoryBackend, cfg.HistoryDB)
+		if err == nil {
+			err = store.Add(stored)
+		}
+		if err != nil {
+			printSinkError("history", err)
+		}
+	}
+}
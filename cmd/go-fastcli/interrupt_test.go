@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompletedOnlyStoresNull(t *testing.T) {
+	// interrupted in the download of b.example, before its upload and
+	// before c.example was tested at all
+	result := Result{
+		Time:        time.Date(2024, 6, 12, 14, 0, 0, 0, time.UTC),
+		Interrupted: true,
+		Servers: []ServerResult{
+			{Host: "a.example", LatencyMs: 10, JitterMs: 1, DownloadMbps: 100, UploadMbps: 20},
+			{Host: "b.example", LatencyMs: 12, JitterMs: 2, DownloadMbps: 40, Incomplete: []string{"download"}},
+			{Host: "c.example"},
+		},
+	}
+	h := FileHistory{Path: filepath.Join(t.TempDir(), "history.ndjson")}
+	if err := h.Add(completedOnly(result)); err != nil {
+		t.Fatal(err)
+	}
+	records, err := h.Records(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2 without c.example", len(records))
+	}
+	if a := records[0]; a.PingMs != 10 || a.DownloadMbps != 100 || a.UploadMbps != 20 {
+		t.Errorf("a.example: got %+v, want its measurements kept", a)
+	}
+	if b := records[1]; b.PingMs != 12 || !math.IsNaN(b.DownloadMbps) || !math.IsNaN(b.UploadMbps) {
+		t.Errorf("b.example: got %+v, want no download or upload", b)
+	}
+	// the result itself is left as it was
+	if len(result.Servers) != 3 || len(result.Servers[1].Insufficient) != 0 {
+		t.Errorf("the result was changed: %+v", result.Servers)
+	}
+}
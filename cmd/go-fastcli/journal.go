@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	defaultJournalMaxSize = "10MiB"
+	defaultJournalKeep    = 5
+)
+
+// JournalEntry is one line of the journal: a run's result, or how far it got
+// and why it failed.
+type JournalEntry struct {
+	Time   time.Time
+	Error  string  `json:",omitempty"`
+	Result *Result `json:",omitempty"`
+}
+
+// AppendJournal appends a compact line for the run to cfg.Journal, rotating
+// the file to .1, .2, ... once it would grow past cfg.JournalMaxSize.
+func AppendJournal(cfg Config, result Result, runErr error) error {
+	maxSize, err := ParseByteSize(cfg.JournalMaxSize)
+	if err != nil {
+		return fmt.Errorf("-journal-max-size: %w", err)
+	}
+	entry := JournalEntry{Time: time.Now(), Result: &result}
+	if runErr != nil {
+		entry.Error = runErr.Error()
+	}
+	if len(result.Servers) == 0 {
+		entry.Result = nil
+	}
+	result.Samples, result.LoadedLatency = nil, nil
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if info, err := os.Stat(cfg.Journal); err == nil && info.Size() > 0 && info.Size()+int64(len(line)) > maxSize {
+		if err := rotateJournal(cfg.Journal, cfg.JournalKeep); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(cfg.Journal, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotateJournal shifts path to path.1, path.1 to path.2 and so on, dropping
+// the oldest beyond keep.
+func rotateJournal(path string, keep int) error {
+	if keep < 1 {
+		return os.Remove(path)
+	}
+	os.Remove(fmt.Sprintf("%s.%d", path, keep))
+	for n := keep - 1; n >= 1; n-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", path, n), fmt.Sprintf("%s.%d", path, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(path, path+".1")
+}
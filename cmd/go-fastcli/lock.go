@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExitLocked is returned when -lock-mode=skip finds another run in progress.
+// It matches EX_TEMPFAIL so cron wrappers can tell it apart from failures.
+const ExitLocked = 75
+
+const lockPollInterval = 500 * time.Millisecond
+
+var ErrLocked = errors.New("another instance holds the lock")
+
+type LockFile struct {
+	Path     string
+	held     *os.File
+	progress *os.File
+}
+
+func (l *LockFile) ProgressPath() string {
+	return l.Path + ".progress"
+}
+
+func readLockPID(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// TryAcquire takes the lock, writing our PID to the lock file, or returns
+// ErrLocked if another running process holds it. A lock left behind by a
+// process that is no longer running is taken over; see acquireLock.
+func (l *LockFile) TryAcquire() error {
+	held, err := acquireLock(l.Path, os.Getpid())
+	if err != nil {
+		return err
+	}
+	l.held = held
+	l.progress, err = os.Create(l.ProgressPath())
+	if err != nil {
+		l.Release()
+	}
+	return err
+}
+
+func (l *LockFile) Wait(ctx context.Context) error {
+	for {
+		err := l.TryAcquire()
+		if err != ErrLocked {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// Progress returns a writer that mirrors output into the progress file read
+// by instances running with -lock-mode=attach.
+func (l *LockFile) Progress() io.Writer {
+	return l.progress
+}
+
+func (l *LockFile) Release() {
+	if l.progress != nil {
+		l.progress.Close()
+		os.Remove(l.ProgressPath())
+	}
+	// removed before it is unlocked, so no one else's lock is removed
+	os.Remove(l.Path)
+	if l.held != nil {
+		l.held.Close()
+	}
+}
+
+// Attach copies the lock holder's progress to w until it releases the lock.
+// It returns false if no other instance was running.
+func (l *LockFile) Attach(ctx context.Context, w io.Writer) bool {
+	pid, err := readLockPID(l.Path)
+	if err != nil || !processAlive(pid) {
+		return false
+	}
+	f, err := os.Open(l.ProgressPath())
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	for {
+		if _, err := io.Copy(w, f); err != nil {
+			return true
+		}
+		if current, err := readLockPID(l.Path); err != nil || current != pid || !processAlive(pid) {
+			io.Copy(w, f)
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return true
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
@@ -0,0 +1,52 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// acquireLock takes an exclusive flock on the lock file at path, creating it
+// if needed, and writes pid to it. The kernel drops the flock when its
+// holder exits, so a lock file left behind by a crash is taken over without
+// guessing from its PID whether its writer still runs. Release removes the
+// file while it still holds the flock; a process that got the flock of the
+// removed file in the meantime sees that it is no longer the file at path
+// and tries again. The returned file holds the flock until it is closed.
+func acquireLock(path string, pid int) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			f.Close()
+			if err == syscall.EWOULDBLOCK {
+				return nil, ErrLocked
+			}
+			return nil, err
+		}
+		held, err := f.Stat()
+		current, statErr := os.Stat(path)
+		if err == nil && statErr == nil && os.SameFile(held, current) {
+			if err = f.Truncate(0); err == nil {
+				_, err = f.WriteAt([]byte(strconv.Itoa(pid)+"\n"), 0)
+			}
+			if err != nil {
+				os.Remove(path)
+				f.Close()
+				return nil, err
+			}
+			return f, nil
+		}
+		f.Close()
+		if err == nil && statErr != nil && !os.IsNotExist(statErr) {
+			err = statErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
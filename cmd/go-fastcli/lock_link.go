@@ -0,0 +1,73 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// acquireLock creates the lock file at path holding pid, on systems without
+// flock. The file is written under a temporary name and linked into place,
+// which fails if a lock exists, so a lock is never seen without its PID. A
+// lock whose process is no longer running is moved aside before it is
+// removed: of several processes taking it over, only one can move it, and
+// it checks that what it moved still has the PID it found dead, or the
+// unreadable content it found. One that
+// moved a lock just taken over by another links it back, though a process
+// starting in that moment can still slip in. It returns no file to hold.
+func acquireLock(path string, pid int) (*os.File, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+		if err != nil {
+			return nil, err
+		}
+		_, err = tmp.WriteString(strconv.Itoa(pid) + "\n")
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Link(tmp.Name(), path)
+		}
+		os.Remove(tmp.Name())
+		if err == nil {
+			return nil, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		found, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if owner, err := strconv.Atoi(strings.TrimSpace(string(found))); err == nil && processAlive(owner) {
+			return nil, ErrLocked
+		}
+		placeholder, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".stale.*")
+		if err != nil {
+			return nil, err
+		}
+		placeholder.Close()
+		stale := placeholder.Name()
+		if err := os.Rename(path, stale); err != nil {
+			os.Remove(stale)
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if moved, err := os.ReadFile(stale); err != nil || !bytes.Equal(moved, found) {
+			os.Link(stale, path)
+			os.Remove(stale)
+			return nil, ErrLocked
+		}
+		os.Remove(stale)
+	}
+	return nil, ErrLocked
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func processAlive(pid int) bool {
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fastcli.lock")
+	a := &LockFile{Path: path}
+	if err := a.TryAcquire(); err != nil {
+		t.Fatal(err)
+	}
+	if pid, err := readLockPID(path); err != nil || pid != os.Getpid() {
+		t.Errorf("lock file has PID %d, %v", pid, err)
+	}
+	b := &LockFile{Path: path}
+	if err := b.TryAcquire(); err != ErrLocked {
+		t.Fatalf("second TryAcquire: got %v, want ErrLocked", err)
+	}
+	a.Release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("lock file still there after Release: %v", err)
+	}
+	if err := b.TryAcquire(); err != nil {
+		t.Fatalf("TryAcquire after Release: %v", err)
+	}
+	b.Release()
+}
+
+func TestLockFileStale(t *testing.T) {
+	for _, content := range []string{"2147483646\n", "", "not a pid"} {
+		path := filepath.Join(t.TempDir(), "fastcli.lock")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// several runs take the stale lock over at once; one must win
+		var wg sync.WaitGroup
+		var won, locked int32
+		locks := make([]*LockFile, 8)
+		for i := range locks {
+			locks[i] = &LockFile{Path: path}
+			wg.Add(1)
+			go func(l *LockFile) {
+				defer wg.Done()
+				switch err := l.TryAcquire(); err {
+				case nil:
+					atomic.AddInt32(&won, 1)
+				case ErrLocked:
+					atomic.AddInt32(&locked, 1)
+				default:
+					t.Error(err)
+				}
+			}(locks[i])
+		}
+		wg.Wait()
+		if won != 1 || locked != int32(len(locks)-1) {
+			t.Errorf("%q: %d runs took the lock over and %d found it locked, want 1", content, won, locked)
+		}
+		for _, l := range locks {
+			if l.held != nil || l.progress != nil {
+				l.Release()
+			}
+		}
+	}
+}
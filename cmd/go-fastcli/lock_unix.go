@@ -0,0 +1,10 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
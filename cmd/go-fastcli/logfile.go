@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultLogKeep is how many rotated log files -log-keep keeps by default.
+const defaultLogKeep = 5
+
+// LogFile is a log file that rotates when it grows beyond MaxSize bytes or
+// its first line gets older than MaxAge, whichever comes first; a zero
+// limit is not applied. Rotation renames the file to Path.1, shifting the
+// older ones to Path.2 and so on up to Path.Keep, and starts a new one.
+// Each line is prefixed with the time it was written, unless it already
+// starts with one in the key=value format of -verbose.
+type LogFile struct {
+	Path    string
+	MaxSize int64
+	MaxAge  time.Duration
+	Keep    int
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	opened  time.Time
+	midLine bool
+}
+
+// Open opens the log file at l.Path for appending, creating it if
+// needed.
+func (l *LogFile) Open() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.open()
+}
+
+func (l *LogFile) open() error {
+	f, err := os.OpenFile(l.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size, l.midLine = f, info.Size(), false
+	// an existing file is as old as it was last written, which is the
+	// closest to its first line that is known
+	l.opened = time.Now()
+	if l.size > 0 {
+		l.opened = info.ModTime()
+	}
+	return nil
+}
+
+// Write appends p, rotating first if the file is due. Errors in rotating are
+// reported once in the new file, or, if it can't be opened, returned.
+func (l *LogFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return 0, os.ErrClosed
+	}
+	if !l.midLine && l.due(len(p)) {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	var b bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !l.midLine && !bytes.HasPrefix(line, []byte("time=")) {
+			b.WriteString(time.Now().Format(time.RFC3339) + " ")
+		}
+		b.Write(line)
+		l.midLine = line[len(line)-1] != '\n'
+	}
+	n, err := l.f.Write(b.Bytes())
+	l.size += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (l *LogFile) due(n int) bool {
+	if l.size == 0 {
+		return false
+	}
+	return l.MaxSize > 0 && l.size+int64(n) > l.MaxSize || l.MaxAge > 0 && time.Since(l.opened) >= l.MaxAge
+}
+
+// rotate shifts the rotated files up by one, dropping the oldest, and
+// starts a new file.
+func (l *LogFile) rotate() error {
+	l.f.Close()
+	l.f = nil
+	var failed error
+	for i := l.Keep - 1; i >= 1; i-- {
+		if err := os.Rename(l.rotated(i), l.rotated(i+1)); err != nil && !os.IsNotExist(err) && failed == nil {
+			failed = err
+		}
+	}
+	if l.Keep > 0 {
+		if err := os.Rename(l.Path, l.rotated(1)); err != nil && failed == nil {
+			failed = err
+		}
+	} else if err := os.Remove(l.Path); err != nil && failed == nil {
+		failed = err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	if failed != nil {
+		fmt.Fprintf(l.f, "%s Error rotating the log: %v\n", time.Now().Format(time.RFC3339), failed)
+	}
+	return nil
+}
+
+func (l *LogFile) rotated(i int) string {
+	return l.Path + "." + strconv.Itoa(i)
+}
+
+func (l *LogFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// logFileFlags are the flags of a long-running command's log file, or
+// the system log it writes to instead.
+type logFileFlags struct {
+	to      *string
+	path    *string
+	maxSize *string
+	maxAge  *time.Duration
+	keep    *int
+}
+
+func registerLogFileFlags(fs *flag.FlagSet) logFileFlags {
+	return logFileFlags{
+		to:      fs.String("log-to", "stderr", "write the log to `destination`: stderr, syslog or journald, which also get each result with its fields"),
+		path:    fs.String("log-file", "", "write the log, errors, warnings and -verbose output, to `file` instead of stderr"),
+		maxSize: fs.String("log-max-size", "10MiB", "rotate the -log-file when it grows beyond `size`, e.g. 10MiB (0 for no limit)"),
+		maxAge:  fs.Duration("log-max-age", 0, "rotate the -log-file once its first line is older than `duration`, e.g. 24h"),
+		keep:    fs.Int("log-keep", defaultLogKeep, "`number` of rotated log files to keep, as file.1 (the newest) to file.N"),
+	}
+}
+
+// open switches out.Log to the log file or the system log if one was
+// given, and returns the system log for the results. The returned func
+// closes them again.
+func (f logFileFlags) open() (SystemLog, func(), error) {
+	if *f.to != "stderr" {
+		if *f.path != "" {
+			return nil, nil, errors.New("-log-file and -log-to can't be used together")
+		}
+		system, err := OpenSystemLog(*f.to)
+		if err != nil {
+			return nil, nil, err
+		}
+		log := out.Log
+		out.Log = redactWriter{&systemLogWriter{log: system}}
+		return system, func() {
+			out.Log = log
+			system.Close()
+		}, nil
+	}
+	if *f.path == "" {
+		return nil, func() {}, nil
+	}
+	var maxSize int64
+	if *f.maxSize != "0" {
+		var err error
+		if maxSize, err = ParseByteSize(*f.maxSize); err != nil {
+			return nil, nil, fmt.Errorf("invalid -log-max-size %q: %w", *f.maxSize, err)
+		}
+	}
+	if *f.maxAge < 0 || *f.keep < 0 {
+		return nil, nil, errors.New("-log-max-age and -log-keep can't be negative")
+	}
+	file := &LogFile{Path: *f.path, MaxSize: maxSize, MaxAge: *f.maxAge, Keep: *f.keep}
+	if err := file.Open(); err != nil {
+		return nil, nil, fmt.Errorf("opening the log file: %w", err)
+	}
+	log := out.Log
+	out.Log = redactWriter{file}
+	return nil, func() {
+		out.Log = log
+		file.Close()
+	}, nil
+}
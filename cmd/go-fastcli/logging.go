@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// LogLevel is how much is logged besides the errors and warnings that are
+// always printed.
+type LogLevel int
+
+const (
+	// LevelWarn logs nothing more, the default.
+	LevelWarn LogLevel = iota
+	// LevelInfo, set by -verbose, logs every HTTP request with its status
+	// and duration, and every retry.
+	LevelInfo
+	// LevelDebug, set by -debug, adds each request's connection and the
+	// times of its lookup, connect, TLS handshake and first byte.
+	LevelDebug
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelInfo:
+		return "INFO"
+	case LevelDebug:
+		return "DEBUG"
+	}
+	return "WARN"
+}
+
+var (
+	logMu    sync.Mutex
+	logLevel = LevelWarn
+)
+
+// logLevelFlag is the boolean flag that raises the log level to its own.
+type logLevelFlag LogLevel
+
+func (f logLevelFlag) String() string { return "false" }
+
+func (f logLevelFlag) IsBoolFlag() bool { return true }
+
+func (f logLevelFlag) Set(value string) error {
+	on, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	if on {
+		SetLogLevel(LogLevel(f))
+	}
+	return nil
+}
+
+// SetLogLevel raises the log level to level, if it is lower, and starts
+// logging the HTTP requests of the test client and of the sinks.
+func SetLogLevel(level LogLevel) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if level <= logLevel {
+		return
+	}
+	if logLevel < LevelInfo {
+		client.OnRequest = logRequest
+		http.DefaultTransport = &loggingTransport{http.DefaultTransport}
+	}
+	logLevel = level
+}
+
+func logEnabled(level LogLevel) bool {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return logLevel >= level
+}
+
+// logAt writes msg and the key-value pairs of attrs to the log in the
+// key=value format of log/slog's text handler, if level is enabled.
+func logAt(level LogLevel, msg string, attrs ...interface{}) {
+	if !logEnabled(level) {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "time=%s level=%s msg=%s", time.Now().Format("2006-01-02T15:04:05.000Z07:00"), level, logValue(msg))
+	for i := 0; i+1 < len(attrs); i += 2 {
+		fmt.Fprintf(&b, " %s=%s", attrs[i], logValue(attrs[i+1]))
+	}
+	b.WriteByte('\n')
+	logMu.Lock()
+	defer logMu.Unlock()
+	fmt.Fprint(out.Log, b.String())
+}
+
+// logValue formats v, quoted if it contains spaces, quotes or equal signs.
+func logValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case time.Duration:
+		s = v.Round(time.Microsecond).String()
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " \"=\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// logRequest logs a finished request of the test client.
+func logRequest(info fastcom.RequestInfo) {
+	attrs := []interface{}{"method", info.Method, "url", info.URL}
+	if info.Err != nil {
+		attrs = append(attrs, "err", info.Err)
+	} else {
+		attrs = append(attrs, "status", info.Status)
+	}
+	attrs = append(attrs, "duration", info.Duration, "bytes", info.Bytes)
+	if logEnabled(LevelDebug) {
+		attrs = append(attrs, "addr", info.Addr, "reused", info.Reused)
+		if !info.Reused {
+			attrs = append(attrs, "dns", info.DNS, "connect", info.Connect, "tls", info.TLS)
+		}
+		attrs = append(attrs, "first_byte", info.FirstByte)
+		logAt(LevelDebug, "request", attrs...)
+		return
+	}
+	logAt(LevelInfo, "request", attrs...)
+}
+
+// loggingTransport logs the requests of the sinks, which use
+// http.DefaultTransport, once their response headers arrive.
+type loggingTransport struct {
+	http.RoundTripper
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.RoundTripper.RoundTrip(req)
+	attrs := []interface{}{"method", req.Method, "url", req.URL.Redacted()}
+	if err != nil {
+		attrs = append(attrs, "err", err)
+	} else {
+		attrs = append(attrs, "status", resp.StatusCode)
+	}
+	logAt(LevelInfo, "request", append(attrs, "duration", time.Since(start))...)
+	return resp, err
+}
+
+func (t *loggingTransport) CloseIdleConnections() {
+	if tr, ok := t.RoundTripper.(interface{ CloseIdleConnections() }); ok {
+		tr.CloseIdleConnections()
+	}
+}
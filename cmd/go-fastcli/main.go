@@ -1,382 +1,364 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"math"
-	"net/http"
-	"net/http/httptrace"
-	"net/url"
-	"strings"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
-)
-
-const FastMaxPayload = 26214400
-const FastAPIToken = "YXNkZmFzZGxmbnNkYWZoYXNkZmhrYWxm"
-const FastAPIURL = "https://api.fast.com/netflix/speedtest/v2?https=true&token=" + FastAPIToken
 
-var tr = &http.Transport{
-	DisableCompression:  true,
-	Proxy:               nil,
-	DisableKeepAlives:   false,
-	MaxIdleConnsPerHost: 1024,
-}
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
 
-var client = &http.Client{Transport: tr}
+// ExitInterrupted follows the shell convention for a process stopped by SIGINT.
+const ExitInterrupted = 130
 
-type LocationInfo struct {
-	City    string
-	Country string
+type command struct {
+	Name    string
+	Summary string
+	Run     func(args []string) int
 }
 
-type ConnectionInfo struct {
-	ASN      string
-	IP       string
-	Location LocationInfo
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "run the full test (default)", func(args []string) int {
+			return runTestCommand("run", args, Config{})
+		}},
+		{"latency", "measure latency only", func(args []string) int {
+			return runTestCommand("latency", args, Config{SkipDownload: true, SkipUpload: true})
+		}},
+		{"download", "measure download speed only", func(args []string) int {
+			return runTestCommand("download", args, Config{SkipLatency: true, SkipUpload: true})
+		}},
+		{"upload", "measure upload speed only", func(args []string) int {
+			return runTestCommand("upload", args, Config{SkipLatency: true, SkipDownload: true})
+		}},
+		{"servers", "list the test servers without testing", func(args []string) int {
+			return runTestCommand("servers", args, Config{SkipLatency: true, SkipDownload: true, SkipUpload: true})
+		}},
+		{"wizard", "compare Wi-Fi and wired speeds step by step", runWizard},
+		{"sla", "check the history or CSV logs against your plan's speeds", runSLA},
+		{"history", "list and summarize results stored with -db", runHistory},
+		{"trend", "show speeds by hour of the day or by day", runTrend},
+		{"compare", "compare two results", runCompare},
+		{"sni", "check for shaping by TLS server name", runSNI},
+		{"dscp", "compare speeds across DSCP markings", runDSCP},
+		{"rate", "find the highest rate that keeps latency low, for SQM", runRate},
+		{"burst", "alternate short bursts of load with idle gaps", runBurst},
+		{"serve", "run tests on a schedule as a daemon", runServe},
+		{"netdata", "run tests on a schedule as a Netdata external plugin", runNetdata},
+		{"status", "show the state of a running daemon", runStatus},
+		{"trigger", "start a run on a running daemon", runTrigger},
+		{"collect", "accept results from many probes", runCollect},
+		{"matrix", "run tests on agents across regions and compare them", runMatrix},
+		{"sign-update", "sign binaries for -self-update", runSignUpdate},
+		{"version", "print the version", runVersion},
+	}
 }
 
-type FastServer struct {
-	City    string
-	Country string
-	URL     string
+func printUsage(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintf(out, "Usage: go-fastcli [command] [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintf(out, "\nFlags for %s:\n", fs.Name())
+	fs.PrintDefaults()
+	fmt.Fprintf(out, "\nEvery flag can also be set with a FASTCLI_ environment variable, e.g. %s.\n", EnvName("targets-file"))
 }
 
-type FakeReader struct {
-	ReadIndex int64
-	MaxIndex  int64
+func main() {
+	if len(os.Args) > 1 {
+		for _, cmd := range commands {
+			if cmd.Name == os.Args[1] {
+				os.Exit(cmd.Run(os.Args[2:]))
+			}
+		}
+	}
+	os.Exit(runTestCommand("run", os.Args[1:], Config{}))
 }
 
-func (c *FakeReader) Read(p []byte) (int, error) {
-	if c.ReadIndex >= c.MaxIndex {
-		return 0, io.EOF
+// runTestCommand runs the speed test; phases not wanted by the subcommand are
+// already switched off in cfg.
+func runTestCommand(name string, args []string, cfg Config) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() { printUsage(fs) }
+	RegisterTestFlags(fs, &cfg)
+	// soft memory limit for constrained devices
+	memoryLimit := fs.String("gomemlimit", "", "soft memory `limit` for the Go runtime, e.g. 128MiB (overrides GOMEMLIMIT)")
+	// protection against overlapping runs
+	lockFile := fs.String("lock-file", "", "refuse to run concurrently with another instance using the same lock `file`")
+	lockMode := fs.String("lock-mode", "wait", "what to do when the lock is held: wait, skip, or attach to the running instance's progress")
+	// result format
+	format := fs.String("format", "text", "result `format`: text, csv, json, one (just the download speed in Mbit/s), simple (speedtest-cli's --simple), telegraf, table (one aligned table of the results), or nagios")
+	// monitoring plugin thresholds
+	warnFlag := fs.String("w", "", "warning `thresholds` for -format nagios and the report's colors as download/upload/latency, e.g. 100/10/50 (Mbit/s, Mbit/s, ms)")
+	critFlag := fs.String("c", "", "critical `thresholds` for -format nagios and the report's colors, like -w")
+	// assertions for scripts and health checks
+	var asserts Assertions
+	fs.Float64Var(&asserts.DownloadMinMbps, "assert-download-min", 0, "exit with code 9 if download is below `Mbit/s`")
+	fs.Float64Var(&asserts.UploadMinMbps, "assert-upload-min", 0, "exit with code 10 if upload is below `Mbit/s`")
+	fs.Float64Var(&asserts.PingMaxMs, "assert-ping-max", 0, "exit with code 12 if ping is above `ms` (failed assertions add up, e.g. 11 for both speeds)")
+	// regression detection against the history
+	baseline := fs.String("baseline", "", "compare the speeds with a `baseline` from the -db history: auto for the median of recent runs, or a history ID; a regression exits like a failed -assert-*")
+	baselineRuns := fs.Int("baseline-runs", 10, "`number` of recent runs -baseline auto is the median of")
+	fs.Float64Var(&cfg.BaselineDropPercent, "baseline-drop", 20, "`percent` a speed may drop below the -baseline before it is a regression")
+	one := fs.Bool("one", false, "only measure download speed and print it as a single number, same as download -format one")
+	simple := fs.Bool("simple", false, "print just the ping, download and upload lines of speedtest-cli's --simple, same as -format simple -quiet")
+	// live progress for wrappers
+	progress := fs.String("progress", "text", "progress `style`: text, bar to also show each transfer's elapsed and remaining time (of its share of -max-total-time) on a terminal, or ndjson for machine-readable events")
+	progressFD := fs.Int("progress-fd", -1, "also write the -progress ndjson events to file `descriptor` N, e.g. 3, which the calling program opened, keeping stdout for the result")
+	progressFile := fs.String("progress-file", "", "also write the -progress ndjson events to `file`, e.g. a named pipe, which is opened once it has a reader")
+	// continuous monitoring
+	watch := fs.Duration("watch", 0, "repeat the test every `interval` until interrupted, retrying failed runs with backoff")
+	// embedding in other applications
+	stdio := fs.Bool("stdio", false, "accept JSON-RPC 2.0 commands (start, cancel, progress) on stdin and write responses and events to stdout")
+	color := fs.String("color", "auto", "color the text report: auto (on a terminal, unless NO_COLOR is set), always or never; speeds and latency are colored by -w and -c, or by -plan-down and -plan-up")
+	// full-screen progress for interactive use
+	tui := fs.Bool("tui", false, "show the test's progress as full-screen panels, with a summary at the end, instead of the text report")
+	// progress goes to stderr when stdout carries data
+	quiet := fs.Bool("quiet", false, "don't print progress to stderr with a machine-readable -format, -progress ndjson or -stdio")
+	ParseFlags(fs, args)
+
+	if *one {
+		cfg.SkipLatency, cfg.SkipUpload = true, true
+		*format = "one"
 	}
-	for i := range p {
-		p[i] = 0
+	if *simple {
+		*format, *quiet = "simple", true
 	}
-	n := len(p)
-	c.ReadIndex += int64(n)
-	return n, nil
-}
-
-func CalcMean(nums []float64) float64 {
-	var total float64
-	for _, num := range nums {
-		total += num
+	if !ValidFormat(*format) {
+		fmt.Fprintf(out.Log, "Unknown -format %s\n", *format)
+		return 2
 	}
-	return total / float64(len(nums))
-}
-
-func CalcMeanOfLastN(nums []float64, n int) float64 {
-	if len(nums) < n {
-		panic("Not enough numbers to calculate mean")
-	} else if n <= 0 {
-		panic("n must be greater than 0")
-	} else if n > len(nums) {
-		panic("n must be less than or equal to the number of numbers")
-	} else {
-		return CalcMean(nums[len(nums)-n:])
+	if *progress != "text" && *progress != "bar" && *progress != "ndjson" {
+		fmt.Fprintf(out.Log, "Unknown -progress %s\n", *progress)
+		return 2
+	}
+	cfg.ProgressBar = *progress == "bar"
+	warn, err := ParseNagiosThresholds(*warnFlag)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error: -w:", err)
+		return 2
+	}
+	crit, err := ParseNagiosThresholds(*critFlag)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error: -c:", err)
+		return 2
+	}
+	if err := asserts.Validate(cfg); err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 2
+	}
+	if *baseline != "" && (*baselineRuns < 1 || cfg.BaselineDropPercent <= 0 || cfg.BaselineDropPercent >= 100) {
+		fmt.Fprintln(out.Log, "Error: -baseline-runs must be at least 1 and -baseline-drop between 0 and 100")
+		return 2
 	}
-}
 
-func CalcStdDeviation(nums []float64) float64 {
-	mean := CalcMean(nums)
-	var total float64
-	for _, num := range nums {
-		total += math.Pow(num-mean, 2)
+	if err := ApplyMemoryTuning(*memoryLimit); err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
 	}
-	return math.Sqrt(total / float64(len(nums)))
-}
 
-func CalcStdDeviationLastN(nums []float64, n int) float64 {
-	if len(nums) < n {
-		panic("Not enough numbers to calculate std deviation")
-	} else if n <= 0 {
-		panic("n must be greater than 0")
-	} else if n > len(nums) {
-		panic("n must be less than or equal to the number of numbers")
-	} else {
-		return CalcStdDeviation(nums[len(nums)-n:])
+	if *stdio && (*progressFD >= 0 || *progressFile != "") {
+		fmt.Fprintln(out.Log, "-stdio already sends the events; -progress-fd and -progress-file are for runs")
+		return 2
+	}
+	progressOutput, err := OpenProgressOutput(*progressFD, *progressFile)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 2
+	}
+	if progressOutput != nil {
+		defer progressOutput.Close()
 	}
-}
 
-func CalcMaxValue(nums []float64) float64 {
-	var max float64
-	for _, num := range nums {
-		if num > max {
-			max = num
+	if *tui {
+		if *format != "text" || *progress != "text" || *stdio || *watch > 0 {
+			fmt.Fprintln(out.Log, "-tui replaces the text report and can't be used with -format, -progress, -stdio or -watch")
+			return 2
+		}
+		if !ansiTerminal(os.Stdout) {
+			fmt.Fprintln(out.Log, "-tui needs a terminal that understands ANSI escapes")
+			return 2
 		}
 	}
-	return max
-}
 
-func CalcMaxValueLastN(nums []float64, n int) float64 {
-	if len(nums) < n {
-		panic("Not enough numbers to calculate max value")
-	} else if n <= 0 {
-		panic("n must be greater than 0")
-	} else if n > len(nums) {
-		panic("n must be less than or equal to the number of numbers")
-	} else {
-		return CalcMaxValue(nums[len(nums)-n:])
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// a second signal ends the process without waiting for the
+		// partial result to be written
+		<-ctx.Done()
+		stop()
+	}()
+
+	if *stdio || *format != "text" || *progress == "ndjson" {
+		out.MachineReadable(*quiet)
 	}
-}
+	if cfg.Colors.Enabled, err = UseColor(*color); err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 2
+	}
+	cfg.Colors.Warn, cfg.Colors.Crit = warn, crit
 
-func CalcJitter(nums []float64) float64 {
-	if len(nums) < 2 {
-		panic("Not enough numbers to calculate jitter")
-	} else {
-		diffs := float64(0)
-		for i := 1; i < len(nums); i++ {
-			diffs += math.Abs(nums[i] - nums[i-1])
+	if *stdio {
+		server := &StdioServer{Config: cfg}
+		if err := server.Serve(ctx, os.Stdin, out.Data); err != nil {
+			fmt.Fprintln(out.Log, "Error:", err)
+			return 1
 		}
-		return diffs / float64(len(nums)-1)
+		return 0
 	}
-}
 
-func FormatFastURL(url string, rangeEnd int) string {
-	return strings.Replace(url, "/speedtest?", fmt.Sprintf("/speedtest/range/0-%d?", rangeEnd), -1)
-}
-
-func FastGetServerList(urlsToTest int) (ConnectionInfo, []FastServer) {
-	resp, err := client.Get(FastAPIURL + fmt.Sprintf("&urlCount=%d", urlsToTest))
-	if err != nil {
-		panic("Error getting server list")
+	if *lockFile != "" {
+		lock := &LockFile{Path: *lockFile}
+		switch *lockMode {
+		case "wait":
+			if err := lock.Wait(ctx); err != nil {
+				fmt.Fprintln(out.Log, "Error acquiring lock file:", err)
+				return 1
+			}
+		case "skip":
+			if err := lock.TryAcquire(); err == ErrLocked {
+				fmt.Fprintln(out.Log, "Another run is in progress, skipping")
+				return ExitLocked
+			} else if err != nil {
+				fmt.Fprintln(out.Log, "Error acquiring lock file:", err)
+				return 1
+			}
+		case "attach":
+			if lock.Attach(ctx, out.Progress) {
+				return 0
+			}
+			if err := lock.Wait(ctx); err != nil {
+				fmt.Fprintln(out.Log, "Error acquiring lock file:", err)
+				return 1
+			}
+		default:
+			fmt.Fprintln(out.Log, "Unknown -lock-mode", *lockMode)
+			return 2
+		}
+		defer lock.Release()
+		out.Progress = io.MultiWriter(out.Progress, lock.Progress())
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		panic("Fast.com API returned " + resp.Status)
+	if *progress == "ndjson" {
+		cfg.OnEvent = NDJSONEvents(out.Data)
 	}
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		panic("Error reading server list")
-	}
-	var jsonData map[string]interface{}
-	if err := json.Unmarshal(body, &jsonData); err != nil {
-		panic("Error parsing server list")
-	}
-	var connectionInfo ConnectionInfo
-	var locationInfo LocationInfo
-	var fastServer FastServer
-	var fastServerList []FastServer
-	var serverList = jsonData["targets"].([]interface{})
-	for _, server := range serverList {
-		serverData := server.(map[string]interface{})
-		fastServer = FastServer{
-			City:    serverData["location"].(map[string]interface{})["city"].(string),
-			Country: serverData["location"].(map[string]interface{})["country"].(string),
-			URL:     serverData["url"].(string),
-		}
-		fastServerList = append(fastServerList, fastServer)
-	}
-	connectionInfo.IP = jsonData["client"].(map[string]interface{})["ip"].(string)
-	connectionInfo.ASN = jsonData["client"].(map[string]interface{})["asn"].(string)
-	locationInfo.City = jsonData["client"].(map[string]interface{})["location"].(map[string]interface{})["city"].(string)
-	locationInfo.Country = jsonData["client"].(map[string]interface{})["location"].(map[string]interface{})["country"].(string)
-	connectionInfo.Location = locationInfo
-	return connectionInfo, fastServerList
-}
-
-func GetHost(_url string) string {
-	u, err := url.Parse(_url)
-	if err != nil {
-		panic("Error parsing URL")
+	if progressOutput != nil {
+		cfg.OnEvent = teeEvents(cfg.OnEvent, NDJSONEvents(progressOutput))
 	}
-	return u.Host
-}
 
-func GetLatency(url string) time.Duration {
-	req, err := http.NewRequest("HEAD", FormatFastURL(url, 0), nil)
-	if err != nil {
-		panic("Error creating request")
-	}
-	var t1, t2 time.Time
-	trace := &httptrace.ClientTrace{
-		ConnectStart: func(_, _ string) {
-			t1 = time.Now()
-		},
-		ConnectDone: func(_, _ string, _ error) {
-			t2 = time.Now()
-		},
-	}
-	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
-	_, err = tr.RoundTrip(req)
-	if err != nil {
-		panic("Error making request")
+	if *watch > 0 && *format == "nagios" {
+		fmt.Fprintln(out.Log, "-format nagios reports a single run and can't be used with -watch")
+		return 2
 	}
-	return t2.Sub(t1)
-}
-
-func GetDownloadSpeed(url string, playloadSize int) float64 {
-	resp, err := client.Get(FormatFastURL(url, playloadSize))
-	if err != nil {
-		panic("Error getting download speed")
+	if asserts.Enabled() && (*watch > 0 || *format == "nagios") {
+		fmt.Fprintln(out.Log, "-assert-* check a single run and can't be used with -watch or -format nagios")
+		return 2
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		panic("Fast.com API returned " + resp.Status)
+	if *baseline != "" {
+		if *watch > 0 || *format == "nagios" {
+			fmt.Fprintln(out.Log, "-baseline checks a single run and can't be used with -watch or -format nagios")
+			return 2
+		}
+		// before the run, which -db adds to the history
+		db := cfg.HistoryDB
+		if db == "" {
+			db = DefaultHistoryDB()
+		}
+		store, err := OpenHistory(cfg.HistoryBackend, db)
+		if err != nil {
+			fmt.Fprintln(out.Log, "Error:", err)
+			return 2
+		}
+		b, err := LoadBaseline(store, *baseline, *baselineRuns)
+		if err != nil {
+			fmt.Fprintln(out.Log, "Error loading the baseline:", err)
+			return 1
+		}
+		cfg.Baseline = &b
 	}
-	t1 := time.Now()
-	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
-		panic("Error reading download speed")
+	defer closeConnections()
+	if *watch > 0 {
+		if cfg.Converge == "" {
+			cfg.Converge = fastcom.ConvergeEWMA
+		}
+		return watchTests(ctx, cfg, *format, *watch)
 	}
-	return float64(playloadSize) / time.Since(t1).Seconds()
-}
-
-func GetUploadSpeed(url string, playload_size int) float64 {
-	counter := &FakeReader{
-		ReadIndex: 0,
-		MaxIndex:  int64(playload_size),
+	var ui *TUI
+	if *tui {
+		ui = &TUI{W: os.Stdout}
+		cfg.OnEvent = teeEvents(cfg.OnEvent, ui.Event)
+		ui.Start()
 	}
-	req, err := http.NewRequest("POST", FormatFastURL(url, playload_size), counter)
-	if err != nil {
-		panic("Error creating request")
+	result, err := runAndReport(ctx, cfg, *format)
+	if ui != nil {
+		ui.Stop()
+		if err == nil || result.Interrupted {
+			WriteSummary(out.Data, result)
+		}
+	}
+	if *format == "nagios" && ctx.Err() == nil {
+		return WriteNagios(out.Data, cfg, result, err, warn, crit)
 	}
-	req.ContentLength = int64(playload_size)
-	req.Header.Set("Content-Type", "application/octet-stream")
-	req.Header.Set("Accept-Encoding", "identity")
-
-	t1 := time.Now()
-	resp, err := client.Do(req)
 	if err != nil {
-		panic("Error doing request")
+		if ctx.Err() != nil {
+			if !result.Interrupted {
+				fmt.Fprintln(out.Log, "Interrupted")
+			}
+			return ExitInterrupted
+		}
+		return 1
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		panic("Fast.com API returned " + resp.Status)
+	failed := asserts.Failures(out.Log, result)
+	if cfg.Baseline != nil {
+		regressions, regressed := cfg.Baseline.Regressions(cfg, result.Summary(), cfg.BaselineDropPercent)
+		for _, regression := range regressions {
+			fmt.Fprintln(out.Log, "Regression:", regression)
+		}
+		failed |= regressed
 	}
-	return float64(int64(playload_size)) / time.Since(t1).Seconds()
+	return AssertionExitCode(failed)
 }
 
-func main() {
-	// number of servers to request
-	serverNum := 1
-
-	// number of times to measure latency
-	latencyLoopNum := 10
-
-	// max loops to run
-	downMaxLoop := 100
-
-	// payload size
-	downMeasureSlowMB := 2  // for slow connections
-	downMeasureFastMB := 10 // for fast connections
-
-	// any value over this would be considered a fast connection
-	downMeasureCutoffMB := float64(downMeasureSlowMB)
-
-	// take last n values to calculate standard deviation
-	downStdLastVarsSlow := 3 // for slow connections
-	downStdLastVarsFast := 4 // for fast connections
-
-	// if standard deviation is less than this, we break out of the loop
-	downStdMaxSlow := 0.2 // for slow connections
-	downStdMaxFast := 5.0 // for fast connections
-
-	// same as above, but for upload
-	upMaxLoop := downMaxLoop
-	upMeasureSlowMB := downMeasureSlowMB
-	upMeasureFastMB := downMeasureFastMB
-	upMeasureCutoffMB := downMeasureCutoffMB
-	upStdLastVarsSlow := downStdLastVarsSlow
-	upStdLastVarsFast := downStdLastVarsFast
-	upStdMaxSlow := downStdMaxSlow
-	upStdMaxFast := downStdMaxFast
-
-	connectionInfo, fastServerList := FastGetServerList(serverNum)
-	fmt.Println("Fast.com Speedtest")
-	fmt.Println()
-	fmt.Printf("Connection Info:\n")
-	fmt.Printf("  - IP: %s\n", connectionInfo.IP)
-	fmt.Printf("  - ASN: %s\n", connectionInfo.ASN)
-	fmt.Printf("  - Location: %s, %s\n", connectionInfo.Location.City, connectionInfo.Location.Country)
-	fmt.Println()
-	fmt.Println("Fast.com Servers:")
-	for _, server := range fastServerList {
-		fmt.Printf("  - Location: %s, %s\n", server.City, server.Country)
-		fmt.Printf("    URL: %s\n", server.URL)
-		fmt.Println()
+// runAndReport runs one test and reports it to out.Data, the journal and the
+// sinks. Errors are printed before they are returned.
+func runAndReport(ctx context.Context, cfg Config, format string) (Result, error) {
+	result, err := runWithinBudget(ctx, cfg)
+	if result.Interrupted {
+		reportInterrupted(cfg, format, result, err)
+		return result, err
 	}
-
-	fmt.Println("Latency:")
-	for _, server := range fastServerList {
-		totalLatency := []time.Duration{}
-		for i := 0; i < latencyLoopNum; i++ {
-			totalLatency = append(totalLatency, GetLatency(server.URL))
+	if cfg.Journal != "" && ctx.Err() == nil {
+		if err := AppendJournal(cfg, result, err); err != nil {
+			fmt.Fprintln(out.Log, "Error writing journal:", err)
 		}
-		fmt.Printf("  - %s: %0.3f ms (%0.3f ms jitter)\n",
-			GetHost(server.URL),
-			CalcMean(
-				func() []float64 {
-					var temp []float64
-					for i := 0; i < len(totalLatency); i++ {
-						temp = append(temp, float64(totalLatency[i].Nanoseconds()))
-					}
-					return temp
-				}(),
-			)*float64(time.Nanosecond)/float64(time.Millisecond),
-			CalcJitter(
-				func() []float64 {
-					var temp []float64
-					for i := 0; i < len(totalLatency); i++ {
-						temp = append(temp, float64(totalLatency[i].Nanoseconds()))
-					}
-					return temp
-				}(),
-			)*float64(time.Nanosecond)/float64(time.Millisecond),
-		)
-	}
-	fmt.Println()
-
-	fmt.Println("Download Speed:")
-	for _, server := range fastServerList {
-		totalDownloads := []float64{}
-		downMeasureMB := downMeasureSlowMB
-		downStdLastVars := downStdLastVarsSlow
-		downStdMax := downStdMaxSlow
-		cutOffComplete := false
-		for i := 0; i < downMaxLoop; i++ {
-			downloadSpeed := GetDownloadSpeed(server.URL, downMeasureMB*1024*1024)
-			if !cutOffComplete && downloadSpeed > downMeasureCutoffMB*1024*1024 {
-				downMeasureMB = downMeasureFastMB
-				downStdLastVars = downStdLastVarsFast
-				downStdMax = downStdMaxFast
-				cutOffComplete = true
-				i-- // Retry this iteration
-				continue
-			}
-			totalDownloads = append(totalDownloads, downloadSpeed)
-			if len(totalDownloads) >= downStdLastVars && CalcStdDeviationLastN(totalDownloads, downStdLastVars) < 1024*1024*downStdMax {
-				break
-			}
+	}
+	if cfg.PushgatewayURL != "" && ctx.Err() == nil {
+		if err := PushMetrics(ctx, cfg.PushgatewayURL, cfg.PushgatewayJob, cfg.PushgatewayInstance, result, err); err != nil {
+			fmt.Fprintln(out.Log, "Error pushing to the Pushgateway:", err)
 		}
-		fmt.Printf("  - %s: %0.3f Mbit/s (used %d MB)\n", GetHost(server.URL), CalcMaxValueLastN(totalDownloads, downStdLastVars)/125000, len(totalDownloads)*downMeasureMB)
 	}
-	fmt.Println()
-
-	fmt.Println("Upload Speed:")
-	for _, server := range fastServerList {
-		totalUploads := []float64{}
-		upMeasureMB := upMeasureSlowMB
-		upStdLastVars := upStdLastVarsSlow
-		upStdMax := upStdMaxSlow
-		cutOffComplete := false
-		for i := 0; i < upMaxLoop; i++ {
-			uploadSpeed := GetUploadSpeed(server.URL, upMeasureMB*1024*1024)
-			if !cutOffComplete && uploadSpeed > upMeasureCutoffMB*1024*1024 {
-				upMeasureMB = upMeasureFastMB
-				upStdLastVars = upStdLastVarsFast
-				upStdMax = upStdMaxFast
-				cutOffComplete = true
-				i-- // Retry this iteration
-				continue
-			}
-			totalUploads = append(totalUploads, uploadSpeed)
-			if len(totalUploads) >= upStdLastVars && CalcStdDeviationLastN(totalUploads, upStdLastVars) < 1024*1024*upStdMax {
-				break
-			}
+	if err != nil {
+		if ctx.Err() != nil {
+			return result, err
 		}
-		fmt.Printf("  - %s: %0.3f Mbit/s (used %d MB)\n", GetHost(server.URL), CalcMaxValueLastN(totalUploads, upStdLastVars)/125000, len(totalUploads)*upMeasureMB)
+		if cfg.OnEvent != nil {
+			cfg.OnEvent(Event{Type: EventError, Time: time.Now(), Error: err.Error()})
+		}
+		fmt.Fprintln(out.Log, "Error:", err)
+		return result, err
+	}
+	if err := WriteResult(out.Data, format, result); err != nil {
+		fmt.Fprintln(out.Log, "Error writing result:", err)
+		return result, err
 	}
+	DeliverResult(ctx, cfg, result, printSinkError)
+	return result, nil
 }
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultRemoteCommand is what "matrix" runs on ssh agents, with -stdio
+// appended. -cloud-metadata labels each row with the agent's region.
+const defaultRemoteCommand = "go-fastcli -cloud-metadata"
+
+// matrixResultWait is how long a daemon agent may take, after its run is
+// done, to make the result available at /v1/results/latest.
+const matrixResultWait = 10 * time.Second
+
+// MatrixAgent is where "matrix" runs a test: a daemon started with
+// "serve -listen", given by its http(s) URL, or a host reached with
+// "ssh:[user@]host", which runs the test with -stdio.
+type MatrixAgent struct {
+	// Label names the agent's row, e.g. its region. Without one, the row
+	// is named after the region of the result's Cloud, or else where
+	// fast.com located the agent.
+	Label  string `json:",omitempty"`
+	Target string
+}
+
+// ParseMatrixAgent parses an agent argument, [label=]URL or
+// [label=]ssh:[user@]host.
+func ParseMatrixAgent(arg string) (MatrixAgent, error) {
+	var agent MatrixAgent
+	if label, target, ok := strings.Cut(arg, "="); ok && !strings.ContainsAny(label, ":/") {
+		agent.Label, arg = label, target
+	}
+	agent.Target = arg
+	switch {
+	case strings.HasPrefix(arg, "http://"), strings.HasPrefix(arg, "https://"):
+	case strings.HasPrefix(arg, "ssh:") && len(arg) > len("ssh:"):
+	default:
+		return agent, fmt.Errorf("invalid agent %q, expected an http(s) URL or ssh:[user@]host", arg)
+	}
+	return agent, nil
+}
+
+// MatrixRow is the outcome of one agent's run.
+type MatrixRow struct {
+	Label  string
+	Agent  string
+	Result *Result `json:",omitempty"`
+	Error  string  `json:",omitempty"`
+}
+
+// MatrixRunner runs tests on agents and collects their results.
+type MatrixRunner struct {
+	// APIToken is sent as a bearer token to daemon agents.
+	APIToken string
+	// SSH is the ssh command with its options, e.g. "ssh -i key.pem", and
+	// RemoteCommand what it runs on the agent.
+	SSH           string
+	RemoteCommand string
+	// Timeout bounds each agent's run.
+	Timeout time.Duration
+}
+
+// Run runs agents one after the other, or all at once if parallel, and
+// returns their rows in the same order.
+func (m *MatrixRunner) Run(ctx context.Context, agents []MatrixAgent, parallel bool) []MatrixRow {
+	rows := make([]MatrixRow, len(agents))
+	var wg sync.WaitGroup
+	for i, agent := range agents {
+		run := func(i int, agent MatrixAgent) {
+			defer wg.Done()
+			rows[i] = m.runAgent(ctx, agent)
+		}
+		wg.Add(1)
+		if parallel {
+			go run(i, agent)
+		} else {
+			run(i, agent)
+		}
+	}
+	wg.Wait()
+	return rows
+}
+
+func (m *MatrixRunner) runAgent(ctx context.Context, agent MatrixAgent) MatrixRow {
+	fmt.Fprintf(out.Progress, "Testing on %s...\n", agent.Target)
+	start := time.Now()
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+	}
+	var result Result
+	var err error
+	if host := strings.TrimPrefix(agent.Target, "ssh:"); host != agent.Target {
+		result, err = m.runSSH(ctx, host)
+	} else {
+		result, err = m.runDaemon(ctx, strings.TrimSuffix(agent.Target, "/"))
+	}
+	row := MatrixRow{Label: agent.Label, Agent: agent.Target}
+	if err != nil {
+		row.Error = err.Error()
+		fmt.Fprintf(out.Progress, "%s failed: %v\n", agent.Target, err)
+	} else {
+		row.Result = &result
+		fmt.Fprintf(out.Progress, "%s done in %s\n", agent.Target, time.Since(start).Round(time.Second))
+	}
+	if row.Label == "" && row.Result != nil {
+		if row.Result.Cloud != nil {
+			row.Label = row.Result.Cloud.Region
+		} else if location := row.Result.Connection.Location; location.City != "" || location.Country != "" {
+			row.Label = locationName(location)
+		}
+	}
+	if row.Label == "" {
+		row.Label = "-"
+	}
+	return row
+}
+
+// runSSH runs the test on host over ssh with -stdio, the same JSON-RPC
+// interface a desktop app uses: it sends start, waits for the result
+// notification and closes stdin, which ends the remote process.
+func (m *MatrixRunner) runSSH(ctx context.Context, host string) (Result, error) {
+	var result Result
+	args := strings.Fields(m.SSH)
+	if len(args) == 0 {
+		return result, errors.New("no ssh command")
+	}
+	args = append(args, "-T", host, m.RemoteCommand+" -stdio -quiet")
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &limitedBuffer{buf: &stderr, max: 4096}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return result, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return result, err
+	}
+	if err := cmd.Start(); err != nil {
+		return result, err
+	}
+	err = readStdioResult(stdin, stdout, &result)
+	stdin.Close()
+	cmd.Wait()
+	switch {
+	case err == nil:
+		return result, nil
+	case ctx.Err() != nil:
+		return result, ctx.Err()
+	}
+	// e.g. ssh's own error, or the shell's when go-fastcli isn't installed
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		err = fmt.Errorf("%w (%s)", err, firstLine(msg))
+	}
+	return result, err
+}
+
+// readStdioResult starts a test with the JSON-RPC interface on in and out
+// and decodes its result notification into result.
+func readStdioResult(in io.Writer, out io.Reader, result *Result) error {
+	if _, err := io.WriteString(in, `{"jsonrpc":"2.0","id":1,"method":"start"}`+"\n"); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(make([]byte, 64*1024), maxCollectedResult)
+	for scanner.Scan() {
+		var msg struct {
+			ID     json.RawMessage
+			Method string
+			Params json.RawMessage
+			Error  *rpcError
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return fmt.Errorf("agent sent %q: %w", truncate(scanner.Text(), 80), err)
+		}
+		switch {
+		case msg.Error != nil:
+			return errors.New(msg.Error.Message)
+		case msg.Method == "event":
+			var evt Event
+			if json.Unmarshal(msg.Params, &evt) == nil && evt.Type == EventError {
+				return errors.New(evt.Error)
+			}
+		case msg.Method == "result":
+			return json.Unmarshal(msg.Params, result)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("agent exited without a result: %w", io.ErrUnexpectedEOF)
+}
+
+// runDaemon triggers a run with the REST API of the daemon at base and
+// follows /v1/stream until it is done. The result is then the one at
+// /v1/results/latest, once it differs from the one before the run; the
+// daemon's clock may be off from ours, so the times aren't compared with
+// the start of the run.
+func (m *MatrixRunner) runDaemon(ctx context.Context, base string) (Result, error) {
+	var result Result
+	previous, _, err := m.latestResult(ctx, base)
+	if err != nil {
+		return result, err
+	}
+
+	stream, err := m.daemonRequest(ctx, "GET", base+"/v1/stream")
+	if err != nil {
+		return result, err
+	}
+	defer stream.Body.Close()
+	trigger, err := m.daemonRequest(ctx, "POST", base+"/v1/test")
+	if err != nil {
+		return result, err
+	}
+	trigger.Body.Close()
+
+	if err := waitForRun(stream.Body); err != nil {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		return result, err
+	}
+	wait := time.NewTimer(matrixResultWait)
+	defer wait.Stop()
+	for {
+		latest, found, err := m.latestResult(ctx, base)
+		if err != nil {
+			return result, err
+		}
+		if found && !latest.Time.Equal(previous.Time) {
+			return latest, nil
+		}
+		select {
+		case <-time.After(250 * time.Millisecond):
+		case <-wait.C:
+			return result, errors.New("the daemon finished the run but has no new result")
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
+	}
+}
+
+// waitForRun reads server-sent events until the run is done or fails.
+func waitForRun(stream io.Reader) error {
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	var event string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && event == EventDone:
+			return nil
+		case strings.HasPrefix(line, "data: ") && event == EventError:
+			var evt Event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &evt); err != nil {
+				return errors.New("the run failed")
+			}
+			return errors.New(evt.Error)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("the daemon closed the stream before the run was done")
+}
+
+func (m *MatrixRunner) latestResult(ctx context.Context, base string) (Result, bool, error) {
+	var result Result
+	resp, err := m.daemonRequest(ctx, "GET", base+"/v1/results/latest")
+	if err != nil {
+		var status *statusError
+		if errors.As(err, &status) && status.Code == http.StatusNotFound {
+			return result, false, nil
+		}
+		return result, false, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxCollectedResult)).Decode(&result); err != nil {
+		return result, false, fmt.Errorf("%s: %w", base, err)
+	}
+	return result, true, nil
+}
+
+// statusError is a daemon's answer other than 2xx.
+type statusError struct {
+	Code    int
+	Message string
+}
+
+func (e *statusError) Error() string { return e.Message }
+
+func (m *MatrixRunner) daemonRequest(ctx context.Context, method, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if m.APIToken != "" {
+		req.Header.Set("Authorization", "Bearer "+m.APIToken)
+	}
+	req.Header.Set("User-Agent", "go-fastcli/"+appVersion())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var apiErr struct{ Error string }
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if json.Unmarshal(msg, &apiErr) == nil && apiErr.Error != "" {
+			msg = []byte(apiErr.Error)
+		}
+		return nil, &statusError{resp.StatusCode, fmt.Sprintf("%s %s returned %s: %s", method, url, resp.Status, strings.TrimSpace(string(msg)))}
+	}
+	return resp, nil
+}
+
+// limitedBuffer keeps the first max bytes written to it and drops the rest.
+type limitedBuffer struct {
+	buf *bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// WriteMatrix writes rows as a table of the agents' averaged ping,
+// download and upload, followed by the failed agents and the results'
+// warnings.
+func WriteMatrix(w io.Writer, rows []MatrixRow) error {
+	value := func(v float64) string {
+		if v <= 0 {
+			return "-"
+		}
+		return strconv.FormatFloat(v, 'f', 1, 64)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Region\tAgent\tPing ms\tJitter ms\tDown Mbit/s\tUp Mbit/s\t")
+	for _, row := range rows {
+		var s Summary
+		if row.Result != nil {
+			s = row.Result.Summary()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t\n", row.Label, row.Agent, value(s.PingMs), value(s.JitterMs),
+			value(s.DownloadMbps), value(s.UploadMbps))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	var failed, warned []MatrixRow
+	for _, row := range rows {
+		if row.Error != "" {
+			failed = append(failed, row)
+		} else if len(row.Result.Warnings) > 0 {
+			warned = append(warned, row)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintln(w, "\nFailed:")
+		for _, row := range failed {
+			fmt.Fprintf(w, "  - %s: %s\n", row.Agent, row.Error)
+		}
+	}
+	if len(warned) > 0 {
+		fmt.Fprintln(w, "\nWarnings:")
+		for _, row := range warned {
+			for _, warning := range row.Result.Warnings {
+				fmt.Fprintf(w, "  - %s: %s\n", row.Agent, warning)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// gcPercentWithLimit is used instead of the default GOGC=100 when a memory
+// limit is in effect: the limit bounds the heap, so the throughput phases can
+// collect less often and spend their CPU on moving bytes instead.
+const gcPercentWithLimit = 200
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+func ParseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	multiplier := int64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("invalid byte size")
+	}
+	return n * multiplier, nil
+}
+
+func ApplyMemoryTuning(memoryLimit string) error {
+	if memoryLimit != "" {
+		limit, err := ParseByteSize(memoryLimit)
+		if err != nil {
+			return fmt.Errorf("invalid -gomemlimit %q: %w", memoryLimit, err)
+		}
+		debug.SetMemoryLimit(limit)
+	}
+	if os.Getenv("GOGC") == "" && (memoryLimit != "" || os.Getenv("GOMEMLIMIT") != "") {
+		debug.SetGCPercent(gcPercentWithLimit)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ProbeMetrics describes the probe itself rather than the network under test,
+// so operators can tell when the monitor is failing.
+type ProbeMetrics struct {
+	mu              sync.Mutex
+	runs            int
+	failures        map[string]int
+	sinkErrors      map[string]int
+	lastRunDuration time.Duration
+	lastRunTime     time.Time
+	bytes           int64
+}
+
+func NewProbeMetrics() *ProbeMetrics {
+	return &ProbeMetrics{
+		failures:   map[string]int{},
+		sinkErrors: map[string]int{},
+	}
+}
+
+// ObserveRun records a finished run and the payload bytes it transferred.
+// category is the phase that failed, or empty if the run succeeded.
+func (m *ProbeMetrics) ObserveRun(started time.Time, duration time.Duration, category string, bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs++
+	m.bytes += bytes
+	m.lastRunTime = started
+	m.lastRunDuration = duration
+	if category != "" {
+		m.failures[category]++
+	}
+}
+
+func (m *ProbeMetrics) ObserveSinkError(sink string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sinkErrors[sink]++
+}
+
+func writeLabeledCounter(w io.Writer, name, label string, values map[string]int) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, key, values[key])
+	}
+}
+
+func (m *ProbeMetrics) WriteMetrics(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintln(w, "# HELP fastcli_probe_runs_total Test runs started by the daemon that have finished.")
+	fmt.Fprintln(w, "# TYPE fastcli_probe_runs_total counter")
+	fmt.Fprintf(w, "fastcli_probe_runs_total %d\n", m.runs)
+	fmt.Fprintln(w, "# HELP fastcli_probe_failures_total Failed test runs by the phase that failed.")
+	fmt.Fprintln(w, "# TYPE fastcli_probe_failures_total counter")
+	writeLabeledCounter(w, "fastcli_probe_failures_total", "category", m.failures)
+	fmt.Fprintln(w, "# HELP fastcli_probe_sink_errors_total Errors delivering results to a sink.")
+	fmt.Fprintln(w, "# TYPE fastcli_probe_sink_errors_total counter")
+	writeLabeledCounter(w, "fastcli_probe_sink_errors_total", "sink", m.sinkErrors)
+	fmt.Fprintln(w, "# HELP fastcli_probe_bytes_total Payload bytes transferred by the daemon's runs, failed ones included.")
+	fmt.Fprintln(w, "# TYPE fastcli_probe_bytes_total counter")
+	fmt.Fprintf(w, "fastcli_probe_bytes_total %d\n", m.bytes)
+	if !m.lastRunTime.IsZero() {
+		fmt.Fprintln(w, "# HELP fastcli_probe_last_run_duration_seconds Wall-clock duration of the last run.")
+		fmt.Fprintln(w, "# TYPE fastcli_probe_last_run_duration_seconds gauge")
+		fmt.Fprintf(w, "fastcli_probe_last_run_duration_seconds %g\n", m.lastRunDuration.Seconds())
+		fmt.Fprintln(w, "# HELP fastcli_probe_last_run_timestamp_seconds Unix time the last run started.")
+		fmt.Fprintln(w, "# TYPE fastcli_probe_last_run_timestamp_seconds gauge")
+		fmt.Fprintf(w, "fastcli_probe_last_run_timestamp_seconds %d\n", m.lastRunTime.Unix())
+	}
+}
+
+// resultGauges are the per-server values exported for each result. A gauge
+// of a phase is left out for the servers without enough samples of it.
+var resultGauges = []struct {
+	name, help, phase string
+	value             func(ServerResult) float64
+}{
+	{"fastcli_download_mbps", "Download speed in Mbit/s.", "download", func(s ServerResult) float64 { return s.DownloadMbps }},
+	{"fastcli_upload_mbps", "Upload speed in Mbit/s.", "upload", func(s ServerResult) float64 { return s.UploadMbps }},
+	{"fastcli_ping_ms", "Unloaded latency in milliseconds.", "latency", func(s ServerResult) float64 { return s.LatencyMs }},
+	{"fastcli_jitter_ms", "Unloaded latency jitter in milliseconds.", "latency", func(s ServerResult) float64 { return s.JitterMs }},
+	{"fastcli_dns_ms", "Time to resolve the server's host in milliseconds, 0 if it wasn't looked up.", "", func(s ServerResult) float64 { return s.DNSMs }},
+	{"fastcli_bytes_used", "Bytes transferred by the download and upload phases.", "", func(s ServerResult) float64 {
+		return float64(int64(s.DownloadMB+s.UploadMB) * 1024 * 1024)
+	}},
+	{"fastcli_route_changed", "Whether the route to the server changed since the previous run (1) or not (0).", "", func(s ServerResult) float64 {
+		if s.Route != nil && s.Route.Changed {
+			return 1
+		}
+		return 0
+	}},
+}
+
+// latencyBucketsMs are the upper bounds of the latency histogram buckets.
+var latencyBucketsMs = []float64{1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+// writeLatencyHistogram exports every latency sample of the last result, so
+// quantiles can be aggregated across probes instead of averaging means.
+func writeLatencyHistogram(w io.Writer, servers []ServerResult) {
+	const name = "fastcli_latency_sample_ms"
+	fmt.Fprintf(w, "# HELP %s Unloaded latency samples in milliseconds.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, server := range servers {
+		if len(server.LatencySamplesMs) == 0 {
+			continue
+		}
+		writeHistogram(w, name, fmt.Sprintf("server=%q", server.Host), server.LatencySamplesMs)
+	}
+}
+
+// loadedLatencySamples returns the -loaded-latency probes of result taken
+// during phase of host.
+func loadedLatencySamples(result Result, host, phase string) []float64 {
+	var millis []float64
+	for _, sample := range result.LoadedLatency {
+		if sample.Host == host && sample.Phase == phase {
+			millis = append(millis, sample.LatencyMs)
+		}
+	}
+	return millis
+}
+
+// writeLoadedLatencyHistogram exports the -loaded-latency probes of the last
+// result by server and phase, the latency the line has while it is busy.
+func writeLoadedLatencyHistogram(w io.Writer, result Result) {
+	if len(result.LoadedLatency) == 0 {
+		return
+	}
+	const name = "fastcli_loaded_latency_ms"
+	fmt.Fprintf(w, "# HELP %s Latency samples taken during the download and upload, in milliseconds.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, server := range result.Servers {
+		for _, phase := range []string{"download", "upload"} {
+			if millis := loadedLatencySamples(result, server.Host, phase); len(millis) > 0 {
+				writeHistogram(w, name, fmt.Sprintf("server=%q,phase=%q", server.Host, phase), millis)
+			}
+		}
+	}
+}
+
+// writeHistogram writes the latencyBucketsMs buckets, sum and count of
+// samples for the series of name with labels.
+func writeHistogram(w io.Writer, name, labels string, samples []float64) {
+	var sum float64
+	for _, sample := range samples {
+		sum += sample
+	}
+	for _, bound := range latencyBucketsMs {
+		count := 0
+		for _, sample := range samples {
+			if sample <= bound {
+				count++
+			}
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, bound, count)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, len(samples))
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, sum)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, len(samples))
+}
+
+// WriteResultMetrics writes gauges for the last successful result, plus
+// whether the last run failed, so results can be scraped directly.
+func WriteResultMetrics(w io.Writer, status DaemonStatus) {
+	if status.LastRun != nil {
+		lastError := 0
+		if status.LastError != "" {
+			lastError = 1
+		}
+		fmt.Fprintln(w, "# HELP fastcli_last_run_failed Whether the last test run failed (1) or succeeded (0).")
+		fmt.Fprintln(w, "# TYPE fastcli_last_run_failed gauge")
+		fmt.Fprintf(w, "fastcli_last_run_failed %d\n", lastError)
+	}
+	result := status.LastResult
+	if result == nil {
+		return
+	}
+	for _, gauge := range resultGauges {
+		fmt.Fprintf(w, "# HELP %s %s\n", gauge.name, gauge.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", gauge.name)
+		for _, server := range result.Servers {
+			if server.lacks(gauge.phase) {
+				continue
+			}
+			fmt.Fprintf(w, "%s{server=%q} %g\n", gauge.name, server.Host, gauge.value(server))
+		}
+	}
+	writeLatencyHistogram(w, result.Servers)
+	writeLoadedLatencyHistogram(w, *result)
+	if result.Connections != nil {
+		fmt.Fprintln(w, "# HELP fastcli_connect_attempts Connects tried by the last successful test, by outcome.")
+		fmt.Fprintln(w, "# TYPE fastcli_connect_attempts gauge")
+		c := result.Connections
+		fmt.Fprintf(w, "fastcli_connect_attempts{outcome=\"succeeded\"} %d\n", c.Succeeded)
+		fmt.Fprintf(w, "fastcli_connect_attempts{outcome=\"timed_out\"} %d\n", c.TimedOut)
+		fmt.Fprintf(w, "fastcli_connect_attempts{outcome=\"refused\"} %d\n", c.Refused)
+		fmt.Fprintf(w, "fastcli_connect_attempts{outcome=\"failed\"} %d\n", c.Failed)
+		fmt.Fprintln(w, "# HELP fastcli_connect_success_ratio Share of connects that succeeded in the last successful test.")
+		fmt.Fprintln(w, "# TYPE fastcli_connect_success_ratio gauge")
+		fmt.Fprintf(w, "fastcli_connect_success_ratio %g\n", c.SuccessRate())
+	}
+	fmt.Fprintln(w, "# HELP fastcli_result_timestamp_seconds Unix time the last successful test started.")
+	fmt.Fprintln(w, "# TYPE fastcli_result_timestamp_seconds gauge")
+	fmt.Fprintf(w, "fastcli_result_timestamp_seconds %d\n", result.Time.Unix())
+}
+
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteResultMetrics(w, d.Status())
+	d.Metrics.WriteMetrics(w)
+}
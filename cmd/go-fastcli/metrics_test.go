@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLoadedLatencyHistogram(t *testing.T) {
+	result := &Result{
+		Servers: []ServerResult{{Host: "a.example", LatencyMs: 10, LatencySamplesMs: []float64{9, 11}}},
+		LoadedLatency: []LatencySample{
+			{Phase: "download", Host: "a.example", LatencyMs: 40},
+			{Phase: "download", Host: "a.example", LatencyMs: 300},
+			{Phase: "upload", Host: "a.example", LatencyMs: 80},
+		},
+	}
+	var buf bytes.Buffer
+	WriteResultMetrics(&buf, DaemonStatus{LastResult: result})
+	got := buf.String()
+	for _, want := range []string{
+		"# TYPE fastcli_loaded_latency_ms histogram\n",
+		`fastcli_loaded_latency_ms_bucket{server="a.example",phase="download",le="50"} 1` + "\n",
+		`fastcli_loaded_latency_ms_bucket{server="a.example",phase="download",le="+Inf"} 2` + "\n",
+		`fastcli_loaded_latency_ms_sum{server="a.example",phase="download"} 340` + "\n",
+		`fastcli_loaded_latency_ms_count{server="a.example",phase="upload"} 1` + "\n",
+		`fastcli_latency_sample_ms_count{server="a.example"} 2` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("the metrics lack %q:\n%s", want, got)
+		}
+	}
+
+	found := map[string]bool{}
+	for _, s := range RemoteWriteSeries(*result) {
+		var name, phase string
+		for _, label := range s.Labels {
+			switch label.Name {
+			case "__name__":
+				name = label.Value
+			case "phase":
+				phase = label.Value
+			}
+		}
+		if name == "fastcli_loaded_latency_ms" {
+			found[phase] = s.Histogram != nil
+		}
+	}
+	if !found["download"] || !found["upload"] {
+		t.Errorf("remote write has loaded latency histograms %v, want download and upload", found)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Nagios plugin exit codes
+const (
+	NagiosOK       = 0
+	NagiosWarning  = 1
+	NagiosCritical = 2
+	NagiosUnknown  = 3
+)
+
+var nagiosStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// NagiosThresholds are the limits of -w and -c. Zero leaves a limit unset.
+type NagiosThresholds struct {
+	DownloadMbps float64
+	UploadMbps   float64
+	LatencyMs    float64
+}
+
+// ParseNagiosThresholds parses "download/upload/latency", e.g. "100/10/50"
+// for 100 Mbit/s down, 10 Mbit/s up and 50 ms. Trailing parts may be left
+// out and any part may be empty.
+func ParseNagiosThresholds(s string) (NagiosThresholds, error) {
+	var t NagiosThresholds
+	if s == "" {
+		return t, nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) > 3 {
+		return t, fmt.Errorf("invalid thresholds %q, expected download/upload/latency", s)
+	}
+	values := []*float64{&t.DownloadMbps, &t.UploadMbps, &t.LatencyMs}
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return t, fmt.Errorf("invalid thresholds %q, expected download/upload/latency", s)
+		}
+		*values[i] = v
+	}
+	return t, nil
+}
+
+// WriteNagios writes the result, or runErr, as a Nagios/Icinga plugin status
+// line with perfdata, followed by the result's warnings as long output, and
+// returns the matching exit code. Phases skipped in cfg are left out, and
+// those with insufficient data have a perfdata value of U and aren't held
+// against the thresholds; if that leaves a threshold unchecked and nothing
+// else is wrong, the status is UNKNOWN rather than OK.
+func WriteNagios(w io.Writer, cfg Config, result Result, runErr error, warn, crit NagiosThresholds) int {
+	if runErr != nil {
+		fmt.Fprintf(w, "FASTCLI UNKNOWN - %s\n", runErr)
+		return NagiosUnknown
+	}
+	summary := result.Summary()
+	status := NagiosOK
+	var problems, measured, perfdata []string
+	unchecked := false
+	check := func(name string, value, warnAt, critAt float64, below bool, unit string) {
+		// Speeds alert when they fall below the limit, which Nagios
+		// ranges write as "limit:".
+		rangeSuffix, perfUnit := "", unit
+		if below {
+			rangeSuffix, perfUnit = ":", ""
+		}
+		if summary.lacks(nagiosPhase(name)) {
+			measured = append(measured, name+" insufficient data")
+			perfdata = append(perfdata, nagiosPerf(name, math.NaN(), perfUnit, warnAt, critAt, rangeSuffix))
+			if warnAt != 0 || critAt != 0 {
+				unchecked = true
+			}
+			return
+		}
+		measured = append(measured, fmt.Sprintf("%s %0.1f %s", name, value, unit))
+		perfdata = append(perfdata, nagiosPerf(name, value, perfUnit, warnAt, critAt, rangeSuffix))
+		exceeds := func(limit float64) bool {
+			if limit == 0 {
+				return false
+			}
+			if below {
+				return value < limit
+			}
+			return value > limit
+		}
+		switch {
+		case exceeds(critAt):
+			status = NagiosCritical
+			problems = append(problems, fmt.Sprintf("%s %0.1f %s", name, value, unit))
+		case exceeds(warnAt):
+			if status < NagiosWarning {
+				status = NagiosWarning
+			}
+			problems = append(problems, fmt.Sprintf("%s %0.1f %s", name, value, unit))
+		}
+	}
+	if !cfg.SkipDownload {
+		check("download", summary.DownloadMbps, warn.DownloadMbps, crit.DownloadMbps, true, "Mbit/s")
+	}
+	if !cfg.SkipUpload {
+		check("upload", summary.UploadMbps, warn.UploadMbps, crit.UploadMbps, true, "Mbit/s")
+	}
+	if !cfg.SkipLatency {
+		check("latency", summary.PingMs, warn.LatencyMs, crit.LatencyMs, false, "ms")
+		jitter := summary.JitterMs
+		if summary.lacks("latency") {
+			jitter = math.NaN()
+		}
+		perfdata = append(perfdata, nagiosPerf("jitter", jitter, "ms", 0, 0, ""))
+	}
+
+	if unchecked && status == NagiosOK {
+		status = NagiosUnknown
+	}
+	text := strings.Join(measured, ", ")
+	if len(problems) > 0 {
+		text = strings.Join(problems, ", ")
+	}
+	fmt.Fprintf(w, "FASTCLI %s - %s | %s\n", nagiosStates[status], text, strings.Join(perfdata, " "))
+	// the long output, shown with the service's details
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(w, "Warning: %s\n", warning)
+	}
+	return status
+}
+
+// nagiosPhase is the phase of a measurement of WriteNagios.
+func nagiosPhase(name string) string {
+	if name == "latency" || name == "jitter" {
+		return "latency"
+	}
+	return name
+}
+
+// nagiosPerf formats a perfdata value. A NaN value is written as U, which
+// Nagios takes for a value that couldn't be determined.
+func nagiosPerf(label string, value float64, unit string, warn, crit float64, rangeSuffix string) string {
+	limit := func(v float64) string {
+		if v == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64) + rangeSuffix
+	}
+	if math.IsNaN(value) {
+		return fmt.Sprintf("%s=U;%s;%s;0;", label, limit(warn), limit(crit))
+	}
+	return fmt.Sprintf("%s=%s%s;%s;%s;0;", label, strconv.FormatFloat(value, 'f', 3, 64), unit, limit(warn), limit(crit))
+}
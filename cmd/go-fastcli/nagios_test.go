@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteNagiosInsufficient(t *testing.T) {
+	result := Result{Servers: []ServerResult{
+		{Host: "a", LatencyMs: 12, JitterMs: 1, UploadMbps: 20, Insufficient: []string{"download"}},
+	}}
+	crit := NagiosThresholds{DownloadMbps: 100, UploadMbps: 10, LatencyMs: 50}
+	var b bytes.Buffer
+	status := WriteNagios(&b, Config{}, result, nil, NagiosThresholds{}, crit)
+	if status != NagiosUnknown {
+		t.Errorf("got status %d, want UNKNOWN as the download can't be held against its threshold", status)
+	}
+	// a threshold that is missed still counts
+	var critical bytes.Buffer
+	result.Servers[0].LatencyMs = 80
+	if status := WriteNagios(&critical, Config{}, result, nil, NagiosThresholds{}, crit); status != NagiosCritical {
+		t.Errorf("got status %d with a high latency, want CRITICAL", status)
+	}
+	line := b.String()
+	for _, want := range []string{"download insufficient data", "download=U;;100:;0;", "upload=20.000;;10:;0;"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("got %q, want it to contain %q", line, want)
+		}
+	}
+}
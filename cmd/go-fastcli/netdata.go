@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// netdataType is the type of the plugin's charts, which Netdata shows as a
+// section of its dashboard.
+const netdataType = "fastcli"
+
+// netdataPriority orders the plugin's charts after Netdata's own.
+const netdataPriority = 90000
+
+// netdataChart is a chart the plugin defines. The protocol only carries
+// integers, so values are sent multiplied by divisor.
+type netdataChart struct {
+	id, title, units, family, kind string
+	dims                           []string
+	divisor                        int
+	// sparse charts leave out dimensions that are 0, such as the speed of a
+	// skipped phase, which Netdata shows as a gap rather than a drop.
+	sparse bool
+}
+
+var netdataCharts = []netdataChart{
+	{"speed", "Speed of the last run", "Mbit/s", "speed", "line", []string{"download", "upload"}, 1000, true},
+	{"live", "Speed of the transfer in progress", "Mbit/s", "speed", "area", []string{"download", "upload"}, 1000, false},
+	{"latency", "Latency of the last run", "ms", "latency", "line", []string{"ping", "jitter"}, 1000, true},
+	{"status", "Whether the last run failed", "failed", "status", "line", []string{"failed"}, 1, false},
+}
+
+// NetdataPlugin reports a daemon's runs to Netdata over its external plugin
+// protocol (plugins.d): it defines the charts once, then every UpdateEvery
+// sends the last run's result, the speed of the transfer in progress, and
+// whether the last run failed. Netdata starts the plugin and reads its
+// stdout.
+type NetdataPlugin struct {
+	W           io.Writer
+	UpdateEvery time.Duration
+
+	mu           sync.Mutex
+	downloadMbps float64
+	uploadMbps   float64
+}
+
+// Event keeps the speed of the second just measured for the live chart. It
+// is safe to call concurrently, as Config.OnEvent requires.
+func (p *NetdataPlugin) Event(evt Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch evt.Type {
+	case EventSample:
+		if evt.Mbps == nil {
+			// a latency sample taken during the transfer
+			return
+		}
+		switch evt.Phase {
+		case "download":
+			p.downloadMbps = *evt.Mbps
+		case "upload":
+			p.uploadMbps = *evt.Mbps
+		}
+	case EventPhaseEnd, EventDone, EventError:
+		p.downloadMbps, p.uploadMbps = 0, 0
+	}
+}
+
+// Define sends the CHART and DIMENSION lines of the plugin's charts.
+func (p *NetdataPlugin) Define() error {
+	seconds := int(p.UpdateEvery / time.Second)
+	var b strings.Builder
+	for i, chart := range netdataCharts {
+		fmt.Fprintf(&b, "CHART %s.%s '' '%s' '%s' %s %s.%s %s %d %d '' go-fastcli\n", netdataType, chart.id,
+			chart.title, chart.units, chart.family, netdataType, chart.id, chart.kind, netdataPriority+i, seconds)
+		for _, dim := range chart.dims {
+			fmt.Fprintf(&b, "DIMENSION %s '' absolute 1 %d\n", dim, chart.divisor)
+		}
+	}
+	_, err := io.WriteString(p.W, b.String())
+	return err
+}
+
+// Update sends the values of the charts for status. The result charts are
+// left out until the first run has finished, and the dimensions of a phase
+// with insufficient data are left unset, which netdata shows as a gap.
+func (p *NetdataPlugin) Update(status DaemonStatus) error {
+	values := map[string][]float64{}
+	if status.LastResult != nil {
+		s := status.LastResult.Summary()
+		value := func(v float64, phase string) float64 {
+			if s.lacks(phase) {
+				return math.NaN()
+			}
+			return v
+		}
+		values["speed"] = []float64{value(s.DownloadMbps, "download"), value(s.UploadMbps, "upload")}
+		values["latency"] = []float64{value(s.PingMs, "latency"), value(s.JitterMs, "latency")}
+	}
+	if status.Runs > 0 {
+		failed := 0.0
+		if status.LastError != "" {
+			failed = 1
+		}
+		values["status"] = []float64{failed}
+	}
+	p.mu.Lock()
+	values["live"] = []float64{p.downloadMbps, p.uploadMbps}
+	p.mu.Unlock()
+
+	var b strings.Builder
+	for _, chart := range netdataCharts {
+		chartValues, ok := values[chart.id]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "BEGIN %s.%s\n", netdataType, chart.id)
+		for i, dim := range chart.dims {
+			if chart.sparse && chartValues[i] == 0 || math.IsNaN(chartValues[i]) {
+				continue
+			}
+			fmt.Fprintf(&b, "SET %s = %d\n", dim, int64(math.Round(chartValues[i]*float64(chart.divisor))))
+		}
+		b.WriteString("END\n")
+	}
+	_, err := io.WriteString(p.W, b.String())
+	return err
+}
+
+// Run defines the charts and updates them from d every UpdateEvery until
+// ctx ends or Netdata stops reading.
+func (p *NetdataPlugin) Run(ctx context.Context, d *Daemon) error {
+	if err := p.Define(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(p.UpdateEvery)
+	defer ticker.Stop()
+	for {
+		if err := p.Update(d.Status()); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// netnsDir is where `ip netns add` creates named namespaces.
+const netnsDir = "/var/run/netns"
+
+// netNS is a network namespace to open the test's sockets in.
+type netNS struct {
+	name string
+	file *os.File
+}
+
+// openNetNS opens a namespace by its `ip netns` name or by path, e.g.
+// /proc/<pid>/ns/net. Entering it needs CAP_SYS_ADMIN, which is checked
+// right away rather than on the first connection.
+func openNetNS(name string) (*netNS, error) {
+	path := name
+	if !strings.Contains(name, "/") {
+		path = filepath.Join(netnsDir, name)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening network namespace: %w", err)
+	}
+	ns := &netNS{name: name, file: file}
+	if err := ns.Do(func() error { return nil }); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return ns, nil
+}
+
+// Do runs fn on a thread switched to the namespace. Sockets fn creates stay
+// in the namespace.
+func (ns *netNS) Do(fn func() error) error {
+	errc := make(chan error, 1)
+	go func() {
+		// The thread is never unlocked, so the runtime discards it when the
+		// goroutine exits instead of reusing it in the wrong namespace.
+		runtime.LockOSThread()
+		if _, _, errno := syscall.RawSyscall(sysSetns, ns.file.Fd(), syscall.CLONE_NEWNET, 0); errno != 0 {
+			errc <- fmt.Errorf("entering network namespace %s: %w", ns.name, errno)
+			return
+		}
+		errc <- fn()
+	}()
+	return <-errc
+}
+
+func (ns *netNS) Close() error {
+	return ns.file.Close()
+}
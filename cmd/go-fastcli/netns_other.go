@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+type netNS struct{}
+
+func openNetNS(name string) (*netNS, error) {
+	return nil, errors.New("network namespaces are only supported on Linux")
+}
+
+func (ns *netNS) Do(fn func() error) error {
+	return fn()
+}
+
+func (ns *netNS) Close() error {
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func readInterfaceStat(name, stat string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join("/sys/class/net", name, "statistics", stat))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func ReadInterfaceCounters(name string) (rx, tx int64, err error) {
+	if rx, err = readInterfaceStat(name, "rx_bytes"); err != nil {
+		return 0, 0, err
+	}
+	if tx, err = readInterfaceStat(name, "tx_bytes"); err != nil {
+		return 0, 0, err
+	}
+	return rx, tx, nil
+}
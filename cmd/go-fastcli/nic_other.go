@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func ReadInterfaceCounters(name string) (rx, tx int64, err error) {
+	return 0, 0, errors.New("interface counters are only supported on Linux")
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NotifyState is what notifications remember of the previous run, to
+// compare the next one with it.
+type NotifyState struct {
+	Time    time.Time
+	Summary Summary
+	// Breached is set if the run was beyond the notification thresholds.
+	Breached bool `json:",omitempty"`
+}
+
+// DefaultNotifyState returns $XDG_STATE_HOME/go-fastcli/last-run.json,
+// falling back to ~/.local/state.
+func DefaultNotifyState() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "last-run.json"
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "go-fastcli", "last-run.json")
+}
+
+// loadNotifyState returns nil if there was no previous run.
+func loadNotifyState(path string) (*NotifyState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state NotifyState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &state, nil
+}
+
+func saveNotifyState(path string, state NotifyState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// thresholdsValue is the flag.Value of a download/upload/latency flag.
+type thresholdsValue struct {
+	p *NagiosThresholds
+}
+
+func (t thresholdsValue) String() string {
+	if t.p == nil || *t.p == (NagiosThresholds{}) {
+		return ""
+	}
+	format := func(v float64) string {
+		if v == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return format(t.p.DownloadMbps) + "/" + format(t.p.UploadMbps) + "/" + format(t.p.LatencyMs)
+}
+
+func (t thresholdsValue) Set(s string) error {
+	v, err := ParseNagiosThresholds(s)
+	if err != nil {
+		return err
+	}
+	*t.p = v
+	return nil
+}
+
+// NotifyBreaches describes the measurements beyond the thresholds: speeds
+// below them and latency above, and speeds that regressed from cfg.Baseline.
+// Phases skipped in cfg or with insufficient data aren't checked.
+func NotifyBreaches(cfg Config, s Summary, t NagiosThresholds) []string {
+	var breaches []string
+	if !cfg.SkipDownload && !s.lacks("download") && t.DownloadMbps > 0 && s.DownloadMbps < t.DownloadMbps {
+		breaches = append(breaches, fmt.Sprintf("download %0.1f Mbit/s is below %g Mbit/s", s.DownloadMbps, t.DownloadMbps))
+	}
+	if !cfg.SkipUpload && !s.lacks("upload") && t.UploadMbps > 0 && s.UploadMbps < t.UploadMbps {
+		breaches = append(breaches, fmt.Sprintf("upload %0.1f Mbit/s is below %g Mbit/s", s.UploadMbps, t.UploadMbps))
+	}
+	if !cfg.SkipLatency && !s.lacks("latency") && t.LatencyMs > 0 && s.PingMs > t.LatencyMs {
+		breaches = append(breaches, fmt.Sprintf("ping %0.1f ms is above %g ms", s.PingMs, t.LatencyMs))
+	}
+	if cfg.Baseline != nil {
+		regressions, _ := cfg.Baseline.Regressions(cfg, s, cfg.BaselineDropPercent)
+		breaches = append(breaches, regressions...)
+	}
+	return breaches
+}
+
+// Notification is the summary of a run that Notify sends.
+type Notification struct {
+	Title string
+	// Breached is set if the run was beyond the thresholds, and Recovered
+	// if it is back within them after a run that wasn't.
+	Breached  bool
+	Recovered bool
+	Body      string
+}
+
+// FormatNotification summarizes a run in plain text, next to the previous
+// run if there was one.
+func FormatNotification(cfg Config, result Result, previous *NotifyState, breaches []string) Notification {
+	host, _ := os.Hostname()
+	n := Notification{Title: "Speed test"}
+	if host != "" {
+		n.Title += " on " + host
+	}
+	var b strings.Builder
+	switch {
+	case len(breaches) > 0:
+		n.Title += ": below thresholds"
+		n.Breached = true
+		for _, breach := range breaches {
+			fmt.Fprintf(&b, "• %s\n", breach)
+		}
+	case previous != nil && previous.Breached:
+		n.Title += ": back within thresholds"
+		n.Recovered = true
+	case len(result.Anomalies) > 0:
+		n.Title += ": unusual result"
+		n.Breached = true
+	}
+	for _, anomaly := range result.Anomalies {
+		fmt.Fprintf(&b, "• %s\n", anomaly)
+	}
+
+	s := result.Summary()
+	speed := func(name, phase string, v float64, was func(Summary) float64) {
+		if s.lacks(phase) {
+			fmt.Fprintf(&b, "%s: insufficient data\n", name)
+			return
+		}
+		fmt.Fprintf(&b, "%s: %0.1f Mbit/s", name, v)
+		if previous != nil && !previous.Summary.lacks(phase) && was(previous.Summary) > 0 {
+			before := was(previous.Summary)
+			fmt.Fprintf(&b, " (was %0.1f, %+0.1f%%)", before, (v-before)/before*100)
+		}
+		b.WriteString("\n")
+	}
+	if !cfg.SkipDownload {
+		speed("Download", "download", s.DownloadMbps, func(s Summary) float64 { return s.DownloadMbps })
+	}
+	if !cfg.SkipUpload {
+		speed("Upload", "upload", s.UploadMbps, func(s Summary) float64 { return s.UploadMbps })
+	}
+	if !cfg.SkipLatency && s.lacks("latency") {
+		b.WriteString("Ping: insufficient data\n")
+	} else if !cfg.SkipLatency {
+		fmt.Fprintf(&b, "Ping: %0.1f ms, jitter %0.1f ms", s.PingMs, s.JitterMs)
+		if previous != nil && !previous.Summary.lacks("latency") && previous.Summary.PingMs > 0 {
+			fmt.Fprintf(&b, " (was %0.1f ms, %+0.1f ms)", previous.Summary.PingMs, s.PingMs-previous.Summary.PingMs)
+		}
+		b.WriteString("\n")
+	}
+
+	var servers []string
+	for _, server := range result.Servers {
+		switch {
+		case server.City == "" && server.Country == "":
+			servers = append(servers, server.Host)
+		case server.City == "" || server.Country == "":
+			servers = append(servers, fmt.Sprintf("%s (%s)", server.Host, server.City+server.Country))
+		default:
+			servers = append(servers, fmt.Sprintf("%s (%s)", server.City, server.Country))
+		}
+	}
+	fmt.Fprintf(&b, "Servers: %s", strings.Join(servers, ", "))
+	if result.Connection.IP != "" {
+		if result.Connection.ISP != "" {
+			fmt.Fprintf(&b, " from %s (%s, %s)", result.Connection.IP, result.Connection.ISP, result.Connection.ASN)
+		} else {
+			fmt.Fprintf(&b, " from %s (%s)", result.Connection.IP, result.Connection.ASN)
+		}
+	}
+	if result.UnexpectedCountry {
+		fmt.Fprintf(&b, "\nConnected from %s, not %s: a VPN or proxy likely carried the test", locationName(result.Connection.Location), countryList(cfg.ExpectCountries))
+	}
+	if previous != nil {
+		fmt.Fprintf(&b, "\nCompared with the run at %s", previous.Time.Local().Format("2006-01-02 15:04"))
+	}
+	n.Body = b.String()
+	return n
+}
+
+// notifyEnabled reports whether cfg sends notifications anywhere.
+func notifyEnabled(cfg Config) bool {
+	return cfg.SlackWebhook != "" || cfg.DiscordWebhook != "" || cfg.NtfyURL != "" || cfg.GotifyURL != ""
+}
+
+// Notify sends the summary of a run to the chats and push services in cfg,
+// comparing it with the previous run in cfg.NotifyState. With
+// cfg.NotifyThresholds or cfg.Baseline set, it only sends it when they are
+// breached or the run is unusual, and once more when a later run is back
+// within them.
+func Notify(ctx context.Context, cfg Config, result Result, onError func(sink string, err error)) {
+	previous, err := loadNotifyState(cfg.NotifyState)
+	if err != nil {
+		onError("notify", err)
+	}
+	breaches := NotifyBreaches(cfg, result.Summary(), cfg.NotifyThresholds)
+	state := NotifyState{Time: result.Time, Summary: result.Summary(), Breached: len(breaches) > 0}
+	if err := saveNotifyState(cfg.NotifyState, state); err != nil {
+		onError("notify", err)
+	}
+	if (cfg.NotifyThresholds != (NagiosThresholds{}) || cfg.Baseline != nil) && len(breaches) == 0 && len(result.Anomalies) == 0 && (previous == nil || !previous.Breached) {
+		return
+	}
+
+	n := FormatNotification(cfg, result, previous, breaches)
+	if cfg.SlackWebhook != "" {
+		text := chatText(n, func(s string) string { return "*" + s + "*" })
+		if err := postChat(ctx, cfg.SlackWebhook, map[string]string{"text": text}); err != nil {
+			onError("slack", err)
+		}
+	}
+	if cfg.DiscordWebhook != "" {
+		text := chatText(n, func(s string) string { return "**" + s + "**" })
+		if err := postChat(ctx, cfg.DiscordWebhook, map[string]string{"content": text}); err != nil {
+			onError("discord", err)
+		}
+	}
+	if cfg.NtfyURL != "" {
+		if err := SendNtfy(ctx, cfg.NtfyURL, cfg.NtfyToken, n); err != nil {
+			onError("ntfy", err)
+		}
+	}
+	if cfg.GotifyURL != "" {
+		if err := SendGotify(ctx, cfg.GotifyURL, cfg.GotifyToken, n); err != nil {
+			onError("gotify", err)
+		}
+	}
+}
+
+// chatText renders n as chat markdown, with bold marking up the title in
+// the dialect of the chat.
+func chatText(n Notification, bold func(string) string) string {
+	title := bold(n.Title)
+	if n.Breached {
+		title = "⚠️ " + title
+	} else if n.Recovered {
+		title = "✅ " + title
+	}
+	return title + "\n" + n.Body
+}
+
+func postChat(ctx context.Context, url string, message map[string]string) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return postWithRetry(ctx, url, nil, body)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+var csvHeader = []string{"timestamp", "ip", "asn", "server", "ping_ms", "jitter_ms", "download_mbps", "upload_mbps", "bytes_used"}
+
+// WriteCSV writes one row per tested server. The header is only written when
+// w isn't a non-empty regular file, so `go-fastcli -format csv >> log.csv`
+// keeps appending rows under a single header.
+func WriteCSV(w io.Writer, result Result) error {
+	cw := csv.NewWriter(w)
+	if needsCSVHeader(w) {
+		cw.Write(csvHeader)
+	}
+	writeCSVRows(cw, result)
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeCSVRows writes a row per server. The fields of a phase with
+// insufficient data are left empty.
+func writeCSVRows(cw *csv.Writer, result Result) {
+	for _, server := range result.Servers {
+		value := func(v float64, phase string) string {
+			if server.lacks(phase) {
+				return ""
+			}
+			return strconv.FormatFloat(v, 'f', 3, 64)
+		}
+		cw.Write([]string{
+			result.Time.UTC().Format(time.RFC3339),
+			result.Connection.IP,
+			result.Connection.ASN,
+			server.Host,
+			value(server.LatencyMs, "latency"),
+			value(server.JitterMs, "latency"),
+			value(server.DownloadMbps, "download"),
+			value(server.UploadMbps, "upload"),
+			strconv.FormatInt(int64(server.DownloadMB+server.UploadMB)*1024*1024, 10),
+		})
+	}
+}
+
+func needsCSVHeader(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return true
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return true
+	}
+	return !info.Mode().IsRegular() || info.Size() == 0
+}
+
+// WriteTable writes the result as one aligned table, a row per server and,
+// with several, a row with the summary of the run, followed by the
+// warnings. Values that weren't measured, or had insufficient data, are
+// shown as "-".
+func WriteTable(w io.Writer, result Result) error {
+	value := func(v float64) string {
+		if v <= 0 {
+			return "-"
+		}
+		return strconv.FormatFloat(v, 'f', 1, 64)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Server\tPing ms\tJitter ms\tDown Mbit/s\tUp Mbit/s\tData used\t")
+	var used int64
+	for _, server := range result.Servers {
+		bytes := int64(server.DownloadMB+server.UploadMB) * 1024 * 1024
+		used += bytes
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t\n", server.Host, value(server.LatencyMs), value(server.JitterMs),
+			value(server.DownloadMbps), value(server.UploadMbps), formatBytes(bytes))
+	}
+	if len(result.Servers) > 1 {
+		s := result.Summary()
+		fmt.Fprintf(tw, "Summary\t%s\t%s\t%s\t%s\t%s\t\n", value(s.PingMs), value(s.JitterMs),
+			value(s.DownloadMbps), value(s.UploadMbps), formatBytes(used))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(result.Warnings) > 0 {
+		fmt.Fprintln(w, "\nWarnings:")
+		printWarnings(w, result.Warnings)
+	}
+	return nil
+}
+
+// WriteResult writes result in a machine-readable format. The text format is
+// printed while the test runs, so there is nothing left to write for it.
+func WriteResult(w io.Writer, format string, result Result) error {
+	switch format {
+	case "text":
+		return nil
+	case "csv":
+		return WriteCSV(w, result)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	case "one":
+		s := result.Summary()
+		if s.lacks("download") {
+			// no number rather than a zero a script would take for one
+			return errors.New("the download had insufficient data")
+		}
+		// a whole number, so shells can compare it with -lt
+		_, err := fmt.Fprintf(w, "%.0f\n", s.DownloadMbps)
+		return err
+	case "simple":
+		s := result.Summary()
+		value := func(v float64, precision int, unit, phase string) string {
+			if s.lacks(phase) {
+				return "insufficient data"
+			}
+			return strconv.FormatFloat(v, 'f', precision, 64) + " " + unit
+		}
+		// the lines of speedtest-cli --simple, which scripts parse
+		_, err := fmt.Fprintf(w, "Ping: %s\nDownload: %s\nUpload: %s\n",
+			value(s.PingMs, 3, "ms", "latency"), value(s.DownloadMbps, 2, "Mbit/s", "download"), value(s.UploadMbps, 2, "Mbit/s", "upload"))
+		return err
+	case "telegraf":
+		return WriteTelegraf(w, result)
+	case "table":
+		return WriteTable(w, result)
+	case "nagios":
+		// needs the thresholds, see WriteNagios
+		return nil
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+func ValidFormat(format string) bool {
+	switch format {
+	case "text", "csv", "json", "one", "simple", "telegraf", "table", "nagios":
+		return true
+	}
+	return false
+}
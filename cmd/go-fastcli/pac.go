@@ -0,0 +1,1314 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PAC files are JavaScript. The interpreter below covers what proxy scripts
+// use in practice: functions, var, if/else, return, the usual operators, a
+// few string methods and the PAC helper functions. Anything else is reported
+// as a syntax error rather than guessed at.
+
+// pacMaxCallDepth is how deep calls may nest before a script is taken to
+// recurse without end. Proxy scripts call a few helpers deep at most.
+const pacMaxCallDepth = 200
+
+// PAC is a parsed proxy auto-config script.
+type PAC struct {
+	mu     sync.Mutex
+	global *pacEnv
+	lookup func(ctx context.Context, host string) ([]string, error)
+	now    func() time.Time
+	// ctx is that of the call to the script in progress, which its DNS
+	// lookups run in
+	ctx context.Context
+}
+
+// LoadPAC fetches a PAC script from an http(s) URL with client, or reads it
+// from a file path or file:// URL. The script resolves hosts with lookup,
+// or the system resolver if nil.
+func LoadPAC(ctx context.Context, client *http.Client, location string, lookup func(ctx context.Context, host string) ([]string, error)) (*PAC, error) {
+	var src []byte
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("fetching PAC file: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching PAC file: %s returned %s", location, resp.Status)
+		}
+		if src, err = io.ReadAll(resp.Body); err != nil {
+			return nil, fmt.Errorf("fetching PAC file: %w", err)
+		}
+	} else {
+		var err error
+		if src, err = os.ReadFile(strings.TrimPrefix(location, "file://")); err != nil {
+			return nil, err
+		}
+	}
+	pac, err := ParsePAC(ctx, string(src), lookup)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+	return pac, nil
+}
+
+// ParsePAC runs the top level of a PAC script, which must define
+// FindProxyForURL. The script resolves hosts with lookup, or the system
+// resolver if nil.
+func ParsePAC(ctx context.Context, src string, lookup func(ctx context.Context, host string) ([]string, error)) (*PAC, error) {
+	tokens, err := pacTokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &pacParser{tokens: tokens}
+	var program []pacStmt
+	for p.peek().kind != pacEOF {
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		program = append(program, stmt)
+	}
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupHost
+	}
+	pac := &PAC{lookup: lookup, now: time.Now, ctx: ctx}
+	pac.global = &pacEnv{vars: pac.builtins()}
+	_, _, err = pacExecBlock(program, pac.global)
+	pac.ctx = nil
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := pac.global.vars["FindProxyForURL"].(*pacFunction); !ok {
+		return nil, errors.New("PAC file does not define FindProxyForURL")
+	}
+	return pac, nil
+}
+
+// FindProxy returns the PAC script's answer for u, e.g. "PROXY p:8080;
+// DIRECT". Like browsers, it only passes the scheme and host of https URLs.
+// DNS lookups of the script give up when ctx ends.
+func (pac *PAC) FindProxy(ctx context.Context, u *url.URL) (string, error) {
+	target := u.String()
+	if u.Scheme == "https" {
+		target = "https://" + u.Host + "/"
+	}
+	pac.mu.Lock()
+	defer pac.mu.Unlock()
+	pac.ctx = ctx
+	defer func() { pac.ctx = nil }()
+	result, err := pacCall(pac.global.vars["FindProxyForURL"], []interface{}{target, u.Hostname()}, 0)
+	if err != nil {
+		return "", fmt.Errorf("FindProxyForURL: %w", err)
+	}
+	s, ok := result.(string)
+	if !ok {
+		return "", fmt.Errorf("FindProxyForURL returned %s instead of a string", pacToString(result))
+	}
+	return s, nil
+}
+
+// Proxy picks the proxy for req, for use as http.Transport.Proxy.
+func (pac *PAC) Proxy(req *http.Request) (*url.URL, error) {
+	result, err := pac.FindProxy(req.Context(), req.URL)
+	if err != nil {
+		return nil, err
+	}
+	return parsePACResult(result)
+}
+
+// parsePACResult returns the first usable proxy of a FindProxyForURL result,
+// or nil for DIRECT.
+func parsePACResult(result string) (*url.URL, error) {
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		var scheme string
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			return nil, nil
+		case "PROXY", "HTTP":
+			scheme = "http"
+		case "HTTPS":
+			scheme = "https"
+		case "SOCKS", "SOCKS5":
+			scheme = "socks5"
+		default:
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("PAC entry %q has no address", strings.TrimSpace(entry))
+		}
+		return &url.URL{Scheme: scheme, Host: fields[1]}, nil
+	}
+	return nil, fmt.Errorf("no supported proxy in PAC result %q", result)
+}
+
+// Tokens
+
+const (
+	pacEOF = iota
+	pacIdent
+	pacNumber
+	pacString
+	pacPunct
+)
+
+type pacToken struct {
+	kind int
+	text string
+	num  float64
+	line int
+}
+
+var pacPuncts = []string{"===", "!==", "==", "!=", "<=", ">=", "&&", "||", "+=", "(", ")", "{", "}", ",", ";", ".", "!", "<", ">", "+", "-", "*", "/", "%", "=", "?", ":", "[", "]"}
+
+func pacTokenize(src string) ([]pacToken, error) {
+	var tokens []pacToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\n' {
+					return nil, fmt.Errorf("line %d: unterminated string", line)
+				}
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+					switch src[j] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(src[j])
+					}
+					continue
+				}
+				sb.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			tokens = append(tokens, pacToken{kind: pacString, text: sb.String(), line: line})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			num, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid number %q", line, src[i:j])
+			}
+			tokens = append(tokens, pacToken{kind: pacNumber, num: num, line: line})
+			i = j
+		case c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '$' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			tokens = append(tokens, pacToken{kind: pacIdent, text: src[i:j], line: line})
+			i = j
+		default:
+			matched := false
+			for _, punct := range pacPuncts {
+				if strings.HasPrefix(src[i:], punct) {
+					tokens = append(tokens, pacToken{kind: pacPunct, text: punct, line: line})
+					i += len(punct)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+			}
+		}
+	}
+	return append(tokens, pacToken{kind: pacEOF, line: line}), nil
+}
+
+// Parser
+
+type pacParser struct {
+	tokens []pacToken
+	pos    int
+}
+
+func (p *pacParser) peek() pacToken {
+	return p.tokens[p.pos]
+}
+
+func (p *pacParser) next() pacToken {
+	t := p.tokens[p.pos]
+	if t.kind != pacEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether the next token is the punctuator or keyword text.
+func (p *pacParser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == pacPunct || t.kind == pacIdent) && t.text == text
+}
+
+func (p *pacParser) accept(text string) bool {
+	if p.is(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *pacParser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("expected %q", text)
+	}
+	return nil
+}
+
+func (p *pacParser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	found := t.text
+	switch t.kind {
+	case pacEOF:
+		found = "end of file"
+	case pacNumber:
+		found = strconv.FormatFloat(t.num, 'f', -1, 64)
+	}
+	return fmt.Errorf("line %d: %s, found %q", t.line, fmt.Sprintf(format, args...), found)
+}
+
+func (p *pacParser) ident() (string, error) {
+	t := p.peek()
+	if t.kind != pacIdent {
+		return "", p.errorf("expected a name")
+	}
+	p.pos++
+	return t.text, nil
+}
+
+func (p *pacParser) block() ([]pacStmt, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var stmts []pacStmt
+	for !p.accept("}") {
+		if p.peek().kind == pacEOF {
+			return nil, p.errorf("expected %q", "}")
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts, nil
+}
+
+func (p *pacParser) statement() (pacStmt, error) {
+	switch {
+	case p.accept("function"):
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		fn, err := p.function()
+		if err != nil {
+			return nil, err
+		}
+		return &pacDeclStmt{names: []string{name}, values: []pacExpr{fn}}, nil
+	case p.accept("var"), p.accept("let"), p.accept("const"):
+		decl := &pacDeclStmt{}
+		for {
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			var value pacExpr = &pacLiteral{}
+			if p.accept("=") {
+				if value, err = p.expression(); err != nil {
+					return nil, err
+				}
+			}
+			decl.names = append(decl.names, name)
+			decl.values = append(decl.values, value)
+			if !p.accept(",") {
+				break
+			}
+		}
+		p.accept(";")
+		return decl, nil
+	case p.accept("if"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		then, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmt := &pacIfStmt{cond: cond, then: then}
+		if p.accept("else") {
+			if stmt.otherwise, err = p.statement(); err != nil {
+				return nil, err
+			}
+		}
+		return stmt, nil
+	case p.accept("return"):
+		stmt := &pacReturnStmt{value: &pacLiteral{}}
+		if !p.is(";") && !p.is("}") {
+			var err error
+			if stmt.value, err = p.expression(); err != nil {
+				return nil, err
+			}
+		}
+		p.accept(";")
+		return stmt, nil
+	case p.is("{"):
+		stmts, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &pacBlockStmt{stmts: stmts}, nil
+	case p.accept(";"):
+		return &pacBlockStmt{}, nil
+	}
+	expr, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	p.accept(";")
+	return &pacExprStmt{expr: expr}, nil
+}
+
+// function parses the parameters and body following "function name".
+func (p *pacParser) function() (pacExpr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	fn := &pacFunctionExpr{}
+	for !p.accept(")") {
+		if len(fn.params) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		fn.params = append(fn.params, name)
+	}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	fn.body = body
+	return fn, nil
+}
+
+func (p *pacParser) expression() (pacExpr, error) {
+	left, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if p.is("=") || p.is("+=") {
+		op := p.next().text
+		name, ok := left.(*pacIdentExpr)
+		if !ok {
+			return nil, p.errorf("can only assign to a variable")
+		}
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if op == "+=" {
+			value = &pacBinaryExpr{op: "+", left: left, right: value}
+		}
+		return &pacAssignExpr{name: name.name, value: value}, nil
+	}
+	return left, nil
+}
+
+func (p *pacParser) ternary() (pacExpr, error) {
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("?") {
+		return cond, nil
+	}
+	then, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return &pacTernaryExpr{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+// pacPrecedence lists binary operators from loosest to tightest binding.
+var pacPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "===", "!=="},
+	{"<", ">", "<=", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *pacParser) binary(level int) (pacExpr, error) {
+	if level == len(pacPrecedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, candidate := range pacPrecedence[level] {
+			if p.peek().kind == pacPunct && p.peek().text == candidate {
+				op = candidate
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		p.next()
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &pacBinaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *pacParser) unary() (pacExpr, error) {
+	if p.is("!") || p.is("-") {
+		op := p.next().text
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &pacUnaryExpr{op: op, operand: operand}, nil
+	}
+	return p.postfix()
+}
+
+func (p *pacParser) postfix() (pacExpr, error) {
+	expr, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			expr = &pacMemberExpr{object: expr, name: name}
+		case p.accept("("):
+			call := &pacCallExpr{callee: expr}
+			for !p.accept(")") {
+				if len(call.args) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				arg, err := p.expression()
+				if err != nil {
+					return nil, err
+				}
+				call.args = append(call.args, arg)
+			}
+			expr = call
+		default:
+			return expr, nil
+		}
+	}
+}
+
+func (p *pacParser) primary() (pacExpr, error) {
+	t := p.peek()
+	switch t.kind {
+	case pacNumber:
+		p.next()
+		return &pacLiteral{value: t.num}, nil
+	case pacString:
+		p.next()
+		return &pacLiteral{value: t.text}, nil
+	case pacIdent:
+		p.next()
+		switch t.text {
+		case "true":
+			return &pacLiteral{value: true}, nil
+		case "false":
+			return &pacLiteral{value: false}, nil
+		case "null", "undefined":
+			return &pacLiteral{}, nil
+		case "function":
+			return p.function()
+		}
+		return &pacIdentExpr{name: t.text}, nil
+	}
+	if p.accept("(") {
+		expr, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	}
+	return nil, p.errorf("unsupported syntax")
+}
+
+// Evaluation. Values are string, float64, bool, nil for null and undefined,
+// *pacFunction, or pacBuiltin.
+
+type pacEnv struct {
+	vars   map[string]interface{}
+	parent *pacEnv
+	depth  int // of the call the scope belongs to
+}
+
+func (env *pacEnv) lookup(name string) (interface{}, bool) {
+	for e := env; e != nil; e = e.parent {
+		if v, ok := e.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (env *pacEnv) assign(name string, value interface{}) {
+	for e := env; e != nil; e = e.parent {
+		if _, ok := e.vars[name]; ok || e.parent == nil {
+			e.vars[name] = value
+			return
+		}
+	}
+}
+
+type pacBuiltin func(args []interface{}) (interface{}, error)
+
+type pacFunction struct {
+	params  []string
+	body    []pacStmt
+	closure *pacEnv
+}
+
+// pacCall calls callee from within a call depth calls deep.
+func pacCall(callee interface{}, args []interface{}, depth int) (interface{}, error) {
+	switch fn := callee.(type) {
+	case pacBuiltin:
+		return fn(args)
+	case *pacFunction:
+		if depth >= pacMaxCallDepth {
+			return nil, fmt.Errorf("too much recursion, calls nest more than %d deep", pacMaxCallDepth)
+		}
+		env := &pacEnv{vars: map[string]interface{}{}, parent: fn.closure, depth: depth + 1}
+		for i, param := range fn.params {
+			if i < len(args) {
+				env.vars[param] = args[i]
+			} else {
+				env.vars[param] = nil
+			}
+		}
+		result, _, err := pacExecBlock(fn.body, env)
+		return result, err
+	}
+	return nil, fmt.Errorf("%s is not a function", pacToString(callee))
+}
+
+type pacStmt interface {
+	exec(env *pacEnv) (result interface{}, returned bool, err error)
+}
+
+func pacExecBlock(stmts []pacStmt, env *pacEnv) (interface{}, bool, error) {
+	for _, stmt := range stmts {
+		if result, returned, err := stmt.exec(env); err != nil || returned {
+			return result, returned, err
+		}
+	}
+	return nil, false, nil
+}
+
+type pacDeclStmt struct {
+	names  []string
+	values []pacExpr
+}
+
+func (s *pacDeclStmt) exec(env *pacEnv) (interface{}, bool, error) {
+	for i, name := range s.names {
+		v, err := s.values[i].eval(env)
+		if err != nil {
+			return nil, false, err
+		}
+		env.vars[name] = v
+	}
+	return nil, false, nil
+}
+
+type pacIfStmt struct {
+	cond            pacExpr
+	then, otherwise pacStmt
+}
+
+func (s *pacIfStmt) exec(env *pacEnv) (interface{}, bool, error) {
+	cond, err := s.cond.eval(env)
+	if err != nil {
+		return nil, false, err
+	}
+	if pacTruthy(cond) {
+		return s.then.exec(env)
+	}
+	if s.otherwise != nil {
+		return s.otherwise.exec(env)
+	}
+	return nil, false, nil
+}
+
+type pacReturnStmt struct {
+	value pacExpr
+}
+
+func (s *pacReturnStmt) exec(env *pacEnv) (interface{}, bool, error) {
+	v, err := s.value.eval(env)
+	return v, true, err
+}
+
+type pacBlockStmt struct {
+	stmts []pacStmt
+}
+
+func (s *pacBlockStmt) exec(env *pacEnv) (interface{}, bool, error) {
+	return pacExecBlock(s.stmts, env)
+}
+
+type pacExprStmt struct {
+	expr pacExpr
+}
+
+func (s *pacExprStmt) exec(env *pacEnv) (interface{}, bool, error) {
+	_, err := s.expr.eval(env)
+	return nil, false, err
+}
+
+type pacExpr interface {
+	eval(env *pacEnv) (interface{}, error)
+}
+
+type pacLiteral struct {
+	value interface{}
+}
+
+func (e *pacLiteral) eval(env *pacEnv) (interface{}, error) {
+	return e.value, nil
+}
+
+type pacIdentExpr struct {
+	name string
+}
+
+func (e *pacIdentExpr) eval(env *pacEnv) (interface{}, error) {
+	v, ok := env.lookup(e.name)
+	if !ok {
+		return nil, fmt.Errorf("%s is not defined", e.name)
+	}
+	return v, nil
+}
+
+type pacAssignExpr struct {
+	name  string
+	value pacExpr
+}
+
+func (e *pacAssignExpr) eval(env *pacEnv) (interface{}, error) {
+	v, err := e.value.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	env.assign(e.name, v)
+	return v, nil
+}
+
+type pacFunctionExpr struct {
+	params []string
+	body   []pacStmt
+}
+
+func (e *pacFunctionExpr) eval(env *pacEnv) (interface{}, error) {
+	return &pacFunction{params: e.params, body: e.body, closure: env}, nil
+}
+
+type pacTernaryExpr struct {
+	cond, then, otherwise pacExpr
+}
+
+func (e *pacTernaryExpr) eval(env *pacEnv) (interface{}, error) {
+	cond, err := e.cond.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if pacTruthy(cond) {
+		return e.then.eval(env)
+	}
+	return e.otherwise.eval(env)
+}
+
+type pacUnaryExpr struct {
+	op      string
+	operand pacExpr
+}
+
+func (e *pacUnaryExpr) eval(env *pacEnv) (interface{}, error) {
+	v, err := e.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if e.op == "!" {
+		return !pacTruthy(v), nil
+	}
+	return -pacToNumber(v), nil
+}
+
+type pacBinaryExpr struct {
+	op          string
+	left, right pacExpr
+}
+
+func (e *pacBinaryExpr) eval(env *pacEnv) (interface{}, error) {
+	left, err := e.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	// && and || short-circuit and yield an operand, as in JavaScript
+	switch e.op {
+	case "&&":
+		if !pacTruthy(left) {
+			return left, nil
+		}
+		return e.right.eval(env)
+	case "||":
+		if pacTruthy(left) {
+			return left, nil
+		}
+		return e.right.eval(env)
+	}
+	right, err := e.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "==":
+		return pacLooseEqual(left, right), nil
+	case "!=":
+		return !pacLooseEqual(left, right), nil
+	case "===":
+		return pacStrictEqual(left, right), nil
+	case "!==":
+		return !pacStrictEqual(left, right), nil
+	case "+":
+		_, ls := left.(string)
+		_, rs := right.(string)
+		if ls || rs {
+			return pacToString(left) + pacToString(right), nil
+		}
+		return pacToNumber(left) + pacToNumber(right), nil
+	case "<", ">", "<=", ">=":
+		ls, lok := left.(string)
+		rs, rok := right.(string)
+		var cmp int
+		if lok && rok {
+			cmp = strings.Compare(ls, rs)
+		} else {
+			l, r := pacToNumber(left), pacToNumber(right)
+			if math.IsNaN(l) || math.IsNaN(r) {
+				return false, nil
+			}
+			switch {
+			case l < r:
+				cmp = -1
+			case l > r:
+				cmp = 1
+			}
+		}
+		switch e.op {
+		case "<":
+			return cmp < 0, nil
+		case ">":
+			return cmp > 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		}
+		return cmp >= 0, nil
+	}
+	l, r := pacToNumber(left), pacToNumber(right)
+	switch e.op {
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		return l / r, nil
+	}
+	return math.Mod(l, r), nil
+}
+
+type pacMemberExpr struct {
+	object pacExpr
+	name   string
+}
+
+func (e *pacMemberExpr) eval(env *pacEnv) (interface{}, error) {
+	object, err := e.object.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := object.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot read %s of %s", e.name, pacToString(object))
+	}
+	if e.name == "length" {
+		return float64(len(s)), nil
+	}
+	method, ok := pacStringMethods[e.name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported string method %s", e.name)
+	}
+	return pacBuiltin(func(args []interface{}) (interface{}, error) {
+		return method(s, args), nil
+	}), nil
+}
+
+type pacCallExpr struct {
+	callee pacExpr
+	args   []pacExpr
+}
+
+func (e *pacCallExpr) eval(env *pacEnv) (interface{}, error) {
+	callee, err := e.callee.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]interface{}, len(e.args))
+	for i, arg := range e.args {
+		if args[i], err = arg.eval(env); err != nil {
+			return nil, err
+		}
+	}
+	return pacCall(callee, args, env.depth)
+}
+
+func pacTruthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	}
+	return true
+}
+
+func pacToString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "undefined"
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return "function"
+}
+
+func pacToNumber(v interface{}) float64 {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return 0
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return f
+	case float64:
+		return v
+	}
+	return math.NaN()
+}
+
+func pacStrictEqual(a, b interface{}) bool {
+	switch a.(type) {
+	case *pacFunction, pacBuiltin:
+		return false
+	}
+	switch b.(type) {
+	case *pacFunction, pacBuiltin:
+		return false
+	}
+	return a == b
+}
+
+func pacLooseEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	_, as := a.(string)
+	_, bs := b.(string)
+	if as && bs {
+		return a == b
+	}
+	if _, ok := a.(*pacFunction); ok {
+		return false
+	}
+	if _, ok := b.(*pacFunction); ok {
+		return false
+	}
+	return pacToNumber(a) == pacToNumber(b)
+}
+
+func pacArg(args []interface{}, i int) string {
+	if i < len(args) {
+		return pacToString(args[i])
+	}
+	return "undefined"
+}
+
+var pacStringMethods = map[string]func(s string, args []interface{}) interface{}{
+	"toLowerCase": func(s string, _ []interface{}) interface{} { return strings.ToLower(s) },
+	"toUpperCase": func(s string, _ []interface{}) interface{} { return strings.ToUpper(s) },
+	"indexOf": func(s string, args []interface{}) interface{} {
+		return float64(strings.Index(s, pacArg(args, 0)))
+	},
+	"lastIndexOf": func(s string, args []interface{}) interface{} {
+		return float64(strings.LastIndex(s, pacArg(args, 0)))
+	},
+	"startsWith": func(s string, args []interface{}) interface{} { return strings.HasPrefix(s, pacArg(args, 0)) },
+	"endsWith":   func(s string, args []interface{}) interface{} { return strings.HasSuffix(s, pacArg(args, 0)) },
+	"charAt": func(s string, args []interface{}) interface{} {
+		i := pacIndex(args, 0, 0, len(s))
+		if i >= len(s) {
+			return ""
+		}
+		return s[i : i+1]
+	},
+	"substring": func(s string, args []interface{}) interface{} {
+		start, end := pacIndex(args, 0, 0, len(s)), pacIndex(args, 1, len(s), len(s))
+		if start > end {
+			start, end = end, start
+		}
+		return s[start:end]
+	},
+	"substr": func(s string, args []interface{}) interface{} {
+		start := pacIndex(args, 0, 0, len(s))
+		end := len(s)
+		if len(args) > 1 {
+			end = start + pacIndex(args, 1, 0, len(s)-start)
+		}
+		return s[start:end]
+	},
+}
+
+// pacIndex returns args[i] as an index clamped to [0, max], or def if absent.
+func pacIndex(args []interface{}, i, def, max int) int {
+	if i >= len(args) {
+		return def
+	}
+	f := pacToNumber(args[i])
+	switch {
+	case math.IsNaN(f) || f < 0:
+		return 0
+	case f > float64(max):
+		return max
+	}
+	return int(f)
+}
+
+// builtins returns the functions browsers provide to PAC scripts.
+func (pac *PAC) builtins() map[string]interface{} {
+	resolve := func(host string) net.IP {
+		if ip := net.ParseIP(host); ip != nil {
+			return ip
+		}
+		ctx := pac.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		addrs, err := pac.lookup(ctx, host)
+		if err != nil {
+			return nil
+		}
+		for _, addr := range addrs {
+			if ip4 := net.ParseIP(addr).To4(); ip4 != nil {
+				return ip4
+			}
+		}
+		return nil
+	}
+	builtins := map[string]pacBuiltin{
+		"isPlainHostName": func(args []interface{}) (interface{}, error) {
+			return !strings.Contains(pacArg(args, 0), "."), nil
+		},
+		"dnsDomainIs": func(args []interface{}) (interface{}, error) {
+			return strings.HasSuffix(strings.ToLower(pacArg(args, 0)), strings.ToLower(pacArg(args, 1))), nil
+		},
+		"localHostOrDomainIs": func(args []interface{}) (interface{}, error) {
+			host, hostdom := strings.ToLower(pacArg(args, 0)), strings.ToLower(pacArg(args, 1))
+			return host == hostdom || !strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+"."), nil
+		},
+		"isResolvable": func(args []interface{}) (interface{}, error) {
+			return resolve(pacArg(args, 0)) != nil, nil
+		},
+		"dnsResolve": func(args []interface{}) (interface{}, error) {
+			if ip := resolve(pacArg(args, 0)); ip != nil {
+				return ip.String(), nil
+			}
+			return nil, nil
+		},
+		"myIpAddress": func(args []interface{}) (interface{}, error) {
+			// connecting a UDP socket sends nothing but picks the source
+			// address of the default route
+			conn, err := net.Dial("udp4", "192.0.2.1:53")
+			if err != nil {
+				return "127.0.0.1", nil
+			}
+			defer conn.Close()
+			return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+		},
+		"isInNet": func(args []interface{}) (interface{}, error) {
+			ip := resolve(pacArg(args, 0))
+			pattern, mask := net.ParseIP(pacArg(args, 1)).To4(), net.ParseIP(pacArg(args, 2)).To4()
+			if ip == nil || ip.To4() == nil || pattern == nil || mask == nil {
+				return false, nil
+			}
+			m := net.IPMask(mask)
+			return ip.To4().Mask(m).Equal(pattern.Mask(m)), nil
+		},
+		"dnsDomainLevels": func(args []interface{}) (interface{}, error) {
+			return float64(strings.Count(pacArg(args, 0), ".")), nil
+		},
+		"shExpMatch": func(args []interface{}) (interface{}, error) {
+			pattern := regexp.QuoteMeta(pacArg(args, 1))
+			pattern = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(pattern)
+			return regexp.MustCompile("^(?s:" + pattern + ")$").MatchString(pacArg(args, 0)), nil
+		},
+		"convert_addr": func(args []interface{}) (interface{}, error) {
+			ip := net.ParseIP(pacArg(args, 0)).To4()
+			if ip == nil {
+				return float64(0), nil
+			}
+			return float64(uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])), nil
+		},
+		"alert": func(args []interface{}) (interface{}, error) {
+			return nil, nil
+		},
+		"weekdayRange": func(args []interface{}) (interface{}, error) {
+			args, now := pac.clock(args)
+			return pacWeekdayRange(args, now)
+		},
+		"dateRange": func(args []interface{}) (interface{}, error) {
+			args, now := pac.clock(args)
+			return pacDateRange(args, now)
+		},
+		"timeRange": func(args []interface{}) (interface{}, error) {
+			args, now := pac.clock(args)
+			return pacTimeRange(args, now)
+		},
+	}
+	vars := map[string]interface{}{}
+	for name, fn := range builtins {
+		vars[name] = fn
+	}
+	return vars
+}
+
+// clock returns the current time for the time-based functions, in UTC if
+// the last of args is "GMT", and args without it.
+func (pac *PAC) clock(args []interface{}) ([]interface{}, time.Time) {
+	now := pac.now()
+	if len(args) > 0 && args[len(args)-1] == "GMT" {
+		return args[:len(args)-1], now.UTC()
+	}
+	return args, now.Local()
+}
+
+var pacWeekdays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+var pacMonths = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+
+// pacName returns the index of v in names, or -1.
+func pacName(names []string, v interface{}) int {
+	for i, name := range names {
+		if v == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// pacInRange reports whether v lies within [start, end], which wraps around
+// when start is after end, as in weekdayRange("FRI", "MON").
+func pacInRange(v, start, end int) bool {
+	if start <= end {
+		return start <= v && v <= end
+	}
+	return v >= start || v <= end
+}
+
+// pacWeekdayRange implements weekdayRange(wd1[, wd2]).
+func pacWeekdayRange(args []interface{}, now time.Time) (interface{}, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, errors.New("weekdayRange: bad number of arguments")
+	}
+	start, end := pacName(pacWeekdays, args[0]), pacName(pacWeekdays, args[len(args)-1])
+	if start < 0 || end < 0 {
+		return false, nil
+	}
+	return pacInRange(int(now.Weekday()), start, end), nil
+}
+
+// pacDateRange implements the forms of dateRange: a day, month or year, or a
+// range of them, of day and month, of month and year or of full dates. A
+// number up to 31 is a day, a larger one a year.
+func pacDateRange(args []interface{}, now time.Time) (interface{}, error) {
+	if len(args) < 1 || len(args) > 6 || len(args) != 1 && len(args)%2 != 0 {
+		return nil, errors.New("dateRange: bad number of arguments")
+	}
+	// both ends and today as year*10000 + month*100 + day, counting only
+	// the fields the ends name
+	var bounds [2]int
+	var hasYear, hasMonth, hasDay bool
+	half := (len(args) + 1) / 2
+	for i, arg := range args {
+		end := 0
+		if i >= half {
+			end = 1
+		}
+		if month := pacName(pacMonths, arg); month >= 0 {
+			bounds[end] += (month + 1) * 100
+			hasMonth = true
+			continue
+		}
+		n, ok := arg.(float64)
+		switch {
+		case !ok || n < 1 || n != math.Trunc(n):
+			return false, nil
+		case n <= 31:
+			bounds[end] += int(n)
+			hasDay = true
+		default:
+			bounds[end] += int(n) * 10000
+			hasYear = true
+		}
+	}
+	if len(args) == 1 {
+		bounds[1] = bounds[0]
+	}
+	today := 0
+	if hasYear {
+		today += now.Year() * 10000
+	}
+	if hasMonth {
+		today += int(now.Month()) * 100
+	}
+	if hasDay {
+		today += now.Day()
+	}
+	return pacInRange(today, bounds[0], bounds[1]), nil
+}
+
+// pacTimeRange implements timeRange(hour1[, hour2]), with minutes and with
+// seconds. An end without seconds or minutes takes in the whole minute or
+// hour, so timeRange(9, 17) lasts until 17:59:59.
+func pacTimeRange(args []interface{}, now time.Time) (interface{}, error) {
+	var fields []int
+	for _, arg := range args {
+		n, ok := arg.(float64)
+		if !ok || n < 0 || n != math.Trunc(n) {
+			return false, nil
+		}
+		fields = append(fields, int(n))
+	}
+	var start, end int
+	switch len(fields) {
+	case 1:
+		start, end = fields[0]*3600, fields[0]*3600+3599
+	case 2:
+		start, end = fields[0]*3600, fields[1]*3600+3599
+	case 4:
+		start, end = fields[0]*3600+fields[1]*60, fields[2]*3600+fields[3]*60+59
+	case 6:
+		start, end = fields[0]*3600+fields[1]*60+fields[2], fields[3]*3600+fields[4]*60+fields[5]
+	default:
+		return nil, errors.New("timeRange: bad number of arguments")
+	}
+	seconds := now.Hour()*3600 + now.Minute()*60 + now.Second()
+	return pacInRange(seconds, start, end), nil
+}
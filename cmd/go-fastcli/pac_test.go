@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// findProxy runs FindProxyForURL of src for target.
+func findProxy(t *testing.T, src, target string) (string, error) {
+	t.Helper()
+	pac, err := ParsePAC(context.Background(), src, nil)
+	if err != nil {
+		t.Fatalf("ParsePAC: %v", err)
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pac.FindProxy(context.Background(), u)
+}
+
+func TestPACEvaluation(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"plain host", `return isPlainHostName(host) ? "DIRECT" : "PROXY p:1";`, "PROXY p:1"},
+		{"domain", `if (dnsDomainIs(host, ".example.com")) return "PROXY a:1"; return "DIRECT";`, "PROXY a:1"},
+		{"shExpMatch", `if (shExpMatch(url, "https://*.example.com/")) return "HTTPS s:443"; return "DIRECT";`, "HTTPS s:443"},
+		{"isInNet on an address", `return isInNet("10.1.2.3", "10.0.0.0", "255.0.0.0") ? "YES" : "NO";`, "YES"},
+		{"string methods", `return host.substring(0, host.indexOf(".")).toUpperCase();`, "WWW"},
+		{"arithmetic", `var n = 1 + 2 * 3; n += 1; return "n" + n;`, "n8"},
+		{"helper", `function pick(h) { return h == "www.example.com" ? "PROXY h:2" : "DIRECT"; } return pick(host);`, "PROXY h:2"},
+		{"levels", `return "levels " + dnsDomainLevels(host);`, "levels 2"},
+		{"convert_addr", `return "" + convert_addr("1.0.0.2");`, "16777218"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := "function FindProxyForURL(url, host) {" + test.body + "}"
+			got, err := findProxy(t, src, "https://www.example.com/path?q=1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPACSyntaxErrors(t *testing.T) {
+	for _, src := range []string{
+		`function FindProxyForURL(url, host) { return host @ 1; }`,
+		`function FindProxyForURL(url, host) { return "DIRECT"`,
+		`var x = "unterminated;`,
+		`var x = 1;`,
+	} {
+		if _, err := ParsePAC(context.Background(), src, nil); err == nil {
+			t.Errorf("ParsePAC(%q) succeeded, want an error", src)
+		}
+	}
+}
+
+func TestPACRecursion(t *testing.T) {
+	src := `function f(n) { return f(n + 1); }
+function FindProxyForURL(url, host) { return f(0); }`
+	_, err := findProxy(t, src, "http://example.com/")
+	if err == nil || !strings.Contains(err.Error(), "too much recursion") {
+		t.Fatalf("got %v, want too much recursion", err)
+	}
+
+	// recursion that ends within the limit is fine
+	src = `function count(n) { return n == 0 ? "DIRECT" : count(n - 1); }
+function FindProxyForURL(url, host) { return count(150); }`
+	got, err := findProxy(t, src, "http://example.com/")
+	if err != nil || got != "DIRECT" {
+		t.Fatalf("got %q, %v, want DIRECT", got, err)
+	}
+}
+
+func TestPACResolve(t *testing.T) {
+	src := `function FindProxyForURL(url, host) {
+	if (!isResolvable(host)) return "NONE";
+	return isInNet(host, "192.168.0.0", "255.255.0.0") ? "PROXY " + dnsResolve(host) + ":3128" : "DIRECT";
+}`
+	var looked []string
+	lookup := func(ctx context.Context, host string) ([]string, error) {
+		looked = append(looked, host)
+		switch host {
+		case "intranet":
+			return []string{"fe80::1", "192.168.1.5"}, nil
+		case "outside":
+			return []string{"203.0.113.1"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	pac, err := ParsePAC(context.Background(), src, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, want := range map[string]string{
+		"intranet": "PROXY 192.168.1.5:3128",
+		"outside":  "DIRECT",
+		"missing":  "NONE",
+	} {
+		got, err := pac.FindProxy(context.Background(), &url.URL{Scheme: "http", Host: host})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", host, got, want)
+		}
+	}
+	if len(looked) == 0 {
+		t.Error("the lookup function was not used")
+	}
+}
+
+func TestPACResolveCancelled(t *testing.T) {
+	src := `function FindProxyForURL(url, host) { return dnsResolve(host) == null ? "DIRECT" : "PROXY p:1"; }`
+	// a lookup that only ends with its context, like one against an
+	// unreachable resolver
+	lookup := func(ctx context.Context, host string) ([]string, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	pac, err := ParsePAC(context.Background(), src, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan string, 1)
+	go func() {
+		got, _ := pac.FindProxy(ctx, &url.URL{Scheme: "http", Host: "slow.example"})
+		done <- got
+	}()
+	select {
+	case got := <-done:
+		if got != "DIRECT" {
+			t.Errorf("got %q, want DIRECT", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("FindProxy did not return when its context ended")
+	}
+}
+
+func TestPACTimeFunctions(t *testing.T) {
+	// Wednesday, 2024-06-12 14:30:15 UTC
+	now := time.Date(2024, time.June, 12, 14, 30, 15, 0, time.UTC)
+	tests := []struct {
+		call string
+		want bool
+	}{
+		{`weekdayRange("WED", "GMT")`, true},
+		{`weekdayRange("MON", "FRI", "GMT")`, true},
+		{`weekdayRange("FRI", "MON", "GMT")`, false},
+		{`weekdayRange("SAT", "WED", "GMT")`, true},
+		{`weekdayRange("XYZ", "GMT")`, false},
+		{`dateRange(12, "GMT")`, true},
+		{`dateRange("JUN", "GMT")`, true},
+		{`dateRange(2023, "GMT")`, false},
+		{`dateRange(1, 15, "GMT")`, true},
+		{`dateRange(20, 5, "GMT")`, false},
+		{`dateRange("NOV", "FEB", "GMT")`, false},
+		{`dateRange("MAY", "JUL", "GMT")`, true},
+		{`dateRange(2020, 2030, "GMT")`, true},
+		{`dateRange(1, "JUN", 11, "JUN", "GMT")`, false},
+		{`dateRange(1, "JUN", 12, "JUN", "GMT")`, true},
+		{`dateRange("DEC", 2023, "JUL", 2024, "GMT")`, true},
+		{`dateRange(1, "JAN", 2025, 1, "FEB", 2025, "GMT")`, false},
+		{`timeRange(14, "GMT")`, true},
+		{`timeRange(9, 14, "GMT")`, true},
+		{`timeRange(22, 6, "GMT")`, false},
+		{`timeRange(14, 0, 14, 30, "GMT")`, true},
+		{`timeRange(14, 31, 15, 0, "GMT")`, false},
+		{`timeRange(14, 30, 16, 14, 31, 0, "GMT")`, false},
+		{`timeRange(14, 30, 15, 14, 30, 15, "GMT")`, true},
+	}
+	for _, test := range tests {
+		src := `function FindProxyForURL(url, host) { return ` + test.call + ` ? "YES" : "NO"; }`
+		pac, err := ParsePAC(context.Background(), src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pac.now = func() time.Time { return now }
+		got, err := pac.FindProxy(context.Background(), &url.URL{Scheme: "http", Host: "example.com"})
+		if err != nil {
+			t.Errorf("%s: %v", test.call, err)
+			continue
+		}
+		if want := map[bool]string{true: "YES", false: "NO"}[test.want]; got != want {
+			t.Errorf("%s: got %s, want %s", test.call, got, want)
+		}
+	}
+
+	for _, call := range []string{`timeRange(1, 2, 3)`, `dateRange(1, 2, 3)`, `weekdayRange()`} {
+		src := `function FindProxyForURL(url, host) { return ` + call + ` ? "YES" : "NO"; }`
+		if _, err := findProxy(t, src, "http://example.com/"); err == nil {
+			t.Errorf("%s succeeded, want an error", call)
+		}
+	}
+}
+
+func TestParsePACResult(t *testing.T) {
+	tests := []struct {
+		result, want string
+	}{
+		{"DIRECT", ""},
+		{"PROXY p:8080; DIRECT", "http://p:8080"},
+		{"FOO x; SOCKS s:1080", "socks5://s:1080"},
+		{" HTTPS h:443 ", "https://h:443"},
+	}
+	for _, test := range tests {
+		u, err := parsePACResult(test.result)
+		if err != nil {
+			t.Errorf("%q: %v", test.result, err)
+			continue
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.result, got, test.want)
+		}
+	}
+	for _, result := range []string{"PROXY", "FOO bar", ""} {
+		if _, err := parsePACResult(result); err == nil {
+			t.Errorf("%q: got no error", result)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// SendNtfy publishes n to the ntfy topic at topicURL, e.g.
+// https://ntfy.sh/mytopic, with a high priority if it reports a breach.
+// token, if set, is an ntfy access token.
+func SendNtfy(ctx context.Context, topicURL, token string, n Notification) error {
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("Title", n.Title)
+	switch {
+	case n.Breached:
+		header.Set("Priority", "high")
+		header.Set("Tags", "warning")
+	case n.Recovered:
+		header.Set("Tags", "white_check_mark")
+	}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return postWithRetry(ctx, topicURL, header, []byte(n.Body))
+}
+
+// Gotify priorities: 8 and above make a sound on Android, 4 to 7 notify
+// silently.
+const (
+	gotifyPriorityBreached = 8
+	gotifyPriorityNormal   = 5
+)
+
+// SendGotify sends n to the Gotify server at serverURL with the application
+// token.
+func SendGotify(ctx context.Context, serverURL, token string, n Notification) error {
+	priority := gotifyPriorityNormal
+	if n.Breached {
+		priority = gotifyPriorityBreached
+	}
+	body, err := json.Marshal(map[string]interface{}{"title": n.Title, "message": n.Body, "priority": priority})
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("X-Gotify-Key", token)
+	return postWithRetry(ctx, strings.TrimSuffix(serverURL, "/")+"/message", header, body)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultPushgatewayJob = "go-fastcli"
+
+// PushMetrics replaces the metrics of the job/instance group on a Prometheus
+// Pushgateway with those of one run, the same as serve -listen exports. A
+// failed run only pushes fastcli_last_run_failed, so the results of an
+// earlier run don't linger. An empty instance is the host name.
+func PushMetrics(ctx context.Context, gatewayURL, job, instance string, result Result, runErr error) error {
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	now := time.Now()
+	status := DaemonStatus{LastRun: &now}
+	if runErr != nil {
+		status.LastError = runErr.Error()
+	} else {
+		status.LastResult = &result
+	}
+	var body bytes.Buffer
+	WriteResultMetrics(&body, status)
+
+	u := strings.TrimSuffix(gatewayURL, "/") + "/metrics" + pushgatewayLabel("job", job) + pushgatewayLabel("instance", instance)
+	req, err := http.NewRequestWithContext(ctx, "PUT", u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	resp, err := sinkClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.New("pushgateway returned " + resp.Status + ": " + strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// pushgatewayLabel encodes a grouping label as a path segment. Values that
+// can't be path segments as they are use the Pushgateway's base64 form.
+func pushgatewayLabel(name, value string) string {
+	if value == "" || strings.Contains(value, "/") {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(value))
+		if encoded == "" {
+			encoded = "="
+		}
+		return "/" + name + "@base64/" + encoded
+	}
+	return "/" + name + "/" + url.PathEscape(value)
+}
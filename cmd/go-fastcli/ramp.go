@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RampProfile is how a transfer phase built up to its speed, from its
+// per-second samples: the second in which the rate first reached 50%, 90%
+// and 100% of the phase's speed, 0 if it never did, and its fastest second.
+// A 100% reached late points at a slow start; a peak well above the speed
+// early on, at a boost that was then throttled.
+type RampProfile struct {
+	To50Sec  int `json:",omitempty"`
+	To90Sec  int `json:",omitempty"`
+	To100Sec int `json:",omitempty"`
+	PeakSec  int
+	PeakMbps float64
+}
+
+// rampBoostRatio is how far above the phase's speed its fastest second has
+// to be to call out as a boost.
+const rampBoostRatio = 1.2
+
+// NewRampProfile profiles samples of a phase whose speed was mbps. It
+// returns nil without either.
+func NewRampProfile(samples []Sample, mbps float64) *RampProfile {
+	if len(samples) == 0 || mbps <= 0 {
+		return nil
+	}
+	r := &RampProfile{}
+	for _, sample := range samples {
+		rate := float64(sample.Bytes) / 125000
+		for _, level := range []struct {
+			share float64
+			sec   *int
+		}{{0.5, &r.To50Sec}, {0.9, &r.To90Sec}, {1, &r.To100Sec}} {
+			if *level.sec == 0 && rate >= mbps*level.share {
+				*level.sec = sample.Second
+			}
+		}
+		if rate > r.PeakMbps {
+			r.PeakSec, r.PeakMbps = sample.Second, rate
+		}
+	}
+	return r
+}
+
+// String describes the profile of a phase whose speed was mbps.
+func (r RampProfile) String(mbps float64) string {
+	var parts []string
+	for _, level := range []struct {
+		name string
+		sec  int
+	}{{"50%", r.To50Sec}, {"90%", r.To90Sec}, {"100%", r.To100Sec}} {
+		if level.sec == 0 {
+			parts = append(parts, level.name+" never")
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s within %d s", level.name, level.sec))
+	}
+	s := strings.Join(parts, ", ") + fmt.Sprintf("; peak %0.1f Mbit/s in second %d", r.PeakMbps, r.PeakSec)
+	if r.PeakMbps > mbps*rampBoostRatio {
+		s += fmt.Sprintf(", %0.0f%% above the speed", (r.PeakMbps/mbps-1)*100)
+	}
+	return s
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// rateSearchSteps halvings narrow the sustainable rate down to 1/64 of the
+// capacity.
+const rateSearchSteps = 6
+
+// A paced transfer only counts as sustained if it achieved this share of
+// its rate.
+const rateDeliveredRatio = 0.9
+
+// RateStep is one paced transfer of a rate search.
+type RateStep struct {
+	// TargetMbps is 0 for the unpaced transfer at full speed.
+	TargetMbps   float64
+	AchievedMbps float64
+	// LatencyMs is the median latency during the transfer.
+	LatencyMs float64
+	OK        bool
+}
+
+// RateSearchResult is the highest rate in one direction at which latency
+// stays within the allowed increase over the idle latency.
+type RateSearchResult struct {
+	Direction     string
+	CapacityMbps  float64
+	IdleLatencyMs float64
+	// SustainableMbps is 0 if even the lowest rate tried added too much
+	// latency.
+	SustainableMbps float64
+	LoadedLatencyMs float64
+	Steps           []RateStep
+}
+
+// RunRateSearch measures capacity and idle latency with a regular test, then
+// binary searches each direction for the highest paced rate whose median
+// latency stays within maxIncrease of the idle latency, holding each rate
+// for step. This is the rate to shape to with SQM such as CAKE.
+func RunRateSearch(ctx context.Context, cfg Config, maxIncrease, step time.Duration, w io.Writer) ([]RateSearchResult, error) {
+	saved := out.Progress
+	out.Progress = io.Discard
+	defer func() { out.Progress = saved }()
+
+	fmt.Fprintln(w, "Measuring capacity and idle latency...")
+	result, err := RunTest(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	server := result.Servers[0]
+	idle, err := fastcom.CalcPercentile(server.LatencySamplesMs, 50)
+	if err != nil {
+		idle = server.LatencyMs
+	}
+	maxIncreaseMs := float64(maxIncrease) / float64(time.Millisecond)
+
+	var results []RateSearchResult
+	for _, direction := range []struct {
+		name     string
+		upload   bool
+		capacity float64
+	}{
+		{"download", false, server.DownloadMbps},
+		{"upload", true, server.UploadMbps},
+	} {
+		if direction.capacity == 0 {
+			continue
+		}
+		r := RateSearchResult{Direction: direction.name, CapacityMbps: direction.capacity, IdleLatencyMs: idle}
+		probe := func(mbps float64) (bool, error) {
+			loaded, err := client.MeasureLoadedLatency(ctx, server.URL, direction.upload, mbps, step)
+			if err != nil {
+				return false, err
+			}
+			millis := make([]float64, len(loaded.Latency.Samples))
+			for i, sample := range loaded.Latency.Samples {
+				millis[i] = float64(sample) / float64(time.Millisecond)
+			}
+			median, _ := fastcom.CalcPercentile(millis, 50)
+			s := RateStep{TargetMbps: mbps, AchievedMbps: loaded.Mbps, LatencyMs: median}
+			s.OK = median-idle <= maxIncreaseMs && loaded.Mbps >= mbps*rateDeliveredRatio
+			r.Steps = append(r.Steps, s)
+			verdict := "too much delay"
+			if s.OK {
+				verdict = "ok"
+				r.SustainableMbps, r.LoadedLatencyMs = mbps, median
+				if mbps == 0 {
+					r.SustainableMbps = loaded.Mbps
+				}
+			} else if loaded.Mbps < mbps*rateDeliveredRatio {
+				verdict = "rate not reached"
+			}
+			target := "full speed"
+			if mbps > 0 {
+				target = fmt.Sprintf("%0.1f Mbit/s", mbps)
+			}
+			fmt.Fprintf(w, "  %s at %s: %0.1f Mbit/s, %0.1f ms (%+0.1f ms), %s\n",
+				direction.name, target, loaded.Mbps, median, median-idle, verdict)
+			return s.OK, nil
+		}
+
+		// at full speed, queues fill up wherever the link is slowest; if
+		// latency stays low even then, there's nothing to shape
+		fmt.Fprintf(w, "Searching the %s rate that keeps latency within +%v of %0.1f ms...\n", direction.name, maxIncrease, idle)
+		ok, err := probe(0)
+		if err != nil {
+			return results, err
+		}
+		if !ok {
+			lo, hi := 0.0, direction.capacity
+			for i := 0; i < rateSearchSteps; i++ {
+				mid := (lo + hi) / 2
+				ok, err := probe(mid)
+				if err != nil {
+					return results, err
+				}
+				if ok {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+		}
+		results = append(results, r)
+	}
+	if len(results) == 0 {
+		return nil, errors.New("no download or upload capacity to search")
+	}
+	return results, nil
+}
+
+// lowestRateTried returns the lowest paced rate of the search of r.
+func lowestRateTried(r RateSearchResult) float64 {
+	lowest := r.CapacityMbps
+	for _, s := range r.Steps {
+		if s.TargetMbps > 0 && s.TargetMbps < lowest {
+			lowest = s.TargetMbps
+		}
+	}
+	return lowest
+}
+
+func WriteRateReport(w io.Writer, results []RateSearchResult, maxIncrease time.Duration) {
+	for _, r := range results {
+		if r.SustainableMbps == 0 {
+			fmt.Fprintf(w, "%-9s latency rose by more than %v even at %0.1f Mbit/s of %0.1f Mbit/s capacity\n",
+				r.Direction+":", maxIncrease, lowestRateTried(r), r.CapacityMbps)
+			continue
+		}
+		fmt.Fprintf(w, "%-9s %0.1f Mbit/s (%0.0f%% of %0.1f Mbit/s capacity) keeps latency at %0.1f ms, %0.1f ms idle\n",
+			r.Direction+":", r.SustainableMbps, r.SustainableMbps/r.CapacityMbps*100, r.CapacityMbps, r.LoadedLatencyMs, r.IdleLatencyMs)
+	}
+}
+
+// WriteSQMSuggestion prints OpenWrt commands that configure SQM with CAKE
+// for ingressPct and egressPct percent of the sustainable download and
+// upload rates. A direction without a sustainable rate gets a warning
+// instead, as shaping it to any rate that was tried wouldn't keep latency
+// down. Ingress gets more headroom by default, as shaping after the
+// bottleneck only controls the queue once the link runs below its speed.
+func WriteSQMSuggestion(w io.Writer, results []RateSearchResult, ingressPct, egressPct float64) {
+	fmt.Fprintln(w, "# Suggested SQM settings for OpenWrt (rates in kbit/s):")
+	suggested := false
+	for _, r := range results {
+		rate, pct, option := r.SustainableMbps, egressPct, "upload"
+		if r.Direction == "download" {
+			pct, option = ingressPct, "download"
+		}
+		if rate == 0 {
+			fmt.Fprintf(w, "# Warning: no %s setting, as latency rose too much even at %0.1f Mbit/s,\n", option, lowestRateTried(r))
+			fmt.Fprintln(w, "# the lowest rate tried, so shaping to any rate tried wouldn't keep it down")
+			continue
+		}
+		if rate > r.CapacityMbps {
+			rate = r.CapacityMbps
+		}
+		fmt.Fprintf(w, "uci set sqm.@queue[0].%s='%0.0f'  # %0.0f%% of %0.1f Mbit/s\n", option, rate*pct/100*1000, pct, rate)
+		suggested = true
+	}
+	if !suggested {
+		return
+	}
+	fmt.Fprintln(w, "uci set sqm.@queue[0].qdisc='cake'")
+	fmt.Fprintln(w, "uci set sqm.@queue[0].script='piece_of_cake.qos'")
+	fmt.Fprintln(w, "uci set sqm.@queue[0].enabled='1'")
+	fmt.Fprintln(w, "uci commit sqm && /etc/init.d/sqm restart")
+}
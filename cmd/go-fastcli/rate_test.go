@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteSQMSuggestion(t *testing.T) {
+	download := RateSearchResult{Direction: "download", CapacityMbps: 100, SustainableMbps: 80}
+	// latency rose at full speed and at every rate of the search, down to 3.1
+	upload := RateSearchResult{Direction: "upload", CapacityMbps: 20, Steps: []RateStep{
+		{TargetMbps: 0}, {TargetMbps: 10}, {TargetMbps: 5}, {TargetMbps: 2.5}, {TargetMbps: 3.1},
+	}}
+
+	var buf bytes.Buffer
+	WriteSQMSuggestion(&buf, []RateSearchResult{download, upload}, 90, 95)
+	got := buf.String()
+	for _, want := range []string{"sqm.@queue[0].download='72000'", "no upload setting", "even at 2.5 Mbit/s", "qdisc='cake'"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q, want it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, "sqm.@queue[0].upload=") {
+		t.Errorf("got %q, want no upload rate", got)
+	}
+
+	buf.Reset()
+	WriteSQMSuggestion(&buf, []RateSearchResult{upload}, 90, 95)
+	if got := buf.String(); strings.Contains(got, "uci ") {
+		t.Errorf("got %q, want no commands without a sustainable rate", got)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The remote-write payload is a protobuf WriteRequest compressed with snappy.
+// Both encodings are small enough to write by hand for the few messages we
+// send, which keeps the binary free of dependencies.
+
+// nativeHistogramSchema sets the resolution of exported histograms: bucket
+// boundaries grow by a factor of 2^(2^-schema), about 19% here.
+const nativeHistogramSchema = 2
+
+type protoBuf []byte
+
+func (b *protoBuf) varint(v uint64) {
+	*b = binary.AppendUvarint(*b, v)
+}
+
+func (b *protoBuf) tag(field, wireType int) {
+	b.varint(uint64(field<<3 | wireType))
+}
+
+func (b *protoBuf) uintField(field int, v uint64) {
+	b.tag(field, 0)
+	b.varint(v)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func (b *protoBuf) sintField(field int, v int64) {
+	b.tag(field, 0)
+	b.varint(zigzag(v))
+}
+
+func (b *protoBuf) doubleField(field int, v float64) {
+	b.tag(field, 1)
+	*b = binary.LittleEndian.AppendUint64(*b, math.Float64bits(v))
+}
+
+func (b *protoBuf) bytesField(field int, v []byte) {
+	b.tag(field, 2)
+	b.varint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *protoBuf) stringField(field int, v string) {
+	b.bytesField(field, []byte(v))
+}
+
+type promLabel struct{ Name, Value string }
+
+// promHistogram is a native histogram with positive buckets only.
+type promHistogram struct {
+	Count     uint64
+	Sum       float64
+	ZeroCount uint64
+	// Buckets maps bucket index to count; bucket i holds values in
+	// (2^((i-1)/2^schema), 2^(i/2^schema)].
+	Buckets   map[int]uint64
+	Timestamp int64
+}
+
+func newPromHistogram(values []float64, timestamp int64) promHistogram {
+	h := promHistogram{Buckets: map[int]uint64{}, Timestamp: timestamp}
+	for _, v := range values {
+		h.Count++
+		h.Sum += v
+		if v <= 0 {
+			h.ZeroCount++
+			continue
+		}
+		h.Buckets[int(math.Ceil(math.Log2(v)*(1<<nativeHistogramSchema)))]++
+	}
+	return h
+}
+
+func (h promHistogram) marshal() []byte {
+	var b protoBuf
+	b.uintField(1, h.Count)
+	b.doubleField(3, h.Sum)
+	b.sintField(4, nativeHistogramSchema)
+	b.doubleField(5, 0)
+	b.uintField(6, h.ZeroCount)
+
+	indexes := make([]int, 0, len(h.Buckets))
+	for i := range h.Buckets {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	// one span per run of consecutive buckets; offsets are the gap from
+	// the end of the previous span
+	var deltas protoBuf
+	var prevCount int64
+	for n := 0; n < len(indexes); {
+		end := n + 1
+		for end < len(indexes) && indexes[end] == indexes[end-1]+1 {
+			end++
+		}
+		offset := indexes[n]
+		if n > 0 {
+			offset = indexes[n] - indexes[n-1] - 1
+		}
+		var span protoBuf
+		span.sintField(1, int64(offset))
+		span.uintField(2, uint64(end-n))
+		b.bytesField(11, span)
+		for _, i := range indexes[n:end] {
+			count := int64(h.Buckets[i])
+			deltas.varint(zigzag(count - prevCount))
+			prevCount = count
+		}
+		n = end
+	}
+	if len(deltas) > 0 {
+		b.bytesField(12, deltas)
+	}
+	b.uintField(15, uint64(h.Timestamp))
+	return b
+}
+
+type promSeries struct {
+	Labels    []promLabel
+	Value     float64
+	Timestamp int64
+	Histogram *promHistogram
+}
+
+func (s promSeries) marshal() []byte {
+	var b protoBuf
+	labels := append([]promLabel(nil), s.Labels...)
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	for _, label := range labels {
+		var l protoBuf
+		l.stringField(1, label.Name)
+		l.stringField(2, label.Value)
+		b.bytesField(1, l)
+	}
+	if s.Histogram != nil {
+		b.bytesField(4, s.Histogram.marshal())
+		return b
+	}
+	var sample protoBuf
+	sample.doubleField(1, s.Value)
+	sample.uintField(2, uint64(s.Timestamp))
+	b.bytesField(2, sample)
+	return b
+}
+
+func marshalWriteRequest(series []promSeries) []byte {
+	var b protoBuf
+	for _, s := range series {
+		b.bytesField(1, s.marshal())
+	}
+	return b
+}
+
+// snappyLiteral encodes data as a snappy block made only of literals. It
+// doesn't compress, but every snappy decoder accepts it.
+func snappyLiteral(data []byte) []byte {
+	out := binary.AppendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > 1<<16 {
+			chunk = chunk[:1<<16]
+		}
+		n := len(chunk) - 1
+		switch {
+		case n < 60:
+			out = append(out, byte(n<<2))
+		case n < 1<<8:
+			out = append(out, 60<<2, byte(n))
+		default:
+			out = append(out, 61<<2, byte(n), byte(n>>8))
+		}
+		out = append(out, chunk...)
+		data = data[len(chunk):]
+	}
+	return out
+}
+
+// RemoteWriteSeries converts a result into remote-write series: the same
+// gauges as the /metrics exporter, plus the latency samples, the
+// -loaded-latency probes and per-second download and upload speeds of each
+// server as native histograms.
+func RemoteWriteSeries(result Result) []promSeries {
+	instance, _ := os.Hostname()
+	ts := result.Time.UnixMilli()
+	var series []promSeries
+	for _, server := range result.Servers {
+		labels := func(name string) []promLabel {
+			return []promLabel{{"__name__", name}, {"job", "go-fastcli"}, {"instance", instance}, {"server", server.Host}}
+		}
+		for _, gauge := range resultGauges {
+			if server.lacks(gauge.phase) {
+				continue
+			}
+			series = append(series, promSeries{Labels: labels(gauge.name), Value: gauge.value(server), Timestamp: ts})
+		}
+		if len(server.LatencySamplesMs) > 0 {
+			h := newPromHistogram(server.LatencySamplesMs, ts)
+			series = append(series, promSeries{Labels: labels("fastcli_latency_sample_ms"), Histogram: &h})
+		}
+		for _, phase := range []string{"download", "upload"} {
+			var mbps []float64
+			for _, sample := range result.Samples {
+				if sample.Phase == phase && sample.Host == server.Host {
+					mbps = append(mbps, float64(sample.Bytes)*8/1e6)
+				}
+			}
+			if len(mbps) == 0 {
+				continue
+			}
+			h := newPromHistogram(mbps, ts)
+			series = append(series, promSeries{Labels: labels("fastcli_" + phase + "_sample_mbps"), Histogram: &h})
+		}
+		for _, phase := range []string{"download", "upload"} {
+			if millis := loadedLatencySamples(result, server.Host, phase); len(millis) > 0 {
+				h := newPromHistogram(millis, ts)
+				series = append(series, promSeries{Labels: append(labels("fastcli_loaded_latency_ms"), promLabel{"phase", phase}), Histogram: &h})
+			}
+		}
+	}
+	return series
+}
+
+// RemoteWrite pushes series to a Prometheus remote-write endpoint such as
+// Mimir or VictoriaMetrics. Basic auth credentials may be given in the URL.
+func RemoteWrite(ctx context.Context, url string, series []promSeries) error {
+	body := snappyLiteral(marshalWriteRequest(series))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := sinkClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.New("remote write returned " + resp.Status + ": " + strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
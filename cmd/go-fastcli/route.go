@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	maxRouteHops    = 30
+	routeHopTimeout = time.Second
+	// tracePort is the first UDP port probed, as in classic traceroute.
+	tracePort = 33434
+)
+
+// RouteInfo is the network path to a server as the hops of a traceroute.
+type RouteInfo struct {
+	// Hops holds the address of each hop, or "*" for hops that didn't
+	// answer.
+	Hops []string
+	Hash string
+	// Changed is set when the hash differs from the previous run's.
+	Changed bool `json:",omitempty"`
+}
+
+// TraceRoute traces the path to host, which may include a port. pinned maps
+// host names to addresses like -resolve.
+func TraceRoute(ctx context.Context, host string, pinned map[string]string) (RouteInfo, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(pinned[host])
+	if ip == nil {
+		ip = net.ParseIP(host)
+	}
+	if ip == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return RouteInfo{}, err
+		}
+		ip = addrs[0].IP
+	}
+	hops, err := traceHops(ctx, ip, maxRouteHops)
+	if err != nil {
+		return RouteInfo{}, err
+	}
+	// a firewall in front of the destination often swallows the last
+	// probes; don't let how many of them were sent change the hash
+	for len(hops) > 0 && hops[len(hops)-1] == "*" {
+		hops = hops[:len(hops)-1]
+	}
+	sum := sha256.Sum256([]byte(strings.Join(hops, " ")))
+	return RouteInfo{Hops: hops, Hash: hex.EncodeToString(sum[:8])}, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+)
+
+// ICMP messages for our probes are read from the socket error queue
+// (IP_RECVERR), which, unlike raw sockets, needs no privileges.
+const (
+	eeOriginICMP  = 2
+	eeOriginICMP6 = 3
+)
+
+func traceHops(ctx context.Context, dst net.IP, maxHops int) ([]string, error) {
+	var hops []string
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hop, reached, err := probeHop(ctx, dst, ttl)
+		if err != nil {
+			return nil, err
+		}
+		hops = append(hops, hop)
+		if reached {
+			break
+		}
+	}
+	return hops, nil
+}
+
+// probeHop sends one UDP probe with the given TTL and returns the address of
+// the router that answered, and whether it was the destination itself.
+func probeHop(ctx context.Context, dst net.IP, ttl int) (string, bool, error) {
+	family, level, ttlOpt, errOpt := syscall.AF_INET, syscall.SOL_IP, syscall.IP_TTL, syscall.IP_RECVERR
+	var sa syscall.Sockaddr
+	if ip4 := dst.To4(); ip4 != nil {
+		sa4 := &syscall.SockaddrInet4{Port: tracePort + ttl}
+		copy(sa4.Addr[:], ip4)
+		sa = sa4
+	} else {
+		family, level, ttlOpt, errOpt = syscall.AF_INET6, syscall.SOL_IPV6, syscall.IPV6_UNICAST_HOPS, syscall.IPV6_RECVERR
+		sa6 := &syscall.SockaddrInet6{Port: tracePort + ttl}
+		copy(sa6.Addr[:], dst.To16())
+		sa = sa6
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return "", false, err
+	}
+	defer syscall.Close(fd)
+	if err := syscall.SetsockoptInt(fd, level, errOpt, 1); err != nil {
+		return "", false, err
+	}
+	if err := syscall.SetsockoptInt(fd, level, ttlOpt, ttl); err != nil {
+		return "", false, err
+	}
+	if err := syscall.Connect(fd, sa); err != nil {
+		return "", false, err
+	}
+	if _, err := syscall.Write(fd, []byte("go-fastcli")); err != nil {
+		return "", false, err
+	}
+
+	buf := make([]byte, 512)
+	oob := make([]byte, 512)
+	deadline := time.Now().Add(routeHopTimeout)
+	for time.Now().Before(deadline) {
+		_, oobn, _, _, err := syscall.Recvmsg(fd, buf, oob, syscall.MSG_ERRQUEUE)
+		if err == syscall.EAGAIN {
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return "", false, ctx.Err()
+			}
+			continue
+		}
+		if err != nil {
+			return "", false, err
+		}
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return "", false, err
+		}
+		for _, msg := range msgs {
+			if msg.Header.Level != int32(level) || msg.Header.Type != int32(errOpt) {
+				continue
+			}
+			if hop, reached, ok := parseExtendedErr(msg.Data); ok {
+				return hop, reached, nil
+			}
+		}
+	}
+	return "*", false, nil
+}
+
+// parseExtendedErr decodes a struct sock_extended_err followed by the
+// address of the router that sent the ICMP message, a sockaddr_in or
+// sockaddr_in6 depending on the origin.
+func parseExtendedErr(data []byte) (hop string, reached bool, ok bool) {
+	if len(data) < 16+8 {
+		return "", false, false
+	}
+	origin, icmpType := data[4], data[5]
+	offender := data[16:]
+	switch {
+	case origin == eeOriginICMP:
+		hop = net.IP(offender[4:8]).String()
+		// 11 is time exceeded; 3, destination unreachable, comes from the
+		// destination itself when no one listens on the probed port
+		return hop, icmpType == 3, icmpType == 11 || icmpType == 3
+	case origin == eeOriginICMP6 && len(offender) >= 24:
+		hop = net.IP(offender[8:24]).String()
+		// ICMPv6 time exceeded is 3 and destination unreachable is 1
+		return hop, icmpType == 1, icmpType == 3 || icmpType == 1
+	}
+	return "", false, false
+}
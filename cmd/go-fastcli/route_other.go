@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+func traceHops(ctx context.Context, dst net.IP, maxHops int) ([]string, error) {
+	return nil, errors.New("tracing routes is only supported on Linux")
+}
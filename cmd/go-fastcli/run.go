@@ -0,0 +1,954 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+var client = fastcom.NewClient()
+
+type Config struct {
+	TargetsFile string
+	SamplesFile string
+	// LatencySamplesFile receives the loaded-latency probes as CSV.
+	LatencySamplesFile string
+	// ChartFile receives a chart of the per-second speeds, as SVG or PNG by
+	// its extension.
+	ChartFile string
+	// LoadedLatencyInterval, if set, probes latency this often during
+	// download and upload.
+	LoadedLatencyInterval time.Duration
+	NICCounters           string
+	NoHTTPS               bool
+	HTTP1                 bool
+	TLSCipher             string
+	Headline              string
+	// Converge is the stopping criterion of the download and upload
+	// measurements, fastcom.ConvergeStdDev, the default if empty, or
+	// fastcom.ConvergeEWMA.
+	Converge string
+	// Warm opens the connection of each transfer phase before the phase
+	// and its samples are timed.
+	Warm bool
+	// Sparkline draws the last seconds of each transfer phase's speed while
+	// it runs.
+	Sparkline bool
+	// Colors colors the text report.
+	Colors Colors
+	// ProgressBar shows how far each transfer phase is through its share
+	// of MaxTotalTime while it runs.
+	ProgressBar bool
+	// DedupeTargets is how duplicate targets are spotted: DedupeHost, the
+	// default if empty, DedupeIP or DedupeOff.
+	DedupeTargets string
+	// IncludeHosts and ExcludeHosts are globs of target host names to test
+	// only, and never.
+	IncludeHosts []string
+	ExcludeHosts []string
+	// Resolve pins target hosts to addresses, as host:ip entries.
+	Resolve       []string
+	SourceAddress string
+	// NetNS is a network namespace, by `ip netns` name or path, to test from.
+	NetNS string
+	// PAC is the URL or path of a proxy auto-config script.
+	PAC string
+	// RequestTimeout and StallTimeout set the client's; see fastcom.Client.
+	RequestTimeout time.Duration
+	StallTimeout   time.Duration
+	// MaxTotalTime, if set, bounds the whole test. Phases share what is left
+	// after discovery and are cut short when their share runs out.
+	MaxTotalTime time.Duration
+	// MinSamples, if set, withholds the speed or latency of a phase that
+	// took fewer transfers or latency samples, marking it Insufficient.
+	MinSamples int
+
+	SkipLatency  bool
+	SkipDownload bool
+	SkipUpload   bool
+
+	CollectorURL   string
+	CollectorKey   string
+	SelfUpdate     bool
+	UpdateKey      ed25519.PublicKey
+	RemoteWriteURL string
+	HistoryDB      string
+	HistoryBackend string
+	StatsDAddr     string
+	StatsDTags     bool
+	GraphiteAddr   string
+	GraphitePrefix string
+
+	PushgatewayURL      string
+	PushgatewayJob      string
+	PushgatewayInstance string
+
+	Journal        string
+	JournalMaxSize string
+	JournalKeep    int
+	WebhookURL     string
+	// WebhookTemplate is a text/template file rendering the webhook body,
+	// or the name of a built-in one.
+	WebhookTemplate string
+	WebhookHeaders  http.Header
+
+	SlackWebhook   string
+	DiscordWebhook string
+	NtfyURL        string
+	NtfyToken      string
+	GotifyURL      string
+	GotifyToken    string
+	// NotifyThresholds, if set, limits notifications to runs beyond them.
+	NotifyThresholds NagiosThresholds
+	NotifyState      string
+
+	// EmailTo receives a report of each run through SMTPServer, at
+	// host:port.
+	EmailTo      []string
+	EmailFrom    string
+	SMTPServer   string
+	SMTPUser     string
+	SMTPPassword string
+
+	// DSCP marks the test traffic with a class such as "EF" or "AF41".
+	DSCP string
+
+	// ExpectCountries are the country codes the connection should be
+	// located in; elsewhere, a VPN or proxy likely carries the test.
+	ExpectCountries []string
+
+	// CloudMetadata looks up the cloud instance the test runs on.
+	CloudMetadata bool
+
+	// PlanDownMbps and PlanUpMbps are the subscribed speeds to grade runs
+	// against.
+	PlanDownMbps float64
+	PlanUpMbps   float64
+
+	// Baseline, if set, is what runs are expected to reach; a drop of more
+	// than BaselineDropPercent below it is a regression.
+	Baseline            *Baseline
+	BaselineDropPercent float64
+
+	// OnEvent, if set, receives progress as the test moves through the
+	// "servers", "latency", "download" and "upload" phases.
+	OnEvent func(Event)
+
+	// targets, if set, replace the server lookup, so repeated runs test
+	// the same servers.
+	targets *targetList
+}
+
+type targetList struct {
+	info    fastcom.ConnectionInfo
+	servers []fastcom.Server
+}
+
+type ServerResult struct {
+	Host      string
+	URL       string
+	City      string
+	Country   string
+	LatencyMs float64
+	JitterMs  float64
+	// DNSMs is how long the server's host took to resolve, once before
+	// the latency samples, which time the connect alone.
+	DNSMs        float64 `json:",omitempty"`
+	DownloadMbps float64
+	DownloadMB   int
+	UploadMbps   float64
+	UploadMB     int
+	DownloadCPU  *CPUUsage `json:",omitempty"`
+	UploadCPU    *CPUUsage `json:",omitempty"`
+	// DownloadLatencyMs and UploadLatencyMs are the median latency while
+	// loaded, with -loaded-latency.
+	DownloadLatencyMs float64 `json:",omitempty"`
+	UploadLatencyMs   float64 `json:",omitempty"`
+	// DownloadRamp and UploadRamp are how each phase built up to its
+	// speed.
+	DownloadRamp *RampProfile `json:",omitempty"`
+	UploadRamp   *RampProfile `json:",omitempty"`
+	TLSCipher    string       `json:",omitempty"`
+
+	LatencySamplesMs []float64  `json:",omitempty"`
+	Route            *RouteInfo `json:",omitempty"`
+	// Shortened lists the phases cut short by MaxTotalTime.
+	Shortened []string `json:",omitempty"`
+	// Insufficient lists the phases with fewer than MinSamples samples,
+	// whose measurements are left at zero and out of the Summary, the
+	// thresholds and the sinks.
+	Insufficient []string `json:",omitempty"`
+	// Incomplete lists the phases an interrupt stopped before they
+	// finished, whose measurements are from the samples taken until then.
+	Incomplete []string `json:",omitempty"`
+}
+
+type Result struct {
+	Time       time.Time
+	Connection fastcom.ConnectionInfo
+	// SourceAddress is the local address the test connected from, and
+	// SourceAddressKind whether it is a "temporary" or "stable" IPv6 address.
+	SourceAddress     string `json:",omitempty"`
+	SourceAddressKind string `json:",omitempty"`
+	// Cloud is the instance the test ran on, with -cloud-metadata.
+	Cloud   *CloudInfo `json:",omitempty"`
+	Servers []ServerResult
+	Samples []Sample `json:",omitempty"`
+	// LoadedLatency holds the latency probes taken during the transfers.
+	LoadedLatency []LatencySample `json:",omitempty"`
+
+	// Connections counts the TCP connections the test tried to open.
+	Connections *fastcom.ConnectStats `json:",omitempty"`
+	// ExcludedTargets are the URLs left out by -include-host and
+	// -exclude-host.
+	ExcludedTargets []string `json:",omitempty"`
+	// DuplicateTargets are the URLs left out as duplicates of another
+	// target's server.
+	DuplicateTargets []string `json:",omitempty"`
+	// Abandoned counts the requests cut off mid-transfer, mostly by the end
+	// of a phase, and how long they took to clean up.
+	Abandoned *fastcom.AbandonStats `json:",omitempty"`
+	// DSCP is the class the test traffic was marked with.
+	DSCP string `json:",omitempty"`
+	// Plan grades the speeds against the subscribed plan.
+	Plan *PlanGrade `json:",omitempty"`
+	// Anomalies describe what the daemon found unusual about the run
+	// compared with the previous ones in the history.
+	Anomalies []string `json:",omitempty"`
+	// UnexpectedCountry is set when fast.com located the connection
+	// outside ExpectCountries, so the result measures a VPN or proxy.
+	UnexpectedCountry bool `json:",omitempty"`
+	// Warnings are the caveats of the result, such as a CPU-limited
+	// transfer or a suspected captive portal.
+	Warnings []Warning `json:",omitempty"`
+	// Interrupted is set when SIGINT or SIGTERM stopped the test; the
+	// phases it cut short are listed in each server's Incomplete and the
+	// phases after them are missing.
+	Interrupted bool `json:",omitempty"`
+}
+
+// ParseResolve parses -resolve entries into a map of host to IP.
+func ParseResolve(entries []string) (map[string]string, error) {
+	pinned := map[string]string{}
+	for _, entry := range entries {
+		host, ip, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid -resolve entry %q, expected host:ip", entry)
+		}
+		pinned[host] = ip
+	}
+	return pinned, nil
+}
+
+func RunTest(ctx context.Context, cfg Config) (Result, error) {
+	// number of servers to request
+	serverNum := 1
+
+	// number of times to measure latency
+	latencyLoopNum := 10
+
+	// same convergence settings for download and upload
+	downMeasure := fastcom.DefaultMeasureConfig
+	switch cfg.Converge {
+	case "", fastcom.ConvergeStdDev, fastcom.ConvergeEWMA:
+		downMeasure.Criterion = cfg.Converge
+	default:
+		return Result{}, fmt.Errorf("unknown convergence criterion %q", cfg.Converge)
+	}
+	upMeasure := downMeasure
+	if err := validDedupe(cfg.DedupeTargets); err != nil {
+		return Result{}, err
+	}
+	if err := validHostPatterns("include-host", cfg.IncludeHosts); err != nil {
+		return Result{}, err
+	}
+	if err := validHostPatterns("exclude-host", cfg.ExcludeHosts); err != nil {
+		return Result{}, err
+	}
+	if cfg.ChartFile != "" && !ValidChartFile(cfg.ChartFile) {
+		return Result{}, fmt.Errorf("-chart %q must end in .svg or .png", cfg.ChartFile)
+	}
+
+	emit := func(evt Event) {
+		if cfg.OnEvent != nil {
+			if evt.Time.IsZero() {
+				evt.Time = time.Now()
+			}
+			cfg.OnEvent(evt)
+		}
+	}
+
+	if !ValidHeadline(cfg.Headline) {
+		return Result{}, fmt.Errorf("unknown headline metric %q", cfg.Headline)
+	}
+	client.HTTPS = !cfg.NoHTTPS
+	client.RequestTimeout, client.StallTimeout = cfg.RequestTimeout, cfg.StallTimeout
+	if err := client.SetCipher(cfg.TLSCipher); err != nil {
+		return Result{}, err
+	}
+	if cfg.HTTP1 {
+		client.DisableHTTP2()
+	}
+	if cfg.SourceAddress != "" {
+		if err := client.SetSourceAddress(cfg.SourceAddress); err != nil {
+			return Result{}, err
+		}
+	}
+	if cfg.NetNS != "" {
+		ns, err := openNetNS(cfg.NetNS)
+		if err != nil {
+			return Result{}, err
+		}
+		defer ns.Close()
+		client.WrapDial(ns.Do)
+	}
+	if cfg.DSCP != "" {
+		dscp, err := ParseDSCP(cfg.DSCP)
+		if err == nil {
+			err = client.SetDSCP(dscp)
+		}
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	pinned, err := ParseResolve(cfg.Resolve)
+	if err != nil {
+		return Result{}, err
+	}
+	for host, ip := range pinned {
+		if err := client.Resolve(host, ip); err != nil {
+			return Result{}, err
+		}
+	}
+	var budget timeBudget
+	if cfg.MaxTotalTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.MaxTotalTime)
+		defer cancel()
+		budget.deadline = time.Now().Add(cfg.MaxTotalTime)
+	}
+	if cfg.PAC != "" {
+		// the PAC file itself is always fetched directly
+		direct := client.Transport.Clone()
+		direct.Proxy = nil
+		defer direct.CloseIdleConnections()
+		pac, err := LoadPAC(ctx, &http.Client{Transport: direct, Timeout: cfg.RequestTimeout}, cfg.PAC, client.LookupHost)
+		if err != nil {
+			return Result{}, err
+		}
+		client.SetProxy(pac.Proxy)
+	}
+
+	connectsBefore := client.ConnectStats()
+	abandonsBefore := client.AbandonStats()
+	unexpectedBefore := client.UnexpectedResponses()
+	var cloud chan *CloudInfo
+	if cfg.CloudMetadata {
+		// looked up alongside the test, which it doesn't load
+		cloud = make(chan *CloudInfo, 1)
+		go func() {
+			info, err := LookupCloudInfo(ctx)
+			if err != nil {
+				logAt(LevelInfo, "no cloud metadata", "err", err)
+			}
+			cloud <- info
+		}()
+	}
+	emit(Event{Type: EventPhaseStart, Phase: "servers"})
+	var connectionInfo fastcom.ConnectionInfo
+	var serverList []fastcom.Server
+	switch {
+	case cfg.targets != nil:
+		connectionInfo, serverList = cfg.targets.info, cfg.targets.servers
+	case cfg.TargetsFile != "":
+		connectionInfo, serverList, err = fastcom.LoadServerList(cfg.TargetsFile)
+	case len(cfg.IncludeHosts) > 0 || len(cfg.ExcludeHosts) > 0:
+		// spares to stand in for the targets the filters leave out
+		connectionInfo, serverList, err = client.GetServerList(ctx, filterServerNum)
+	default:
+		connectionInfo, serverList, err = client.GetServerList(ctx, serverNum)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	serverList, excluded := FilterServers(serverList, cfg.IncludeHosts, cfg.ExcludeHosts)
+	if len(serverList) == 0 {
+		return Result{}, fmt.Errorf("-include-host and -exclude-host left out all %d targets: %w", len(excluded), fastcom.ErrNoTargets)
+	}
+	if cfg.targets == nil && cfg.TargetsFile == "" && len(serverList) > serverNum {
+		// the spares that weren't needed
+		serverList = serverList[:serverNum]
+	}
+	serverList, duplicates := DedupeServers(ctx, serverList, cfg.DedupeTargets)
+	result := Result{
+		Time:             time.Now(),
+		Connection:       connectionInfo,
+		DSCP:             cfg.DSCP,
+		ExcludedTargets:  excluded,
+		DuplicateTargets: duplicates,
+	}
+	emit(Event{Type: EventPhaseEnd, Phase: "servers", Servers: len(serverList)})
+	for _, server := range serverList {
+		result.Servers = append(result.Servers, ServerResult{
+			Host:    fastcom.GetHost(server.URL),
+			URL:     server.URL,
+			City:    server.City,
+			Country: server.Country,
+		})
+		if !cfg.SkipLatency {
+			budget.weight += latencyWeight
+		}
+		if !cfg.SkipDownload {
+			budget.weight += transferWeight
+		}
+		if !cfg.SkipUpload {
+			budget.weight += transferWeight
+		}
+	}
+
+	result.UnexpectedCountry = unexpectedCountry(cfg.ExpectCountries, connectionInfo.Location.Country)
+
+	fmt.Fprintln(out.Progress, cfg.Colors.Header("Fast.com Speedtest"))
+	fmt.Fprintln(out.Progress)
+	if connectionInfo.IP != "" {
+		fmt.Fprintln(out.Progress, cfg.Colors.Header("Connection Info:"))
+		fmt.Fprintf(out.Progress, "  - IP: %s\n", connectionInfo.IP)
+		fmt.Fprintf(out.Progress, "  - ASN: %s\n", orUnknown(connectionInfo.ASN))
+		if connectionInfo.ISP != "" {
+			fmt.Fprintf(out.Progress, "  - ISP: %s\n", connectionInfo.ISP)
+		}
+		if result.UnexpectedCountry {
+			fmt.Fprintf(out.Progress, "  - Location: %s (expected %s: VPN or proxy?)\n", locationName(connectionInfo.Location), countryList(cfg.ExpectCountries))
+		} else {
+			fmt.Fprintf(out.Progress, "  - Location: %s\n", locationName(connectionInfo.Location))
+		}
+		for _, field := range extraFields(connectionInfo.Extra) {
+			fmt.Fprintf(out.Progress, "  - %s\n", field)
+		}
+		fmt.Fprintln(out.Progress)
+	}
+	fmt.Fprintln(out.Progress, cfg.Colors.Header("Fast.com Servers:"))
+	for _, server := range serverList {
+		if server.City != "" || server.Country != "" {
+			fmt.Fprintf(out.Progress, "  - Location: %s\n", locationName(fastcom.LocationInfo{City: server.City, Country: server.Country}))
+			fmt.Fprintf(out.Progress, "    URL: %s\n", server.URL)
+		} else {
+			fmt.Fprintf(out.Progress, "  - URL: %s\n", server.URL)
+		}
+		fmt.Fprintln(out.Progress)
+	}
+	for _, url := range excluded {
+		fmt.Fprintf(out.Progress, "  - Skipped by host filter: %s\n", url)
+		fmt.Fprintln(out.Progress)
+	}
+	for _, duplicate := range duplicates {
+		fmt.Fprintf(out.Progress, "  - Skipped duplicate: %s\n", duplicate)
+		fmt.Fprintln(out.Progress)
+	}
+
+	// a blank line separates the sections of the text report
+	printedSection := false
+	section := func(title string) {
+		if printedSection {
+			fmt.Fprintln(out.Progress)
+		}
+		printedSection = true
+		fmt.Fprintln(out.Progress, cfg.Colors.Header(title))
+	}
+	if !cfg.SkipLatency {
+		section("Latency:")
+		// the servers are probed at once, and the connections for the
+		// transfers opened meanwhile, so the first transfer doesn't wait
+		// for a handshake
+		latencies := make([]fastcom.Latency, len(serverList))
+		errs := make([]error, len(serverList))
+		phaseCtx, cancel := budget.phase(ctx, latencyWeight*len(serverList))
+		var wg sync.WaitGroup
+		for n, server := range serverList {
+			host := result.Servers[n].Host
+			emit(Event{Type: EventPhaseStart, Phase: "latency", Server: host})
+			wg.Add(1)
+			go func(n int, url string) {
+				defer wg.Done()
+				latencies[n], errs[n] = client.MeasureLatencyFunc(phaseCtx, url, latencyLoopNum, func(sample time.Duration) {
+					emit(Event{Type: EventSample, Phase: "latency", Server: host, LatencyMs: float64(sample) / float64(time.Millisecond)})
+				})
+			}(n, server.URL)
+			if !cfg.SkipDownload || !cfg.SkipUpload {
+				// a failure here shows up in the transfer instead
+				go client.Prewarm(phaseCtx, server.URL)
+			}
+		}
+		wg.Wait()
+		cancel()
+		for n := range serverList {
+			latency, err := latencies[n], errs[n]
+			if err != nil && interrupted(ctx) {
+				partialLatency(cfg, &result, n, latency)
+				continue
+			}
+			if err != nil {
+				return result, fmt.Errorf("measuring latency: %w", budget.explain(err))
+			}
+			if latency.Shortened {
+				result.Servers[n].Shortened = append(result.Servers[n].Shortened, "latency")
+			}
+			for _, sample := range latency.Samples {
+				result.Servers[n].LatencySamplesMs = append(result.Servers[n].LatencySamplesMs, float64(sample)/float64(time.Millisecond))
+			}
+			if insufficientSamples(cfg, &result.Servers[n], "latency", len(latency.Samples)) {
+				emit(Event{Type: EventPhaseEnd, Phase: "latency", Server: result.Servers[n].Host, Shortened: latency.Shortened, Insufficient: true})
+				continue
+			}
+			result.Servers[n].LatencyMs = latency.MeanMs
+			result.Servers[n].JitterMs = latency.JitterMs
+			result.Servers[n].DNSMs = latency.DNSMs
+			emit(Event{Type: EventPhaseEnd, Phase: "latency", Server: result.Servers[n].Host, LatencyMs: latency.MeanMs, JitterMs: latency.JitterMs, Shortened: latency.Shortened})
+			fmt.Fprintf(out.Progress, "  - %s: %s (%0.3f ms jitter%s%s)\n", result.Servers[n].Host, cfg.Colors.Latency(result.Servers[n].LatencyMs), result.Servers[n].JitterMs, dnsNote(latency.DNSMs), shortenedNote(latency.Shortened))
+		}
+		if result.Interrupted {
+			return result, fmt.Errorf("measuring latency: %w", ctx.Err())
+		}
+	}
+
+	if !cfg.SkipDownload {
+		section("Download Speed:")
+		for n, server := range serverList {
+			emit(Event{Type: EventPhaseStart, Phase: "download", Server: result.Servers[n].Host})
+			if cfg.Warm {
+				// a failure here shows up in the transfer instead
+				client.Prewarm(ctx, server.URL)
+			}
+			phaseCtx, cancel := budget.phase(ctx, transferWeight)
+			onSample, clearLive := liveSamples(phaseCtx, cfg, emit)
+			sampler := &Sampler{Phase: "download", Host: result.Servers[n].Host, Interface: cfg.NICCounters, Bytes: client.BytesTransferred, Active: client.TakeActiveTransfers, OnSample: onSample}
+			stopProbes := startLoadedLatency(ctx, cfg, "download", result.Servers[n].Host, server.URL, emit)
+			sampler.Start()
+			cpu := &CPUMonitor{}
+			cpu.Start()
+			download, err := client.MeasureDownload(phaseCtx, server.URL, downMeasure)
+			cancel()
+			probes := stopProbes()
+			samples := sampler.Stop()
+			clearLive()
+			result.Samples = append(result.Samples, samples...)
+			result.LoadedLatency = append(result.LoadedLatency, probes...)
+			if usage, ok := cpu.Stop(); ok {
+				result.Servers[n].DownloadCPU = &usage
+			}
+			if err != nil {
+				if interrupted(ctx) {
+					partialTransfer(cfg, &result, n, "download", samples)
+				}
+				return result, fmt.Errorf("measuring download speed: %w", budget.explain(err))
+			}
+			if result.Servers[n].DownloadMbps, err = HeadlineMbps(cfg.Headline, download, samples); err != nil {
+				return result, fmt.Errorf("calculating download speed: %w", err)
+			}
+			result.Servers[n].TLSCipher = client.CipherSuite()
+			result.Servers[n].DownloadMB = download.UsedMB
+			if download.Shortened {
+				result.Servers[n].Shortened = append(result.Servers[n].Shortened, "download")
+			}
+			if insufficientSamples(cfg, &result.Servers[n], "download", len(download.SamplesMbps)) {
+				result.Servers[n].DownloadMbps = 0
+				emit(Event{Type: EventPhaseEnd, Phase: "download", Server: result.Servers[n].Host, UsedMB: result.Servers[n].DownloadMB, Shortened: download.Shortened, Insufficient: true})
+			} else {
+				emit(Event{Type: EventPhaseEnd, Phase: "download", Server: result.Servers[n].Host, Mbps: eventMbps(result.Servers[n].DownloadMbps), UsedMB: result.Servers[n].DownloadMB, Shortened: download.Shortened})
+				fmt.Fprintf(out.Progress, "  - %s: %s (used %d MB%s)\n", result.Servers[n].Host, cfg.Colors.Speed("download", result.Servers[n].DownloadMbps, cfg.PlanDownMbps), result.Servers[n].DownloadMB, shortenedNote(download.Shortened))
+				if ramp := NewRampProfile(samples, result.Servers[n].DownloadMbps); ramp != nil {
+					result.Servers[n].DownloadRamp = ramp
+					fmt.Fprintf(out.Progress, "    Ramp: %s\n", ramp.String(result.Servers[n].DownloadMbps))
+				}
+			}
+			result.Servers[n].DownloadLatencyMs = printLoadedLatency(probes)
+			printCipherCost(result.Servers[n].TLSCipher, result.Servers[n].DownloadMbps, result.Servers[n].DownloadCPU)
+		}
+	}
+
+	if !cfg.SkipUpload {
+		section("Upload Speed:")
+		for n, server := range serverList {
+			emit(Event{Type: EventPhaseStart, Phase: "upload", Server: result.Servers[n].Host})
+			if cfg.Warm {
+				// a failure here shows up in the transfer instead
+				client.Prewarm(ctx, server.URL)
+			}
+			phaseCtx, cancel := budget.phase(ctx, transferWeight)
+			onSample, clearLive := liveSamples(phaseCtx, cfg, emit)
+			sampler := &Sampler{Phase: "upload", Host: result.Servers[n].Host, Interface: cfg.NICCounters, Bytes: client.BytesTransferred, Active: client.TakeActiveTransfers, OnSample: onSample}
+			stopProbes := startLoadedLatency(ctx, cfg, "upload", result.Servers[n].Host, server.URL, emit)
+			sampler.Start()
+			cpu := &CPUMonitor{}
+			cpu.Start()
+			upload, err := client.MeasureUpload(phaseCtx, server.URL, upMeasure)
+			cancel()
+			probes := stopProbes()
+			samples := sampler.Stop()
+			clearLive()
+			result.Samples = append(result.Samples, samples...)
+			result.LoadedLatency = append(result.LoadedLatency, probes...)
+			if usage, ok := cpu.Stop(); ok {
+				result.Servers[n].UploadCPU = &usage
+			}
+			if err != nil {
+				if interrupted(ctx) {
+					partialTransfer(cfg, &result, n, "upload", samples)
+				}
+				return result, fmt.Errorf("measuring upload speed: %w", budget.explain(err))
+			}
+			if result.Servers[n].UploadMbps, err = HeadlineMbps(cfg.Headline, upload, samples); err != nil {
+				return result, fmt.Errorf("calculating upload speed: %w", err)
+			}
+			result.Servers[n].UploadMB = upload.UsedMB
+			if upload.Shortened {
+				result.Servers[n].Shortened = append(result.Servers[n].Shortened, "upload")
+			}
+			if insufficientSamples(cfg, &result.Servers[n], "upload", len(upload.SamplesMbps)) {
+				result.Servers[n].UploadMbps = 0
+				emit(Event{Type: EventPhaseEnd, Phase: "upload", Server: result.Servers[n].Host, UsedMB: result.Servers[n].UploadMB, Shortened: upload.Shortened, Insufficient: true})
+			} else {
+				emit(Event{Type: EventPhaseEnd, Phase: "upload", Server: result.Servers[n].Host, Mbps: eventMbps(result.Servers[n].UploadMbps), UsedMB: result.Servers[n].UploadMB, Shortened: upload.Shortened})
+				fmt.Fprintf(out.Progress, "  - %s: %s (used %d MB%s)\n", result.Servers[n].Host, cfg.Colors.Speed("upload", result.Servers[n].UploadMbps, cfg.PlanUpMbps), result.Servers[n].UploadMB, shortenedNote(upload.Shortened))
+				if ramp := NewRampProfile(samples, result.Servers[n].UploadMbps); ramp != nil {
+					result.Servers[n].UploadRamp = ramp
+					fmt.Fprintf(out.Progress, "    Ramp: %s\n", ramp.String(result.Servers[n].UploadMbps))
+				}
+			}
+			result.Servers[n].UploadLatencyMs = printLoadedLatency(probes)
+		}
+	}
+
+	connects := client.ConnectStats().Sub(connectsBefore)
+	abandons := client.AbandonStats().Sub(abandonsBefore)
+	if connects.Attempts > 0 || abandons.Requests > 0 {
+		section("Connections:")
+	}
+	if connects.Attempts > 0 {
+		result.Connections = &connects
+		printConnectStats(connects)
+	}
+	if abandons.Requests > 0 {
+		result.Abandoned = &abandons
+		fmt.Fprintf(out.Progress, "  - %d requests abandoned mid-transfer, %0.1f ms to clean up on average\n", abandons.Requests, abandons.MeanCleanupMs())
+	}
+
+	if ip := client.SourceAddress(); ip != nil {
+		result.SourceAddress = ip.String()
+		result.SourceAddressKind = ipv6AddressKind(ip)
+		section("Source Address:")
+		if result.SourceAddressKind != "" {
+			fmt.Fprintf(out.Progress, "  - %s (%s)\n", result.SourceAddress, result.SourceAddressKind)
+		} else {
+			fmt.Fprintf(out.Progress, "  - %s\n", result.SourceAddress)
+		}
+	}
+
+	if cloud != nil {
+		if result.Cloud = <-cloud; result.Cloud != nil {
+			section("Cloud Instance:")
+			fmt.Fprintf(out.Progress, "  - %s\n", result.Cloud)
+		}
+	}
+
+	if grade := GradePlan(cfg, result.Summary(), cfg.PlanDownMbps, cfg.PlanUpMbps); grade != (PlanGrade{}) {
+		result.Plan = &grade
+		section("Plan:")
+		if grade.DownloadMbps > 0 {
+			fmt.Fprintf(out.Progress, "  - Download: %0.1f%% of %g Mbit/s\n", grade.DownloadPercent, grade.DownloadMbps)
+		}
+		if grade.UploadMbps > 0 {
+			fmt.Fprintf(out.Progress, "  - Upload: %0.1f%% of %g Mbit/s\n", grade.UploadPercent, grade.UploadMbps)
+		}
+	}
+
+	result.Warnings = resultWarnings(cfg, result, client.UnexpectedResponses()-unexpectedBefore)
+	if len(result.Warnings) > 0 {
+		section("Warnings:")
+		printWarnings(out.Progress, result.Warnings)
+	}
+
+	if cfg.SamplesFile != "" {
+		if err := WriteSamplesCSV(cfg.SamplesFile, result.Samples); err != nil {
+			return result, fmt.Errorf("writing samples: %w", err)
+		}
+	}
+	if cfg.LatencySamplesFile != "" {
+		if err := WriteLatencySamplesCSV(cfg.LatencySamplesFile, result.LoadedLatency); err != nil {
+			return result, fmt.Errorf("writing latency samples: %w", err)
+		}
+	}
+	if cfg.ChartFile != "" {
+		if err := WriteChart(cfg.ChartFile, result.Samples); err != nil {
+			return result, fmt.Errorf("writing chart: %w", err)
+		}
+	}
+	emit(Event{Type: EventDone})
+	return result, nil
+}
+
+func emitSample(emit func(Event)) func(Sample) {
+	return func(sample Sample) {
+		emit(Event{Type: EventSample, Phase: sample.Phase, Server: sample.Host, Second: sample.Second, Mbps: eventMbps(float64(sample.Bytes) / 125000)})
+	}
+}
+
+// startLoadedLatency probes the latency to url during a transfer phase if
+// cfg asks for it, timed from now, when the phase's throughput samples
+// start. The returned function stops it and returns the probes.
+func startLoadedLatency(ctx context.Context, cfg Config, phase, host, url string, emit func(Event)) func() []LatencySample {
+	if cfg.LoadedLatencyInterval <= 0 {
+		return func() []LatencySample { return nil }
+	}
+	toSample := func(probe fastcom.LatencyProbe) LatencySample {
+		return LatencySample{
+			Phase:     phase,
+			Host:      host,
+			OffsetMs:  float64(probe.At) / float64(time.Millisecond),
+			LatencyMs: float64(probe.Latency) / float64(time.Millisecond),
+		}
+	}
+	prober := &fastcom.LatencyProber{
+		Client:   client,
+		URL:      url,
+		Interval: cfg.LoadedLatencyInterval,
+		OnProbe: func(probe fastcom.LatencyProbe) {
+			sample := toSample(probe)
+			emit(Event{Type: EventSample, Phase: phase, Server: host, Second: int(sample.OffsetMs/1000) + 1, LatencyMs: sample.LatencyMs})
+		},
+	}
+	prober.Start(ctx)
+	return func() []LatencySample {
+		var samples []LatencySample
+		for _, probe := range prober.Stop() {
+			samples = append(samples, toSample(probe))
+		}
+		return samples
+	}
+}
+
+// printLoadedLatency prints the median and 90th percentile of the probes
+// and returns the median, or 0 without probes.
+func printLoadedLatency(probes []LatencySample) float64 {
+	if len(probes) == 0 {
+		return 0
+	}
+	millis := make([]float64, len(probes))
+	for i, probe := range probes {
+		millis[i] = probe.LatencyMs
+	}
+	median, _ := fastcom.CalcPercentile(millis, 50)
+	p90, _ := fastcom.CalcPercentile(millis, 90)
+	fmt.Fprintf(out.Progress, "    Loaded latency: %0.1f ms median, %0.1f ms p90 (%d probes)\n", median, p90, len(probes))
+	return median
+}
+
+// unexpectedCountry reports whether country is known and not one of the
+// expected country codes.
+func unexpectedCountry(expected []string, country string) bool {
+	if len(expected) == 0 || country == "" {
+		return false
+	}
+	for _, c := range expected {
+		if strings.EqualFold(strings.TrimSpace(c), country) {
+			return false
+		}
+	}
+	return true
+}
+
+// countryList joins country codes for a message, e.g. "DE or AT".
+func countryList(codes []string) string {
+	upper := make([]string, len(codes))
+	for i, c := range codes {
+		upper[i] = strings.ToUpper(strings.TrimSpace(c))
+	}
+	return strings.Join(upper, " or ")
+}
+
+// orUnknown is s, or "unknown" for a field fast.com left out.
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// locationName names a location for the report as "City, Country", or
+// whichever of the two is known: fast.com leaves them out for some
+// connections and targets.
+func locationName(l fastcom.LocationInfo) string {
+	switch {
+	case l.City == "" && l.Country == "":
+		return "unknown"
+	case l.City == "":
+		return l.Country
+	case l.Country == "":
+		return l.City
+	}
+	return l.City + ", " + l.Country
+}
+
+// extraFields formats the client fields of the fast.com API that have no
+// place of their own as "name: value", sorted by name. Values that aren't
+// strings are written as JSON.
+func extraFields(extra map[string]interface{}) []string {
+	var fields []string
+	for name, value := range extra {
+		s, ok := value.(string)
+		if !ok {
+			b, _ := json.Marshal(value)
+			s = string(b)
+		}
+		fields = append(fields, name+": "+s)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// insufficientSamples reports whether a phase took fewer than cfg.MinSamples
+// samples, marking it on the server and printing that its number is
+// withheld: a speed from a single transfer cut short by errors or the time
+// budget looks as confident as any other.
+func insufficientSamples(cfg Config, server *ServerResult, phase string, samples int) bool {
+	if samples >= cfg.MinSamples {
+		return false
+	}
+	server.Insufficient = append(server.Insufficient, phase)
+	fmt.Fprintf(out.Progress, "  - %s: insufficient data (%d of %d samples)\n", server.Host, samples, cfg.MinSamples)
+	return true
+}
+
+func shortenedNote(shortened bool) string {
+	if shortened {
+		return ", shortened by -max-total-time"
+	}
+	return ""
+}
+
+// dnsNote reports the time the server's host took to resolve, which the
+// latency leaves out, if it was looked up.
+func dnsNote(dnsMs float64) string {
+	if dnsMs > 0 {
+		return fmt.Sprintf(", %0.3f ms DNS", dnsMs)
+	}
+	return ""
+}
+
+func printConnectStats(s fastcom.ConnectStats) {
+	fmt.Fprintf(out.Progress, "  - %d of %d succeeded (%0.1f%%)\n", s.Succeeded, s.Attempts, s.SuccessRate()*100)
+	if s.Succeeded < s.Attempts {
+		fmt.Fprintf(out.Progress, "    %d timed out, %d refused or reset, %d failed otherwise\n", s.TimedOut, s.Refused, s.Failed)
+	}
+	if s.Slow > 0 {
+		fmt.Fprintf(out.Progress, "    %d took over %s, likely a lost SYN\n", s.Slow, fastcom.SlowConnect)
+	}
+}
+
+// lingerWait is how long closeConnections waits for the connections still
+// held by abandoned requests.
+const lingerWait = time.Second
+
+// closeConnections closes the client's connections before the program exits,
+// and warns about those that linger, which delay the exit.
+func closeConnections() {
+	if n := client.CloseConnections(lingerWait); n > 0 {
+		fmt.Fprintf(out.Log, "Warning: %d connections still open %s after the test, held by abandoned requests\n", n, lingerWait)
+	}
+}
+
+// printCipherCost shows how much CPU the negotiated cipher needed per 100
+// Mbit/s, so runs with different -tls-cipher values can be compared.
+func printCipherCost(cipher string, mbps float64, usage *CPUUsage) {
+	if cipher == "" {
+		return
+	}
+	if usage == nil || mbps <= 0 {
+		fmt.Fprintf(out.Progress, "    TLS: %s\n", cipher)
+		return
+	}
+	fmt.Fprintf(out.Progress, "    TLS: %s (%0.1f%% of a core per 100 Mbit/s)\n", cipher, usage.ProcessPercent/(mbps/100))
+}
+
+type Summary struct {
+	PingMs       float64
+	JitterMs     float64
+	DownloadMbps float64
+	UploadMbps   float64
+	BytesUsed    int64
+	// Insufficient lists the phases (latency, download or upload) no
+	// server took enough samples of, whose numbers are left at zero.
+	Insufficient []string `json:",omitempty"`
+}
+
+// lacks reports whether no server took enough samples of phase.
+func (s Summary) lacks(phase string) bool {
+	return containsString(s.Insufficient, phase)
+}
+
+// lacks reports whether the server took too few samples of phase to report
+// it.
+func (s ServerResult) lacks(phase string) bool {
+	return containsString(s.Insufficient, phase)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary averages the per-server measurements into one set of numbers. A
+// phase is averaged over the servers that took enough samples of it; if
+// none did, it is listed as Insufficient.
+func (r Result) Summary() Summary {
+	var s Summary
+	if len(r.Servers) == 0 {
+		return s
+	}
+	var latency, download, upload float64
+	for _, server := range r.Servers {
+		if !server.lacks("latency") {
+			s.PingMs += server.LatencyMs
+			s.JitterMs += server.JitterMs
+			latency++
+		}
+		if !server.lacks("download") {
+			s.DownloadMbps += server.DownloadMbps
+			download++
+		}
+		if !server.lacks("upload") {
+			s.UploadMbps += server.UploadMbps
+			upload++
+		}
+		s.BytesUsed += int64(server.DownloadMB+server.UploadMB) * 1024 * 1024
+	}
+	if latency > 0 {
+		s.PingMs /= latency
+		s.JitterMs /= latency
+	} else {
+		s.Insufficient = append(s.Insufficient, "latency")
+	}
+	if download > 0 {
+		s.DownloadMbps /= download
+	} else {
+		s.Insufficient = append(s.Insufficient, "download")
+	}
+	if upload > 0 {
+		s.UploadMbps /= upload
+	} else {
+		s.Insufficient = append(s.Insufficient, "upload")
+	}
+	return s
+}
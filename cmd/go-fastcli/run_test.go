@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+func TestLocationName(t *testing.T) {
+	tests := []struct {
+		location fastcom.LocationInfo
+		want     string
+	}{
+		{fastcom.LocationInfo{City: "Berlin", Country: "DE"}, "Berlin, DE"},
+		{fastcom.LocationInfo{Country: "DE"}, "DE"},
+		{fastcom.LocationInfo{City: "Berlin"}, "Berlin"},
+		{fastcom.LocationInfo{}, "unknown"},
+	}
+	for _, test := range tests {
+		if got := locationName(test.location); got != test.want {
+			t.Errorf("locationName(%+v) = %q, want %q", test.location, got, test.want)
+		}
+	}
+	if got := orUnknown(""); got != "unknown" {
+		t.Errorf(`orUnknown("") = %q, want "unknown"`, got)
+	}
+	if got := orUnknown("AS15169"); got != "AS15169" {
+		t.Errorf(`orUnknown("AS15169") = %q, want "AS15169"`, got)
+	}
+}
+
+func TestSummaryInsufficient(t *testing.T) {
+	result := Result{Servers: []ServerResult{
+		{Host: "a", LatencyMs: 10, JitterMs: 2, DownloadMbps: 100, Insufficient: []string{"upload"}},
+		{Host: "b", LatencyMs: 20, JitterMs: 4, Insufficient: []string{"download", "upload"}},
+	}}
+	s := result.Summary()
+	if s.PingMs != 15 || s.JitterMs != 3 {
+		t.Errorf("got ping %g and jitter %g, want the mean of both servers", s.PingMs, s.JitterMs)
+	}
+	if s.DownloadMbps != 100 {
+		t.Errorf("got download %g, want 100 from the one server that measured it", s.DownloadMbps)
+	}
+	if s.lacks("latency") || s.lacks("download") || !s.lacks("upload") {
+		t.Errorf("got Insufficient %q, want only upload", s.Insufficient)
+	}
+}
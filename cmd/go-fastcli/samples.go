@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"time"
+)
+
+type Sample struct {
+	Phase    string
+	Host     string
+	Second   int
+	Bytes    int64
+	NICBytes int64 // -1 when interface counters are unavailable
+	// Active is the most transfers that were in flight at once during the
+	// second, the workers the phase had going.
+	Active int
+}
+
+// LatencySample is a latency probe taken during a transfer phase, OffsetMs
+// after the phase's throughput samples started, so it falls within the
+// Sample of second OffsetMs/1000+1.
+type LatencySample struct {
+	Phase     string
+	Host      string
+	OffsetMs  float64
+	LatencyMs float64
+}
+
+type Sampler struct {
+	Phase     string
+	Host      string
+	Interface string
+	// Bytes reports the running total of payload bytes transferred.
+	Bytes func() int64
+	// Active, if set, reports the most transfers in flight at once since
+	// its previous call.
+	Active func() int64
+	// OnSample, if set, is called from the sampling goroutine as each second
+	// completes.
+	OnSample func(Sample)
+	Samples  []Sample
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func (s *Sampler) nicBytes() int64 {
+	if s.Interface == "" {
+		return -1
+	}
+	rx, tx, err := ReadInterfaceCounters(s.Interface)
+	if err != nil {
+		return -1
+	}
+	if s.Phase == "upload" {
+		return tx
+	}
+	return rx
+}
+
+func (s *Sampler) active() int {
+	if s.Active == nil {
+		return 0
+	}
+	return int(s.Active())
+}
+
+func (s *Sampler) Start() {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		lastBytes := s.Bytes()
+		lastNIC := s.nicBytes()
+		s.active()
+		for second := 1; ; second++ {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+			curBytes := s.Bytes()
+			curNIC := s.nicBytes()
+			sample := Sample{
+				Phase:    s.Phase,
+				Host:     s.Host,
+				Second:   second,
+				Bytes:    curBytes - lastBytes,
+				NICBytes: -1,
+				Active:   s.active(),
+			}
+			if lastNIC >= 0 && curNIC >= lastNIC {
+				sample.NICBytes = curNIC - lastNIC
+			}
+			s.Samples = append(s.Samples, sample)
+			if s.OnSample != nil {
+				s.OnSample(sample)
+			}
+			lastBytes, lastNIC = curBytes, curNIC
+		}
+	}()
+}
+
+func (s *Sampler) Stop() []Sample {
+	close(s.stop)
+	<-s.done
+	return s.Samples
+}
+
+// WriteLatencySamplesCSV writes the loaded-latency probes, with the second
+// of the throughput samples each falls within, to overlay the two.
+func WriteLatencySamplesCSV(path string, samples []LatencySample) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"phase", "host", "offset_ms", "second", "latency_ms"})
+	for _, sample := range samples {
+		w.Write([]string{
+			sample.Phase,
+			sample.Host,
+			strconv.FormatFloat(sample.OffsetMs, 'f', 1, 64),
+			strconv.Itoa(int(sample.OffsetMs/1000) + 1),
+			strconv.FormatFloat(sample.LatencyMs, 'f', 3, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func WriteSamplesCSV(path string, samples []Sample) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"phase", "host", "second", "app_bytes", "app_mbps", "nic_bytes", "nic_mbps", "overhead_pct", "active"})
+	for _, sample := range samples {
+		record := []string{
+			sample.Phase,
+			sample.Host,
+			strconv.Itoa(sample.Second),
+			strconv.FormatInt(sample.Bytes, 10),
+			strconv.FormatFloat(float64(sample.Bytes)/125000, 'f', 3, 64),
+			"", "", "",
+			strconv.Itoa(sample.Active),
+		}
+		if sample.NICBytes >= 0 {
+			record[5] = strconv.FormatInt(sample.NICBytes, 10)
+			record[6] = strconv.FormatFloat(float64(sample.NICBytes)/125000, 'f', 3, 64)
+			if sample.Bytes > 0 {
+				record[7] = strconv.FormatFloat(float64(sample.NICBytes-sample.Bytes)/float64(sample.Bytes)*100, 'f', 2, 64)
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
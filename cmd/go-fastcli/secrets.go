@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Secret flags, such as API keys and URLs with credentials, take a reference
+// instead of the secret itself, so it needn't sit in a unit file, crontab or
+// shell history:
+//
+//	env:NAME   the environment variable NAME
+//	file:PATH  the contents of PATH without the trailing newline
+//	age:PATH   PATH decrypted with age and the identity in $FASTCLI_AGE_IDENTITY
+//
+// Any other value is the secret itself. Either way, the secret is redacted
+// from everything written to out.Log.
+
+// Secrets shorter than this aren't redacted, as replacing them would mangle
+// unrelated text.
+const minRedactLength = 4
+
+const redacted = "[REDACTED]"
+
+var secrets struct {
+	sync.Mutex
+	values []string
+}
+
+// secretValue is the flag.Value of a secret flag.
+type secretValue struct {
+	p *string
+}
+
+// SecretVar defines a string flag like fs.StringVar whose value may be a
+// secret reference.
+func SecretVar(fs *flag.FlagSet, p *string, name, usage string) {
+	fs.Var(secretValue{p}, name, usage)
+}
+
+func (s secretValue) String() string {
+	if s.p == nil {
+		return ""
+	}
+	return *s.p
+}
+
+func (s secretValue) Set(value string) error {
+	secret, err := ResolveSecret(value)
+	if err != nil {
+		return err
+	}
+	*s.p = secret
+	AddSecret(secret)
+	return nil
+}
+
+// ResolveSecret returns the secret a flag value refers to.
+func ResolveSecret(value string) (string, error) {
+	kind, ref, _ := strings.Cut(value, ":")
+	switch kind {
+	case "env":
+		secret, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", ref)
+		}
+		return secret, nil
+	case "file":
+		data, err := os.ReadFile(ref)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case "age":
+		data, err := ageDecrypt(ref)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return value, nil
+}
+
+// ageDecrypt drives the age command, like SQLiteHistory drives sqlite3, to
+// keep the binary free of dependencies.
+func ageDecrypt(path string) ([]byte, error) {
+	identity := os.Getenv("FASTCLI_AGE_IDENTITY")
+	if identity == "" {
+		return nil, errors.New("age: set FASTCLI_AGE_IDENTITY to the identity file to decrypt " + path)
+	}
+	cmd := exec.Command("age", "--decrypt", "--identity", identity, path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("age: %s", msg)
+		}
+		return nil, fmt.Errorf("age: %w", err)
+	}
+	return out, nil
+}
+
+// AddSecret redacts secret from the log. For a URL, the password and the
+// forms with the password masked, as url.Redacted and net/http errors print
+// it, are redacted too.
+func AddSecret(secret string) {
+	values := []string{secret}
+	if u, err := url.Parse(secret); err == nil && u.Scheme != "" && u.Host != "" {
+		if password, ok := u.User.Password(); ok {
+			masked := strings.Replace(u.String(), u.User.String()+"@", u.User.Username()+":***@", 1)
+			values = append(values, password, u.Redacted(), masked)
+		}
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, v := range values {
+		if len(v) >= minRedactLength {
+			secrets.values = append(secrets.values, v)
+		}
+	}
+}
+
+// Redact replaces the known secrets in s.
+func Redact(s string) string {
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, secret := range secrets.values {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+type redactWriter struct {
+	w io.Writer
+}
+
+func (r redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// The age-encrypted file in $FASTCLI_SECRETS holds NAME=value lines that
+// ParseFlags reads like FASTCLI_* environment variables, which take
+// precedence over it. This keeps a whole section of settings encrypted.
+var secretsEnv struct {
+	once   sync.Once
+	values map[string]string
+	err    error
+}
+
+// lookupEnv looks name up in the environment, then in $FASTCLI_SECRETS.
+func lookupEnv(name string) (string, bool, error) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true, nil
+	}
+	secretsEnv.once.Do(func() {
+		if path := os.Getenv("FASTCLI_SECRETS"); path != "" {
+			secretsEnv.values, secretsEnv.err = readSecretsFile(path)
+		}
+	})
+	value, ok := secretsEnv.values[name]
+	return value, ok, secretsEnv.err
+}
+
+func readSecretsFile(path string) (map[string]string, error) {
+	data, err := ageDecrypt(path)
+	if err != nil {
+		return nil, fmt.Errorf("FASTCLI_SECRETS: %w", err)
+	}
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("FASTCLI_SECRETS: line %d: expected NAME=value", line)
+		}
+		values[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return values, nil
+}
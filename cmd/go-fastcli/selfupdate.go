@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxUpdateSize bounds the download of a new binary.
+const maxUpdateSize = 256 << 20
+
+// updateClient downloads new binaries, giving up after updateTimeout
+// rather than leaving a stuck download to block the probe. It doesn't follow
+// redirects away from https.
+var updateClient = &http.Client{
+	Timeout: updateTimeout,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("refusing the redirect to %s, which isn't https", req.URL.Redacted())
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	},
+}
+
+const updateTimeout = 10 * time.Minute
+
+// UpdateInfo tells the probes a collector rejects where to get the version
+// it requires.
+type UpdateInfo struct {
+	Version string
+	// URL of the binary, which must be https; {os} and {arch} are replaced by
+	// the probe's GOOS and GOARCH.
+	URL string
+	// SHA256 maps "os/arch", e.g. "linux/arm64", to the hex digest of the
+	// binary. Probes refuse binaries without one.
+	SHA256 map[string]string
+	// Signature maps "os/arch" to the base64 Ed25519 signature of the
+	// binary's updateMessage, made with the key whose public half the probes
+	// have as -update-key. Unlike the digest, it can't be forged by whoever
+	// can change what the collector or the download server sends.
+	Signature map[string]string
+}
+
+// updateMessage is what the signature of a binary signs: its version,
+// platform and digest, so a signed binary can't be passed off as another
+// version or for another platform.
+func updateMessage(version, platform, digest string) []byte {
+	return []byte("go-fastcli update " + version + " " + platform + " sha256:" + strings.ToLower(digest))
+}
+
+// updateKey is a flag holding a base64 Ed25519 public key.
+type updateKey struct {
+	p *ed25519.PublicKey
+}
+
+func (k updateKey) String() string {
+	if k.p == nil || *k.p == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(*k.p)
+}
+
+func (k updateKey) Set(value string) error {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("not a base64 Ed25519 public key, as go-fastcli sign-update -generate-key prints")
+	}
+	*k.p = key
+	return nil
+}
+
+// installed is the version SelfUpdate last installed, which the process
+// keeps running until it is restarted.
+var installed struct {
+	sync.Mutex
+	version string
+}
+
+// SelfUpdate downloads the binary described by update, checks its digest
+// and its signature by key, and replaces the executable with it. The running
+// process carries on with the version it started with, and the lock and
+// result are handled as usual; the new version takes over from the next
+// start.
+func SelfUpdate(ctx context.Context, update UpdateInfo, key ed25519.PublicKey) error {
+	if !canSelfUpdate {
+		return errors.New("self-update is not supported on " + runtime.GOOS)
+	}
+	if key == nil {
+		return errors.New("-self-update needs -update-key to verify the binary with")
+	}
+	if update.Version == appVersion() {
+		return fmt.Errorf("already running version %s, which the collector rejects", update.Version)
+	}
+	installed.Lock()
+	defer installed.Unlock()
+	if update.Version == installed.version {
+		// already in place, waiting for a restart
+		return nil
+	}
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	want := strings.ToLower(update.SHA256[platform])
+	if want == "" {
+		return fmt.Errorf("the collector offers no checksum for a %s binary", platform)
+	}
+	signature, err := base64.StdEncoding.DecodeString(update.Signature[platform])
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("the collector offers no valid signature for a %s binary", platform)
+	}
+	if !ed25519.Verify(key, updateMessage(update.Version, platform, want), signature) {
+		return fmt.Errorf("the signature of the %s binary of version %s doesn't match -update-key", platform, update.Version)
+	}
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return err
+	}
+
+	rawURL := strings.NewReplacer("{os}", runtime.GOOS, "{arch}", runtime.GOARCH).Replace(update.URL)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("refusing to download %s, which isn't https", u.Redacted())
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := updateClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s: %s", u.Redacted(), resp.Status)
+	}
+	// the new binary is written next to the old one so it can be renamed
+	// over it
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".go-fastcli-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(resp.Body, maxUpdateSize+1))
+	if err == nil && n > maxUpdateSize {
+		err = fmt.Errorf("%s is larger than %d bytes", u.Redacted(), maxUpdateSize)
+	}
+	// the signature covers the digest, so a matching digest is a signed
+	// binary
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != want {
+		err = fmt.Errorf("%s does not match its signed SHA-256 checksum", u.Redacted())
+	}
+	if err == nil {
+		err = tmp.Chmod(0o755)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return err
+	}
+	installed.version = update.Version
+	fmt.Fprintf(out.Log, "Installed version %s, which takes over from the next start\n", update.Version)
+	return nil
+}
+
+// runSignUpdate generates the key pair for -update-key, or signs binaries
+// for the Update of a collector's keys.json.
+func runSignUpdate(args []string) int {
+	fs := flag.NewFlagSet("sign-update", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go-fastcli sign-update -generate-key file\n       go-fastcli sign-update -key file -version version [-url url] os/arch=binary...\n\n")
+		fs.PrintDefaults()
+	}
+	generate := fs.String("generate-key", "", "write a new private key to `file` and print its public key for -update-key")
+	keyFile := fs.String("key", "", "sign with the private key in `file`")
+	version := fs.String("version", "", "`version` of the binaries")
+	downloadURL := fs.String("url", "", "download `url` of the binaries, with {os} and {arch}, to include in the output")
+	ParseFlags(fs, args)
+
+	if *generate != "" {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			fmt.Fprintln(out.Log, "Error:", err)
+			return 1
+		}
+		seed := base64.StdEncoding.EncodeToString(private.Seed()) + "\n"
+		f, err := os.OpenFile(*generate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			_, err = f.WriteString(seed)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(out.Log, "Error:", err)
+			return 1
+		}
+		fmt.Fprintln(out.Data, base64.StdEncoding.EncodeToString(public))
+		return 0
+	}
+
+	if *keyFile == "" || *version == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	data, err := os.ReadFile(*keyFile)
+	if err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		fmt.Fprintf(out.Log, "Error: %s is not a key written by -generate-key\n", *keyFile)
+		return 1
+	}
+	private := ed25519.NewKeyFromSeed(seed)
+	update := UpdateInfo{Version: *version, URL: *downloadURL, SHA256: map[string]string{}, Signature: map[string]string{}}
+	for _, arg := range fs.Args() {
+		platform, path, ok := strings.Cut(arg, "=")
+		if !ok || !strings.Contains(platform, "/") {
+			fmt.Fprintf(out.Log, "Error: %q is not os/arch=binary\n", arg)
+			return 2
+		}
+		digest, err := fileSHA256(path)
+		if err != nil {
+			fmt.Fprintln(out.Log, "Error:", err)
+			return 1
+		}
+		update.SHA256[platform] = digest
+		update.Signature[platform] = base64.StdEncoding.EncodeToString(ed25519.Sign(private, updateMessage(*version, platform, digest)))
+	}
+	enc := json.NewEncoder(out.Data)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(update); err != nil {
+		fmt.Fprintln(out.Log, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+// fileSHA256 returns the hex SHA-256 digest of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// A running executable can't be replaced everywhere, e.g. on Windows.
+const canSelfUpdate = false
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSelfUpdateRefuses(t *testing.T) {
+	if !canSelfUpdate {
+		t.Skip("self-update is not supported on " + runtime.GOOS)
+	}
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	digest := strings.Repeat("ab", 32)
+	sign := func(version, platform string) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(private, updateMessage(version, platform, digest)))
+	}
+	signed := func(url string) UpdateInfo {
+		return UpdateInfo{
+			Version:   "99.0.0",
+			URL:       url,
+			SHA256:    map[string]string{platform: digest},
+			Signature: map[string]string{platform: sign("99.0.0", platform)},
+		}
+	}
+	otherKey, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name   string
+		update UpdateInfo
+		key    ed25519.PublicKey
+		want   string
+	}{
+		{"no key", signed("https://example.com/b"), nil, "-update-key"},
+		{"other key", signed("https://example.com/b"), otherKey, "doesn't match -update-key"},
+		{"plain http", signed("http://example.com/b"), public, "isn't https"},
+		{"unsigned", UpdateInfo{Version: "99.0.0", URL: "https://example.com/b", SHA256: map[string]string{platform: digest}}, public, "no valid signature"},
+		{"no checksum", UpdateInfo{Version: "99.0.0", URL: "https://example.com/b"}, public, "no checksum"},
+		{"signature of another version", UpdateInfo{
+			Version:   "99.0.0",
+			URL:       "https://example.com/b",
+			SHA256:    map[string]string{platform: digest},
+			Signature: map[string]string{platform: sign("98.0.0", platform)},
+		}, public, "doesn't match -update-key"},
+		{"signature for another platform", UpdateInfo{
+			Version:   "99.0.0",
+			URL:       "https://example.com/b",
+			SHA256:    map[string]string{platform: digest},
+			Signature: map[string]string{platform: sign("99.0.0", "plan9/mips")},
+		}, public, "doesn't match -update-key"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := SelfUpdate(context.Background(), test.update, test.key)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestUpdateKeyFlag(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key ed25519.PublicKey
+	flag := updateKey{&key}
+	if err := flag.Set(base64.StdEncoding.EncodeToString(public) + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(public) || flag.String() != base64.StdEncoding.EncodeToString(public) {
+		t.Errorf("got key %s", flag.String())
+	}
+	for _, value := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if err := flag.Set(value); err == nil {
+			t.Errorf("%q: got no error", value)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// sinkTimeout bounds each request to a sink, reading the response included,
+// so an endpoint that stops answering can't hold up the run.
+const sinkTimeout = 30 * time.Second
+
+// sinkClient is the HTTP client of the sinks. It uses http.DefaultTransport,
+// which -verbose wraps to log their requests.
+var sinkClient = &http.Client{Timeout: sinkTimeout}
+
+// DeliverResult sends a finished result to every configured sink. onError is
+// called for each sink that fails, so one broken destination doesn't keep the
+// result from the others.
+func DeliverResult(ctx context.Context, cfg Config, result Result, onError func(sink string, err error)) {
+	var update *UpdateInfo
+	if cfg.CollectorURL != "" {
+		if err := SubmitResult(ctx, cfg.CollectorURL, cfg.CollectorKey, result); err != nil {
+			var mismatch *VersionMismatchError
+			if cfg.SelfUpdate && errors.As(err, &mismatch) && mismatch.Update != nil {
+				update = mismatch.Update
+			}
+			onError("collector", err)
+		}
+	}
+	if cfg.HistoryDB != "" {
+		store, err := OpenHistory(cfg.HistoryBackend, cfg.HistoryDB)
+		if err == nil {
+			err = store.Add(result)
+		}
+		if err != nil {
+			onError("history", err)
+		}
+	}
+	if cfg.RemoteWriteURL != "" {
+		if err := RemoteWrite(ctx, cfg.RemoteWriteURL, RemoteWriteSeries(result)); err != nil {
+			onError("remote-write", err)
+		}
+	}
+	if cfg.StatsDAddr != "" {
+		if err := SendStatsD(ctx, cfg.StatsDAddr, cfg.StatsDTags, result); err != nil {
+			onError("statsd", err)
+		}
+	}
+	if cfg.GraphiteAddr != "" {
+		if err := SendGraphite(ctx, cfg.GraphiteAddr, cfg.GraphitePrefix, result); err != nil {
+			onError("graphite", err)
+		}
+	}
+	if cfg.WebhookURL != "" {
+		var tmpl *template.Template
+		var err error
+		if cfg.WebhookTemplate != "" {
+			tmpl, err = LoadWebhookTemplate(cfg.WebhookTemplate)
+		}
+		if err == nil {
+			err = SendWebhook(ctx, cfg.WebhookURL, cfg.WebhookHeaders, tmpl, result)
+		}
+		if err != nil {
+			onError("webhook", err)
+		}
+	}
+	if notifyEnabled(cfg) {
+		Notify(ctx, cfg, result, onError)
+	}
+	if len(cfg.EmailTo) > 0 {
+		result.Samples, result.LoadedLatency = nil, nil
+		report := EmailReport{Start: result.Time, End: time.Now(), Results: []Result{result}}
+		if err := SendEmailReport(ctx, cfg, report); err != nil {
+			onError("email", err)
+		}
+	}
+	// last, so a slow download doesn't hold up the other sinks
+	if update != nil {
+		if err := SelfUpdate(ctx, *update, cfg.UpdateKey); err != nil {
+			onError("collector", fmt.Errorf("self-update: %w", err))
+		}
+	}
+}
+
+func printSinkError(sink string, err error) {
+	fmt.Fprintf(out.Log, "Error sending result to %s: %v\n", sink, err)
+}
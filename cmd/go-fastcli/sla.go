@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Plan is a subscribed service level, e.g. 500/50 Mbit/s where 95% of
+// measurements must reach at least 80% of the advertised speeds.
+type Plan struct {
+	DownloadMbps    float64
+	UploadMbps      float64
+	MinPercent      float64
+	RequiredPercent float64
+}
+
+// ParsePlanSpeeds parses "download/upload" in Mbit/s, e.g. "500/50".
+func ParsePlanSpeeds(s string) (down, up float64, err error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("plan must look like 500/50 (download/upload Mbit/s)")
+	}
+	if down, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64); err != nil || down <= 0 {
+		return 0, 0, fmt.Errorf("invalid plan download speed %q", parts[0])
+	}
+	if up, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64); err != nil || up <= 0 {
+		return 0, 0, fmt.Errorf("invalid plan upload speed %q", parts[1])
+	}
+	return down, up, nil
+}
+
+// PlanGrade is a run's speeds as a percentage of the subscribed plan.
+type PlanGrade struct {
+	DownloadMbps    float64 `json:",omitempty"`
+	UploadMbps      float64 `json:",omitempty"`
+	DownloadPercent float64 `json:",omitempty"`
+	UploadPercent   float64 `json:",omitempty"`
+}
+
+// GradePlan grades the summary against plan speeds of downMbps and upMbps.
+// A zero plan speed, or a phase skipped in cfg or with insufficient data,
+// isn't graded.
+func GradePlan(cfg Config, s Summary, downMbps, upMbps float64) PlanGrade {
+	var g PlanGrade
+	if downMbps > 0 && !cfg.SkipDownload && !s.lacks("download") {
+		g.DownloadMbps, g.DownloadPercent = downMbps, s.DownloadMbps/downMbps*100
+	}
+	if upMbps > 0 && !cfg.SkipUpload && !s.lacks("upload") {
+		g.UploadMbps, g.UploadPercent = upMbps, s.UploadMbps/upMbps*100
+	}
+	return g
+}
+
+// WritePlanGrades summarizes how often the records reached minPercent of
+// the plan, e.g. "you got at least 90% of plan in 84% of tests". A zero plan
+// speed is left out, as are the records with insufficient data of a speed.
+func WritePlanGrades(w io.Writer, records []HistoryRecord, downMbps, upMbps, minPercent float64) {
+	if len(records) == 0 {
+		return
+	}
+	line := func(name string, plan float64, value func(HistoryRecord) float64) {
+		if plan <= 0 {
+			return
+		}
+		ok, n, sum := 0, 0, 0.0
+		for _, r := range records {
+			if math.IsNaN(value(r)) {
+				continue
+			}
+			percent := value(r) / plan * 100
+			sum += percent
+			n++
+			if percent >= minPercent {
+				ok++
+			}
+		}
+		if n == 0 {
+			fmt.Fprintf(w, "%s: insufficient data\n", name)
+			return
+		}
+		fmt.Fprintf(w, "%s: you got at least %g%% of your %g Mbit/s plan in %0.0f%% of tests (%d of %d), %0.1f%% of plan on average\n",
+			name, minPercent, plan, float64(ok)/float64(n)*100, ok, n, sum/float64(n))
+	}
+	line("Download", downMbps, func(r HistoryRecord) float64 { return r.DownloadMbps })
+	line("Upload", upMbps, func(r HistoryRecord) float64 { return r.UploadMbps })
+}
+
+type ComplianceReport struct {
+	Plan         Plan
+	Since        time.Time
+	Until        time.Time
+	Measurements int
+	// DownloadMeasured and UploadMeasured count the measurements with
+	// enough samples of the speed, which the ratios are out of.
+	DownloadMeasured int
+	UploadMeasured   int
+	DownloadOK       int
+	UploadOK         int
+	Below            []HistoryRecord
+	DownloadRatio    float64
+	UploadRatio      float64
+	Compliant        bool
+}
+
+func ComputeCompliance(plan Plan, records []HistoryRecord) ComplianceReport {
+	report := ComplianceReport{Plan: plan, Measurements: len(records)}
+	downFloor := plan.DownloadMbps * plan.MinPercent / 100
+	upFloor := plan.UploadMbps * plan.MinPercent / 100
+	for _, record := range records {
+		// a speed with insufficient data counts neither way
+		downOK, upOK := true, true
+		if !record.lacks("download") {
+			report.DownloadMeasured++
+			downOK = record.DownloadMbps >= downFloor
+			if downOK {
+				report.DownloadOK++
+			}
+		}
+		if !record.lacks("upload") {
+			report.UploadMeasured++
+			upOK = record.UploadMbps >= upFloor
+			if upOK {
+				report.UploadOK++
+			}
+		}
+		if !downOK || !upOK {
+			report.Below = append(report.Below, record)
+		}
+	}
+	if report.DownloadMeasured > 0 {
+		report.DownloadRatio = float64(report.DownloadOK) / float64(report.DownloadMeasured) * 100
+	}
+	if report.UploadMeasured > 0 {
+		report.UploadRatio = float64(report.UploadOK) / float64(report.UploadMeasured) * 100
+	}
+	if report.Measurements > 0 {
+		report.Compliant = report.DownloadRatio >= plan.RequiredPercent && report.UploadRatio >= plan.RequiredPercent
+	}
+	return report
+}
+
+func verdict(ok bool) string {
+	if ok {
+		return "PASS"
+	}
+	return "FAIL"
+}
+
+func formatBound(t time.Time, fallback string) string {
+	if t.IsZero() {
+		return fallback
+	}
+	return t.Format("2006-01-02 15:04 MST")
+}
+
+func (r ComplianceReport) Write(w io.Writer) {
+	plan := r.Plan
+	fmt.Fprintln(w, "SLA Compliance Report")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Plan: %g/%g Mbit/s, %g%% of measurements must reach %g%% of plan (%g/%g Mbit/s)\n",
+		plan.DownloadMbps, plan.UploadMbps, plan.RequiredPercent, plan.MinPercent,
+		plan.DownloadMbps*plan.MinPercent/100, plan.UploadMbps*plan.MinPercent/100)
+	fmt.Fprintf(w, "Period: %s to %s\n", formatBound(r.Since, "first measurement"), formatBound(r.Until, "now"))
+	fmt.Fprintf(w, "Measurements: %d\n", r.Measurements)
+	if r.Measurements == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "  - Download: %d/%d compliant (%0.1f%%) %s\n", r.DownloadOK, r.DownloadMeasured, r.DownloadRatio, verdict(r.DownloadRatio >= plan.RequiredPercent))
+	fmt.Fprintf(w, "  - Upload: %d/%d compliant (%0.1f%%) %s\n", r.UploadOK, r.UploadMeasured, r.UploadRatio, verdict(r.UploadRatio >= plan.RequiredPercent))
+	if left := 2*r.Measurements - r.DownloadMeasured - r.UploadMeasured; left > 0 {
+		fmt.Fprintf(w, "    (%d speeds with insufficient data left out)\n", left)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Overall: %s\n", verdict(r.Compliant))
+	if len(r.Below) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Measurements below plan:")
+		for _, record := range r.Below {
+			speed := func(v float64) string {
+				if math.IsNaN(v) {
+					return "insufficient data"
+				}
+				return fmt.Sprintf("%0.3f Mbit/s", v)
+			}
+			fmt.Fprintf(w, "  - %s %s: %s down, %s up\n", record.Time.Format("2006-01-02 15:04 MST"), record.Server, speed(record.DownloadMbps), speed(record.UploadMbps))
+		}
+	}
+}
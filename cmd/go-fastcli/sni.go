@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// sniShapingRatio flags a target when its speed with its own server name is
+// below this fraction of the speed with the innocuous name.
+const sniShapingRatio = 0.7
+
+type SNIResult struct {
+	Host          string
+	IP            string
+	RealMbps      float64
+	InnocuousMbps float64
+}
+
+// Shaped reports whether the real server name got noticeably less bandwidth.
+func (r SNIResult) Shaped() bool {
+	return r.InnocuousMbps > 0 && r.RealMbps/r.InnocuousMbps < sniShapingRatio
+}
+
+// RunSNICheck downloads from each target twice at the same time, from the
+// same IP address: once with the target's own TLS server name and once with
+// serverName. Shaping that keys on the Netflix host name shows up as a big
+// difference between the two.
+func RunSNICheck(ctx context.Context, cfg Config, serverName string, w io.Writer) ([]SNIResult, error) {
+	var servers []fastcom.Server
+	var err error
+	if cfg.TargetsFile != "" {
+		_, servers, err = fastcom.LoadServerList(cfg.TargetsFile)
+	} else {
+		_, servers, err = fastcom.NewClient().GetServerList(ctx, 1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pinned, err := ParseResolve(cfg.Resolve)
+	if err != nil {
+		return nil, err
+	}
+	var results []SNIResult
+	for _, server := range servers {
+		u, err := url.Parse(server.URL)
+		if err != nil {
+			return results, err
+		}
+		if u.Scheme != "https" {
+			return results, fmt.Errorf("%s: the TLS server name can only be compared on HTTPS targets", server.URL)
+		}
+		r := SNIResult{Host: u.Hostname(), IP: pinned[u.Hostname()]}
+		if r.IP == "" {
+			addrs, err := net.DefaultResolver.LookupIPAddr(ctx, r.Host)
+			if err != nil {
+				return results, err
+			}
+			r.IP = addrs[0].IP.String()
+		}
+		fmt.Fprintf(w, "Testing %s (%s) with its own server name and as %s...\n", r.Host, r.IP, serverName)
+
+		// both clients connect to the same address so only the name differs
+		real, innocuous := fastcom.NewClient(), fastcom.NewClient()
+		for _, c := range []*fastcom.Client{real, innocuous} {
+			if err := c.SetCipher(cfg.TLSCipher); err != nil {
+				return results, err
+			}
+			if err := c.Resolve(r.Host, r.IP); err != nil {
+				return results, err
+			}
+		}
+		innocuous.SetServerName(serverName)
+
+		var wg sync.WaitGroup
+		var realErr, innocuousErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var t fastcom.Throughput
+			t, realErr = real.MeasureDownload(ctx, server.URL, fastcom.DefaultMeasureConfig)
+			r.RealMbps = t.Mbps
+		}()
+		go func() {
+			defer wg.Done()
+			var t fastcom.Throughput
+			t, innocuousErr = innocuous.MeasureDownload(ctx, server.URL, fastcom.DefaultMeasureConfig)
+			r.InnocuousMbps = t.Mbps
+		}()
+		wg.Wait()
+		if realErr != nil {
+			return results, fmt.Errorf("downloading as %s: %w", r.Host, realErr)
+		}
+		if innocuousErr != nil {
+			return results, fmt.Errorf("downloading as %s: %w", serverName, innocuousErr)
+		}
+		results = append(results, r)
+
+		fmt.Fprintf(w, "  - as %s: %0.3f Mbit/s\n", r.Host, r.RealMbps)
+		fmt.Fprintf(w, "  - as %s: %0.3f Mbit/s\n", serverName, r.InnocuousMbps)
+		if r.Shaped() {
+			fmt.Fprintf(w, "  Traffic named %s got %0.0f%% of the speed: it may be shaped by host name.\n", r.Host, r.RealMbps/r.InnocuousMbps*100)
+		} else {
+			fmt.Fprintln(w, "  No sign of shaping by host name.")
+		}
+	}
+	return results, nil
+}
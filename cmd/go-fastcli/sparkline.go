@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// sparkWindow is how many seconds of samples the sparkline shows.
+const sparkWindow = 30
+
+// sparkBlocks are the levels of a sparkline, lowest first.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// progressBarWidth is the width of the -progress bar in cells.
+const progressBarWidth = 20
+
+// LiveLine is the line a transfer phase redraws on a terminal as each second
+// completes: the current speed, after the -sparkline of the last
+// sparkWindow seconds, so a steady link shows as a flat line and a wobbly
+// one as a jagged one, and the -progress bar of the phase's time.
+type LiveLine struct {
+	W     io.Writer
+	Spark bool
+	Bar   bool
+	// Deadline is when the phase's share of -max-total-time runs out, or
+	// zero without one, when only the elapsed time is known.
+	Started  time.Time
+	Deadline time.Time
+	// ANSI is set when the terminal understands escapes. Without, the
+	// line is overwritten with spaces instead of erased.
+	ANSI  bool
+	mbps  []float64
+	width int
+}
+
+// Add draws the line with sample added. Like Sampler.OnSample, it is called
+// from the sampling goroutine only.
+func (l *LiveLine) Add(sample Sample) {
+	l.mbps = append(l.mbps, float64(sample.Bytes)/125000)
+	if len(l.mbps) > sparkWindow {
+		l.mbps = l.mbps[len(l.mbps)-sparkWindow:]
+	}
+	line := " "
+	if l.Bar {
+		elapsed := time.Since(l.Started)
+		if l.Deadline.IsZero() {
+			line += fmt.Sprintf(" %s elapsed", elapsed.Round(time.Second))
+		} else {
+			total := l.Deadline.Sub(l.Started)
+			remaining := time.Until(l.Deadline)
+			if remaining < 0 {
+				remaining = 0
+			}
+			line += fmt.Sprintf(" [%s] %s of %s, %s left", progressBar(float64(elapsed)/float64(total), progressBarWidth),
+				elapsed.Round(time.Second), total.Round(time.Second), remaining.Round(time.Second))
+		}
+	}
+	if l.Spark {
+		line += " " + sparkline(l.mbps)
+	}
+	line += fmt.Sprintf(" %0.1f Mbit/s", l.mbps[len(l.mbps)-1])
+	l.draw(line)
+}
+
+// draw replaces the line on the terminal with line.
+func (l *LiveLine) draw(line string) {
+	width := utf8.RuneCountInString(line)
+	if l.ANSI {
+		fmt.Fprint(l.W, "\r\033[K"+line)
+	} else if pad := l.width - width; pad > 0 {
+		fmt.Fprint(l.W, "\r"+line+strings.Repeat(" ", pad)+"\r"+line)
+	} else {
+		fmt.Fprint(l.W, "\r"+line)
+	}
+	l.width = width
+}
+
+// Clear erases the line, once the sampler has stopped, for the phase's
+// result.
+func (l *LiveLine) Clear() {
+	if len(l.mbps) > 0 {
+		l.draw("")
+	}
+}
+
+// progressBar is a bar of width cells, done of which is filled.
+func progressBar(done float64, width int) string {
+	filled := int(done * float64(width))
+	if filled > width {
+		filled = width
+	}
+	if filled < 0 {
+		filled = 0
+	}
+	return strings.Repeat("#", filled) + strings.Repeat("-", width-filled)
+}
+
+// sparkline scales values to the highest of them, so it shows how steady
+// the speed is rather than how fast.
+func sparkline(values []float64) string {
+	var max float64
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	line := make([]rune, len(values))
+	for i, v := range values {
+		level := 0
+		if max > 0 {
+			level = int(v / max * float64(len(sparkBlocks)-1))
+		}
+		line[i] = sparkBlocks[level]
+	}
+	return string(line)
+}
+
+// liveSamples returns the sampler callback of a transfer phase running
+// within ctx, which draws the -sparkline and -progress bar when the report
+// goes to a terminal, and the func that clears them when the phase ends.
+func liveSamples(ctx context.Context, cfg Config, emit func(Event)) (onSample func(Sample), done func()) {
+	emitted := emitSample(emit)
+	if !cfg.Sparkline && !cfg.ProgressBar || !isTerminal(out.Progress) {
+		return emitted, func() {}
+	}
+	live := &LiveLine{W: out.Progress, Spark: cfg.Sparkline, Bar: cfg.ProgressBar, Started: time.Now(), ANSI: ansiTerminal(out.Progress)}
+	live.Deadline, _ = ctx.Deadline()
+	return func(sample Sample) {
+		emitted(sample)
+		live.Add(sample)
+	}, live.Clear
+}
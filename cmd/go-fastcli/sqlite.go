@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS results (
+	time TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	asn TEXT NOT NULL,
+	server TEXT NOT NULL,
+	ping_ms REAL,
+	jitter_ms REAL,
+	download_mbps REAL,
+	upload_mbps REAL,
+	bytes_used INTEGER NOT NULL,
+	anomaly TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS results_time ON results (time);
+`
+
+// SQLiteHistory stores results in a SQLite database, one row per server. It
+// drives the sqlite3 command instead of linking a driver, so the binary
+// stays free of cgo and dependencies; sqlite3 3.33 or later must be
+// installed.
+type SQLiteHistory struct {
+	Path string
+}
+
+// DefaultHistoryDB returns $XDG_DATA_HOME/go-fastcli/results.db, falling
+// back to ~/.local/share.
+func DefaultHistoryDB() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "results.db"
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "go-fastcli", "results.db")
+}
+
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func (h SQLiteHistory) run(sql string, args ...string) ([]byte, error) {
+	cmd := exec.Command("sqlite3", append(append([]string{"-batch", "-bail"}, args...), h.Path)...)
+	cmd.Stdin = strings.NewReader(sql)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("sqlite3: %s", msg)
+		}
+		return nil, fmt.Errorf("sqlite3: %w", err)
+	}
+	return out, nil
+}
+
+// Add stores the result, creating the database if needed.
+func (h SQLiteHistory) Add(result Result) error {
+	if err := os.MkdirAll(filepath.Dir(h.Path), 0o755); err != nil {
+		return err
+	}
+	if err := h.migrate(); err != nil {
+		return err
+	}
+	var sql strings.Builder
+	sql.WriteString("BEGIN;\n")
+	writeInserts(&sql, result)
+	sql.WriteString("COMMIT;\n")
+	_, err := h.run(sql.String())
+	return err
+}
+
+// sqliteNullable rebuilds a results table from before measurements could be
+// NULL, keeping the rowids the records are known by. SQLite can't drop a NOT
+// NULL constraint in place.
+const sqliteNullable = `BEGIN;
+ALTER TABLE results RENAME TO results_old;
+DROP INDEX results_time;
+` + sqliteSchema + `INSERT INTO results (rowid, time, ip, asn, server, ping_ms, jitter_ms, download_mbps, upload_mbps, bytes_used, anomaly)
+	SELECT rowid, time, ip, asn, server, ping_ms, jitter_ms, download_mbps, upload_mbps, bytes_used, anomaly FROM results_old;
+DROP TABLE results_old;
+COMMIT;
+`
+
+// migrate creates the results table, or brings an existing one up to date:
+// it adds the columns of later versions, as SQLite has no ADD COLUMN IF NOT
+// EXISTS, and lets the measurements be NULL.
+func (h SQLiteHistory) migrate() error {
+	out, err := h.run(sqliteSchema + "SELECT count(*) FROM pragma_table_info('results') WHERE name = 'anomaly';\n" +
+		"SELECT \"notnull\" FROM pragma_table_info('results') WHERE name = 'ping_ms';\n")
+	if err != nil {
+		return err
+	}
+	columns := strings.Fields(string(out))
+	if len(columns) != 2 {
+		return fmt.Errorf("sqlite3: unexpected output %q", out)
+	}
+	if columns[0] == "0" {
+		if _, err := h.run("ALTER TABLE results ADD COLUMN anomaly TEXT NOT NULL DEFAULT '';\n"); err != nil {
+			return err
+		}
+	}
+	if columns[1] == "1" {
+		_, err = h.run(sqliteNullable)
+	}
+	return err
+}
+
+// Records returns the stored measurements in [since, until), oldest first.
+// Zero times leave the range open.
+func (h SQLiteHistory) Records(since, until time.Time) ([]HistoryRecord, error) {
+	if _, err := os.Stat(h.Path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s does not exist; store results in it with -db first", h.Path)
+	} else if err != nil {
+		return nil, err
+	}
+	query := "SELECT rowid AS id, * FROM results WHERE 1"
+	if !since.IsZero() {
+		query += " AND time >= " + sqlQuote(since.UTC().Format(time.RFC3339))
+	}
+	if !until.IsZero() {
+		query += " AND time < " + sqlQuote(until.UTC().Format(time.RFC3339))
+	}
+	return h.query(query + " ORDER BY time")
+}
+
+// Record returns the record with the given ID, as listed by history.
+func (h SQLiteHistory) Record(id int64) (HistoryRecord, error) {
+	if _, err := os.Stat(h.Path); err != nil {
+		return HistoryRecord{}, err
+	}
+	records, err := h.query(fmt.Sprintf("SELECT rowid AS id, * FROM results WHERE rowid = %d", id))
+	if err != nil {
+		return HistoryRecord{}, err
+	}
+	if len(records) == 0 {
+		return HistoryRecord{}, fmt.Errorf("no record %d in %s", id, h.Path)
+	}
+	return records[0], nil
+}
+
+func (h SQLiteHistory) query(query string) ([]HistoryRecord, error) {
+	out, err := h.run(sqliteSchema+query+";\n", "-json")
+	if err != nil {
+		return nil, err
+	}
+	var rows []historyRow
+	// sqlite3 prints nothing at all for an empty result
+	if len(bytes.TrimSpace(out)) > 0 {
+		if err := json.Unmarshal(out, &rows); err != nil {
+			return nil, fmt.Errorf("reading sqlite3 output: %w", err)
+		}
+	}
+	return historyRecords(rows), nil
+}
+
+// writeInserts adds a row per server of result to the results table of the
+// SQL backends. The measurements of a phase with insufficient data, and
+// those that aren't finite, are NULL: %g would write NaN and +Inf, which
+// SQLite takes for column names and fails the insert.
+func writeInserts(sql *strings.Builder, result Result) {
+	ts := result.Time.UTC().Format(time.RFC3339)
+	anomaly := strings.Join(result.Anomalies, "; ")
+	for _, server := range result.Servers {
+		fmt.Fprintf(sql, "INSERT INTO results (time, ip, asn, server, ping_ms, jitter_ms, download_mbps, upload_mbps, bytes_used, anomaly) VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %d, %s);\n",
+			sqlQuote(ts), sqlQuote(result.Connection.IP), sqlQuote(result.Connection.ASN), sqlQuote(server.Host),
+			sqlNumber(storedValue(server, "latency", server.LatencyMs)), sqlNumber(storedValue(server, "latency", server.JitterMs)),
+			sqlNumber(storedValue(server, "download", server.DownloadMbps)), sqlNumber(storedValue(server, "upload", server.UploadMbps)),
+			int64(server.DownloadMB+server.UploadMB)*1024*1024, sqlQuote(anomaly))
+	}
+}
+
+// sqlNumber formats a measurement for SQL, NULL if there is none or it
+// isn't finite.
+func sqlNumber(v *float64) string {
+	if v == nil || math.IsNaN(*v) || math.IsInf(*v, 0) {
+		return "NULL"
+	}
+	return strconv.FormatFloat(*v, 'g', -1, 64)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// SendStatsD sends the download, upload and ping of result as gauges to the
+// StatsD server at addr, in one UDP packet. With tags, each server gets its
+// own gauges with DogStatsD tags for the server and IP family; otherwise the
+// averages across servers are sent. Phases that were skipped or had
+// insufficient data are left out.
+func SendStatsD(ctx context.Context, addr string, tags bool, result Result) error {
+	var packet strings.Builder
+	gauge := func(name string, value float64, tagList []string) {
+		if value == 0 {
+			return
+		}
+		fmt.Fprintf(&packet, "fastcli.%s:%g|g", name, value)
+		if len(tagList) > 0 {
+			packet.WriteString("|#" + strings.Join(tagList, ","))
+		}
+		packet.WriteByte('\n')
+	}
+	if tags {
+		for _, server := range result.Servers {
+			tagList := []string{"server:" + statsdTag(server.Host)}
+			if family := ipFamily(result.Connection.IP); family != "" {
+				tagList = append(tagList, "family:"+family)
+			}
+			if !server.lacks("download") {
+				gauge("download_mbps", server.DownloadMbps, tagList)
+			}
+			if !server.lacks("upload") {
+				gauge("upload_mbps", server.UploadMbps, tagList)
+			}
+			if !server.lacks("latency") {
+				gauge("ping_ms", server.LatencyMs, tagList)
+			}
+		}
+	} else {
+		summary := result.Summary()
+		if !summary.lacks("download") {
+			gauge("download_mbps", summary.DownloadMbps, nil)
+		}
+		if !summary.lacks("upload") {
+			gauge("upload_mbps", summary.UploadMbps, nil)
+		}
+		if !summary.lacks("latency") {
+			gauge("ping_ms", summary.PingMs, nil)
+		}
+	}
+	if packet.Len() == 0 {
+		return nil
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(strings.TrimSuffix(packet.String(), "\n")))
+	return err
+}
+
+// statsdTag replaces the characters that delimit DogStatsD tags.
+func statsdTag(s string) string {
+	return strings.NewReplacer(",", "_", "|", "_", "#", "_").Replace(s)
+}
+
+// ipFamily returns "ipv4" or "ipv6" for ip, or "" if it isn't an address.
+func ipFamily(ip string) string {
+	addr := net.ParseIP(ip)
+	switch {
+	case addr == nil:
+		return ""
+	case addr.To4() != nil:
+		return "ipv4"
+	}
+	return "ipv6"
+}
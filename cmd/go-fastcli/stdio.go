@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcBusy           = 1
+	rpcNotRunning     = 2
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  interface{}     `json:"params,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type startParams struct {
+	Latency  *bool `json:"latency"`
+	Download *bool `json:"download"`
+	Upload   *bool `json:"upload"`
+}
+
+type progressResult struct {
+	Running   bool    `json:"running"`
+	Phase     string  `json:"phase,omitempty"`
+	LastEvent *Event  `json:"last_event,omitempty"`
+	Result    *Result `json:"result,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// StdioServer lets a parent process drive tests with JSON-RPC 2.0 requests,
+// one per line on stdin. Responses and "event"/"result" notifications are
+// written one per line to stdout.
+type StdioServer struct {
+	Config Config
+
+	out      *json.Encoder
+	outMu    sync.Mutex
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	progress progressResult
+	done     chan struct{}
+}
+
+func (s *StdioServer) send(msg rpcMessage) {
+	msg.JSONRPC = "2.0"
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.out.Encode(msg)
+}
+
+func (s *StdioServer) reply(id json.RawMessage, result interface{}, err *rpcError) {
+	// Requests without an id are notifications and get no response.
+	if id == nil {
+		return
+	}
+	s.send(rpcMessage{ID: id, Result: result, Error: err})
+}
+
+func (s *StdioServer) onEvent(evt Event) {
+	s.mu.Lock()
+	if evt.Type == EventPhaseStart {
+		s.progress.Phase = evt.Phase
+	}
+	s.progress.LastEvent = &evt
+	s.mu.Unlock()
+	s.send(rpcMessage{Method: "event", Params: evt})
+}
+
+func (s *StdioServer) start(ctx context.Context, params startParams) *rpcError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.progress.Running {
+		return &rpcError{Code: rpcBusy, Message: "a test is already running"}
+	}
+	cfg := s.Config
+	if params.Latency != nil {
+		cfg.SkipLatency = !*params.Latency
+	}
+	if params.Download != nil {
+		cfg.SkipDownload = !*params.Download
+	}
+	if params.Upload != nil {
+		cfg.SkipUpload = !*params.Upload
+	}
+	cfg.OnEvent = s.onEvent
+
+	runCtx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	s.progress = progressResult{Running: true}
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		defer cancel()
+		result, err := RunTest(runCtx, cfg)
+		s.mu.Lock()
+		s.progress.Running = false
+		if err != nil {
+			s.progress.Error = err.Error()
+		} else {
+			s.progress.Result = &result
+		}
+		s.mu.Unlock()
+		if err != nil {
+			s.onEvent(Event{Type: EventError, Error: err.Error()})
+			return
+		}
+		s.send(rpcMessage{Method: "result", Params: result})
+	}()
+	return nil
+}
+
+func (s *StdioServer) handle(ctx context.Context, req rpcRequest) {
+	switch req.Method {
+	case "start":
+		var params startParams
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				s.reply(req.ID, nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()})
+				return
+			}
+		}
+		if err := s.start(ctx, params); err != nil {
+			s.reply(req.ID, nil, err)
+			return
+		}
+		s.reply(req.ID, map[string]bool{"started": true}, nil)
+	case "cancel":
+		s.mu.Lock()
+		running, cancel := s.progress.Running, s.cancel
+		s.mu.Unlock()
+		if !running {
+			s.reply(req.ID, nil, &rpcError{Code: rpcNotRunning, Message: "no test is running"})
+			return
+		}
+		cancel()
+		s.reply(req.ID, map[string]bool{"cancelled": true}, nil)
+	case "progress":
+		s.mu.Lock()
+		progress := s.progress
+		s.mu.Unlock()
+		s.reply(req.ID, progress, nil)
+	default:
+		s.reply(req.ID, nil, &rpcError{Code: rpcMethodNotFound, Message: "unknown method " + req.Method})
+	}
+}
+
+// Serve handles requests until in is closed or ctx is cancelled, then waits
+// for a running test to stop.
+func (s *StdioServer) Serve(ctx context.Context, in io.Reader, out io.Writer) error {
+	s.out = json.NewEncoder(out)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			s.send(rpcMessage{ID: json.RawMessage("null"), Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			s.reply(req.ID, nil, &rpcError{Code: rpcInvalidRequest, Message: "expected a JSON-RPC 2.0 request"})
+			continue
+		}
+		s.handle(ctx, req)
+	}
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	return scanner.Err()
+}
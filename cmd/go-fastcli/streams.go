@@ -0,0 +1,29 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// Streams decides where each kind of output goes, so a command never has to.
+// Data is what a caller may parse or pipe: results, reports and the text
+// report in the text format. Progress is the running commentary of a test,
+// and Log gets errors and warnings.
+type Streams struct {
+	Data     io.Writer
+	Progress io.Writer
+	Log      io.Writer
+}
+
+// out is shared by all commands. In the text format the progress is the
+// report, so both go to stdout. Secrets are redacted from the log.
+var out = Streams{Data: os.Stdout, Progress: os.Stdout, Log: redactWriter{os.Stderr}}
+
+// MachineReadable keeps stdout for data only by moving progress to stderr,
+// or dropping it if quiet.
+func (s *Streams) MachineReadable(quiet bool) {
+	s.Progress = s.Log
+	if quiet {
+		s.Progress = io.Discard
+	}
+}
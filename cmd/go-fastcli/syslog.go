@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// logPriority is a syslog severity, which journald uses as well.
+type logPriority int
+
+const (
+	priErr     logPriority = 3
+	priWarning logPriority = 4
+	priInfo    logPriority = 6
+	priDebug   logPriority = 7
+)
+
+// resultMessageID is the journald MESSAGE_ID of results, so they can be
+// listed with journalctl MESSAGE_ID=c7225e58b83243109fbef75c8d7ee50b.
+const resultMessageID = "c7225e58b83243109fbef75c8d7ee50b"
+
+// logField is a structured field of a system log message, named as
+// journald wants: upper case letters, digits and underscores.
+type logField struct {
+	Name, Value string
+}
+
+// SystemLog is the system's log the daemon writes to instead of stderr,
+// with -log-to syslog or journald.
+type SystemLog interface {
+	// Send logs msg at priority. Fields are kept apart where the log
+	// supports it, and appended to msg as key=value pairs where not.
+	Send(priority logPriority, msg string, fields []logField) error
+	Close() error
+}
+
+// OpenSystemLog connects to the log named by -log-to.
+func OpenSystemLog(name string) (SystemLog, error) {
+	switch name {
+	case "syslog":
+		return openSyslog()
+	case "journald":
+		return openJournald()
+	}
+	return nil, fmt.Errorf("unknown log destination %q, expected stderr, syslog or journald", name)
+}
+
+// systemLogWriter sends each line written to it as a message, at the
+// priority of its level= in the format of -verbose or its "Error" or
+// "Warning" prefix. The time of -verbose lines is left to the log.
+type systemLogWriter struct {
+	log SystemLog
+
+	mu   sync.Mutex
+	line []byte
+}
+
+func (w *systemLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(w.line[:i])
+		w.line = w.line[i+1:]
+		if line == "" {
+			continue
+		}
+		priority, msg := linePriority(line)
+		if err := w.log.Send(priority, msg, nil); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func linePriority(line string) (logPriority, string) {
+	if strings.HasPrefix(line, "time=") {
+		_, rest, _ := strings.Cut(line, " ")
+		level, msg, _ := strings.Cut(strings.TrimPrefix(rest, "level="), " ")
+		switch level {
+		case "DEBUG":
+			return priDebug, msg
+		case "INFO":
+			return priInfo, msg
+		}
+		return priWarning, msg
+	}
+	switch {
+	case strings.HasPrefix(line, "Error"):
+		return priErr, line
+	case strings.HasPrefix(line, "Warning"):
+		return priWarning, line
+	}
+	return priInfo, line
+}
+
+// logResult sends result to the system log as one message with the
+// averaged measurements as fields, e.g. FASTCLI_DOWNLOAD_MBPS. The fields of
+// a phase with insufficient data are left out.
+func logResult(log SystemLog, result Result) error {
+	s := result.Summary()
+	fields := []logField{{"MESSAGE_ID", resultMessageID}}
+	type measurement struct {
+		field, unit string
+		v           float64
+	}
+	var parts []string
+	measure := func(phase string, values ...measurement) {
+		if s.lacks(phase) {
+			parts = append(parts, "insufficient "+phase+" data")
+			return
+		}
+		for _, m := range values {
+			parts = append(parts, fmt.Sprintf("%0.2f %s", m.v, m.unit))
+			fields = append(fields, logField{m.field, strconv.FormatFloat(m.v, 'f', 3, 64)})
+		}
+	}
+	measure("download", measurement{"FASTCLI_DOWNLOAD_MBPS", "Mbit/s down", s.DownloadMbps})
+	measure("upload", measurement{"FASTCLI_UPLOAD_MBPS", "Mbit/s up", s.UploadMbps})
+	measure("latency", measurement{"FASTCLI_PING_MS", "ms ping", s.PingMs}, measurement{"FASTCLI_JITTER_MS", "ms jitter", s.JitterMs})
+	msg := "Result: " + strings.Join(parts, ", ")
+	fields = append(fields, logField{"FASTCLI_BYTES_USED", strconv.FormatInt(s.BytesUsed, 10)})
+	var servers []string
+	for _, server := range result.Servers {
+		servers = append(servers, server.Host)
+	}
+	fields = append(fields, logField{"FASTCLI_SERVERS", strings.Join(servers, ",")})
+	if result.Connection.ASN != "" {
+		fields = append(fields, logField{"FASTCLI_ASN", result.Connection.ASN})
+	}
+	if result.Connection.ISP != "" {
+		fields = append(fields, logField{"FASTCLI_ISP", result.Connection.ISP})
+	}
+	if result.Connection.Location.Country != "" {
+		fields = append(fields, logField{"FASTCLI_COUNTRY", result.Connection.Location.Country})
+	}
+	priority := priInfo
+	if len(result.Warnings) > 0 {
+		priority = priWarning
+		fields = append(fields, logField{"FASTCLI_WARNINGS", warningCodes(result.Warnings)})
+	}
+	return log.Send(priority, msg, fields)
+}
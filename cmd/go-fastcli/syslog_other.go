@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func openSyslog() (SystemLog, error) {
+	return nil, errors.New("syslog is only available on Unix systems")
+}
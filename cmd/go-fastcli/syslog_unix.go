@@ -0,0 +1,45 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+)
+
+// syslogLog sends messages to the local syslog daemon, in the daemon
+// facility, with the fields appended as key=value pairs.
+type syslogLog struct {
+	w *syslog.Writer
+}
+
+func openSyslog() (SystemLog, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "go-fastcli")
+	if err != nil {
+		return nil, fmt.Errorf("syslog: %w", err)
+	}
+	return &syslogLog{w}, nil
+}
+
+func (l *syslogLog) Send(priority logPriority, msg string, fields []logField) error {
+	for _, field := range fields {
+		if field.Name == "MESSAGE_ID" {
+			continue
+		}
+		msg += fmt.Sprintf(" %s=%s", strings.ToLower(strings.TrimPrefix(field.Name, "FASTCLI_")), logValue(field.Value))
+	}
+	switch priority {
+	case priErr:
+		return l.w.Err(msg)
+	case priWarning:
+		return l.w.Warning(msg)
+	case priDebug:
+		return l.w.Debug(msg)
+	}
+	return l.w.Info(msg)
+}
+
+func (l *syslogLog) Close() error {
+	return l.w.Close()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// WriteTelegraf writes the result as the flat JSON object Telegraf's exec
+// input parses with data_format = "json": numeric fields averaged across
+// servers, an RFC 3339 time, the IP, ASN and ISP and the -cloud-metadata as
+// strings for tag_keys, and the codes of any warnings, comma-separated.
+// Fields of skipped phases, and of phases with insufficient data, are left
+// out rather than reported as 0.
+func WriteTelegraf(w io.Writer, result Result) error {
+	summary := result.Summary()
+	fields := map[string]interface{}{
+		"time":       result.Time.UTC().Format(time.RFC3339),
+		"ip":         result.Connection.IP,
+		"asn":        result.Connection.ASN,
+		"servers":    len(result.Servers),
+		"bytes_used": summary.BytesUsed,
+	}
+	if result.Connection.ISP != "" {
+		fields["isp"] = result.Connection.ISP
+	}
+	set := func(name, phase string, value float64) {
+		if value != 0 && !summary.lacks(phase) {
+			fields[name] = value
+		}
+	}
+	set("ping_ms", "latency", summary.PingMs)
+	set("jitter_ms", "latency", summary.JitterMs)
+	set("download_mbps", "download", summary.DownloadMbps)
+	set("upload_mbps", "upload", summary.UploadMbps)
+	if c := result.Cloud; c != nil {
+		fields["cloud_provider"], fields["instance_type"], fields["region"] = c.Provider, c.InstanceType, c.Region
+		if c.Zone != "" {
+			fields["zone"] = c.Zone
+		}
+	}
+	if len(result.Warnings) > 0 {
+		fields["warnings"] = warningCodes(result.Warnings)
+	}
+	return json.NewEncoder(w).Encode(fields)
+}
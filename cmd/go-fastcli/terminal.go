@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// isTerminal reports whether w writes to a terminal. Pipes, files and
+// /dev/null or NUL are not, though the latter are character devices too.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && isTerminalFile(f)
+}
+
+var (
+	ansiOnce    sync.Once
+	ansiEnabled = map[*os.File]bool{}
+)
+
+// ansiTerminal reports whether w writes to a terminal that understands ANSI
+// escapes, for colors, redrawing a line and the -tui panels. Windows
+// consoles do once virtual terminal processing is switched on, which is
+// tried here the first time; terminals elsewhere always do.
+func ansiTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || !isTerminalFile(f) {
+		return false
+	}
+	ansiOnce.Do(func() {
+		for _, f := range []*os.File{os.Stdout, os.Stderr} {
+			ansiEnabled[f] = isTerminalFile(f) && enableANSI(f)
+		}
+	})
+	enabled, known := ansiEnabled[f]
+	return !known || enabled
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// TrendStats are the minimum, median and 95th percentile of a speed over
+// the runs in a bucket, leaving out those with insufficient data of it.
+type TrendStats struct {
+	Min    float64
+	Median float64
+	P95    float64
+}
+
+// TrendBucket is the runs from one hour of the day, or from one date.
+type TrendBucket struct {
+	Label    string
+	Runs     int
+	Download TrendStats
+	Upload   TrendStats
+}
+
+// Trend groups the runs in records by the local hour of the day they
+// started in (by "hour"), or by their date (by "day"). Buckets without runs
+// are left out; the rest are in the order of the day, or of the calendar.
+func Trend(records []HistoryRecord, by string) ([]TrendBucket, error) {
+	var label func(HistoryRecord) string
+	switch by {
+	case "hour":
+		label = func(r HistoryRecord) string { return fmt.Sprintf("%02d:00", r.Time.Local().Hour()) }
+	case "day":
+		label = func(r HistoryRecord) string { return r.Time.Local().Format("2006-01-02 Mon") }
+	default:
+		return nil, fmt.Errorf("unknown -by %q, expected hour or day", by)
+	}
+
+	type speeds struct {
+		runs     int
+		down, up []float64
+	}
+	var labels []string
+	buckets := map[string]*speeds{}
+	// the records of a run share its time; like Result.Summary, a run's
+	// speed is the mean over the servers that measured it
+	for i := 0; i < len(records); {
+		first := records[i]
+		var run Result
+		for ; i < len(records) && records[i].Time.Equal(first.Time); i++ {
+			run.Servers = append(run.Servers, records[i].serverResult())
+		}
+		b := buckets[label(first)]
+		if b == nil {
+			b = &speeds{}
+			buckets[label(first)] = b
+			labels = append(labels, label(first))
+		}
+		b.runs++
+		s := run.Summary()
+		if !s.lacks("download") {
+			b.down = append(b.down, s.DownloadMbps)
+		}
+		if !s.lacks("upload") {
+			b.up = append(b.up, s.UploadMbps)
+		}
+	}
+	if by == "hour" {
+		// "00:00" to "23:00" sort as strings
+		sort.Strings(labels)
+	}
+
+	stats := func(values []float64) TrendStats {
+		var s TrendStats
+		s.Min, _ = fastcom.CalcPercentile(values, 0)
+		s.Median, _ = fastcom.CalcPercentile(values, 50)
+		s.P95, _ = fastcom.CalcPercentile(values, 95)
+		return s
+	}
+	trend := make([]TrendBucket, len(labels))
+	for i, l := range labels {
+		b := buckets[l]
+		trend[i] = TrendBucket{Label: l, Runs: b.runs, Download: stats(b.down), Upload: stats(b.up)}
+	}
+	return trend, nil
+}
+
+// WriteTrend prints the buckets as a table, followed by the slowest and
+// fastest bucket by median download, where evening congestion shows.
+func WriteTrend(w io.Writer, trend []TrendBucket) {
+	if len(trend) == 0 {
+		fmt.Fprintln(w, "No measurements")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\tRuns\tDown min\tDown median\tDown p95\tUp min\tUp median\tUp p95\t")
+	for _, b := range trend {
+		fmt.Fprintf(tw, "%s\t%d\t%0.1f\t%0.1f\t%0.1f\t%0.1f\t%0.1f\t%0.1f\t\n", b.Label, b.Runs,
+			b.Download.Min, b.Download.Median, b.Download.P95, b.Upload.Min, b.Upload.Median, b.Upload.P95)
+	}
+	tw.Flush()
+	if len(trend) < 2 {
+		return
+	}
+	slowest, fastest := trend[0], trend[0]
+	for _, b := range trend {
+		if b.Download.Median < slowest.Download.Median {
+			slowest = b
+		}
+		if b.Download.Median > fastest.Download.Median {
+			fastest = b
+		}
+	}
+	if fastest.Download.Median > 0 {
+		fmt.Fprintf(w, "\nSlowest: %s, median download %0.1f Mbit/s, %0.0f%% below %s (%0.1f Mbit/s)\n",
+			slowest.Label, slowest.Download.Median, (1-slowest.Download.Median/fastest.Download.Median)*100,
+			fastest.Label, fastest.Download.Median)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// tuiWidth is the inner width of the TUI's panels, which fits an 80 column
+// terminal.
+const tuiWidth = 64
+
+// tuiLatencyWindow is how many latency samples the latency panel shows.
+const tuiLatencyWindow = 30
+
+// tuiServer is what the TUI knows about one server so far.
+type tuiServer struct {
+	Host         string
+	LatencyMs    float64
+	DownloadMbps float64
+	UploadMbps   float64
+}
+
+// TUI draws a test's progress as full-screen panels, like the fast.com web
+// page: a gauge of the current speed, the rolling latency, each server's
+// results and share of the speed, and the connections in use. It is driven
+// by the test's events and redraws a few times a second; the text report is
+// held back meanwhile, and Stop prints the log written during the test.
+type TUI struct {
+	W io.Writer
+
+	mu        sync.Mutex
+	started   time.Time
+	phase     string
+	server    string
+	speeds    []float64
+	peakMbps  float64
+	latencies []float64
+	servers   []*tuiServer
+	connects  fastcom.ConnectStats
+
+	stop     chan struct{}
+	done     chan struct{}
+	progress io.Writer
+	log      io.Writer
+	logged   lockedBuffer
+}
+
+// lockedBuffer collects the log while the TUI has the screen.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Event updates the panels with evt. It is safe to call concurrently, as
+// Config.OnEvent requires.
+func (t *TUI) Event(evt Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch evt.Type {
+	case EventPhaseStart:
+		t.phase, t.server = evt.Phase, evt.Server
+		if evt.Phase == "download" || evt.Phase == "upload" {
+			t.speeds = nil
+		}
+		if evt.Server != "" && t.find(evt.Server) == nil {
+			t.servers = append(t.servers, &tuiServer{Host: evt.Server})
+		}
+	case EventSample:
+		if evt.Mbps == nil {
+			t.latencies = append(t.latencies, evt.LatencyMs)
+			if len(t.latencies) > tuiLatencyWindow {
+				t.latencies = t.latencies[len(t.latencies)-tuiLatencyWindow:]
+			}
+			return
+		}
+		t.speeds = append(t.speeds, *evt.Mbps)
+		if len(t.speeds) > sparkWindow {
+			t.speeds = t.speeds[len(t.speeds)-sparkWindow:]
+		}
+		if *evt.Mbps > t.peakMbps {
+			t.peakMbps = *evt.Mbps
+		}
+	case EventPhaseEnd:
+		s := t.find(evt.Server)
+		if s == nil {
+			return
+		}
+		switch evt.Phase {
+		case "latency":
+			s.LatencyMs = evt.LatencyMs
+		case "download":
+			if evt.Mbps != nil {
+				s.DownloadMbps = *evt.Mbps
+			}
+		case "upload":
+			if evt.Mbps != nil {
+				s.UploadMbps = *evt.Mbps
+			}
+		}
+	}
+}
+
+func (t *TUI) find(host string) *tuiServer {
+	for _, s := range t.servers {
+		if s.Host == host {
+			return s
+		}
+	}
+	return nil
+}
+
+// Start switches the terminal to the alternate screen and draws the panels
+// until Stop. The text report and the log are held back meanwhile.
+func (t *TUI) Start() {
+	t.started = time.Now()
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+	t.progress, t.log = out.Progress, out.Log
+	out.Progress, out.Log = io.Discard, redactWriter{&t.logged}
+	before := client.ConnectStats()
+	// alternate screen, cursor hidden
+	fmt.Fprint(t.W, "\033[?1049h\033[?25l")
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			t.mu.Lock()
+			t.connects = client.ConnectStats().Sub(before)
+			t.mu.Unlock()
+			t.draw()
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop restores the terminal and the report, and prints the log written
+// while the TUI had the screen.
+func (t *TUI) Stop() {
+	close(t.stop)
+	<-t.done
+	fmt.Fprint(t.W, "\033[?25h\033[?1049l")
+	out.Progress, out.Log = t.progress, t.log
+	t.logged.mu.Lock()
+	defer t.logged.mu.Unlock()
+	t.log.Write(t.logged.buf.Bytes())
+}
+
+func (t *TUI) draw() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var b strings.Builder
+	b.WriteString("\033[H")
+
+	var speed []string
+	switch t.phase {
+	case "download", "upload":
+		var mbps float64
+		if len(t.speeds) > 0 {
+			mbps = t.speeds[len(t.speeds)-1]
+		}
+		name := "Download"
+		if t.phase == "upload" {
+			name = "Upload"
+		}
+		// a round number at or above the fastest second so far
+		scale := niceStep(t.peakMbps)
+		speed = []string{
+			fmt.Sprintf("%s from %s", name, t.server),
+			"",
+			fmt.Sprintf("%s  %0.1f Mbit/s", gauge(mbps, scale, 40), mbps),
+			fmt.Sprintf("%-40s  of %g Mbit/s", sparkline(t.speeds), scale),
+		}
+	case "":
+		speed = []string{"Starting"}
+	default:
+		speed = []string{fmt.Sprintf("Measuring %s", t.phase)}
+	}
+	b.WriteString(panel("Speed", speed))
+
+	latency := []string{"No samples yet"}
+	if len(t.latencies) > 0 {
+		median, _ := fastcom.CalcPercentile(t.latencies, 50)
+		latency = []string{fmt.Sprintf("%-30s  last %0.1f ms, median %0.1f ms",
+			sparkline(t.latencies), t.latencies[len(t.latencies)-1], median)}
+	}
+	b.WriteString(panel("Latency", latency))
+	b.WriteString(panel("Servers", serverLines(t.servers)))
+	b.WriteString(panel("Connections", []string{fmt.Sprintf("%d open, %d opened, %d failed, %s elapsed",
+		client.OpenConnections(), t.connects.Succeeded, t.connects.Attempts-t.connects.Succeeded,
+		time.Since(t.started).Round(time.Second))}))
+	// clear what an earlier, longer frame left below
+	b.WriteString("\033[J")
+	io.WriteString(t.W, b.String())
+}
+
+// serverLines tabulates the servers' results, with each one's share of the
+// total download and upload speed.
+func serverLines(servers []*tuiServer) []string {
+	if len(servers) == 0 {
+		return []string{"Looking for servers"}
+	}
+	var down, up float64
+	for _, s := range servers {
+		down += s.DownloadMbps
+		up += s.UploadMbps
+	}
+	share := func(v, total float64) string {
+		if total <= 0 {
+			return ""
+		}
+		return fmt.Sprintf("%0.0f%%", v/total*100)
+	}
+	value := func(v float64, unit string) string {
+		if v <= 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%0.1f %s", v, unit)
+	}
+	lines := []string{fmt.Sprintf("%-18s %8s %12s %4s %12s %4s", "Server", "Ping", "Download", "", "Upload", "")}
+	for _, s := range servers {
+		host := s.Host
+		if len(host) > 18 {
+			host = host[:17] + "…"
+		}
+		lines = append(lines, fmt.Sprintf("%-18s %8s %12s %4s %12s %4s", host, value(s.LatencyMs, "ms"),
+			value(s.DownloadMbps, "Mbit/s"), share(s.DownloadMbps, down), value(s.UploadMbps, "Mbit/s"), share(s.UploadMbps, up)))
+	}
+	return lines
+}
+
+// WriteSummary prints the final summary of result, in the style of the
+// panels, once the TUI has given the screen back.
+func WriteSummary(w io.Writer, result Result) {
+	s := result.Summary()
+	servers := make([]*tuiServer, len(result.Servers))
+	for i, server := range result.Servers {
+		servers[i] = &tuiServer{Host: server.Host, LatencyMs: server.LatencyMs, DownloadMbps: server.DownloadMbps, UploadMbps: server.UploadMbps}
+	}
+	value := func(v float64, unit, phase string) string {
+		if s.lacks(phase) {
+			return "insufficient data"
+		}
+		return fmt.Sprintf("%0.1f %s", v, unit)
+	}
+	lines := []string{
+		fmt.Sprintf("Download %s, upload %s", value(s.DownloadMbps, "Mbit/s", "download"), value(s.UploadMbps, "Mbit/s", "upload")),
+		fmt.Sprintf("Ping %s, jitter %s", value(s.PingMs, "ms", "latency"), value(s.JitterMs, "ms", "latency")),
+		"",
+	}
+	io.WriteString(w, panel("Summary", append(lines, serverLines(servers)...)))
+}
+
+// gauge is a bar of width cells, filled in the share of scale that v is.
+func gauge(v, scale float64, width int) string {
+	filled := 0
+	if scale > 0 {
+		filled = int(v / scale * float64(width))
+	}
+	if filled > width {
+		filled = width
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+// panel draws lines in a box titled title, each line cut or padded to
+// tuiWidth and cleared to the end of the terminal line.
+func panel(title string, lines []string) string {
+	var b strings.Builder
+	top := "┌ " + title + " "
+	b.WriteString(top + strings.Repeat("─", tuiWidth+2-utf8.RuneCountInString(top)+1) + "┐\033[K\n")
+	for _, line := range lines {
+		if n := utf8.RuneCountInString(line); n > tuiWidth {
+			line = string([]rune(line)[:tuiWidth])
+		} else {
+			line += strings.Repeat(" ", tuiWidth-n)
+		}
+		b.WriteString("│ " + line + " │\033[K\n")
+	}
+	b.WriteString("└" + strings.Repeat("─", tuiWidth+2) + "┘\033[K\n")
+	return b.String()
+}
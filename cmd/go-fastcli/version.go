@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Version is set by release builds with -ldflags "-X main.Version=1.2.3".
+var Version string
+
+// appVersion returns Version, or the module version go install recorded, or
+// "devel" for a local build.
+func appVersion() string {
+	if Version != "" {
+		return strings.TrimPrefix(Version, "v")
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return strings.TrimPrefix(info.Main.Version, "v")
+	}
+	return "devel"
+}
+
+func runVersion(args []string) int {
+	fmt.Fprintln(out.Data, appVersion())
+	return 0
+}
+
+// parseVersion parses "1", "1.4" or "1.4.2", ignoring a leading "v" and any
+// pre-release or build suffix.
+func parseVersion(s string) ([3]int, error) {
+	var v [3]int
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+type versionBound struct {
+	op      string
+	version [3]int
+}
+
+// VersionConstraint is a range of versions such as ">=1.4, <2": comparisons
+// separated by commas, which must all hold. A bare version means "=".
+type VersionConstraint struct {
+	text   string
+	bounds []versionBound
+}
+
+func ParseVersionConstraint(s string) (VersionConstraint, error) {
+	c := VersionConstraint{text: s}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		version := strings.TrimLeft(field, "<>=")
+		op := field[:len(field)-len(version)]
+		switch op {
+		case "":
+			op = "="
+		case "=", "<", "<=", ">", ">=":
+		default:
+			return c, fmt.Errorf("invalid version constraint %q", s)
+		}
+		v, err := parseVersion(strings.TrimSpace(version))
+		if err != nil {
+			return c, fmt.Errorf("invalid version constraint %q: %w", s, err)
+		}
+		c.bounds = append(c.bounds, versionBound{op, v})
+	}
+	return c, nil
+}
+
+// Allows reports whether version is in the range. Versions that can't be
+// parsed, such as "devel", are never allowed.
+func (c VersionConstraint) Allows(version string) bool {
+	v, err := parseVersion(version)
+	if err != nil {
+		return false
+	}
+	for _, b := range c.bounds {
+		cmp := compareVersions(v, b.version)
+		var ok bool
+		switch b.op {
+		case "=":
+			ok = cmp == 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (c VersionConstraint) String() string {
+	return c.text
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Warning codes, which programs can match on.
+const (
+	WarnCPULimited          = "cpu_limited"
+	WarnCaptivePortal       = "captive_portal"
+	WarnBackgroundTraffic   = "background_traffic"
+	WarnConnectFailures     = "connect_failures"
+	WarnShortened           = "shortened"
+	WarnInsufficientSamples = "insufficient_samples"
+	WarnUnexpectedCountry   = "unexpected_country"
+	WarnWorkersDropped      = "workers_dropped"
+)
+
+// backgroundTrafficPercent is how much more than the test's payload the
+// -nic-counters interface may carry in a phase before other traffic is
+// suspected. Protocol headers account for about 5%.
+const backgroundTrafficPercent = 25
+
+// backgroundTrafficMinBytes keeps the warning from small phases, where a few
+// stray packets are a large share.
+const backgroundTrafficMinBytes = 1 << 20
+
+// Warning is a caveat about a result, kept with it so it travels with the
+// numbers into every format. Server and Phase are set when it concerns one
+// server's phase.
+type Warning struct {
+	Code    string
+	Server  string `json:",omitempty"`
+	Phase   string `json:",omitempty"`
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.Server == "" {
+		return w.Message
+	}
+	return fmt.Sprintf("%s %s: %s", w.Server, w.Phase, w.Message)
+}
+
+// resultWarnings collects the caveats of result. unexpected is the number
+// of responses during the test that didn't look like a target's.
+func resultWarnings(cfg Config, result Result, unexpected int64) []Warning {
+	var warnings []Warning
+	add := func(code, server, phase, format string, args ...interface{}) {
+		warnings = append(warnings, Warning{Code: code, Server: server, Phase: phase, Message: fmt.Sprintf(format, args...)})
+	}
+	if result.UnexpectedCountry {
+		add(WarnUnexpectedCountry, "", "", "fast.com sees this connection in %s, not %s; a VPN or proxy likely carries the test, so it measures that instead of your line",
+			locationName(result.Connection.Location), countryList(cfg.ExpectCountries))
+	}
+	if unexpected > 0 {
+		add(WarnCaptivePortal, "", "", "%d responses were redirects, web pages or cut short, as from a captive portal or a filtering proxy; the speeds may not be of your line", unexpected)
+	}
+	if c := result.Connections; c != nil && c.Succeeded < c.Attempts {
+		add(WarnConnectFailures, "", "", "%d of %d connects failed; the latency leaves out the failed samples", c.Attempts-c.Succeeded, c.Attempts)
+	}
+	for _, server := range result.Servers {
+		for _, phase := range server.Shortened {
+			add(WarnShortened, server.Host, phase, "shortened by -max-total-time before it settled")
+		}
+		for _, phase := range server.Insufficient {
+			add(WarnInsufficientSamples, server.Host, phase, "too few samples, at least %d are needed; the value is left out", cfg.MinSamples)
+		}
+		for _, phase := range []string{"download", "upload"} {
+			usage := server.DownloadCPU
+			if phase == "upload" {
+				usage = server.UploadCPU
+			}
+			if usage != nil && usage.CPULimited {
+				add(WarnCPULimited, server.Host, phase, "possibly CPU-limited (%s), so the speed may be lower than the connection's and isn't corrected for it; try -no-https or -http1", usage)
+			}
+			if percent, ok := backgroundTraffic(result.Samples, server.Host, phase); ok {
+				add(WarnBackgroundTraffic, server.Host, phase, "the interface carried %0.0f%% more than the test; other traffic likely shared the line", percent)
+			}
+			if dropped, workers, seconds := droppedWorkers(result.Samples, server.Host, phase); dropped > 0 {
+				add(WarnWorkersDropped, server.Host, phase, "%d of %d transfer workers stopped %d s before the phase ended; the speed of those seconds understates the line", dropped, workers, seconds)
+			}
+		}
+	}
+	return warnings
+}
+
+// droppedWorkers returns how many of the most transfer workers host's phase
+// had going at once were no longer working in its last seconds, and for how
+// many seconds before the phase ended.
+func droppedWorkers(samples []Sample, host, phase string) (dropped, workers, seconds int) {
+	last := 0
+	for _, sample := range samples {
+		if sample.Host != host || sample.Phase != phase {
+			continue
+		}
+		if sample.Active > workers {
+			workers = sample.Active
+		}
+		if sample.Active < workers {
+			seconds++
+		} else {
+			seconds = 0
+		}
+		last = sample.Active
+	}
+	if seconds == 0 {
+		return 0, workers, 0
+	}
+	return workers - last, workers, seconds
+}
+
+// backgroundTraffic returns how much more than the test's payload, in
+// percent, the -nic-counters interface carried during host's phase, and
+// whether that is enough to suspect other traffic.
+func backgroundTraffic(samples []Sample, host, phase string) (float64, bool) {
+	var test, nic int64
+	for _, sample := range samples {
+		if sample.Host != host || sample.Phase != phase || sample.NICBytes < 0 {
+			continue
+		}
+		test += sample.Bytes
+		nic += sample.NICBytes
+	}
+	if test <= 0 || nic-test < backgroundTrafficMinBytes {
+		return 0, false
+	}
+	percent := float64(nic-test) / float64(test) * 100
+	return percent, percent > backgroundTrafficPercent
+}
+
+// warningCodes joins the distinct codes of warnings with commas.
+func warningCodes(warnings []Warning) string {
+	var codes []string
+	seen := map[string]bool{}
+	for _, w := range warnings {
+		if !seen[w.Code] {
+			seen[w.Code] = true
+			codes = append(codes, w.Code)
+		}
+	}
+	return strings.Join(codes, ",")
+}
+
+// printWarnings ends the text report, or the table, with the warnings.
+func printWarnings(w io.Writer, warnings []Warning) {
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "  - %s\n", warning)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDroppedWorkers(t *testing.T) {
+	phase := func(active ...int) []Sample {
+		samples := []Sample{{Phase: "upload", Host: "a.example", Second: 1, Active: 4}}
+		for i, n := range active {
+			samples = append(samples, Sample{Phase: "download", Host: "a.example", Second: i + 1, Active: n})
+		}
+		return samples
+	}
+	tests := []struct {
+		name                      string
+		samples                   []Sample
+		dropped, workers, seconds int
+	}{
+		{"steady", phase(1, 1, 1, 1), 0, 1, 0},
+		{"gap in the middle", phase(1, 0, 1, 1), 0, 1, 0},
+		{"stopped", phase(1, 1, 0, 0), 1, 1, 2},
+		{"some stopped", phase(2, 4, 4, 3, 1), 3, 4, 2},
+		{"none", nil, 0, 0, 0},
+	}
+	for _, test := range tests {
+		dropped, workers, seconds := droppedWorkers(test.samples, "a.example", "download")
+		if dropped != test.dropped || workers != test.workers || seconds != test.seconds {
+			t.Errorf("%s: got %d of %d for %d s, want %d of %d for %d s", test.name, dropped, workers, seconds, test.dropped, test.workers, test.seconds)
+		}
+	}
+
+	result := Result{
+		Servers: []ServerResult{{Host: "a.example", DownloadMbps: 90}},
+		Samples: phase(1, 1, 1, 0),
+	}
+	var found bool
+	for _, w := range resultWarnings(Config{}, result, 0) {
+		if w.Code == WarnWorkersDropped {
+			found = w.Phase == "download" && strings.Contains(w.Message, "1 of 1 transfer workers stopped 1 s before")
+		}
+	}
+	if !found {
+		t.Errorf("no %s warning for the download: %v", WarnWorkersDropped, resultWarnings(Config{}, result, 0))
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// watchFirstBackoff is the delay before retrying a failed run in -watch
+// mode. It doubles with every consecutive failure, up to the interval.
+const watchFirstBackoff = 30 * time.Second
+
+// watchTests runs a test every interval until interrupted. A failed run, e.g.
+// while the fast.com API is briefly unreachable, is retried with backoff
+// instead of ending the loop.
+func watchTests(ctx context.Context, cfg Config, format string, interval time.Duration) int {
+	var backoff time.Duration
+	for run := 0; ; run++ {
+		if run > 0 && format == "text" {
+			fmt.Fprintln(out.Progress)
+		}
+		started := time.Now()
+		result, err := runAndReport(ctx, cfg, format)
+		if ctx.Err() != nil {
+			if !result.Interrupted {
+				fmt.Fprintln(out.Log, "Interrupted")
+			}
+			return ExitInterrupted
+		}
+		wait := time.Until(started.Add(interval))
+		if err != nil {
+			if backoff == 0 {
+				backoff = watchFirstBackoff
+			} else {
+				backoff *= 2
+			}
+			if backoff > interval {
+				backoff = interval
+			}
+			wait = backoff
+			fmt.Fprintf(out.Log, "Retrying in %s\n", wait)
+			logAt(LevelInfo, "retrying", "run", run+1, "backoff", wait, "err", err)
+		} else {
+			backoff = 0
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			// stopping between runs is the normal way to end -watch
+			return 0
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	webhookAttempts     = 4
+	webhookFirstBackoff = time.Second
+)
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"hostname": func() string {
+		host, _ := os.Hostname()
+		return host
+	},
+	// round formats v with the given number of decimals
+	"round": func(v float64, decimals int) string {
+		return strconv.FormatFloat(v, 'f', decimals, 64)
+	},
+	// measure formats a measurement of the summary (download, upload, ping
+	// or jitter) with its unit, or says its phase had insufficient data
+	"measure": func(s Summary, name string) (string, error) {
+		var v float64
+		var unit, phase string
+		switch name {
+		case "download":
+			v, unit, phase = s.DownloadMbps, "Mbit/s", "download"
+		case "upload":
+			v, unit, phase = s.UploadMbps, "Mbit/s", "upload"
+		case "ping":
+			v, unit, phase = s.PingMs, "ms", "latency"
+		case "jitter":
+			v, unit, phase = s.JitterMs, "ms", "latency"
+		default:
+			return "", fmt.Errorf("no measurement %q, expected download, upload, ping or jitter", name)
+		}
+		if s.lacks(phase) {
+			return "insufficient data", nil
+		}
+		return strconv.FormatFloat(v, 'f', 1, 64) + " " + unit, nil
+	},
+}
+
+// webhookTemplates are the built-in templates -webhook-template takes by
+// name, for receivers that don't accept the result JSON.
+var webhookTemplates = map[string]string{
+	// Slack Block Kit, for incoming webhooks
+	"slack": `{
+  "text": {{json (printf "Speed test on %s: download %s, upload %s" hostname (measure .Summary "download") (measure .Summary "upload"))}},
+  "blocks": [
+    {"type": "header", "text": {"type": "plain_text", "text": {{json (printf "Speed test on %s" hostname)}}}},
+    {"type": "section", "fields": [
+      {"type": "mrkdwn", "text": {{json (printf "*Download*\n%s" (measure .Summary "download"))}}},
+      {"type": "mrkdwn", "text": {{json (printf "*Upload*\n%s" (measure .Summary "upload"))}}},
+      {"type": "mrkdwn", "text": {{json (printf "*Ping*\n%s" (measure .Summary "ping"))}}},
+      {"type": "mrkdwn", "text": {{json (printf "*Jitter*\n%s" (measure .Summary "jitter"))}}}
+    ]},
+    {"type": "context", "elements": [
+      {"type": "mrkdwn", "text": {{json (printf "%s (%s), %d servers" .Connection.IP .Connection.ASN (len .Servers))}}}
+    ]}
+  ]
+}
+`,
+	// a Discord embed
+	"discord": `{
+  "embeds": [{
+    "title": {{json (printf "Speed test on %s" hostname)}},
+    "timestamp": {{json .Time}},
+    "fields": [
+      {"name": "Download", "value": "{{measure .Summary "download"}}", "inline": true},
+      {"name": "Upload", "value": "{{measure .Summary "upload"}}", "inline": true},
+      {"name": "Ping", "value": "{{measure .Summary "ping"}}, jitter {{measure .Summary "jitter"}}", "inline": true}
+    ],
+    "footer": {"text": {{json (printf "%s (%s)" .Connection.IP .Connection.ASN)}}}
+  }]
+}
+`,
+	// a plain text ntfy message; -webhook-header "Title: ..." adds a title
+	"ntfy": `Download {{measure .Summary "download"}}, upload {{measure .Summary "upload"}}, ping {{measure .Summary "ping"}} on {{hostname}}
+`,
+}
+
+// LoadWebhookTemplate parses a text/template that renders the webhook body
+// from a Result, e.g. {"text": "{{printf "%.0f" .Summary.DownloadMbps}} Mbit/s"}.
+// If there is no file at path, it may name a built-in template: slack,
+// discord or ntfy.
+func LoadWebhookTemplate(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if builtin, ok := webhookTemplates[path]; ok && errors.Is(err, fs.ErrNotExist) {
+		data, err = []byte(builtin), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return template.New(path).Funcs(webhookFuncs).Parse(string(data))
+}
+
+// headerValue is the flag.Value of a repeatable "Name: value" header flag.
+// Values may be secret references, for Authorization headers.
+type headerValue struct {
+	h *http.Header
+}
+
+func (v headerValue) String() string {
+	if v.h == nil {
+		return ""
+	}
+	var headers []string
+	for name := range *v.h {
+		headers = append(headers, name+": "+v.h.Get(name))
+	}
+	return strings.Join(headers, ", ")
+}
+
+func (v headerValue) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("invalid header %q, expected Name: value", s)
+	}
+	ref := strings.TrimSpace(value)
+	value, err := ResolveSecret(ref)
+	if err != nil {
+		return err
+	}
+	if value != ref || strings.EqualFold(strings.TrimSpace(name), "Authorization") {
+		AddSecret(value)
+	}
+	if *v.h == nil {
+		*v.h = http.Header{}
+	}
+	v.h.Add(strings.TrimSpace(name), value)
+	return nil
+}
+
+func (v headerValue) Reset() {
+	*v.h = nil
+}
+
+// retryable reports whether a webhook response is worth retrying.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// SendWebhook POSTs the result to url with the extra header, as JSON or
+// rendered with tmpl if it is not nil. A rendered body that isn't JSON is
+// sent as plain text, unless header sets the Content-Type. Network errors
+// and 5xx or 429 responses are retried with exponential backoff.
+func SendWebhook(ctx context.Context, url string, header http.Header, tmpl *template.Template, result Result) error {
+	var body []byte
+	if tmpl != nil {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, result); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+		if !json.Valid(body) && header.Get("Content-Type") == "" {
+			header = header.Clone()
+			if header == nil {
+				header = http.Header{}
+			}
+			header.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+	} else {
+		var err error
+		if body, err = json.Marshal(result); err != nil {
+			return err
+		}
+	}
+	return postWithRetry(ctx, url, header, body)
+}
+
+// postWithRetry POSTs body to url, as JSON unless header sets another
+// Content-Type, retrying network errors and 5xx or 429 responses with
+// exponential backoff.
+func postWithRetry(ctx context.Context, url string, header http.Header, body []byte) error {
+	backoff := webhookFirstBackoff
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		retry, err := postWebhook(ctx, url, header, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry || attempt == webhookAttempts {
+			break
+		}
+		logAt(LevelInfo, "retrying", "url", url, "attempt", attempt, "backoff", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return lastErr
+		}
+		backoff *= 2
+	}
+	return lastErr
+}
+
+func postWebhook(ctx context.Context, url string, header http.Header, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := sinkClient.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return retryable(resp.StatusCode), fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return false, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// A wired result this much faster than Wi-Fi points at the wireless link
+// rather than the internet connection.
+const wifiBottleneckRatio = 1.3
+
+func prompt(in *bufio.Reader, w io.Writer, text string) (string, error) {
+	fmt.Fprint(w, text)
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(strings.ToLower(line)), nil
+}
+
+func wizardTest(ctx context.Context, cfg Config, w io.Writer, label string) (Summary, error) {
+	fmt.Fprintf(w, "Running the %s test, this takes about a minute...\n", label)
+	cfg.OnEvent = func(evt Event) {
+		if evt.Type == EventPhaseStart && evt.Phase != "servers" {
+			fmt.Fprintf(w, "  measuring %s...\n", evt.Phase)
+		}
+	}
+	saved := out.Progress
+	out.Progress = io.Discard
+	result, err := RunTest(ctx, cfg)
+	out.Progress = saved
+	if err != nil {
+		return Summary{}, err
+	}
+	summary := result.Summary()
+	if len(summary.Insufficient) > 0 {
+		// a zero would read as a difference between the tests
+		return Summary{}, fmt.Errorf("the %s test had insufficient %s data; try it again", label, strings.Join(summary.Insufficient, " and "))
+	}
+	fmt.Fprintf(w, "%s result: %0.1f Mbit/s down, %0.1f Mbit/s up, %0.1f ms ping (%0.1f ms jitter)\n\n",
+		label, summary.DownloadMbps, summary.UploadMbps, summary.PingMs, summary.JitterMs)
+	return summary, nil
+}
+
+// wifiBottleneck returns the direction, download or upload, in which the
+// wired test beat Wi-Fi by the most, if by more than wifiBottleneckRatio,
+// and by how many percent; percent is +Inf if Wi-Fi moved nothing.
+func wifiBottleneck(wifi, wired Summary) (direction string, percent float64, ok bool) {
+	for _, d := range []struct {
+		name        string
+		wifi, wired float64
+	}{
+		{"download", wifi.DownloadMbps, wired.DownloadMbps},
+		{"upload", wifi.UploadMbps, wired.UploadMbps},
+	} {
+		if d.wired <= d.wifi*wifiBottleneckRatio {
+			continue
+		}
+		p := math.Inf(1)
+		if d.wifi > 0 {
+			p = (d.wired/d.wifi - 1) * 100
+		}
+		if !ok || p > percent {
+			direction, percent, ok = d.name, p, true
+		}
+	}
+	return direction, percent, ok
+}
+
+func explainWiredVsWireless(w io.Writer, wifi, wired Summary) {
+	fmt.Fprintln(w, "What this means:")
+	direction, percent, bottleneck := wifiBottleneck(wifi, wired)
+	switch {
+	case bottleneck:
+		if math.IsInf(percent, 1) {
+			fmt.Fprintf(w, "  Your wired connection could %s while Wi-Fi moved no data, so Wi-Fi is what's\n", direction)
+			fmt.Fprintln(w, "  slowing you down, not your internet provider. Try moving closer to the router,")
+			fmt.Fprintln(w, "  removing obstacles, using the 5 GHz band, or changing the Wi-Fi channel.")
+			break
+		}
+		fmt.Fprintf(w, "  Your wired %s was %0.0f%% faster, so Wi-Fi is what's slowing you down,\n", direction, percent)
+		fmt.Fprintln(w, "  not your internet provider. Try moving closer to the router, removing obstacles,")
+		fmt.Fprintln(w, "  using the 5 GHz band, or changing the Wi-Fi channel.")
+	case wifi.PingMs > wired.PingMs*2 && wifi.PingMs-wired.PingMs > 5:
+		fmt.Fprintln(w, "  Speeds are similar, but Wi-Fi adds noticeable delay. Video calls and games may")
+		fmt.Fprintln(w, "  feel laggy on Wi-Fi; a better signal or a wired connection will help.")
+	default:
+		fmt.Fprintln(w, "  Wi-Fi and wired results are about the same, so Wi-Fi isn't the problem.")
+		fmt.Fprintln(w, "  If speeds are lower than your plan, contact your internet provider and mention")
+		fmt.Fprintln(w, "  that a wired test gave the same result.")
+	}
+}
+
+// RunWizard walks the user through testing over Wi-Fi and then over Ethernet
+// and explains the difference.
+func RunWizard(ctx context.Context, cfg Config, in io.Reader, w io.Writer) error {
+	reader := bufio.NewReader(in)
+	fmt.Fprintln(w, "Wired vs Wi-Fi speed check")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Step 1 of 2: connect this computer to Wi-Fi and unplug any network cable.")
+	if _, err := prompt(reader, w, "Press Enter when ready..."); err != nil {
+		return err
+	}
+	wifi, err := wizardTest(ctx, cfg, w, "Wi-Fi")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "Step 2 of 2: plug a network cable from this computer into the router.")
+	fmt.Fprintln(w, "If you can, turn Wi-Fi off so the test uses the cable.")
+	answer, err := prompt(reader, w, "Press Enter when ready, or type s to skip...")
+	if err != nil {
+		return err
+	}
+	if answer == "s" {
+		fmt.Fprintln(w, "Skipped the wired test. Without it we can't tell whether Wi-Fi is the problem.")
+		return nil
+	}
+	wired, err := wizardTest(ctx, cfg, w, "wired")
+	if err != nil {
+		return err
+	}
+	explainWiredVsWireless(w, wifi, wired)
+	return nil
+}
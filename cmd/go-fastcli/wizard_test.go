@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExplainWiredVsWireless(t *testing.T) {
+	tests := []struct {
+		name        string
+		wifi, wired Summary
+		want        string
+	}{
+		{"download", Summary{DownloadMbps: 50, UploadMbps: 20}, Summary{DownloadMbps: 100, UploadMbps: 20}, "wired download was 100% faster"},
+		// the download was slower over the cable, the upload much faster
+		{"upload", Summary{DownloadMbps: 100, UploadMbps: 10}, Summary{DownloadMbps: 90, UploadMbps: 40}, "wired upload was 300% faster"},
+		{"no wifi data", Summary{UploadMbps: 20}, Summary{DownloadMbps: 100, UploadMbps: 20}, "could download while Wi-Fi moved no data"},
+		{"same", Summary{DownloadMbps: 100, UploadMbps: 20}, Summary{DownloadMbps: 110, UploadMbps: 21}, "about the same"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			explainWiredVsWireless(&buf, test.wifi, test.wired)
+			got := buf.String()
+			if !strings.Contains(got, test.want) || strings.Contains(got, "Inf") || strings.Contains(got, "NaN") || strings.Contains(got, " -") {
+				t.Errorf("got %q, want it to say %q", got, test.want)
+			}
+		})
+	}
+}
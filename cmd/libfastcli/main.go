@@ -0,0 +1,38 @@
+// Command libfastcli builds the measurement engine as a C shared library:
+//
+//	go build -buildmode=c-shared -o libfastcli.so ./cmd/libfastcli
+//
+// This writes libfastcli.so and a libfastcli.h header declaring
+// RunSpeedTest and FreeString.
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"context"
+	"unsafe"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+// RunSpeedTest runs a test configured by a fastcom.SpeedTestConfig JSON
+// string (which may be NULL or empty for the defaults) and returns a
+// fastcom.SpeedTestResult JSON string. It blocks until the test finishes.
+// The caller must free the returned string with FreeString.
+//
+//export RunSpeedTest
+func RunSpeedTest(configJSON *C.char) *C.char {
+	return C.CString(fastcom.RunSpeedTestJSON(context.Background(), C.GoString(configJSON)))
+}
+
+// FreeString frees a string returned by RunSpeedTest.
+//
+//export FreeString
+func FreeString(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+func main() {}
@@ -0,0 +1,40 @@
+//go:build js && wasm
+
+// Command wasmfastcli builds the measurement engine as a WebAssembly module
+// for browsers and other JavaScript hosts:
+//
+//	GOOS=js GOARCH=wasm go build -o fastcli.wasm ./cmd/wasmfastcli
+//
+// Once loaded with Go's wasm_exec.js, it defines a global
+// fastcliRunSpeedTest(configJSON) function returning a Promise that resolves
+// to the result JSON. Requests go through the host's fetch API.
+package main
+
+import (
+	"context"
+	"syscall/js"
+
+	"github.com/rany2/go-fastcli/pkg/fastcom"
+)
+
+func runSpeedTest(this js.Value, args []js.Value) interface{} {
+	configJSON := ""
+	if len(args) > 0 && args[0].Type() == js.TypeString {
+		configJSON = args[0].String()
+	}
+	executor := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		resolve := args[0]
+		// Blocking calls must not run on the event loop goroutine.
+		go func() {
+			resolve.Invoke(fastcom.RunSpeedTestJSON(context.Background(), configJSON))
+		}()
+		return nil
+	})
+	defer executor.Release()
+	return js.Global().Get("Promise").New(executor)
+}
+
+func main() {
+	js.Global().Set("fastcliRunSpeedTest", js.FuncOf(runSpeedTest))
+	select {}
+}
@@ -1,3 +1,3 @@
 module github.com/rany2/go-fastcli
 
-go 1.16
+go 1.19
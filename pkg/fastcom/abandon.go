@@ -0,0 +1,150 @@
+package fastcom
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AbandonStats counts the requests a client gave up on because their context
+// ended while they were in flight, e.g. when a phase ran out of time.
+type AbandonStats struct {
+	Requests int64
+	// CleanupMs is the total time from the end of the context to the
+	// abandoned requests returning, which includes tearing down their
+	// connections.
+	CleanupMs float64
+}
+
+// MeanCleanupMs returns the average cleanup time of an abandoned request, or
+// 0 if there were none.
+func (s AbandonStats) MeanCleanupMs() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.CleanupMs / float64(s.Requests)
+}
+
+// Sub returns the requests abandoned since prev was taken.
+func (s AbandonStats) Sub(prev AbandonStats) AbandonStats {
+	return AbandonStats{Requests: s.Requests - prev.Requests, CleanupMs: s.CleanupMs - prev.CleanupMs}
+}
+
+// AbandonStats returns the requests abandoned so far. It is safe to call
+// concurrently.
+func (c *Client) AbandonStats() AbandonStats {
+	return AbandonStats{
+		Requests:  atomic.LoadInt64(&c.abandoned),
+		CleanupMs: float64(atomic.LoadInt64(&c.abandonCleanup)) / float64(time.Millisecond),
+	}
+}
+
+// trackAbandon watches ctx while a request is in flight. The returned func
+// ends the watch with the request's error and counts the request as
+// abandoned if it failed because ctx ended.
+func (c *Client) trackAbandon(ctx context.Context) func(err *error) {
+	if ctx.Done() == nil {
+		return func(*error) {}
+	}
+	stop := make(chan struct{})
+	ended := make(chan time.Time, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ended <- time.Now()
+		case <-stop:
+		}
+	}()
+	return func(err *error) {
+		close(stop)
+		if *err == nil || ctx.Err() == nil {
+			return
+		}
+		var cleanup time.Duration
+		select {
+		case at := <-ended:
+			cleanup = time.Since(at)
+		default:
+			// the watch stopped before it saw ctx end, so the
+			// request returned right away
+		}
+		atomic.AddInt64(&c.abandoned, 1)
+		atomic.AddInt64(&c.abandonCleanup, int64(cleanup))
+	}
+}
+
+// trackedConn counts itself out of the client's open connections when it is
+// closed.
+type trackedConn struct {
+	net.Conn
+	once   sync.Once
+	client *Client
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		atomic.AddInt64(&c.client.openConns, -1)
+		c.client.connsMu.Lock()
+		delete(c.client.conns, c)
+		c.client.connsMu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+func (c *Client) trackConn(conn net.Conn) net.Conn {
+	atomic.AddInt64(&c.openConns, 1)
+	tracked := &trackedConn{Conn: conn, client: c}
+	c.connsMu.Lock()
+	if c.conns == nil {
+		c.conns = map[*trackedConn]struct{}{}
+	}
+	c.conns[tracked] = struct{}{}
+	c.connsMu.Unlock()
+	return tracked
+}
+
+// AbortConnections closes every connection the client has open, in use or
+// not, so requests stuck on one fail even where cancelling their context
+// doesn't reach.
+func (c *Client) AbortConnections() {
+	c.connsMu.Lock()
+	conns := make([]*trackedConn, 0, len(c.conns))
+	for conn := range c.conns {
+		conns = append(conns, conn)
+	}
+	c.connsMu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	c.CloseIdleConnections()
+}
+
+// OpenConnections returns the number of connections the client has open,
+// idle or in use. Nothing is counted on js/wasm, where the browser connects.
+func (c *Client) OpenConnections() int64 {
+	return atomic.LoadInt64(&c.openConns)
+}
+
+// CloseIdleConnections closes the connections of both transports that are
+// not in use. The transports keep idle connections indefinitely, so a
+// long-running program should call it once it is done with the targets.
+func (c *Client) CloseIdleConnections() {
+	c.Transport.CloseIdleConnections()
+	c.LatencyTransport.CloseIdleConnections()
+}
+
+// CloseConnections closes the client's idle connections and waits up to wait
+// for the others, still held by abandoned requests, to close too. It returns
+// how many were still open when it gave up; those linger and may delay the
+// exit of the program.
+func (c *Client) CloseConnections(wait time.Duration) int64 {
+	c.CloseIdleConnections()
+	deadline := time.Now().Add(wait)
+	for c.OpenConnections() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		c.CloseIdleConnections()
+	}
+	return c.OpenConnections()
+}
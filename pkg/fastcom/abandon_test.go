@@ -0,0 +1,44 @@
+package fastcom
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAbortConnections(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := NewClient()
+	c.StallTimeout = 0
+	done := make(chan error, 1)
+	go func() {
+		// nothing ends this download but AbortConnections
+		_, err := c.GetDownloadSpeed(context.Background(), srv.URL+"/speedtest", 1<<20)
+		done <- err
+	}()
+	for c.OpenConnections() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.AbortConnections()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("the download succeeded, want an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the download did not end when its connection was closed")
+	}
+	if n := c.OpenConnections(); n != 0 {
+		t.Errorf("%d connections still open", n)
+	}
+}
@@ -0,0 +1,515 @@
+// Package fastcom runs fast.com speed tests: it queries the fast.com API for
+// test targets and measures latency, download and upload speed against them.
+package fastcom
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const MaxPayload = 26214400
+const APIToken = "YXNkZmFzZGxmbnNkYWZoYXNkZmhrYWxm"
+const APIURL = "https://api.fast.com/netflix/speedtest/v2?token=" + APIToken
+
+var ErrNoTargets = errors.New("no targets to test against")
+
+// LocationInfo is where fast.com places the connection or a target. Either
+// field may be empty: the API leaves them out for some of both.
+type LocationInfo struct {
+	City    string
+	Country string
+}
+
+// UnmarshalJSON decodes a location leniently, as it is informational: a
+// city or country that isn't a string, or a location that isn't an object,
+// is left empty instead of failing the whole response.
+func (l *LocationInfo) UnmarshalJSON(data []byte) error {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		*l = LocationInfo{}
+		return nil
+	}
+	for name, value := range fields {
+		s, _ := value.(string)
+		switch strings.ToLower(name) {
+		case "city":
+			l.City = s
+		case "country":
+			l.Country = s
+		}
+	}
+	return nil
+}
+
+type ConnectionInfo struct {
+	ASN      string
+	IP       string
+	ISP      string `json:",omitempty"`
+	Location LocationInfo
+	// Extra holds any other client fields the fast.com API returned, by
+	// their name in the API.
+	Extra map[string]interface{} `json:",omitempty"`
+}
+
+// UnmarshalJSON decodes the client object of the fast.com API, or a
+// ConnectionInfo saved as JSON, keeping fields it doesn't know in Extra. Like
+// the location, it is decoded leniently: a number where a string was
+// expected, such as an ASN, is kept as text, and anything else that doesn't
+// fit is left empty.
+func (c *ConnectionInfo) UnmarshalJSON(data []byte) error {
+	*c = ConnectionInfo{}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	for name, raw := range fields {
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil || value == nil {
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "asn":
+			c.ASN = jsonText(value)
+		case "ip":
+			c.IP = jsonText(value)
+		case "isp":
+			c.ISP = jsonText(value)
+		case "location":
+			_ = json.Unmarshal(raw, &c.Location)
+		case "extra":
+			extra, _ := value.(map[string]interface{})
+			for name, value := range extra {
+				c.addExtra(name, value)
+			}
+		default:
+			c.addExtra(name, value)
+		}
+	}
+	return nil
+}
+
+func (c *ConnectionInfo) addExtra(name string, value interface{}) {
+	if c.Extra == nil {
+		c.Extra = make(map[string]interface{})
+	}
+	c.Extra[name] = value
+}
+
+// jsonText returns a decoded JSON string or number as text, or "" for
+// anything else.
+func jsonText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+type Server struct {
+	City    string
+	Country string
+	URL     string
+}
+
+// StatusError is returned when fast.com or a target answers with anything
+// other than 200 OK.
+type StatusError struct {
+	URL    string
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return GetHost(e.URL) + " returned " + e.Status
+}
+
+type apiTarget struct {
+	URL      string       `json:"url"`
+	Location LocationInfo `json:"location"`
+}
+
+type apiResponse struct {
+	Client  *ConnectionInfo `json:"client"`
+	Targets []apiTarget     `json:"targets"`
+}
+
+func (r *apiResponse) servers() []Server {
+	var serverList []Server
+	for _, target := range r.Targets {
+		serverList = append(serverList, Server{
+			City:    target.Location.City,
+			Country: target.Location.Country,
+			URL:     target.URL,
+		})
+	}
+	return serverList
+}
+
+// Client holds the HTTP transport shared by all phases of a test. The zero
+// value is not usable; create one with NewClient.
+type Client struct {
+	Transport  *http.Transport
+	HTTPClient *http.Client
+	APIURL     string
+
+	// HTTPS asks the API for https:// targets. Plain HTTP targets are cheaper
+	// to drive on slow CPUs.
+	HTTPS bool
+
+	// LatencyTransport never reuses connections, so every latency sample
+	// measures a fresh connect instead of a pooled one.
+	LatencyTransport *http.Transport
+
+	// OnRequest, if set, is called with the timings of every request the
+	// client makes once it has finished: when its response body is closed,
+	// or when it failed. It is called from the requests' goroutines.
+	OnRequest func(RequestInfo)
+
+	// RequestTimeout bounds each request without a payload, from connecting
+	// to reading the response. StallTimeout aborts a download or upload that
+	// moved no data for that long. Zero disables either.
+	RequestTimeout time.Duration
+	StallTimeout   time.Duration
+
+	transferred   int64
+	cipherSuite   atomic.Value
+	dialer        *net.Dialer
+	pinned        map[string]string
+	sourceAddress atomic.Value
+	wrapDial      func(dial func() error) error
+	connects      ConnectStats
+
+	abandoned      int64
+	abandonCleanup int64 // nanoseconds
+	openConns      int64
+	unexpected     int64
+	active         int64 // transfers in flight
+	activePeak     int64 // most in flight since TakeActiveTransfers
+
+	connsMu sync.Mutex
+	conns   map[*trackedConn]struct{} // open, for AbortConnections
+}
+
+func NewClient() *Client {
+	tr := &http.Transport{
+		DisableCompression:  true,
+		Proxy:               nil,
+		DisableKeepAlives:   false,
+		MaxIdleConnsPerHost: 1024,
+	}
+	latencyTr := tr.Clone()
+	latencyTr.DisableKeepAlives = true
+	c := &Client{
+		Transport:        tr,
+		HTTPClient:       &http.Client{Transport: tr},
+		APIURL:           APIURL,
+		HTTPS:            true,
+		LatencyTransport: latencyTr,
+		RequestTimeout:   DefaultRequestTimeout,
+		StallTimeout:     DefaultStallTimeout,
+	}
+	if !usesFetch {
+		c.installDialer()
+	}
+	return c
+}
+
+// Resolve pins host to ip for every connection the client makes, like curl's
+// --resolve. TLS still verifies the certificate against host. It must be
+// called before the client is used.
+func (c *Client) Resolve(host, ip string) error {
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid IP address %q for %s", ip, host)
+	}
+	c.installDialer()
+	c.pinned[host] = ip
+	return nil
+}
+
+// SetSourceAddress makes connections from ip, e.g. to pick a stable rather
+// than a temporary IPv6 address. Targets are then only reached over ip's
+// address family. It must be called before the client is used.
+func (c *Client) SetSourceAddress(ip string) error {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return fmt.Errorf("invalid source address %q", ip)
+	}
+	c.installDialer()
+	c.dialer.LocalAddr = &net.TCPAddr{IP: addr}
+	return nil
+}
+
+// WrapDial makes the client open every socket, including those for DNS
+// lookups, inside wrap, e.g. to create them in another network namespace.
+// wrap must call dial exactly once and may do so on another goroutine. It
+// must be called before the client is used.
+func (c *Client) WrapDial(wrap func(dial func() error) error) {
+	c.installDialer()
+	c.wrapDial = wrap
+	// without a fallback delay, dialing happens in the wrapped goroutine
+	// instead of racing IPv4 and IPv6 from new ones
+	c.dialer.FallbackDelay = -1
+	c.dialer.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var conn net.Conn
+			err := wrap(func() (err error) {
+				var d net.Dialer
+				conn, err = d.DialContext(ctx, network, addr)
+				return err
+			})
+			return conn, err
+		},
+	}
+}
+
+// LookupHost resolves host the way the client's connections do, honouring
+// Resolve and WrapDial.
+func (c *Client) LookupHost(ctx context.Context, host string) ([]string, error) {
+	resolver := net.DefaultResolver
+	if c.dialer != nil {
+		if ip, ok := c.pinned[host]; ok {
+			return []string{ip}, nil
+		}
+		if c.dialer.Resolver != nil {
+			resolver = c.dialer.Resolver
+		}
+	}
+	return resolver.LookupHost(ctx, host)
+}
+
+// SourceAddress returns the local IP address of the most recent connection,
+// or nil if none was made yet.
+func (c *Client) SourceAddress() net.IP {
+	ip, _ := c.sourceAddress.Load().(net.IP)
+	return ip
+}
+
+// installDialer replaces the transports' dialer with one that applies
+// Resolve and SetSourceAddress and records the source address. It is only
+// done when needed on js/wasm, where any custom dialer disables the fetch
+// API.
+func (c *Client) installDialer() {
+	if c.dialer != nil {
+		return
+	}
+	c.pinned = map[string]string{}
+	c.dialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			if ip, ok := c.pinned[host]; ok {
+				addr = net.JoinHostPort(ip, port)
+			} else if ip, ok := resolvedAddress(ctx, host); ok {
+				addr = net.JoinHostPort(ip, port)
+			}
+		}
+		var conn net.Conn
+		var err error
+		start := time.Now()
+		if c.wrapDial != nil {
+			err = c.wrapDial(func() (err error) {
+				conn, err = c.dialer.DialContext(ctx, network, addr)
+				return err
+			})
+		} else {
+			conn, err = c.dialer.DialContext(ctx, network, addr)
+		}
+		c.countConnect(ctx, time.Since(start), err)
+		if err == nil {
+			if local, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+				c.sourceAddress.Store(local.IP)
+			}
+			conn = c.trackConn(conn)
+		}
+		return conn, err
+	}
+	c.Transport.DialContext = dial
+	c.LatencyTransport.DialContext = dial
+}
+
+// SetDSCP marks the packets of every connection the client opens with the
+// DSCP code point dscp, 0 to 63. Idle connections, which may carry another
+// marking, are closed. It may be called between measurements.
+func (c *Client) SetDSCP(dscp int) error {
+	if dscp < 0 || dscp > 63 {
+		return fmt.Errorf("invalid DSCP value %d, expected 0 to 63", dscp)
+	}
+	c.installDialer()
+	c.dialer.Control = func(network, address string, raw syscall.RawConn) error {
+		// DSCP is the upper six bits of the traffic class byte
+		return setTOS(network, raw, dscp<<2)
+	}
+	c.Transport.CloseIdleConnections()
+	return nil
+}
+
+// SetProxy makes the client send its requests through the proxy chosen by
+// proxy, as in http.Transport. It must be called before the client is used.
+func (c *Client) SetProxy(proxy func(*http.Request) (*url.URL, error)) {
+	c.Transport.Proxy = proxy
+	c.LatencyTransport.Proxy = proxy
+}
+
+// DisableHTTP2 makes the client speak HTTP/1.1 to HTTPS targets. It must be
+// called before the client is used.
+func (c *Client) DisableHTTP2() {
+	c.Transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	c.LatencyTransport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+}
+
+func (c *Client) GetServerList(ctx context.Context, urlsToTest int) (_ ConnectionInfo, _ []Server, err error) {
+	ctx, end := c.withRequestTimeout(ctx)
+	defer end(&err)
+	apiURL := c.APIURL + "&https=" + strconv.FormatBool(c.HTTPS) + "&urlCount=" + strconv.Itoa(urlsToTest)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return ConnectionInfo{}, nil, err
+	}
+	req, traced := c.traceRequest(req)
+	resp, err := traced(c.HTTPClient.Do(req))
+	if err != nil {
+		return ConnectionInfo{}, nil, fmt.Errorf("could not reach the fast.com API, check your connection: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ConnectionInfo{}, nil, &StatusError{URL: apiURL, Status: resp.Status}
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return ConnectionInfo{}, nil, fmt.Errorf("reading server list: %w", err)
+	}
+	var apiResp apiResponse
+	if err := json.Unmarshal(body, &apiResp); err != nil {
+		if isWebPage(resp) {
+			return ConnectionInfo{}, nil, errors.New("fast.com API returned a web page, likely the sign-in page of a captive portal")
+		}
+		return ConnectionInfo{}, nil, fmt.Errorf("fast.com API returned an unexpected response: %w", err)
+	}
+	if len(apiResp.Targets) == 0 {
+		return ConnectionInfo{}, nil, ErrNoTargets
+	}
+	var connectionInfo ConnectionInfo
+	if apiResp.Client != nil {
+		connectionInfo = *apiResp.Client
+	}
+	return connectionInfo, apiResp.servers(), nil
+}
+
+// ParseServerList parses a saved fast.com API response, a JSON array of URLs
+// or target objects, or plain text with one URL per line.
+func ParseServerList(data []byte) (ConnectionInfo, []Server, error) {
+	var jsonData interface{}
+	if err := json.Unmarshal(data, &jsonData); err != nil {
+		// a URL never starts like JSON, which must have been cut short or
+		// mangled
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+			return ConnectionInfo{}, nil, fmt.Errorf("parsing targets: %w", err)
+		}
+		return ConnectionInfo{}, ParsePlainServerList(data), nil
+	}
+	switch jsonData.(type) {
+	case map[string]interface{}:
+		var apiResp apiResponse
+		if err := json.Unmarshal(data, &apiResp); err != nil {
+			return ConnectionInfo{}, nil, fmt.Errorf("parsing targets: %w", err)
+		}
+		var connectionInfo ConnectionInfo
+		if apiResp.Client != nil {
+			connectionInfo = *apiResp.Client
+		}
+		serverList := apiResp.servers()
+		for _, server := range serverList {
+			if server.URL == "" {
+				return ConnectionInfo{}, nil, errors.New("parsing targets: target has no url")
+			}
+		}
+		return connectionInfo, serverList, nil
+	case []interface{}:
+		var targets []json.RawMessage
+		if err := json.Unmarshal(data, &targets); err != nil {
+			return ConnectionInfo{}, nil, fmt.Errorf("parsing targets: %w", err)
+		}
+		var serverList []Server
+		for _, raw := range targets {
+			var targetURL string
+			if err := json.Unmarshal(raw, &targetURL); err == nil {
+				serverList = append(serverList, Server{URL: targetURL})
+				continue
+			}
+			var target apiTarget
+			if err := json.Unmarshal(raw, &target); err != nil {
+				return ConnectionInfo{}, nil, fmt.Errorf("parsing targets: %w", err)
+			}
+			if target.URL == "" {
+				return ConnectionInfo{}, nil, errors.New("parsing targets: target has no url")
+			}
+			serverList = append(serverList, Server{
+				City:    target.Location.City,
+				Country: target.Location.Country,
+				URL:     target.URL,
+			})
+		}
+		return ConnectionInfo{}, serverList, nil
+	default:
+		return ConnectionInfo{}, nil, errors.New("parsing targets: expected an object, array or one URL per line")
+	}
+}
+
+func ParsePlainServerList(data []byte) []Server {
+	var serverList []Server
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		serverList = append(serverList, Server{URL: line})
+	}
+	return serverList
+}
+
+func LoadServerList(path string) (ConnectionInfo, []Server, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ConnectionInfo{}, nil, err
+	}
+	connectionInfo, serverList, err := ParseServerList(data)
+	if err != nil {
+		return ConnectionInfo{}, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(serverList) == 0 {
+		return ConnectionInfo{}, nil, fmt.Errorf("%s: %w", path, ErrNoTargets)
+	}
+	return connectionInfo, serverList, nil
+}
+
+func FormatURL(url string, rangeEnd int) string {
+	return strings.Replace(url, "/speedtest?", "/speedtest/range/0-"+strconv.Itoa(rangeEnd)+"?", -1)
+}
+
+// GetHost returns the host part of a target URL, or the URL itself if it
+// cannot be parsed.
+func GetHost(_url string) string {
+	u, err := url.Parse(_url)
+	if err != nil || u.Host == "" {
+		return _url
+	}
+	return u.Host
+}
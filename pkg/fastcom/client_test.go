@@ -0,0 +1,146 @@
+package fastcom
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseServerList(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		info    ConnectionInfo
+		servers []Server
+	}{
+		{
+			name: "api response",
+			data: `{"client": {"ip": "192.0.2.1", "asn": "15169", "isp": "Example", "location": {"city": "Berlin", "country": "DE"}},
+				"targets": [{"url": "https://a.example/speedtest", "location": {"city": "Frankfurt", "country": "DE"}}]}`,
+			info:    ConnectionInfo{ASN: "15169", IP: "192.0.2.1", ISP: "Example", Location: LocationInfo{City: "Berlin", Country: "DE"}},
+			servers: []Server{{City: "Frankfurt", Country: "DE", URL: "https://a.example/speedtest"}},
+		},
+		{
+			name:    "no location",
+			data:    `{"client": {"ip": "192.0.2.1"}, "targets": [{"url": "https://a.example/"}]}`,
+			info:    ConnectionInfo{IP: "192.0.2.1"},
+			servers: []Server{{URL: "https://a.example/"}},
+		},
+		{
+			name:    "null and partial locations",
+			data:    `{"client": {"ip": "192.0.2.1", "location": null}, "targets": [{"url": "https://a.example/", "location": {"country": "US"}}]}`,
+			info:    ConnectionInfo{IP: "192.0.2.1"},
+			servers: []Server{{Country: "US", URL: "https://a.example/"}},
+		},
+		{
+			name:    "odd-typed location fields",
+			data:    `{"client": {"ip": "192.0.2.1", "location": {"city": 7, "country": ["DE"]}}, "targets": [{"url": "https://a.example/", "location": "Frankfurt"}]}`,
+			info:    ConnectionInfo{IP: "192.0.2.1"},
+			servers: []Server{{URL: "https://a.example/"}},
+		},
+		{
+			name:    "numeric asn",
+			data:    `{"client": {"ip": "192.0.2.1", "asn": 15169}, "targets": [{"url": "https://a.example/"}]}`,
+			info:    ConnectionInfo{ASN: "15169", IP: "192.0.2.1"},
+			servers: []Server{{URL: "https://a.example/"}},
+		},
+		{
+			name:    "client not an object",
+			data:    `{"client": "192.0.2.1", "targets": [{"url": "https://a.example/"}]}`,
+			servers: []Server{{URL: "https://a.example/"}},
+		},
+		{
+			name:    "extra client fields",
+			data:    `{"client": {"ip": "192.0.2.1", "isp": "Example", "asnName": "EXAMPLE-AS", "region": null}, "targets": [{"url": "https://a.example/"}]}`,
+			info:    ConnectionInfo{IP: "192.0.2.1", ISP: "Example", Extra: map[string]interface{}{"asnName": "EXAMPLE-AS"}},
+			servers: []Server{{URL: "https://a.example/"}},
+		},
+		{
+			name:    "array of urls and targets",
+			data:    `["https://a.example/", {"url": "https://b.example/", "location": {"city": "Paris"}}]`,
+			servers: []Server{{URL: "https://a.example/"}, {City: "Paris", URL: "https://b.example/"}},
+		},
+		{
+			name:    "plain text",
+			data:    "# targets\nhttps://a.example/\n\n  https://b.example/  \n",
+			servers: []Server{{URL: "https://a.example/"}, {URL: "https://b.example/"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, servers, err := ParseServerList([]byte(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, test.info) {
+				t.Errorf("got client %+v, want %+v", info, test.info)
+			}
+			if !reflect.DeepEqual(servers, test.servers) {
+				t.Errorf("got targets %+v, want %+v", servers, test.servers)
+			}
+		})
+	}
+}
+
+func TestParseServerListErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"client": {"ip": "192.0.2.1", "location": {"city": "Ber`,
+		`{"client": {"ip": "192.0.2.1"}, "targets": [{"url": "https://a.exa`,
+		`["https://a.example/", `,
+		`{"targets": [{"location": {"city": "Paris"}}]}`,
+		`[{"url": 5}]`,
+		`{"targets": "https://a.example/"}`,
+		`"https://a.example/"`,
+	} {
+		if _, servers, err := ParseServerList([]byte(data)); err == nil {
+			t.Errorf("%s: got %+v, want an error", data, servers)
+		}
+	}
+}
+
+func TestLocationInfoUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		data string
+		want LocationInfo
+	}{
+		{`{"city": "Berlin", "country": "DE"}`, LocationInfo{City: "Berlin", Country: "DE"}},
+		{`{"City": "Berlin", "Country": "DE"}`, LocationInfo{City: "Berlin", Country: "DE"}},
+		{`{"country": "DE"}`, LocationInfo{Country: "DE"}},
+		{`{"city": null, "country": 49}`, LocationInfo{}},
+		{`{}`, LocationInfo{}},
+		{`null`, LocationInfo{}},
+		{`"Berlin"`, LocationInfo{}},
+		{`[1, 2]`, LocationInfo{}},
+	}
+	for _, test := range tests {
+		var got LocationInfo
+		if err := json.Unmarshal([]byte(test.data), &got); err != nil {
+			t.Errorf("%s: %v", test.data, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.data, got, test.want)
+		}
+	}
+}
+
+func TestConnectionInfoRoundTrip(t *testing.T) {
+	info := ConnectionInfo{
+		ASN:      "15169",
+		IP:       "192.0.2.1",
+		ISP:      "Example",
+		Location: LocationInfo{City: "Berlin", Country: "DE"},
+		Extra:    map[string]interface{}{"asnName": "EXAMPLE-AS"},
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ConnectionInfo
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, info) {
+		t.Errorf("%s decoded as %+v, want %+v", data, got, info)
+	}
+}
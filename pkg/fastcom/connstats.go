@@ -0,0 +1,85 @@
+package fastcom
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// SlowConnect is how long a connect has to take to count as slow. The first
+// SYN retransmission happens after a second, so slower connects usually mean
+// a lost SYN or SYN-ACK.
+const SlowConnect = time.Second
+
+// ConnectStats counts the TCP connections a client tried to open. Dials cut
+// off by the end of their context, e.g. at the end of a test or a deadline
+// like -max-total-time, are not counted; TimedOut only counts dials that
+// ran out of the dialer's own time.
+type ConnectStats struct {
+	Attempts  int64
+	Succeeded int64
+	TimedOut  int64
+	// Refused counts connections refused or reset by the other end.
+	Refused int64
+	Failed  int64
+	// Slow counts connections that succeeded but took SlowConnect or more.
+	Slow int64
+}
+
+// SuccessRate returns the share of attempts that succeeded, or 1 if there
+// were none.
+func (s ConnectStats) SuccessRate() float64 {
+	if s.Attempts == 0 {
+		return 1
+	}
+	return float64(s.Succeeded) / float64(s.Attempts)
+}
+
+// Sub returns the connections counted since prev was taken.
+func (s ConnectStats) Sub(prev ConnectStats) ConnectStats {
+	return ConnectStats{
+		Attempts:  s.Attempts - prev.Attempts,
+		Succeeded: s.Succeeded - prev.Succeeded,
+		TimedOut:  s.TimedOut - prev.TimedOut,
+		Refused:   s.Refused - prev.Refused,
+		Failed:    s.Failed - prev.Failed,
+		Slow:      s.Slow - prev.Slow,
+	}
+}
+
+// ConnectStats returns the connections counted so far. It is safe to call
+// concurrently. Nothing is counted on js/wasm, where the browser connects.
+func (c *Client) ConnectStats() ConnectStats {
+	return ConnectStats{
+		Attempts:  atomic.LoadInt64(&c.connects.Attempts),
+		Succeeded: atomic.LoadInt64(&c.connects.Succeeded),
+		TimedOut:  atomic.LoadInt64(&c.connects.TimedOut),
+		Refused:   atomic.LoadInt64(&c.connects.Refused),
+		Failed:    atomic.LoadInt64(&c.connects.Failed),
+		Slow:      atomic.LoadInt64(&c.connects.Slow),
+	}
+}
+
+// countConnect counts a dial of ctx that took took and ended with err.
+func (c *Client) countConnect(ctx context.Context, took time.Duration, err error) {
+	var netErr net.Error
+	switch {
+	case err != nil && (ctx.Err() != nil || errors.Is(err, context.Canceled)):
+		return
+	case err == nil:
+		atomic.AddInt64(&c.connects.Succeeded, 1)
+		if took >= SlowConnect {
+			atomic.AddInt64(&c.connects.Slow, 1)
+		}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		atomic.AddInt64(&c.connects.TimedOut, 1)
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		atomic.AddInt64(&c.connects.Refused, 1)
+	default:
+		atomic.AddInt64(&c.connects.Failed, 1)
+	}
+	atomic.AddInt64(&c.connects.Attempts, 1)
+}
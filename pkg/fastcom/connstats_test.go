@@ -0,0 +1,36 @@
+package fastcom
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCountConnect(t *testing.T) {
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	dialTimeout := &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}
+
+	var c Client
+	c.countConnect(context.Background(), 10*time.Millisecond, nil)
+	c.countConnect(context.Background(), 2*time.Second, nil)
+	c.countConnect(context.Background(), time.Second, dialTimeout)
+	c.countConnect(context.Background(), 0, fmt.Errorf("dial: %w", syscall.ECONNREFUSED))
+	// cut off by the caller's deadline, which isn't the network's doing
+	c.countConnect(expired, time.Second, dialTimeout)
+	c.countConnect(expired, time.Second, context.DeadlineExceeded)
+
+	want := ConnectStats{Attempts: 4, Succeeded: 2, TimedOut: 1, Refused: 1, Slow: 1}
+	if got := c.ConnectStats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
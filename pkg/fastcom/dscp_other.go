@@ -0,0 +1,14 @@
+//go:build !unix
+
+package fastcom
+
+import (
+	"errors"
+	"runtime"
+	"syscall"
+)
+
+// Windows ignores IP_TOS and only marks traffic through its QoS policies.
+func setTOS(network string, raw syscall.RawConn, tos int) error {
+	return errors.New("DSCP marking is not supported on " + runtime.GOOS)
+}
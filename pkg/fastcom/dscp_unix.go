@@ -0,0 +1,21 @@
+//go:build unix
+
+package fastcom
+
+import "syscall"
+
+// setTOS sets the traffic class byte of an IPv4 or IPv6 socket.
+func setTOS(network string, raw syscall.RawConn, tos int) error {
+	var err error
+	ctrlErr := raw.Control(func(fd uintptr) {
+		if network == "tcp6" || network == "udp6" {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+		} else {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		}
+	})
+	if ctrlErr != nil {
+		return ctrlErr
+	}
+	return err
+}
@@ -0,0 +1,7 @@
+//go:build js && wasm
+
+package fastcom
+
+// On js/wasm, net/http sends requests with the host's fetch API, which hides
+// connection setup from httptrace.
+const usesFetch = true
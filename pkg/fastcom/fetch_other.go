@@ -0,0 +1,5 @@
+//go:build !(js && wasm)
+
+package fastcom
+
+const usesFetch = false
@@ -0,0 +1,452 @@
+package fastcom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type FakeReader struct {
+	ReadIndex int64
+	MaxIndex  int64
+	Counter   *int64
+}
+
+func (c *FakeReader) Read(p []byte) (int, error) {
+	if c.ReadIndex >= c.MaxIndex {
+		return 0, io.EOF
+	}
+	if remaining := c.MaxIndex - c.ReadIndex; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	n := len(p)
+	c.ReadIndex += int64(n)
+	if c.Counter != nil {
+		atomic.AddInt64(c.Counter, int64(n))
+	}
+	return n, nil
+}
+
+type countingReader struct {
+	reader  io.Reader
+	counter *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	atomic.AddInt64(c.counter, int64(n))
+	return n, err
+}
+
+// transferBufferSize is the size of the buffers downloads are read into.
+// Larger reads take fewer calls per byte, which counts on slow CPUs.
+const transferBufferSize = 64 * 1024
+
+// transferBuffers keeps the buffers of finished downloads for the next ones,
+// so a run allocates a few of them rather than one per request.
+var transferBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, transferBufferSize)
+		return &buf
+	},
+}
+
+// drain reads r to its end into a buffer from transferBuffers and returns
+// the number of bytes read.
+func drain(r io.Reader) (n int64, err error) {
+	buf := transferBuffers.Get().(*[]byte)
+	defer transferBuffers.Put(buf)
+	for {
+		m, err := r.Read(*buf)
+		n += int64(m)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// startTransfer counts a download or upload as in flight until the returned
+// func is called.
+func (c *Client) startTransfer() func() {
+	n := atomic.AddInt64(&c.active, 1)
+	for {
+		peak := atomic.LoadInt64(&c.activePeak)
+		if n <= peak || atomic.CompareAndSwapInt64(&c.activePeak, peak, n) {
+			break
+		}
+	}
+	return func() { atomic.AddInt64(&c.active, -1) }
+}
+
+// TakeActiveTransfers returns the most downloads and uploads that were in
+// flight at once since the previous call, counting those still in flight,
+// and starts counting anew. Called once a second during a phase, it gives
+// the transfers working in each second. It is safe to call concurrently.
+func (c *Client) TakeActiveTransfers() int64 {
+	return atomic.SwapInt64(&c.activePeak, atomic.LoadInt64(&c.active))
+}
+
+// BytesTransferred returns the payload bytes moved by the download and upload
+// measurements of this client so far. It is safe to call concurrently.
+func (c *Client) BytesTransferred() int64 {
+	return atomic.LoadInt64(&c.transferred)
+}
+
+var ErrConnectionReused = errors.New("latency sample did not open a new connection")
+
+func (c *Client) GetLatency(ctx context.Context, url string) (_ time.Duration, err error) {
+	defer c.trackAbandon(ctx)(&err)
+	ctx, end := c.withRequestTimeout(ctx)
+	defer end(&err)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", FormatURL(url, 0), nil)
+	if err != nil {
+		return 0, err
+	}
+	var t1, t2 time.Time
+	var reused bool
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(_, _ string) {
+			t1 = time.Now()
+		},
+		ConnectDone: func(_, _ string, _ error) {
+			t2 = time.Now()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused = info.Reused
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	req, traced := c.traceRequest(req)
+	start := time.Now()
+	resp, err := traced(c.LatencyTransport.RoundTrip(req))
+	if err != nil {
+		return 0, fmt.Errorf("connecting to %s: %w", GetHost(url), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		atomic.AddInt64(&c.unexpected, 1)
+	}
+	if usesFetch {
+		// no connect timings, so time the whole request instead
+		return time.Since(start), nil
+	}
+	if reused || t1.IsZero() || t2.IsZero() {
+		return 0, ErrConnectionReused
+	}
+	return t2.Sub(t1), nil
+}
+
+func (c *Client) GetDownloadSpeed(ctx context.Context, url string, payloadSize int) (_ float64, err error) {
+	defer c.startTransfer()()
+	defer c.trackAbandon(ctx)(&err)
+	ctx, stall := c.watchStall(ctx)
+	defer stall.end(&err)
+	req, err := http.NewRequestWithContext(ctx, "GET", FormatURL(url, payloadSize), nil)
+	if err != nil {
+		return 0, err
+	}
+	req, traced := c.traceRequest(req)
+	resp, err := traced(c.HTTPClient.Do(req))
+	if err != nil {
+		return 0, fmt.Errorf("downloading from %s: %w", GetHost(url), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, &StatusError{URL: url, Status: resp.Status}
+	}
+	c.observeTLS(resp.TLS)
+	t1 := time.Now()
+	n, err := drain(&countingReader{reader: stall.reader(resp.Body), counter: &c.transferred})
+	if err != nil {
+		return 0, fmt.Errorf("downloading from %s: %w", GetHost(url), err)
+	}
+	if n < int64(payloadSize) || isWebPage(resp) {
+		atomic.AddInt64(&c.unexpected, 1)
+	}
+	return float64(n) / time.Since(t1).Seconds(), nil
+}
+
+// Prewarm opens a connection to url for the transfers, TLS handshake
+// included, with a request for a single byte, and leaves it idle in their
+// pool, so the first transfer doesn't have to wait for it.
+func (c *Client) Prewarm(ctx context.Context, url string) (err error) {
+	defer c.trackAbandon(ctx)(&err)
+	ctx, end := c.withRequestTimeout(ctx)
+	defer end(&err)
+	req, err := http.NewRequestWithContext(ctx, "GET", FormatURL(url, 0), nil)
+	if err != nil {
+		return err
+	}
+	req, traced := c.traceRequest(req)
+	resp, err := traced(c.HTTPClient.Do(req))
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", GetHost(url), err)
+	}
+	defer resp.Body.Close()
+	// only a drained response returns its connection to the pool
+	_, err = drain(resp.Body)
+	return err
+}
+
+func (c *Client) GetUploadSpeed(ctx context.Context, url string, payloadSize int) (_ float64, err error) {
+	defer c.startTransfer()()
+	defer c.trackAbandon(ctx)(&err)
+	ctx, stall := c.watchStall(ctx)
+	defer stall.end(&err)
+	counter := &FakeReader{
+		ReadIndex: 0,
+		MaxIndex:  int64(payloadSize),
+		Counter:   &c.transferred,
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", FormatURL(url, payloadSize), stall.reader(counter))
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = int64(payloadSize)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Accept-Encoding", "identity")
+
+	req, traced := c.traceRequest(req)
+	t1 := time.Now()
+	resp, err := traced(c.HTTPClient.Do(req))
+	if err != nil {
+		return 0, fmt.Errorf("uploading to %s: %w", GetHost(url), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, &StatusError{URL: url, Status: resp.Status}
+	}
+	c.observeTLS(resp.TLS)
+	return float64(int64(payloadSize)) / time.Since(t1).Seconds(), nil
+}
+
+// MaxLatencyFailures is how many failed samples MeasureLatency skips before
+// giving up.
+const MaxLatencyFailures = 3
+
+type Latency struct {
+	Samples  []time.Duration
+	MeanMs   float64
+	JitterMs float64
+	// DNSMs is how long the target's host took to resolve, once before
+	// the samples, or 0 if nothing was looked up; see PreResolve.
+	DNSMs float64
+	// Shortened is set when ctx's deadline stopped the measurement early.
+	Shortened bool
+}
+
+// MeasureLatency takes samples latency samples, all from the address the
+// target's host resolves to first, so they time the connect alone. If ctx's
+// deadline passes after the first sample, it returns the samples taken so
+// far.
+func (c *Client) MeasureLatency(ctx context.Context, url string, samples int) (Latency, error) {
+	return c.MeasureLatencyFunc(ctx, url, samples, nil)
+}
+
+// MeasureLatencyFunc is like MeasureLatency, but also calls onSample, if not
+// nil, with each sample as it is taken.
+func (c *Client) MeasureLatencyFunc(ctx context.Context, url string, samples int, onSample func(time.Duration)) (Latency, error) {
+	var latency Latency
+	ctx, dns, err := c.PreResolve(ctx, url)
+	if err != nil {
+		return latency, err
+	}
+	latency.DNSMs = float64(dns) / float64(time.Millisecond)
+	var millis []float64
+	var failures int
+	var lastErr error
+	for i := 0; i < samples; i++ {
+		sample, err := c.GetLatency(ctx, url)
+		if err != nil {
+			if len(millis) > 0 && deadlinePassed(ctx) {
+				latency.Shortened = true
+				break
+			}
+			// occasional failed connects show up in ConnectStats
+			// instead of failing the whole measurement
+			if ctx.Err() == nil && failures < MaxLatencyFailures {
+				failures++
+				lastErr = err
+				continue
+			}
+			return latency, err
+		}
+		if onSample != nil {
+			onSample(sample)
+		}
+		latency.Samples = append(latency.Samples, sample)
+		millis = append(millis, float64(sample)/float64(time.Millisecond))
+	}
+	if len(millis) == 0 && lastErr != nil {
+		return latency, lastErr
+	}
+	if latency.MeanMs, err = CalcMean(millis); err != nil {
+		return latency, fmt.Errorf("calculating latency: %w", err)
+	}
+	if len(millis) < 2 {
+		return latency, nil
+	}
+	if latency.JitterMs, err = CalcJitter(millis); err != nil {
+		return latency, fmt.Errorf("calculating jitter: %w", err)
+	}
+	return latency, nil
+}
+
+func deadlinePassed(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// Convergence criteria for MeasureConfig.Criterion
+const (
+	// ConvergeStdDev stops once the standard deviation of the last
+	// transfers falls below StdMaxSlow or StdMaxFast.
+	ConvergeStdDev = "stddev"
+	// ConvergeEWMA stops once an exponentially weighted moving average of
+	// the speed flattens out. Smoothing lets it settle sooner on noisy
+	// links, such as cable, whose transfers rarely agree closely.
+	ConvergeEWMA = "ewma"
+)
+
+// MeasureConfig controls when a download or upload measurement is considered
+// stable enough to stop.
+type MeasureConfig struct {
+	// max loops to run
+	MaxLoop int
+
+	// payload size
+	SlowMB int // for slow connections
+	FastMB int // for fast connections
+
+	// any value over this would be considered a fast connection
+	CutoffMB float64
+
+	// take last n values to calculate standard deviation
+	StdLastVarsSlow int // for slow connections
+	StdLastVarsFast int // for fast connections
+
+	// if standard deviation is less than this, we break out of the loop
+	StdMaxSlow float64 // for slow connections
+	StdMaxFast float64 // for fast connections
+
+	// Criterion is ConvergeStdDev, the default if empty, or ConvergeEWMA
+	Criterion string
+
+	// weight of each new transfer in the moving average
+	EWMAAlpha float64
+
+	// if the moving average changes by less than this fraction per
+	// transfer over the last n values, we break out of the loop
+	EWMAMaxSlope float64
+}
+
+var DefaultMeasureConfig = MeasureConfig{
+	MaxLoop:         100,
+	SlowMB:          2,
+	FastMB:          10,
+	CutoffMB:        2,
+	StdLastVarsSlow: 3,
+	StdLastVarsFast: 4,
+	StdMaxSlow:      0.2,
+	StdMaxFast:      5.0,
+	EWMAAlpha:       0.3,
+	EWMAMaxSlope:    0.02,
+}
+
+type Throughput struct {
+	// Mbps is the highest of the last, stable transfers.
+	Mbps   float64
+	UsedMB int
+	// SamplesMbps holds the speed of every transfer that counted.
+	SamplesMbps []float64
+	// Shortened is set when ctx's deadline stopped the measurement before
+	// the speed stabilized. Mbps is then the best of the finished transfers.
+	Shortened bool
+}
+
+func measureThroughput(ctx context.Context, cfg MeasureConfig, transfer func(payloadSize int) (float64, error)) (Throughput, error) {
+	totals := []float64{}
+	measureMB := cfg.SlowMB
+	stdLastVars := cfg.StdLastVarsSlow
+	stdMax := cfg.StdMaxSlow
+	cutOffComplete := false
+	shortened := false
+	for i := 0; i < cfg.MaxLoop; i++ {
+		speed, err := transfer(measureMB * 1024 * 1024)
+		if err != nil {
+			if len(totals) > 0 && deadlinePassed(ctx) {
+				shortened = true
+				if len(totals) < stdLastVars {
+					stdLastVars = len(totals)
+				}
+				break
+			}
+			return Throughput{}, err
+		}
+		if !cutOffComplete && speed > cfg.CutoffMB*1024*1024 {
+			measureMB = cfg.FastMB
+			stdLastVars = cfg.StdLastVarsFast
+			stdMax = cfg.StdMaxFast
+			cutOffComplete = true
+			i-- // Retry this iteration
+			continue
+		}
+		totals = append(totals, speed)
+		if len(totals) >= stdLastVars {
+			var stable bool
+			if cfg.Criterion == ConvergeEWMA {
+				slope, err := CalcEWMASlopeLastN(totals, cfg.EWMAAlpha, stdLastVars)
+				if err != nil {
+					return Throughput{}, err
+				}
+				stable = slope < cfg.EWMAMaxSlope
+			} else {
+				std, err := CalcStdDeviationLastN(totals, stdLastVars)
+				if err != nil {
+					return Throughput{}, err
+				}
+				stable = std < 1024*1024*stdMax
+			}
+			if stable {
+				break
+			}
+		}
+	}
+	max, err := CalcMaxValueLastN(totals, stdLastVars)
+	if err != nil {
+		return Throughput{}, fmt.Errorf("calculating speed: %w", err)
+	}
+	samplesMbps := make([]float64, len(totals))
+	for i, speed := range totals {
+		samplesMbps[i] = speed / 125000
+	}
+	return Throughput{
+		Mbps:        max / 125000,
+		UsedMB:      len(totals) * measureMB,
+		SamplesMbps: samplesMbps,
+		Shortened:   shortened,
+	}, nil
+}
+
+func (c *Client) MeasureDownload(ctx context.Context, url string, cfg MeasureConfig) (Throughput, error) {
+	return measureThroughput(ctx, cfg, func(payloadSize int) (float64, error) {
+		return c.GetDownloadSpeed(ctx, url, payloadSize)
+	})
+}
+
+func (c *Client) MeasureUpload(ctx context.Context, url string, cfg MeasureConfig) (Throughput, error) {
+	return measureThroughput(ctx, cfg, func(payloadSize int) (float64, error) {
+		return c.GetUploadSpeed(ctx, url, payloadSize)
+	})
+}
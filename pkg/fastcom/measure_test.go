@@ -0,0 +1,82 @@
+package fastcom
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDrainAllocations(t *testing.T) {
+	r := &FakeReader{}
+	allocs := testing.AllocsPerRun(20, func() {
+		r.ReadIndex, r.MaxIndex = 0, 4<<20
+		if n, err := drain(r); err != nil || n != 4<<20 {
+			t.Fatalf("got %d, %v, want %d bytes", n, err, 4<<20)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("draining 4 MiB allocated %v times, want 0", allocs)
+	}
+}
+
+func TestPacedReaderAllocations(t *testing.T) {
+	src := &FakeReader{}
+	p := &pacer{}
+	r := &pacedReader{ctx: context.Background(), r: src, p: p}
+	allocs := testing.AllocsPerRun(5, func() {
+		// 256 KiB at 128 MiB/s is 16 reads held back for about 2ms in all
+		src.ReadIndex, src.MaxIndex = 0, 256<<10
+		p.start, p.moved, p.bytesPerSec = time.Now(), 0, 128<<20
+		if _, err := drain(r); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// the timer, once, is all
+	if allocs > 1 {
+		t.Errorf("a paced transfer allocated %v times, want at most 1", allocs)
+	}
+}
+
+func TestPacedReaderCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &pacer{start: time.Now(), bytesPerSec: 1}
+	r := &pacedReader{ctx: ctx, r: &FakeReader{MaxIndex: 1 << 20}, p: p}
+	time.AfterFunc(20*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() {
+		_, err := drain(r)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("got %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the paced transfer did not end with its context")
+	}
+}
+
+func TestTakeActiveTransfers(t *testing.T) {
+	var c Client
+	if n := c.TakeActiveTransfers(); n != 0 {
+		t.Fatalf("got %d before any transfer", n)
+	}
+	// two at once, one of which ends before the count is taken
+	endA, endB := c.startTransfer(), c.startTransfer()
+	endA()
+	if n := c.TakeActiveTransfers(); n != 2 {
+		t.Errorf("got %d, want 2", n)
+	}
+	// the one still in flight counts for the next period too
+	if n := c.TakeActiveTransfers(); n != 1 {
+		t.Errorf("got %d, want 1", n)
+	}
+	endB()
+	if n := c.TakeActiveTransfers(); n != 1 {
+		t.Errorf("got %d, want 1 for the transfer that ended in the period", n)
+	}
+	if n := c.TakeActiveTransfers(); n != 0 {
+		t.Errorf("got %d, want 0 after all ended", n)
+	}
+}
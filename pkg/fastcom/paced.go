@@ -0,0 +1,226 @@
+package fastcom
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// LoadedLatencyInterval is the time between latency samples taken while a
+// paced transfer runs.
+const LoadedLatencyInterval = 200 * time.Millisecond
+
+type LoadedLatency struct {
+	// Mbps is the rate the transfer achieved, at most the paced rate.
+	Mbps    float64
+	Latency Latency
+}
+
+// MeasureLoadedLatency transfers to or from url at mbps for d, on one
+// connection, while it takes latency samples on new ones. A rate of 0 leaves
+// the transfer unpaced. It shows how much delay queues build up at a given
+// load.
+func (c *Client) MeasureLoadedLatency(ctx context.Context, url string, upload bool, mbps float64, d time.Duration) (LoadedLatency, error) {
+	// one lookup before the load rather than one per sample under it
+	resolvedCtx, _, err := c.PreResolve(ctx, url)
+	if err != nil {
+		return LoadedLatency{}, err
+	}
+	loadCtx, cancel := context.WithTimeout(resolvedCtx, d)
+	defer cancel()
+	p := &pacer{start: time.Now(), bytesPerSec: mbps * 125000}
+	loadErr := make(chan error, 1)
+	go func() {
+		loadErr <- c.pacedTransfer(loadCtx, url, upload, p)
+	}()
+
+	var result LoadedLatency
+	var millis []float64
+	for loadCtx.Err() == nil {
+		sample, err := c.GetLatency(loadCtx, url)
+		if err == nil {
+			result.Latency.Samples = append(result.Latency.Samples, sample)
+			millis = append(millis, float64(sample)/float64(time.Millisecond))
+		}
+		select {
+		case <-loadCtx.Done():
+		case <-time.After(LoadedLatencyInterval):
+		}
+	}
+	err = <-loadErr
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	if err != nil && loadCtx.Err() == nil {
+		return result, err
+	}
+	result.Mbps = float64(atomic.LoadInt64(&p.moved)) / time.Since(p.start).Seconds() / 125000
+	if len(millis) == 0 {
+		return result, fmt.Errorf("no latency samples from %s under load", GetHost(url))
+	}
+	result.Latency.MeanMs, _ = CalcMean(millis)
+	if len(millis) > 1 {
+		result.Latency.JitterMs, _ = CalcJitter(millis)
+	}
+	return result, nil
+}
+
+// Burst is the throughput of a short transfer at full speed.
+type Burst struct {
+	Mbps float64
+	// SamplesMbps holds the rate of each interval from the start, which
+	// shows how quickly the transfer ramped up.
+	SamplesMbps []float64
+}
+
+// MeasureBurst transfers to or from url at full speed for d, on one
+// connection, sampling the rate every interval. The connection is reused
+// from earlier bursts if it is still open, as a browser's would be.
+func (c *Client) MeasureBurst(ctx context.Context, url string, upload bool, d, interval time.Duration) (Burst, error) {
+	loadCtx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	p := &pacer{start: time.Now()}
+	loadErr := make(chan error, 1)
+	go func() {
+		loadErr <- c.pacedTransfer(loadCtx, url, upload, p)
+	}()
+
+	var result Burst
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last int64
+	for loadCtx.Err() == nil {
+		select {
+		case <-loadCtx.Done():
+		case <-ticker.C:
+			moved := atomic.LoadInt64(&p.moved)
+			result.SamplesMbps = append(result.SamplesMbps, float64(moved-last)/interval.Seconds()/125000)
+			last = moved
+		}
+	}
+	err := <-loadErr
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	if err != nil && loadCtx.Err() == nil {
+		return result, err
+	}
+	result.Mbps = float64(atomic.LoadInt64(&p.moved)) / time.Since(p.start).Seconds() / 125000
+	return result, nil
+}
+
+// pacedTransfer keeps requesting payloads of about a second's worth of
+// data until ctx is done.
+func (c *Client) pacedTransfer(ctx context.Context, url string, upload bool, p *pacer) error {
+	size := int(p.bytesPerSec)
+	if size < 1024*1024 {
+		size = 1024 * 1024
+	} else if size > MaxPayload {
+		size = MaxPayload
+	}
+	for ctx.Err() == nil {
+		if err := c.pacedRequest(ctx, url, upload, size, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pacedRequest transfers one payload of size bytes, paced by p.
+func (c *Client) pacedRequest(ctx context.Context, url string, upload bool, size int, p *pacer) (err error) {
+	defer c.startTransfer()()
+	ctx, stall := c.watchStall(ctx)
+	defer stall.end(&err)
+	var req *http.Request
+	if upload {
+		body := &pacedReader{ctx: ctx, r: &FakeReader{MaxIndex: int64(size), Counter: &c.transferred}, p: p}
+		req, err = http.NewRequestWithContext(ctx, "POST", FormatURL(url, size), stall.reader(body))
+		if err == nil {
+			req.ContentLength = int64(size)
+			req.Header.Set("Content-Type", "application/octet-stream")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, "GET", FormatURL(url, size), nil)
+	}
+	if err != nil {
+		return err
+	}
+	req, traced := c.traceRequest(req)
+	resp, err := traced(c.HTTPClient.Do(req))
+	if err != nil {
+		return fmt.Errorf("transferring with %s: %w", GetHost(url), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{URL: url, Status: resp.Status}
+	}
+	if !upload {
+		_, err = drain(&pacedReader{ctx: ctx, r: &countingReader{reader: stall.reader(resp.Body), counter: &c.transferred}, p: p})
+	}
+	if err != nil {
+		return fmt.Errorf("transferring with %s: %w", GetHost(url), err)
+	}
+	return nil
+}
+
+// pacer holds transfers to an average rate since start by delaying them
+// once they get ahead.
+type pacer struct {
+	start       time.Time
+	bytesPerSec float64
+	moved       int64
+}
+
+// delay counts n more bytes moved and returns how long to hold the
+// transfer that moved them.
+func (p *pacer) delay(n int) time.Duration {
+	moved := atomic.AddInt64(&p.moved, int64(n))
+	if p.bytesPerSec <= 0 {
+		return 0
+	}
+	due := p.start.Add(time.Duration(float64(moved) / p.bytesPerSec * float64(time.Second)))
+	return time.Until(due)
+}
+
+// pacedReaderChunk bounds each read, so pacing stays smooth.
+const pacedReaderChunk = 16 * 1024
+
+type pacedReader struct {
+	ctx   context.Context
+	r     io.Reader
+	p     *pacer
+	timer *time.Timer // reused for every delay of the transfer
+}
+
+func (r *pacedReader) Read(b []byte) (int, error) {
+	if len(b) > pacedReaderChunk {
+		b = b[:pacedReaderChunk]
+	}
+	n, err := r.r.Read(b)
+	if delay := r.p.delay(n); delay > 0 {
+		if waitErr := r.wait(delay); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (r *pacedReader) wait(d time.Duration) error {
+	if r.timer == nil {
+		r.timer = time.NewTimer(d)
+	} else {
+		r.timer.Reset(d)
+	}
+	select {
+	case <-r.timer.C:
+		return nil
+	case <-r.ctx.Done():
+		if !r.timer.Stop() {
+			<-r.timer.C
+		}
+		return r.ctx.Err()
+	}
+}
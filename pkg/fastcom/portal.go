@@ -0,0 +1,22 @@
+package fastcom
+
+import (
+	"mime"
+	"net/http"
+	"sync/atomic"
+)
+
+// UnexpectedResponses returns how many responses so far didn't look like a
+// target's: a redirect from a latency sample, or a download that was
+// shorter than requested or a web page. A captive portal or a filtering
+// proxy answers that way in place of the target. It is safe to call
+// concurrently.
+func (c *Client) UnexpectedResponses() int64 {
+	return atomic.LoadInt64(&c.unexpected)
+}
+
+// isWebPage reports whether resp is an HTML page, which no target serves.
+func isWebPage(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/html"
+}
@@ -0,0 +1,101 @@
+package fastcom
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxProbesInFlight bounds the probes waiting on a congested link; ticks
+// beyond it are skipped.
+const maxProbesInFlight = 8
+
+// LatencyProbe is one latency sample taken At a time after the prober's
+// origin.
+type LatencyProbe struct {
+	At      time.Duration
+	Latency time.Duration
+}
+
+// LatencyProber samples latency to URL every Interval while a transfer loads
+// the link. Probes run on a fixed schedule, each on a new connection of its
+// own, so neither a slow probe nor the transfer's progress delays the next.
+type LatencyProber struct {
+	Client   *Client
+	URL      string
+	Interval time.Duration
+	// Origin is the time At is measured from, e.g. the start of the
+	// throughput samples; it defaults to when Start is called.
+	Origin time.Time
+	// OnProbe, if set, is called from the probing goroutines.
+	OnProbe func(LatencyProbe)
+
+	mu       sync.Mutex
+	probes   []LatencyProbe
+	inFlight int
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func (p *LatencyProber) Start(ctx context.Context) {
+	if p.Origin.IsZero() {
+		p.Origin = time.Now()
+	}
+	// probes share one lookup; without it, each resolves for itself
+	if resolvedCtx, _, err := p.Client.PreResolve(ctx, p.URL); err == nil {
+		ctx = resolvedCtx
+	}
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+		for {
+			p.probe(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (p *LatencyProber) probe(ctx context.Context) {
+	p.mu.Lock()
+	if p.inFlight >= maxProbesInFlight {
+		p.mu.Unlock()
+		return
+	}
+	p.inFlight++
+	p.mu.Unlock()
+	at := time.Since(p.Origin)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		latency, err := p.Client.GetLatency(ctx, p.URL)
+		p.mu.Lock()
+		p.inFlight--
+		if err != nil {
+			p.mu.Unlock()
+			return
+		}
+		probe := LatencyProbe{At: at, Latency: latency}
+		p.probes = append(p.probes, probe)
+		p.mu.Unlock()
+		if p.OnProbe != nil {
+			p.OnProbe(probe)
+		}
+	}()
+}
+
+// Stop cancels the probes still in flight and returns the finished ones in
+// the order they were sent.
+func (p *LatencyProber) Stop() []LatencyProbe {
+	p.cancel()
+	p.wg.Wait()
+	sort.Slice(p.probes, func(i, j int) bool { return p.probes[i].At < p.probes[j].At })
+	return p.probes
+}
@@ -0,0 +1,79 @@
+package fastcom
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// resolvedKey is the context key of the addresses PreResolve looked up.
+type resolvedKey struct{}
+
+// PreResolve looks up the host of target once and returns a context whose
+// connections to it dial that address directly, with the time the lookup
+// took. A series of latency samples taken within the context then measures
+// the same address each time, without a lookup per sample or a race between
+// IPv4 and IPv6 that may pick another address. The request still names the
+// host, so TLS sends it as the server name and verifies the certificate
+// against it.
+//
+// Nothing is looked up, and ctx is returned as is with a zero time, for an
+// IP address, a host pinned with Resolve, and on js/wasm, where the browser
+// connects.
+func (c *Client) PreResolve(ctx context.Context, target string) (context.Context, time.Duration, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return ctx, 0, err
+	}
+	host := u.Hostname()
+	if c.dialer == nil || net.ParseIP(host) != nil {
+		return ctx, 0, nil
+	}
+	if _, ok := c.pinned[host]; ok {
+		return ctx, 0, nil
+	}
+	start := time.Now()
+	addrs, err := c.LookupHost(ctx, host)
+	took := time.Since(start)
+	if err != nil {
+		return ctx, took, fmt.Errorf("resolving %s: %w", host, err)
+	}
+	ip := c.pickAddress(addrs)
+	if ip == "" {
+		return ctx, took, fmt.Errorf("resolving %s: no address reachable from %s", host, c.dialer.LocalAddr)
+	}
+	return context.WithValue(ctx, resolvedKey{}, resolvedHost{host, ip}), took, nil
+}
+
+// pickAddress returns the first of addrs, which the resolver sorted by
+// preference, that the source address set with SetSourceAddress can reach.
+func (c *Client) pickAddress(addrs []string) string {
+	local, _ := c.dialer.LocalAddr.(*net.TCPAddr)
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		if local == nil || (ip.To4() != nil) == (local.IP.To4() != nil) {
+			return addr
+		}
+	}
+	return ""
+}
+
+// resolvedHost is the address PreResolve looked up for a host.
+type resolvedHost struct {
+	host, ip string
+}
+
+// resolvedAddress returns the address PreResolve looked up for host within
+// ctx, if any.
+func resolvedAddress(ctx context.Context, host string) (string, bool) {
+	resolved, ok := ctx.Value(resolvedKey{}).(resolvedHost)
+	if !ok || resolved.host != host {
+		return "", false
+	}
+	return resolved.ip, true
+}
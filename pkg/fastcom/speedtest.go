@@ -0,0 +1,138 @@
+package fastcom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SpeedTestConfig configures RunSpeedTest. Every field is optional; its JSON
+// form is what the C and WebAssembly builds accept.
+type SpeedTestConfig struct {
+	// Targets replaces the fast.com API with a list of target URLs.
+	Targets        []string `json:"targets"`
+	Servers        int      `json:"servers"`
+	LatencySamples int      `json:"latency_samples"`
+	NoHTTPS        bool     `json:"no_https"`
+	HTTP1          bool     `json:"http1"`
+	TLSCipher      string   `json:"tls_cipher"`
+	SkipLatency    bool     `json:"skip_latency"`
+	SkipDownload   bool     `json:"skip_download"`
+	SkipUpload     bool     `json:"skip_upload"`
+	TimeoutSeconds float64  `json:"timeout_seconds"`
+}
+
+type ServerSpeed struct {
+	Host         string  `json:"host"`
+	URL          string  `json:"url"`
+	City         string  `json:"city,omitempty"`
+	Country      string  `json:"country,omitempty"`
+	LatencyMs    float64 `json:"latency_ms"`
+	JitterMs     float64 `json:"jitter_ms"`
+	DownloadMbps float64 `json:"download_mbps"`
+	DownloadMB   int     `json:"download_mb"`
+	UploadMbps   float64 `json:"upload_mbps"`
+	UploadMB     int     `json:"upload_mb"`
+}
+
+// SpeedTestResult is the outcome of RunSpeedTest. Error is set when the test
+// failed; servers measured before the failure are still included.
+type SpeedTestResult struct {
+	Time    time.Time     `json:"time"`
+	IP      string        `json:"ip,omitempty"`
+	ASN     string        `json:"asn,omitempty"`
+	ISP     string        `json:"isp,omitempty"`
+	City    string        `json:"city,omitempty"`
+	Country string        `json:"country,omitempty"`
+	Servers []ServerSpeed `json:"servers"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// RunSpeedTest runs a complete test with a new client. It is meant for
+// embedders that want one call instead of driving the phases themselves.
+func RunSpeedTest(ctx context.Context, cfg SpeedTestConfig) (SpeedTestResult, error) {
+	result := SpeedTestResult{Time: time.Now()}
+	if cfg.Servers <= 0 {
+		cfg.Servers = 1
+	}
+	if cfg.LatencySamples <= 0 {
+		cfg.LatencySamples = 10
+	}
+	if cfg.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.TimeoutSeconds*float64(time.Second)))
+		defer cancel()
+	}
+
+	client := NewClient()
+	client.HTTPS = !cfg.NoHTTPS
+	if err := client.SetCipher(cfg.TLSCipher); err != nil {
+		return result, err
+	}
+	if cfg.HTTP1 {
+		client.DisableHTTP2()
+	}
+
+	var conn ConnectionInfo
+	var servers []Server
+	if len(cfg.Targets) > 0 {
+		for _, url := range cfg.Targets {
+			servers = append(servers, Server{URL: url})
+		}
+	} else {
+		var err error
+		if conn, servers, err = client.GetServerList(ctx, cfg.Servers); err != nil {
+			return result, err
+		}
+	}
+	result.IP, result.ASN, result.ISP = conn.IP, conn.ASN, conn.ISP
+	result.City, result.Country = conn.Location.City, conn.Location.Country
+
+	for _, server := range servers {
+		r := ServerSpeed{Host: GetHost(server.URL), URL: server.URL, City: server.City, Country: server.Country}
+		if !cfg.SkipLatency {
+			latency, err := client.MeasureLatency(ctx, server.URL, cfg.LatencySamples)
+			if err != nil {
+				return result, fmt.Errorf("measuring latency: %w", err)
+			}
+			r.LatencyMs, r.JitterMs = latency.MeanMs, latency.JitterMs
+		}
+		if !cfg.SkipDownload {
+			download, err := client.MeasureDownload(ctx, server.URL, DefaultMeasureConfig)
+			if err != nil {
+				return result, fmt.Errorf("measuring download speed: %w", err)
+			}
+			r.DownloadMbps, r.DownloadMB = download.Mbps, download.UsedMB
+		}
+		if !cfg.SkipUpload {
+			upload, err := client.MeasureUpload(ctx, server.URL, DefaultMeasureConfig)
+			if err != nil {
+				return result, fmt.Errorf("measuring upload speed: %w", err)
+			}
+			r.UploadMbps, r.UploadMB = upload.Mbps, upload.UsedMB
+		}
+		result.Servers = append(result.Servers, r)
+	}
+	return result, nil
+}
+
+// RunSpeedTestJSON runs a test configured by a SpeedTestConfig JSON document
+// (empty for the defaults) and returns a SpeedTestResult JSON document, with
+// any error reported in its error field.
+func RunSpeedTestJSON(ctx context.Context, configJSON string) string {
+	var cfg SpeedTestConfig
+	var result SpeedTestResult
+	var err error
+	if configJSON != "" {
+		err = json.Unmarshal([]byte(configJSON), &cfg)
+	}
+	if err == nil {
+		result, err = RunSpeedTest(ctx, cfg)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	out, _ := json.Marshal(result)
+	return string(out)
+}
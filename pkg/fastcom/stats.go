@@ -0,0 +1,197 @@
+package fastcom
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+var ErrNotEnoughValues = errors.New("not enough values")
+var ErrInvalidN = errors.New("n must be greater than 0")
+
+// ErrNoVariance is returned by CalcWelchT when neither series varies, which
+// leaves the t statistic undefined.
+var ErrNoVariance = errors.New("the values don't vary")
+
+func lastN(nums []float64, n int) ([]float64, error) {
+	if n <= 0 {
+		return nil, ErrInvalidN
+	} else if len(nums) < n {
+		return nil, ErrNotEnoughValues
+	}
+	return nums[len(nums)-n:], nil
+}
+
+func CalcMean(nums []float64) (float64, error) {
+	if len(nums) == 0 {
+		return 0, ErrNotEnoughValues
+	}
+	var total float64
+	for _, num := range nums {
+		total += num
+	}
+	return total / float64(len(nums)), nil
+}
+
+func CalcMeanOfLastN(nums []float64, n int) (float64, error) {
+	last, err := lastN(nums, n)
+	if err != nil {
+		return 0, err
+	}
+	return CalcMean(last)
+}
+
+func CalcStdDeviation(nums []float64) (float64, error) {
+	mean, err := CalcMean(nums)
+	if err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, num := range nums {
+		total += math.Pow(num-mean, 2)
+	}
+	return math.Sqrt(total / float64(len(nums))), nil
+}
+
+func CalcStdDeviationLastN(nums []float64, n int) (float64, error) {
+	last, err := lastN(nums, n)
+	if err != nil {
+		return 0, err
+	}
+	return CalcStdDeviation(last)
+}
+
+// CalcEWMA returns the exponentially weighted moving average of nums after
+// each value, starting from the first value. alpha is the weight of each new
+// value, between 0 and 1.
+func CalcEWMA(nums []float64, alpha float64) ([]float64, error) {
+	if len(nums) == 0 {
+		return nil, ErrNotEnoughValues
+	}
+	ewma := make([]float64, len(nums))
+	ewma[0] = nums[0]
+	for i := 1; i < len(nums); i++ {
+		ewma[i] = alpha*nums[i] + (1-alpha)*ewma[i-1]
+	}
+	return ewma, nil
+}
+
+// CalcEWMASlopeLastN returns how much the moving average of nums changed per
+// value over the last n values, relative to its last value.
+func CalcEWMASlopeLastN(nums []float64, alpha float64, n int) (float64, error) {
+	if n < 2 {
+		return 0, ErrInvalidN
+	}
+	ewma, err := CalcEWMA(nums, alpha)
+	if err != nil {
+		return 0, err
+	}
+	last, err := lastN(ewma, n)
+	if err != nil {
+		return 0, err
+	}
+	if last[n-1] == 0 {
+		return 0, nil
+	}
+	return math.Abs(last[n-1]-last[0]) / float64(n-1) / last[n-1], nil
+}
+
+func CalcMaxValue(nums []float64) (float64, error) {
+	if len(nums) == 0 {
+		return 0, ErrNotEnoughValues
+	}
+	var max float64
+	for _, num := range nums {
+		if num > max {
+			max = num
+		}
+	}
+	return max, nil
+}
+
+func CalcMaxValueLastN(nums []float64, n int) (float64, error) {
+	last, err := lastN(nums, n)
+	if err != nil {
+		return 0, err
+	}
+	return CalcMaxValue(last)
+}
+
+func CalcJitter(nums []float64) (float64, error) {
+	if len(nums) < 2 {
+		return 0, ErrNotEnoughValues
+	}
+	diffs := float64(0)
+	for i := 1; i < len(nums); i++ {
+		diffs += math.Abs(nums[i] - nums[i-1])
+	}
+	return diffs / float64(len(nums)-1), nil
+}
+
+// CalcPercentile returns the p-th percentile (0-100) of nums, interpolating
+// linearly between the closest ranks.
+func CalcPercentile(nums []float64, p float64) (float64, error) {
+	if len(nums) == 0 {
+		return 0, ErrNotEnoughValues
+	}
+	sorted := append([]float64(nil), nums...)
+	sort.Float64s(sorted)
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower)), nil
+}
+
+// CalcTrimmedMean returns the mean of nums after dropping the given fraction
+// of values from each end, e.g. 0.1 drops the best and worst 10%.
+func CalcTrimmedMean(nums []float64, fraction float64) (float64, error) {
+	if len(nums) == 0 {
+		return 0, ErrNotEnoughValues
+	}
+	sorted := append([]float64(nil), nums...)
+	sort.Float64s(sorted)
+	trim := int(float64(len(sorted)) * fraction)
+	return CalcMean(sorted[trim : len(sorted)-trim])
+}
+
+// CalcWelchT returns Welch's t statistic for the difference between the
+// means of b and a, and its degrees of freedom. a and b are series in the
+// order their values were taken. The test assumes independent values, but
+// consecutive seconds of a transfer, or latency probes in a row, move
+// together, and counting each as new evidence overstates the significance.
+// Each series counts as its effective sample size instead, n(1-r)/(1+r) for
+// a positive lag-1 autocorrelation r. It returns ErrNotEnoughValues if that
+// leaves fewer than 2 values, and ErrNoVariance if neither series varies.
+func CalcWelchT(a, b []float64) (t, df float64, err error) {
+	if len(a) < 2 || len(b) < 2 {
+		return 0, 0, ErrNotEnoughValues
+	}
+	stats := func(nums []float64) (mean, v, n float64) {
+		mean, _ = CalcMean(nums)
+		var ss, cov float64
+		for i, num := range nums {
+			ss += (num - mean) * (num - mean)
+			if i > 0 {
+				cov += (nums[i-1] - mean) * (num - mean)
+			}
+		}
+		n = float64(len(nums))
+		if ss > 0 && cov > 0 {
+			r := cov / ss
+			n *= (1 - r) / (1 + r)
+		}
+		return mean, ss / float64(len(nums)-1), n
+	}
+	meanA, varA, na := stats(a)
+	meanB, varB, nb := stats(b)
+	if varA == 0 && varB == 0 {
+		return 0, 0, ErrNoVariance
+	}
+	if na < 2 || nb < 2 {
+		return 0, 0, ErrNotEnoughValues
+	}
+	seA, seB := varA/na, varB/nb
+	t = (meanB - meanA) / math.Sqrt(seA+seB)
+	df = (seA + seB) * (seA + seB) / (seA*seA/(na-1) + seB*seB/(nb-1))
+	return t, df, nil
+}
@@ -0,0 +1,56 @@
+package fastcom
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalcWelchT(t *testing.T) {
+	// alternating values don't move together, so they count in full
+	a := []float64{10, 12, 10, 12, 10, 12, 10, 12}
+	b := []float64{11, 13, 11, 13, 11, 13, 11, 13}
+	tStat, df, err := CalcWelchT(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// means 11 and 12, variances 8/7, n 8: t = 1/sqrt(2/7)
+	if want := 1 / math.Sqrt(2.0/7); math.Abs(tStat-want) > 1e-9 || math.Abs(df-14) > 1e-9 {
+		t.Errorf("got t %g, df %g, want %g, 14", tStat, df, want)
+	}
+
+	// values that rise and fall in runs, as a transfer's speed does, move
+	// together; with a lag-1 autocorrelation of 0.6 each series of 20 counts
+	// as 5 values
+	var runsA, runsB []float64
+	for i := 0; i < 20; i++ {
+		runsA = append(runsA, float64(i/2%5))
+		runsB = append(runsB, float64(i/2%5)+1)
+	}
+	tStat, df, err = CalcWelchT(runsA, runsB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// counted as independent, they would give t 2.18 on 38 degrees of
+	// freedom, a significant difference
+	if want := 1 / math.Sqrt(2*(40.0/19)/5); math.Abs(tStat-want) > 1e-9 || math.Abs(df-8) > 1e-9 {
+		t.Errorf("got t %g, df %g, want %g, 8", tStat, df, want)
+	}
+}
+
+func TestCalcWelchTErrors(t *testing.T) {
+	tests := []struct {
+		a, b []float64
+		want error
+	}{
+		{[]float64{1}, []float64{1, 2}, ErrNotEnoughValues},
+		{[]float64{5, 5, 5}, []float64{5, 5}, ErrNoVariance},
+		{[]float64{5, 5, 5}, []float64{7, 7, 7}, ErrNoVariance},
+		// a step counts as fewer than 2 values
+		{[]float64{1, 1, 1, 1, 2, 2, 2, 2}, []float64{1, 2, 1, 2}, ErrNotEnoughValues},
+	}
+	for _, test := range tests {
+		if _, _, err := CalcWelchT(test.a, test.b); err != test.want {
+			t.Errorf("CalcWelchT(%v, %v): got %v, want %v", test.a, test.b, err, test.want)
+		}
+	}
+}
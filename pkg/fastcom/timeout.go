@@ -0,0 +1,112 @@
+package fastcom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Default timeouts of NewClient.
+const (
+	DefaultRequestTimeout = 30 * time.Second
+	DefaultStallTimeout   = 10 * time.Second
+)
+
+// ErrRequestTimeout is returned when a request without a payload, such as
+// the fast.com API request or a latency sample, took longer than the
+// client's RequestTimeout.
+var ErrRequestTimeout = errors.New("request timed out")
+
+// ErrStalled is returned when a download or upload moved no data for the
+// client's StallTimeout.
+var ErrStalled = errors.New("transfer stalled")
+
+// withRequestTimeout bounds a request without a payload by RequestTimeout.
+// The returned func ends the bound and, if it is what ended the request,
+// says so in err. The time runs out on a context of its own, so it isn't
+// taken for the end of ctx, e.g. by MeasureLatency.
+func (c *Client) withRequestTimeout(ctx context.Context) (context.Context, func(err *error)) {
+	if c.RequestTimeout <= 0 {
+		return ctx, func(*error) {}
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, c.RequestTimeout)
+	return reqCtx, func(err *error) {
+		if *err != nil && ctx.Err() == nil && reqCtx.Err() != nil {
+			*err = fmt.Errorf("%w after %v: %v", ErrRequestTimeout, c.RequestTimeout, *err)
+		}
+		cancel()
+	}
+}
+
+// stallWatch cancels a transfer once no data moved through its reader for
+// timeout. A nil stallWatch watches nothing.
+type stallWatch struct {
+	timeout time.Duration
+	last    int64 // time of the last progress, in Unix nanoseconds
+	stalled int32
+	timer   *time.Timer
+	cancel  context.CancelFunc
+}
+
+// watchStall returns a context for a transfer that ends when it stalls for
+// StallTimeout. Waiting for the response counts as stalling, so a server
+// that accepts a request and never answers is caught as well.
+func (c *Client) watchStall(ctx context.Context) (context.Context, *stallWatch) {
+	if c.StallTimeout <= 0 {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	w := &stallWatch{timeout: c.StallTimeout, last: time.Now().UnixNano(), cancel: cancel}
+	// check resets the timer, so it must be in place before it can fire
+	w.timer = time.AfterFunc(time.Hour, w.check)
+	w.timer.Stop()
+	w.timer.Reset(w.timeout)
+	return ctx, w
+}
+
+func (w *stallWatch) check() {
+	idle := time.Since(time.Unix(0, atomic.LoadInt64(&w.last)))
+	if idle < w.timeout {
+		w.timer.Reset(w.timeout - idle)
+		return
+	}
+	atomic.StoreInt32(&w.stalled, 1)
+	w.cancel()
+}
+
+// reader counts the data read from r as progress.
+func (w *stallWatch) reader(r io.Reader) io.Reader {
+	if w == nil {
+		return r
+	}
+	return &progressReader{r, w}
+}
+
+// end stops the watch and, if the transfer failed because it stalled, says
+// so in err.
+func (w *stallWatch) end(err *error) {
+	if w == nil {
+		return
+	}
+	w.timer.Stop()
+	w.cancel()
+	if *err != nil && atomic.LoadInt32(&w.stalled) == 1 {
+		*err = fmt.Errorf("%w, no data for %v: %v", ErrStalled, w.timeout, *err)
+	}
+}
+
+type progressReader struct {
+	r io.Reader
+	w *stallWatch
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(&r.w.last, time.Now().UnixNano())
+	}
+	return n, err
+}
@@ -0,0 +1,22 @@
+package fastcom
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWatchStallTinyTimeout(t *testing.T) {
+	// the timer fires at once, before watchStall has returned
+	c := &Client{StallTimeout: time.Nanosecond}
+	for i := 0; i < 100; i++ {
+		ctx, w := c.watchStall(context.Background())
+		<-ctx.Done()
+		err := ctx.Err()
+		w.end(&err)
+		if !errors.Is(err, ErrStalled) {
+			t.Fatalf("got %v, want %v", err, ErrStalled)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package fastcom
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+)
+
+var cipherFamilies = map[string][]uint16{
+	"aes-gcm": {
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	},
+	"chacha20": {
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	},
+}
+
+var ErrUnknownCipher = errors.New("unknown cipher, expected auto, aes-gcm or chacha20")
+
+// SetCipher restricts HTTPS connections to one cipher family: "aes-gcm",
+// "chacha20" (much faster on CPUs without AES instructions), or "auto" for
+// Go's default choice. TLS 1.3 does not let clients pick a suite, so choosing
+// a family also caps connections at TLS 1.2. It must be called before the
+// client is used.
+func (c *Client) SetCipher(name string) error {
+	if name == "auto" || name == "" {
+		return nil
+	}
+	suites, ok := cipherFamilies[name]
+	if !ok {
+		return ErrUnknownCipher
+	}
+	for _, tr := range []*http.Transport{c.Transport, c.LatencyTransport} {
+		tr.TLSClientConfig = &tls.Config{
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: suites,
+		}
+		// A custom TLS config otherwise turns off HTTP/2.
+		tr.ForceAttemptHTTP2 = true
+	}
+	return nil
+}
+
+func (c *Client) observeTLS(state *tls.ConnectionState) {
+	if state != nil {
+		c.cipherSuite.Store(tls.CipherSuiteName(state.CipherSuite))
+	}
+}
+
+// CipherSuite returns the TLS cipher suite negotiated by the most recent
+// download or upload, or an empty string for plain HTTP.
+func (c *Client) CipherSuite() string {
+	name, _ := c.cipherSuite.Load().(string)
+	return name
+}
+
+// SetServerName sends name as the TLS server name (SNI) instead of the
+// target's host name, to tell whether traffic is shaped by host name. The
+// certificate can't match name, so it is not verified: only use this for
+// measurements. It must be called after SetCipher and before the client is
+// used.
+func (c *Client) SetServerName(name string) {
+	for _, tr := range []*http.Transport{c.Transport, c.LatencyTransport} {
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{}
+		}
+		tr.TLSClientConfig.ServerName = name
+		tr.TLSClientConfig.InsecureSkipVerify = true
+		tr.ForceAttemptHTTP2 = true
+	}
+}
@@ -0,0 +1,138 @@
+package fastcom
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RequestInfo describes a finished request of the client, for OnRequest.
+type RequestInfo struct {
+	Method string
+	URL    string
+	// Status is the response's status code, or 0 if the request failed
+	// with Err.
+	Status int
+	Err    error
+	// Addr is the server address the request was sent to, and Reused
+	// whether its connection was an idle one from the pool.
+	Addr   string
+	Reused bool
+	// DNS, Connect and TLS are the times of the lookup, connect and TLS
+	// handshake of a new connection, zero for a reused one or a step that
+	// wasn't needed.
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// FirstByte is the time to the first byte of the response, and
+	// Duration the time until its body was closed.
+	FirstByte time.Duration
+	Duration  time.Duration
+	// Bytes is the size of the response body that was read.
+	Bytes int64
+}
+
+// traceRequest times req for OnRequest. The returned func takes the result
+// of sending req and reports it when the response body is closed, or right
+// away if the request failed. Without OnRequest, req is left as is.
+func (c *Client) traceRequest(req *http.Request) (*http.Request, func(*http.Response, error) (*http.Response, error)) {
+	if c.OnRequest == nil {
+		return req, func(resp *http.Response, err error) (*http.Response, error) { return resp, err }
+	}
+	t := &requestTrace{onRequest: c.OnRequest, start: time.Now()}
+	t.info.Method, t.info.URL = req.Method, req.URL.String()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), t.clientTrace()))
+	return req, func(resp *http.Response, err error) (*http.Response, error) {
+		if err != nil {
+			t.report(0, err)
+			return resp, err
+		}
+		resp.Body = &tracedBody{ReadCloser: resp.Body, trace: t, status: resp.StatusCode}
+		return resp, nil
+	}
+}
+
+// requestTrace collects the RequestInfo of one request. The hooks of
+// racing IPv4 and IPv6 connects may run concurrently.
+type requestTrace struct {
+	// first, for 64-bit alignment of atomic access on 32-bit platforms
+	bytes     int64
+	onRequest func(RequestInfo)
+	start     time.Time
+
+	mu                               sync.Mutex
+	info                             RequestInfo
+	dnsStart, connectStart, tlsStart time.Time
+	once                             sync.Once
+}
+
+func (t *requestTrace) clientTrace() *httptrace.ClientTrace {
+	at := func(f func()) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		f()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { at(func() { t.dnsStart = time.Now() }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { at(func() { t.info.DNS = time.Since(t.dnsStart) }) },
+		ConnectStart: func(_, _ string) {
+			at(func() {
+				if t.connectStart.IsZero() {
+					t.connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(_, _ string, err error) {
+			at(func() {
+				if err == nil && t.info.Connect == 0 {
+					t.info.Connect = time.Since(t.connectStart)
+				}
+			})
+		},
+		TLSHandshakeStart: func() { at(func() { t.tlsStart = time.Now() }) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, _ error) {
+			at(func() { t.info.TLS = time.Since(t.tlsStart) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			at(func() {
+				t.info.Addr, t.info.Reused = info.Conn.RemoteAddr().String(), info.Reused
+			})
+		},
+		GotFirstResponseByte: func() { at(func() { t.info.FirstByte = time.Since(t.start) }) },
+	}
+}
+
+func (t *requestTrace) report(status int, err error) {
+	t.once.Do(func() {
+		t.mu.Lock()
+		info := t.info
+		t.mu.Unlock()
+		info.Status, info.Err = status, err
+		info.Duration = time.Since(t.start)
+		info.Bytes = atomic.LoadInt64(&t.bytes)
+		t.onRequest(info)
+	})
+}
+
+// tracedBody reports its request when it is closed.
+type tracedBody struct {
+	io.ReadCloser
+	trace  *requestTrace
+	status int
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.trace.bytes, int64(n))
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.trace.report(b.status, nil)
+	return err
+}